@@ -0,0 +1,119 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+// Acknowledged delivery for ACK_ROOMS. Every broadcast in such a room gets a
+// room-wide sequence number in the envelope ("seq" in v1, "s" in v2) and
+// each recipient must answer {"op":"ack","seq":N}. A message not acked
+// within ACK_TIMEOUT is queued again, up to ACK_RETRIES times, and then
+// dropped as a dead letter. At most ACK_MAX_PENDING messages are held per
+// client; beyond that new messages are dropped rather than tracked.
+
+const (
+    dropAckTimeout  = "ack_timeout"
+    dropAckOverflow = "ack_overflow"
+)
+
+type ackConfig struct {
+    timeout    time.Duration
+    retries    int
+    maxPending int
+}
+
+func newAckConfig(cfg Config) *ackConfig {
+    ac := &ackConfig{timeout: cfg.AckTimeout, retries: cfg.AckRetries, maxPending: cfg.AckMaxPending}
+    if ac.timeout <= 0 {
+        ac.timeout = 2 * time.Second
+    }
+    if ac.maxPending <= 0 {
+        ac.maxPending = 256
+    }
+    return ac
+}
+
+type unacked struct {
+    env      Envelope
+    msg      []byte
+    attempts int
+    timer    *time.Timer
+}
+
+// ackTracker holds one client's unacked messages. Retransmissions run on
+// timer goroutines, so sends happen under mu; close stops them once the
+// connection is gone.
+type ackTracker struct {
+    c   *Client
+    cfg ackConfig
+
+    mu      sync.Mutex
+    pending map[uint64]*unacked
+    closed  bool
+}
+
+func newAckTracker(c *Client, cfg ackConfig) *ackTracker {
+    return &ackTracker{c: c, cfg: cfg, pending: make(map[uint64]*unacked)}
+}
+
+// track records msg as sent (or attempted) and arms its retransmit timer.
+// It reports false when the client already has too many unacked messages.
+func (a *ackTracker) track(env Envelope, msg []byte) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.closed {
+        return true
+    }
+    if len(a.pending) >= a.cfg.maxPending {
+        return false
+    }
+    u := &unacked{env: env, msg: msg}
+    u.timer = time.AfterFunc(a.cfg.timeout, func() { a.expire(env.Seq) })
+    a.pending[env.Seq] = u
+    return true
+}
+
+func (a *ackTracker) expire(seq uint64) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    u, ok := a.pending[seq]
+    if !ok || a.closed {
+        return
+    }
+    if u.attempts >= a.cfg.retries {
+        delete(a.pending, seq)
+        a.c.room.hub.dead.add(dropAckTimeout, a.c.room.name, u.env.Username, a.c.username, u.env.Payload)
+        return
+    }
+    u.attempts++
+    a.c.trySend(u.msg)
+    u.timer.Reset(a.cfg.timeout)
+}
+
+// ack settles seq; unknown or already settled sequences are ignored.
+func (a *ackTracker) ack(seq uint64) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if u, ok := a.pending[seq]; ok {
+        u.timer.Stop()
+        delete(a.pending, seq)
+    }
+}
+
+func (a *ackTracker) unackedCount() int {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return len(a.pending)
+}
+
+// close stops retransmission; pending messages are abandoned.
+func (a *ackTracker) close() {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    a.closed = true
+    for seq, u := range a.pending {
+        u.timer.Stop()
+        delete(a.pending, seq)
+    }
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestAckRetransmitsUntilAcked(t *testing.T) {
+    hub := NewHubWithConfig(Config{AckRooms: "critical", AckTimeout: 200 * time.Millisecond, AckRetries: 3})
+    base := startTestServer(t, hub)
+    sender := dialWS(t, base+"/ws/critical/alice")
+    late := dialWS(t, base+"/ws/critical/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "critical") == 2 })
+
+    if err := sender.WriteMessage(websocket.BinaryMessage, []byte("important")); err != nil {
+        t.Fatal(err)
+    }
+    // bob ignores the first delivery and acks the retransmission
+    readOne := func() Envelope {
+        t.Helper()
+        late.SetReadDeadline(time.Now().Add(2 * time.Second))
+        _, raw, err := late.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        var env Envelope
+        if err := json.Unmarshal(raw, &env); err != nil {
+            t.Fatal(err)
+        }
+        return env
+    }
+    first, start := readOne(), time.Now()
+    again := readOne()
+    if first.Seq != 1 || again.Seq != 1 || string(again.Payload) != "important" {
+        t.Fatalf("expected seq 1 twice, got %+v then %+v", first, again)
+    }
+    if waited := time.Since(start); waited < 150*time.Millisecond {
+        t.Fatalf("retransmitted after %v, before the ack timeout", waited)
+    }
+    ack, _ := json.Marshal(ControlFrame{Op: "ack", Seq: again.Seq})
+    if err := late.WriteMessage(websocket.TextMessage, ack); err != nil {
+        t.Fatal(err)
+    }
+    if extra := readEnvelopes(t, late, 300*time.Millisecond); len(extra) != 0 {
+        t.Fatalf("retransmitted after ack: %+v", extra)
+    }
+    if got := readEnvelopes(t, sender, 50*time.Millisecond); len(got) != 0 {
+        t.Fatalf("ack was relayed as data: %+v", got)
+    }
+}
+
+func TestAckGivesUpAfterRetries(t *testing.T) {
+    hub := NewHubWithConfig(Config{AckRooms: "critical", AckTimeout: 20 * time.Millisecond, AckRetries: 2})
+    dead := make(chan DeadLetter, 4)
+    hub.dead = newDeadLetterSink(4, func(dl DeadLetter) { dead <- dl })
+    r := hub.getRoom("critical")
+    c := fakeClient(r, "bob", 8)
+    c.acks = newAckTracker(c, *r.acks)
+
+    r.publish(nil, NewEnvelope("critical", "udp", []byte("x")))
+    if got := drain(c, 3, time.Second); len(got) != 3 {
+        t.Fatalf("expected 1 send + 2 retries, got %d", len(got))
+    }
+    select {
+    case dl := <-dead:
+        if dl.Reason != dropAckTimeout || dl.To != "bob" {
+            t.Fatalf("unexpected dead letter %+v", dl)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("no dead letter after retries were exhausted")
+    }
+    if n := c.acks.unackedCount(); n != 0 {
+        t.Fatalf("%d messages still pending", n)
+    }
+}
+
+func TestAckPendingBounded(t *testing.T) {
+    hub := NewHubWithConfig(Config{AckRooms: "critical", AckTimeout: time.Minute, AckMaxPending: 2})
+    r := hub.getRoom("critical")
+    c := fakeClient(r, "bob", 8)
+    c.acks = newAckTracker(c, *r.acks)
+    for i := 0; i < 3; i++ {
+        r.publish(nil, NewEnvelope("critical", "udp", []byte("x")))
+    }
+    if got := drain(c, 3, 100*time.Millisecond); len(got) != 2 {
+        t.Fatalf("expected 2 tracked deliveries, got %d", len(got))
+    }
+    c.acks.ack(1)
+    r.publish(nil, NewEnvelope("critical", "udp", []byte("x")))
+    if got := drain(c, 1, 100*time.Millisecond); len(got) != 1 {
+        t.Fatal("ack did not free a pending slot")
+    }
+    c.acks.close()
+}
+
+func TestAckRoomRejectsBinaryEnvelope(t *testing.T) {
+    url := startTestServer(t, NewHubWithConfig(Config{AckRooms: "critical"}))
+    for _, q := range []string{"?ver=3", "?max_overhead=100"} {
+        if _, resp, err := websocket.DefaultDialer.Dial(url+"/ws/critical/bob"+q, nil); err == nil || resp == nil || resp.StatusCode != 400 {
+            t.Fatalf("%s: expected 400, got %v", q, resp)
+        }
+    }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestAdminActionsAudited(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "admin-token", CaptureSampleRate: 1, CaptureMax: 10})
+    entries := make(chan AuditEntry, 16)
+    hub.audits = &auditLog{write: func(e AuditEntry) error {
+        entries <- e
+        return nil
+    }}
+    base := startTestServer(t, hub)
+    hub.getRoom("chat")
+
+    statsRequest(t, base, http.MethodGet, "/stats?room=chat&reset=1", "", nil)
+    statsRequest(t, base, http.MethodGet, "/stats?room=chat&reset=1", "admin-token", nil)
+    statsRequest(t, base, http.MethodGet, "/stats?room=chat", "admin-token", nil) // a plain read is not an admin action
+    statsRequest(t, base, http.MethodGet, "/config", "wrong", nil)
+    statsRequest(t, base, http.MethodGet, "/config", "admin-token", nil)
+    statsRequest(t, base, http.MethodGet, "/capture", "admin-token", nil)
+    schemaRequest(t, base, http.MethodPut, "?room=chat", chatSchema, "")
+    schemaRequest(t, base, http.MethodPut, "?room=chat", `{"type": 12}`, "admin-token")
+    schemaRequest(t, base, http.MethodPut, "?room=chat", chatSchema, "admin-token")
+    schemaRequest(t, base, http.MethodDelete, "?room=chat", "", "admin-token")
+
+    want := []AuditEntry{
+        {Actor: "anonymous", Action: auditStatsReset, Target: "chat", Result: auditDenied},
+        {Actor: "admin", Action: auditStatsReset, Target: "chat", Result: auditOK},
+        {Actor: "anonymous", Action: auditConfigRead, Result: auditDenied},
+        {Actor: "admin", Action: auditConfigRead, Result: auditOK},
+        {Actor: "admin", Action: auditCaptureRead, Result: auditOK},
+        {Actor: "anonymous", Action: auditSchemaPut, Target: "chat", Result: auditDenied},
+        {Actor: "admin", Action: auditSchemaPut, Target: "chat"}, // result: the validation error
+        {Actor: "admin", Action: auditSchemaPut, Target: "chat", Result: auditOK},
+        {Actor: "admin", Action: auditSchemaDelete, Target: "chat", Result: auditOK},
+    }
+    for i, w := range want {
+        var got AuditEntry
+        select {
+        case got = <-entries:
+        case <-time.After(time.Second):
+            t.Fatalf("entry %d missing, want %+v", i, w)
+        }
+        if w.Result == "" && (got.Result == auditOK || got.Result == auditDenied) {
+            t.Fatalf("entry %d result %q, want the failure", i, got.Result)
+        }
+        if w.Result == "" {
+            w.Result = got.Result
+        }
+        if got.Actor != w.Actor || got.Action != w.Action || got.Target != w.Target || got.Result != w.Result {
+            t.Fatalf("entry %d = %+v, want %+v", i, got, w)
+        }
+        if got.Addr != "127.0.0.1" || time.Since(got.Time) > time.Minute {
+            t.Fatalf("entry %d addr %q time %v", i, got.Addr, got.Time)
+        }
+    }
+    select {
+    case e := <-entries:
+        t.Fatalf("unexpected entry %+v", e)
+    default:
+    }
+}
+
+func TestAuditSinks(t *testing.T) {
+    if a, err := newAuditLog(""); a != nil || err != nil {
+        t.Fatalf("empty AUDIT_SINK: %v, %v", a, err)
+    }
+    if _, err := newAuditLog("syslog"); err == nil {
+        t.Fatal("unknown AUDIT_SINK accepted")
+    }
+    path := filepath.Join(t.TempDir(), "audit.log")
+    a, err := newAuditLog("file:" + path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    a.record(AuditEntry{Actor: "admin", Action: auditConfigRead, Result: auditOK})
+    b, _ := os.ReadFile(path)
+    var e AuditEntry
+    if err := json.Unmarshal(bytes.TrimSpace(b), &e); err != nil || e.Action != auditConfigRead {
+        t.Fatalf("file sink wrote %q (%v)", b, err)
+    }
+    var disabled *auditLog
+    disabled.record(e)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "crypto/subtle"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// WebSocket upgrade authentication (AUTH_TOKEN). With a token configured,
+// an upgrade must carry it as "Authorization: Bearer <token>" or, for
+// browsers that cannot set headers on a WebSocket, as ?token=; anything
+// else is refused with 401 before the upgrade. Unset, the relay is open.
+//
+// OBSERVER_TOKEN is a second credential, presented the same way, that
+// admits a connection as an observer (see roles.go) whatever its ?role=
+// says: holders of it can watch rooms but never publish. It only means
+// something behind an AUTH_TOKEN, since an open relay lets anyone publish.
+
+// authorizedClient reports whether r may connect under token.
+func authorizedClient(r *http.Request, token string) bool {
+    if token == "" {
+        return true
+    }
+    got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok {
+        got = r.URL.Query().Get("token")
+    }
+    return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// validObserverConfig refuses an OBSERVER_TOKEN that would not restrict
+// anyone.
+func validObserverConfig(cfg Config) error {
+    switch {
+    case cfg.ObserverToken == "":
+        return nil
+    case cfg.AuthToken == "":
+        return fmt.Errorf("OBSERVER_TOKEN needs AUTH_TOKEN")
+    case cfg.ObserverToken == cfg.AuthToken:
+        return fmt.Errorf("OBSERVER_TOKEN must differ from AUTH_TOKEN")
+    }
+    return nil
+}
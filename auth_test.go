@@ -0,0 +1,99 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestUpgradeRequiresAuthToken(t *testing.T) {
+    hub := NewHubWithConfig(Config{AuthToken: "s3cret"})
+    base := startTestServer(t, hub)
+    dial := func(path string, header http.Header) (int, error) {
+        c, resp, err := websocket.DefaultDialer.Dial(base+path, header)
+        if err == nil {
+            c.Close()
+            return http.StatusSwitchingProtocols, nil
+        }
+        if resp == nil {
+            return 0, err
+        }
+        return resp.StatusCode, nil
+    }
+    cases := []struct {
+        name   string
+        path   string
+        header http.Header
+        want   int
+    }{
+        {"header", "/ws/auth/a", http.Header{"Authorization": {"Bearer s3cret"}}, http.StatusSwitchingProtocols},
+        {"query", "/ws/auth/b?token=s3cret", nil, http.StatusSwitchingProtocols},
+        {"missing", "/ws/auth/c", nil, http.StatusUnauthorized},
+        {"wrong header", "/ws/auth/d", http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized},
+        {"wrong query", "/ws/auth/e?token=s3cre", nil, http.StatusUnauthorized},
+    }
+    for _, tc := range cases {
+        got, err := dial(tc.path, tc.header)
+        if err != nil {
+            t.Fatalf("%s: %v", tc.name, err)
+        }
+        if got != tc.want {
+            t.Fatalf("%s: status %d, want %d", tc.name, got, tc.want)
+        }
+    }
+}
+
+func TestUpgradeOpenWithoutAuthToken(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/open/a")
+}
+
+func TestObserverTokenCannotPublish(t *testing.T) {
+    hub := NewHubWithConfig(Config{AuthToken: "s3cret", ObserverToken: "watch"})
+    dead := make(chan DeadLetter, 4)
+    hub.dead = newDeadLetterSink(4, func(dl DeadLetter) { dead <- dl })
+    base := startTestServer(t, hub)
+    // dropping ?role=observer, or asking for another role, does not help
+    bare := dialWS(t, base+"/ws/match/spectator?token=watch")
+    posing := dialWS(t, base+"/ws/match/poser?token=watch&role=player")
+    player := dialWS(t, base+"/ws/match/player?token=s3cret")
+    waitFor(time.Second, func() bool { return roomSize(hub, "match") == 3 })
+
+    for _, c := range []*websocket.Conn{bare, posing} {
+        if err := c.WriteMessage(websocket.BinaryMessage, []byte("let me play")); err != nil {
+            t.Fatal(err)
+        }
+        select {
+        case dl := <-dead:
+            if dl.Reason != dropReadOnly {
+                t.Fatalf("dead letter %+v, want read_only", dl)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("observer frame was not dropped")
+        }
+    }
+    if err := player.WriteMessage(websocket.BinaryMessage, []byte("move")); err != nil {
+        t.Fatal(err)
+    }
+    if got := readEnvelopes(t, bare, 200*time.Millisecond); len(got) != 1 || string(got[0].Payload) != "move" {
+        t.Fatalf("observer got %v, want the player's move", got)
+    }
+    if got := readEnvelopes(t, player, 200*time.Millisecond); len(got) != 0 {
+        t.Fatalf("player got %v from observers", got)
+    }
+}
+
+func TestValidObserverConfig(t *testing.T) {
+    if err := validObserverConfig(Config{ObserverToken: "watch"}); err == nil {
+        t.Fatal("OBSERVER_TOKEN without AUTH_TOKEN accepted")
+    }
+    if err := validObserverConfig(Config{AuthToken: "same", ObserverToken: "same"}); err == nil {
+        t.Fatal("OBSERVER_TOKEN equal to AUTH_TOKEN accepted")
+    }
+    if err := validObserverConfig(Config{AuthToken: "s3cret", ObserverToken: "watch"}); err != nil {
+        t.Fatal(err)
+    }
+}
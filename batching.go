@@ -0,0 +1,122 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// Per-client batching windows. A connection opening with ?batch=<duration>
+// has the broadcasts it is sent within that window of the first one
+// written as a single {"type":"batch","messages":[<envelopes>]} frame,
+// saving per-frame overhead on high-latency links. BATCH_WINDOW applies a
+// window to every connection, but only while it is behind: a message is
+// held for a batch when more are already queued behind it, so a client
+// that keeps up still gets each message as it comes. Only JSON envelopes
+// are batched; binary ones, and anything that is not a broadcast, are
+// written alone after the pending batch.
+const maxBatchMessages = 256
+
+// featureBatchWindow is the window of a connection the "batching" feature
+// flag is on for, unless it asked for its own with ?batch=.
+const featureBatchWindow = 50 * time.Millisecond
+
+// BatchFrame is the frame a batch is delivered in.
+type BatchFrame struct {
+    Type     string            `json:"type"`
+    Messages []json.RawMessage `json:"messages"`
+}
+
+// batcher is a connection's pending batch; the writer owns it.
+type batcher struct {
+    window   time.Duration
+    adaptive bool // only batch while the send queue is backed up
+    pending  []outbound
+    timer    *time.Timer
+    due      <-chan time.Time // fires when the pending batch is due; nil when empty
+}
+
+// parseBatchWindow validates the ?batch query value; empty means the
+// server's BATCH_WINDOW.
+func parseBatchWindow(s string) (time.Duration, error) {
+    if s == "" {
+        return 0, nil
+    }
+    d, err := time.ParseDuration(s)
+    if err != nil || d <= 0 {
+        return 0, fmt.Errorf("invalid batch window %q", s)
+    }
+    return d, nil
+}
+
+// newBatcher returns the batcher for a connection asking for window, or
+// nil when neither it nor the server batches.
+func newBatcher(window, serverWindow time.Duration) *batcher {
+    if window > 0 {
+        return &batcher{window: window}
+    }
+    if serverWindow > 0 {
+        return &batcher{window: serverWindow, adaptive: true}
+    }
+    return nil
+}
+
+// batchable reports whether o can go in a batch frame.
+func batchable(o outbound) bool {
+    return o.env != nil && len(o.msg) > 0 && o.msg[0] == '{'
+}
+
+// hold adds o to the pending batch if it belongs in one, starting the
+// window on the first message; a full batch is written at once.
+func (c *Client) hold(o outbound) (bool, error) {
+    b := c.batch
+    if b == nil || !batchable(o) {
+        return false, nil
+    }
+    if len(b.pending) == 0 {
+        if b.adaptive && len(c.sendCh) == 0 {
+            return false, nil
+        }
+        if b.timer == nil {
+            b.timer = time.NewTimer(b.window)
+        } else {
+            b.timer.Reset(b.window)
+        }
+        b.due = b.timer.C
+    }
+    b.pending = append(b.pending, o)
+    if len(b.pending) >= maxBatchMessages {
+        return true, c.flushBatch()
+    }
+    return true, nil
+}
+
+// flushBatch writes the pending batch, a lone message as itself.
+func (c *Client) flushBatch() error {
+    b := c.batch
+    if b == nil || len(b.pending) == 0 {
+        return nil
+    }
+    pending := b.pending
+    b.pending, b.due = nil, nil
+    b.timer.Stop()
+    if len(pending) == 1 {
+        return c.writeFrame(pending[0].msg, !pending[0].noCompress)
+    }
+    frame := BatchFrame{Type: "batch", Messages: make([]json.RawMessage, len(pending))}
+    compressible := true
+    for i, o := range pending {
+        frame.Messages[i] = o.msg
+        compressible = compressible && !o.noCompress
+    }
+    msg, _ := json.Marshal(frame)
+    return c.writeFrame(msg, compressible)
+}
+
+// batchDue is the channel the writer waits on for the pending batch.
+func (c *Client) batchDue() <-chan time.Time {
+    if c.batch == nil {
+        return nil
+    }
+    return c.batch.due
+}
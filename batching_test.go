@@ -0,0 +1,86 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// readFrames returns the raw frames c receives until it is idle.
+func readFrames(c *websocket.Conn, idle time.Duration) [][]byte {
+    var out [][]byte
+    for {
+        c.SetReadDeadline(time.Now().Add(idle))
+        _, raw, err := c.ReadMessage()
+        if err != nil {
+            return out
+        }
+        out = append(out, raw)
+    }
+}
+
+func TestBatchWindowCoalescesIntoOneFrame(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    batched := dialWS(t, base+"/ws/b/slow?batch=300ms")
+    plain := dialWS(t, base+"/ws/b/fast")
+    alice := dialWS(t, base+"/ws/b/alice")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "b") == 3 }) {
+        t.Fatal("clients did not join")
+    }
+    for i := 0; i < 3; i++ {
+        if err := alice.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("m%d", i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    frames := readFrames(batched, 600*time.Millisecond)
+    if len(frames) != 1 {
+        t.Fatalf("batched client got %d frames, want 1: %q", len(frames), frames)
+    }
+    var batch BatchFrame
+    if err := json.Unmarshal(frames[0], &batch); err != nil || batch.Type != "batch" || len(batch.Messages) != 3 {
+        t.Fatalf("batch frame %s: %v", frames[0], err)
+    }
+    for i, raw := range batch.Messages {
+        var env Envelope
+        if err := json.Unmarshal(raw, &env); err != nil || string(env.Payload) != fmt.Sprintf("m%d", i) {
+            t.Fatalf("message %d = %s", i, raw)
+        }
+    }
+
+    if got := readEnvelopes(t, plain, 300*time.Millisecond); len(got) != 3 {
+        t.Fatalf("unbatched client got %d frames, want 3", len(got))
+    }
+}
+
+func TestAdaptiveBatchingOnlyWhenBehind(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    room := hub.getRoom("a")
+    c := &Client{username: "c", room: room, sendCh: make(chan outbound, 4), batch: newBatcher(0, time.Second)}
+    env := NewEnvelope("a", "x", []byte("hi"))
+    o := outbound{msg: renderEnvelopeV1(env), env: &env}
+
+    if held, _ := c.hold(o); held {
+        t.Fatal("held a message with nothing queued behind it")
+    }
+    c.sendCh <- o
+    if held, _ := c.hold(o); !held {
+        t.Fatal("did not batch while the queue is backed up")
+    }
+    if held, _ := c.hold(outbound{msg: []byte{3, 0}, env: &env}); held {
+        t.Fatal("held a binary envelope")
+    }
+}
+
+func TestInvalidBatchWindowRejected(t *testing.T) {
+    base := startTestServer(t, NewHub())
+    for _, q := range []string{"?batch=soon", "?batch=-1s"} {
+        if _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/b/bob"+q, nil); err == nil || resp == nil || resp.StatusCode != 400 {
+            t.Fatalf("%s: want 400", q)
+        }
+    }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+
+    "github.com/gorilla/websocket"
+)
+
+// Binary control frames. Binary-first clients can send control ops in a
+// compact form instead of JSON: a binary frame starting with 0xFF, then an
+// op byte, then the op's fields. A connection opts in with the
+// relay.binary-control subprotocol or the binary_control handshake
+// capability; on such a connection binary frames starting with 0xFF are
+// reserved for control and never relayed: an op the connection does not
+// have on is answered with an error. JSON control frames keep working.
+//
+//	0xFF 0x01 <room>         subscribe (room is the rest of the frame)
+//	0xFF 0x02 <room>         unsubscribe
+//	0xFF 0x03 <0|1>          compression off/on
+//	0xFF 0x04 <uvarint seq>  ack
+//	0xFF 0x05                roster
+const (
+    binaryControlProtocol = "relay.binary-control"
+    binaryControlTag      = 0xFF
+)
+
+var binaryControlOps = map[byte]string{
+    0x01: "subscribe",
+    0x02: "unsubscribe",
+    0x03: "compression",
+    0x04: "ack",
+    0x05: "roster",
+}
+
+var errBinaryControl = errors.New("malformed binary control frame")
+
+// parseBinaryControl reports whether msg is a binary control frame; err is
+// set when it carries the control tag but does not decode.
+func parseBinaryControl(msg []byte) (cf ControlFrame, ok bool, err error) {
+    if len(msg) == 0 || msg[0] != binaryControlTag {
+        return cf, false, nil
+    }
+    if len(msg) < 2 || binaryControlOps[msg[1]] == "" {
+        return cf, true, errBinaryControl
+    }
+    cf.Op = binaryControlOps[msg[1]]
+    fields := msg[2:]
+    switch cf.Op {
+    case "subscribe", "unsubscribe":
+        if len(fields) == 0 {
+            return cf, true, errBinaryControl
+        }
+        cf.Room = string(fields)
+    case "compression":
+        if len(fields) != 1 || fields[0] > 1 {
+            return cf, true, errBinaryControl
+        }
+        cf.Enabled = fields[0] == 1
+    case "ack":
+        seq, n := binary.Uvarint(fields)
+        if n <= 0 || n != len(fields) {
+            return cf, true, errBinaryControl
+        }
+        cf.Seq = seq
+    case "roster":
+        if len(fields) != 0 {
+            return cf, true, errBinaryControl
+        }
+    }
+    return cf, true, nil
+}
+
+// encodeBinaryControl renders cf in the binary control format.
+func encodeBinaryControl(cf ControlFrame) ([]byte, error) {
+    for code, op := range binaryControlOps {
+        if op != cf.Op {
+            continue
+        }
+        b := []byte{binaryControlTag, code}
+        switch op {
+        case "subscribe", "unsubscribe":
+            return append(b, cf.Room...), nil
+        case "compression":
+            if cf.Enabled {
+                return append(b, 1), nil
+            }
+            return append(b, 0), nil
+        case "ack":
+            return binary.AppendUvarint(b, cf.Seq), nil
+        case "roster":
+            return b, nil
+        }
+    }
+    return nil, fmt.Errorf("op %q has no binary encoding", cf.Op)
+}
+
+// control parses msg as a control frame in any encoding c accepts.
+func (c *Client) control(msgType int, msg []byte) (ControlFrame, bool, error) {
+    if msgType == websocket.BinaryMessage && c.binControl {
+        return parseBinaryControl(msg)
+    }
+    cf, ok := parseControl(msgType, msg)
+    return cf, ok, nil
+}
+
+// reservedControl reports whether msg is a binary control frame on c's
+// connection, which must not be relayed even when its op is not handled.
+func (c *Client) reservedControl(msgType int, msg []byte) bool {
+    return msgType == websocket.BinaryMessage && c.binControl && len(msg) > 0 && msg[0] == binaryControlTag
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestControlOpsInBothEncodings(t *testing.T) {
+    cases := []struct {
+        json   string
+        binary []byte
+        want   ControlFrame
+    }{
+        {`{"op":"subscribe","room":"news"}`, []byte("\xff\x01news"), ControlFrame{Op: "subscribe", Room: "news"}},
+        {`{"op":"unsubscribe","room":"news"}`, []byte("\xff\x02news"), ControlFrame{Op: "unsubscribe", Room: "news"}},
+        {`{"op":"compression","enabled":true}`, []byte{0xff, 0x03, 1}, ControlFrame{Op: "compression", Enabled: true}},
+        {`{"op":"compression","enabled":false}`, []byte{0xff, 0x03, 0}, ControlFrame{Op: "compression"}},
+        {`{"op":"ack","seq":300}`, []byte{0xff, 0x04, 0xac, 0x02}, ControlFrame{Op: "ack", Seq: 300}},
+        {`{"op":"roster"}`, []byte{0xff, 0x05}, ControlFrame{Op: "roster"}},
+    }
+    for _, tc := range cases {
+        cf, ok := parseControl(websocket.TextMessage, []byte(tc.json))
+        if !ok || !reflect.DeepEqual(cf, tc.want) {
+            t.Errorf("JSON %s = %+v, %v; want %+v", tc.json, cf, ok, tc.want)
+        }
+        cf, ok, err := parseBinaryControl(tc.binary)
+        if !ok || err != nil || !reflect.DeepEqual(cf, tc.want) {
+            t.Errorf("binary %x = %+v, %v, %v; want %+v", tc.binary, cf, ok, err, tc.want)
+        }
+        enc, err := encodeBinaryControl(tc.want)
+        if err != nil || !reflect.DeepEqual(enc, tc.binary) {
+            t.Errorf("encode %+v = %x, %v; want %x", tc.want, enc, err, tc.binary)
+        }
+    }
+}
+
+func TestParseBinaryControlRejectsMalformed(t *testing.T) {
+    if _, ok, err := parseBinaryControl([]byte("plain data")); ok || err != nil {
+        t.Fatalf("data frame parsed as control: ok=%v err=%v", ok, err)
+    }
+    for _, bad := range [][]byte{{0xff}, {0xff, 0x09}, {0xff, 0x01}, {0xff, 0x03, 2}, {0xff, 0x04}, {0xff, 0x04, 0x80}, {0xff, 0x05, 0}} {
+        if _, ok, err := parseBinaryControl(bad); !ok || err == nil {
+            t.Errorf("%x: ok=%v err=%v, want a malformed control frame", bad, ok, err)
+        }
+    }
+    if _, err := encodeBinaryControl(ControlFrame{Op: "hello"}); err == nil {
+        t.Error("hello has no binary encoding")
+    }
+}
+
+func TestBinaryControlSelectedBySubprotocol(t *testing.T) {
+    hub := NewHubWithConfig(Config{SingleRoomOnly: true})
+    base := startTestServer(t, hub)
+    dialer := websocket.Dialer{Subprotocols: []string{binaryControlProtocol}}
+    c, _, err := dialer.Dial(base+"/ws/lobby/alice", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    if c.Subprotocol() != binaryControlProtocol {
+        t.Fatalf("negotiated subprotocol %q", c.Subprotocol())
+    }
+    plain := dialWS(t, base+"/ws/lobby/bob")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+
+    // a binary subscribe is handled as control (and refused here)
+    frame, _ := encodeBinaryControl(ControlFrame{Op: "subscribe", Room: "other"})
+    if err := c.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    var ef ErrorFrame
+    if err := c.ReadJSON(&ef); err != nil || ef.Code != "single_room_only" {
+        t.Fatalf("binary subscribe got %+v (%v), want single_room_only", ef, err)
+    }
+    // without the subprotocol the same bytes are data
+    if err := plain.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    var env Envelope
+    if err := c.ReadJSON(&env); err != nil || !reflect.DeepEqual(env.Payload, frame) {
+        t.Fatalf("plain client's frame arrived as %+v (%v), want relayed data", env, err)
+    }
+}
+
+func TestBinaryControlNeverRelayed(t *testing.T) {
+    hub := NewHub() // SINGLE_ROOM_ONLY off and no deflate: none of these ops are on
+    base := startTestServer(t, hub)
+    dialer := websocket.Dialer{Subprotocols: []string{binaryControlProtocol}}
+    c, _, err := dialer.Dial(base+"/ws/lobby/alice", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    peer := dialWS(t, base+"/ws/lobby/bob")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+
+    for _, cf := range []ControlFrame{{Op: "subscribe", Room: "other"}, {Op: "unsubscribe", Room: "other"}, {Op: "compression", Enabled: true}} {
+        frame, _ := encodeBinaryControl(cf)
+        if err := c.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+            t.Fatal(err)
+        }
+        expectErrorFrame(t, c, "bad_control")
+    }
+    // roster is answered in its binary form too
+    frame, _ := encodeBinaryControl(ControlFrame{Op: "roster"})
+    if err := c.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    var rf RosterFrame
+    if err := c.ReadJSON(&rf); err != nil || rf.Type != "roster" || len(rf.Users) != 2 {
+        t.Fatalf("binary roster got %+v (%v)", rf, err)
+    }
+    if got := readEnvelopes(t, peer, 200*time.Millisecond); len(got) != 0 {
+        t.Fatalf("peer got %v, want no control frame relayed", got)
+    }
+}
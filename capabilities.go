@@ -0,0 +1,129 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "sort"
+    "sync"
+)
+
+// GET /capabilities lets a client see what it can negotiate, and within
+// which limits, before it connects. It needs no token and is built from
+// the configuration and the loaded feature flags, which do not change
+// while the server runs, so it is rendered once and served with an ETag.
+
+// ServerCapabilities is the /capabilities payload.
+type ServerCapabilities struct {
+    Envelopes        []int              `json:"envelopes"` // ?ver= values
+    DefaultEnvelope  int                `json:"default_envelope"`
+    Codecs           []string           `json:"codecs"`
+    Subprotocols     []string           `json:"subprotocols"`
+    Compression      CompressionSupport `json:"compression"`
+    Handshake        bool               `json:"handshake"` // a hello frame is awaited, see HANDSHAKE_TIMEOUT
+    AuthRequired     bool               `json:"auth_required"`
+    QueryOptions     []string           `json:"query_options"`
+    OverflowPolicies []string           `json:"overflow_policies"`
+    Features         []string           `json:"features"` // feature flags a connection may get
+    Limits           ServerLimits       `json:"limits"`
+}
+
+type CompressionSupport struct {
+    Deflate   bool `json:"deflate"` // permessage-deflate is offered
+    Level     int  `json:"level,omitempty"`
+    Threshold int  `json:"threshold,omitempty"`
+}
+
+// ServerLimits are the active limits; 0 means unlimited or off.
+type ServerLimits struct {
+    MaxMessageBytes    int64   `json:"max_message_bytes"`
+    MaxMsgsPerSec      float64 `json:"max_msgs_per_sec"`
+    MaxControlPerSec   float64 `json:"max_control_per_sec"`
+    MaxConnections     int     `json:"max_connections"`
+    MaxConnsPerIP      int     `json:"max_conns_per_ip"`
+    MaxClientsPerRoom  int     `json:"max_clients_per_room"`
+    MaxRoomsPerUser    int     `json:"max_rooms_per_identity"`
+    SendBufferSize     int     `json:"send_buffer_size"`
+    PingIntervalMs     int64   `json:"ping_interval_ms"`
+    ReadTimeoutMs      int64   `json:"read_timeout_ms"`
+    WriteTimeoutMs     int64   `json:"write_timeout_ms"`
+    HandshakeTimeoutMs int64   `json:"handshake_timeout_ms"`
+    MinStatsIntervalMs int64   `json:"min_stats_interval_ms"`
+}
+
+// capabilityQueryOptions are the query parameters /ws understands.
+var capabilityQueryOptions = []string{
+    "batch", "ctype", "echo", "labels", "max_overhead", "overflow", "pace", "recent",
+    "resume", "role", "route", "sent", "stats", "ttl", "ver", "vip",
+}
+
+func (h *Hub) capabilities() ServerCapabilities {
+    cfg := h.cfg
+    versions := make([]int, 0, len(envelopeRenderers))
+    for v := range envelopeRenderers {
+        versions = append(versions, v)
+    }
+    sort.Ints(versions)
+    h.mu.RLock()
+    features := make([]string, 0, len(h.features))
+    for name := range h.features {
+        features = append(features, name)
+    }
+    h.mu.RUnlock()
+    sort.Strings(features)
+    return ServerCapabilities{
+        Envelopes:        versions,
+        DefaultEnvelope:  defaultEnvelopeVersion,
+        Codecs:           []string{payloadCodec},
+        Subprotocols:     []string{binaryControlProtocol},
+        Compression:      CompressionSupport{Deflate: true, Level: cfg.CompressionLevel, Threshold: cfg.CompressThreshold},
+        Handshake:        cfg.HandshakeTimeout > 0,
+        AuthRequired:     cfg.AuthToken != "",
+        QueryOptions:     capabilityQueryOptions,
+        OverflowPolicies: []string{OverflowDropNew, OverflowDropOld, OverflowClose},
+        Features:         features,
+        Limits: ServerLimits{
+            MaxMessageBytes:    cfg.MaxMessageBytes,
+            MaxMsgsPerSec:      cfg.MaxMsgsPerSec,
+            MaxControlPerSec:   cfg.MaxControlPerSec,
+            MaxConnections:     cfg.MaxConnections,
+            MaxConnsPerIP:      cfg.MaxConnsPerIP,
+            MaxClientsPerRoom:  cfg.MaxClientsPerRoom,
+            MaxRoomsPerUser:    cfg.MaxRoomsPerIdentity,
+            SendBufferSize:     cfg.sendBufferSize(),
+            PingIntervalMs:     cfg.PingInterval.Milliseconds(),
+            ReadTimeoutMs:      cfg.readWait().Milliseconds(),
+            WriteTimeoutMs:     cfg.writeTimeout().Milliseconds(),
+            HandshakeTimeoutMs: cfg.HandshakeTimeout.Milliseconds(),
+            MinStatsIntervalMs: cfg.MinStatsInterval.Milliseconds(),
+        },
+    }
+}
+
+// capabilitiesHandler serves /capabilities, rendered on first use.
+func capabilitiesHandler(hub *Hub) http.HandlerFunc {
+    var once sync.Once
+    var body []byte
+    var etag string
+    return func(w http.ResponseWriter, r *http.Request) {
+        applyCORSHeaders(w, hub.cfg.AllowedOrigin)
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        once.Do(func() {
+            body, _ = json.Marshal(hub.capabilities())
+            sum := sha256.Sum256(body)
+            etag = `"` + hex.EncodeToString(sum[:8]) + `"`
+        })
+        w.Header().Set("Cache-Control", "public, max-age=300")
+        w.Header().Set("ETag", etag)
+        if r.Header.Get("If-None-Match") == etag {
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.Write(body)
+    }
+}
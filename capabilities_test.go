@@ -0,0 +1,75 @@
+package main
+
+import (
+    "net/http"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestCapabilitiesMatchConfig(t *testing.T) {
+    hub := NewHubWithConfig(Config{
+        MaxMessageBytes:  4096,
+        MaxMsgsPerSec:    50,
+        MaxConnsPerIP:    3,
+        SendBufferSize:   32,
+        PingInterval:     20 * time.Second,
+        HandshakeTimeout: time.Second,
+        CompressionLevel: 5,
+        AuthToken:        "secret",
+    })
+    if err := hub.LoadFeatureFlags(`{"beta":{"percent":10},"alpha":{"identities":["bob"]}}`); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+
+    var caps ServerCapabilities
+    getJSON(t, base, "/capabilities", &caps)
+    if !reflect.DeepEqual(caps.Envelopes, []int{1, 2, 3, 4}) || caps.DefaultEnvelope != defaultEnvelopeVersion {
+        t.Fatalf("envelopes %v default %d", caps.Envelopes, caps.DefaultEnvelope)
+    }
+    if !caps.Handshake || !caps.AuthRequired || !caps.Compression.Deflate || caps.Compression.Level != 5 {
+        t.Fatalf("capabilities %+v", caps)
+    }
+    if !reflect.DeepEqual(caps.Features, []string{"alpha", "beta"}) {
+        t.Fatalf("features %v", caps.Features)
+    }
+    want := ServerLimits{
+        MaxMessageBytes:    4096,
+        MaxMsgsPerSec:      50,
+        MaxConnsPerIP:      3,
+        SendBufferSize:     32,
+        PingIntervalMs:     20000,
+        ReadTimeoutMs:      hub.cfg.readWait().Milliseconds(),
+        WriteTimeoutMs:     defaultWriteTimeout.Milliseconds(),
+        HandshakeTimeoutMs: 1000,
+    }
+    if caps.Limits != want {
+        t.Fatalf("limits %+v, want %+v", caps.Limits, want)
+    }
+}
+
+func TestCapabilitiesAreCacheable(t *testing.T) {
+    base := startTestServer(t, NewHub())
+    url := strings.Replace(base, "ws://", "http://", 1) + "/capabilities"
+    resp, err := http.Get(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    etag := resp.Header.Get("ETag")
+    if resp.StatusCode != http.StatusOK || etag == "" || !strings.Contains(resp.Header.Get("Cache-Control"), "max-age") {
+        t.Fatalf("status %d, headers %v", resp.StatusCode, resp.Header)
+    }
+    req, _ := http.NewRequest(http.MethodGet, url, nil)
+    req.Header.Set("If-None-Match", etag)
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotModified {
+        t.Fatalf("revalidation got %d, want 304", resp.StatusCode)
+    }
+}
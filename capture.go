@@ -0,0 +1,96 @@
+package main
+
+import (
+    "encoding/json"
+    "math"
+    "net/http"
+    "sync"
+    "sync/atomic"
+)
+
+// Sampled message capture for debugging (CAPTURE_SAMPLE_RATE). A fraction
+// of broadcasts is kept in full, metadata and payload as delivered, in a
+// ring of the last CAPTURE_MAX samples, served to admins at /capture.
+// Sampling is deterministic: with rate r, the n-th broadcast is kept when
+// floor(n*r) steps up, so 0.25 keeps every fourth. Payloads from E2EE rooms
+// are never captured; their samples carry metadata only.
+
+// CapturedMessage is one sample in /capture.
+type CapturedMessage struct {
+    Room      string `json:"room"`
+    From      string `json:"from"`
+    Ts        int64  `json:"ts"`
+    SenderSeq uint64 `json:"sender_seq,omitempty"`
+    Size      int    `json:"size"`
+    Payload   []byte `json:"payload,omitempty"`
+    Redacted  bool   `json:"redacted,omitempty"` // payload withheld (E2EE room)
+}
+
+type captureRing struct {
+    rate float64
+    seen atomic.Uint64
+
+    mu   sync.Mutex
+    buf  []CapturedMessage
+    next int
+    full bool
+}
+
+// newCaptureRing returns nil when rate is 0.
+func newCaptureRing(rate float64, size int) *captureRing {
+    if rate <= 0 {
+        return nil
+    }
+    return &captureRing{rate: math.Min(rate, 1), buf: make([]CapturedMessage, max(size, 1))}
+}
+
+// sample considers a broadcast in r for capture.
+func (cr *captureRing) sample(r *Room, env Envelope) {
+    if cr == nil {
+        return
+    }
+    n := float64(cr.seen.Add(1))
+    if math.Floor(n*cr.rate) == math.Floor((n-1)*cr.rate) {
+        return
+    }
+    m := CapturedMessage{Room: r.logicalName(), From: env.Username, Ts: env.Ts, SenderSeq: env.SenderSeq, Size: len(env.Payload)}
+    if r.e2ee {
+        m.Redacted = true
+    } else {
+        m.Payload = append([]byte(nil), env.Payload...)
+    }
+    cr.mu.Lock()
+    cr.buf[cr.next] = m
+    cr.next = (cr.next + 1) % len(cr.buf)
+    cr.full = cr.full || cr.next == 0
+    cr.mu.Unlock()
+}
+
+// snapshot returns the captured samples, oldest first.
+func (cr *captureRing) snapshot() []CapturedMessage {
+    cr.mu.Lock()
+    defer cr.mu.Unlock()
+    if !cr.full {
+        return append([]CapturedMessage(nil), cr.buf[:cr.next]...)
+    }
+    return append(append([]CapturedMessage(nil), cr.buf[cr.next:]...), cr.buf[:cr.next]...)
+}
+
+// captureHandler serves /capture to requests bearing ADMIN_TOKEN.
+func captureHandler(hub *Hub) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if hub.cfg.AdminToken == "" || hub.capture == nil {
+            http.NotFound(w, r)
+            return
+        }
+        if !authorizedAdmin(r, hub.cfg.AdminToken) {
+            hub.audit(r, auditCaptureRead, "", auditDenied)
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        hub.audit(r, auditCaptureRead, "", auditOK)
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(hub.capture.snapshot())
+    }
+}
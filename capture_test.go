@@ -0,0 +1,73 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "testing"
+)
+
+func getCapture(t *testing.T, wsBase, token string) (int, []CapturedMessage) {
+    t.Helper()
+    req, _ := http.NewRequest(http.MethodGet, "http"+wsBase[len("ws"):]+"/capture", nil)
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    var got []CapturedMessage
+    if resp.StatusCode == http.StatusOK {
+        if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return resp.StatusCode, got
+}
+
+func TestCaptureSamplesAndSkipsE2EEPayloads(t *testing.T) {
+    hub := NewHubWithConfig(Config{CaptureSampleRate: 0.5, CaptureMax: 3, E2EERooms: "secret", AdminToken: "adm"})
+    base := startTestServer(t, hub)
+    open := hub.getRoom("open")
+    sender := fakeClient(open, "alice", 16)
+    fakeClient(open, "bob", 16)
+    for i := 1; i <= 8; i++ {
+        open.broadcast(sender, NewEnvelope("open", "alice", []byte(fmt.Sprintf("m%d", i))))
+    }
+    secret := hub.getRoom("secret")
+    spy := fakeClient(secret, "eve", 16)
+    fakeClient(secret, "mallory", 16)
+    for i := 9; i <= 10; i++ {
+        secret.broadcast(spy, NewEnvelope("secret", "eve", []byte("ciphertext")))
+    }
+
+    if code, _ := getCapture(t, base, ""); code != http.StatusUnauthorized {
+        t.Fatalf("capture without token: %d", code)
+    }
+    code, got := getCapture(t, base, "adm")
+    if code != http.StatusOK {
+        t.Fatalf("capture: %d", code)
+    }
+    // every second broadcast sampled (m2, m4, m6, m8, 10th), the last three kept
+    if len(got) != 3 {
+        t.Fatalf("captured %d samples, want 3: %+v", len(got), got)
+    }
+    for i, want := range []string{"m6", "m8"} {
+        if got[i].Room != "open" || got[i].From != "alice" || string(got[i].Payload) != want || got[i].Size != 2 || got[i].Redacted {
+            t.Fatalf("sample %d = %+v, want %s", i, got[i], want)
+        }
+    }
+    if e := got[2]; e.Room != "secret" || !e.Redacted || e.Payload != nil || e.Size != len("ciphertext") {
+        t.Fatalf("e2ee sample %+v, want metadata only", e)
+    }
+}
+
+func TestCaptureDisabledByDefault(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "adm"})
+    base := startTestServer(t, hub)
+    if code, _ := getCapture(t, base, "adm"); code != http.StatusNotFound {
+        t.Fatalf("capture while off: %d", code)
+    }
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Server-assigned client IDs. Unlike the username, which the client picks,
+// the ID is issued by the server inside an HMAC-signed token; presenting the
+// token again (?resume=<token>) reclaims the same ID as long as it was seen
+// within the TTL. Expired IDs are dropped when a resume finds them and by a
+// background sweep, so connecting does not scan every ID ever issued. The
+// ID goes out with the connection's messages, presence and roster entries.
+type clientIDRegistry struct {
+    secret []byte
+    ttl    time.Duration
+
+    mu    sync.Mutex
+    state map[string]*clientIDState
+}
+
+type clientIDState struct {
+    lastSeen time.Time
+    conns    int
+}
+
+// SessionFrame is the first frame sent to a connection when client IDs are enabled.
+type SessionFrame struct {
+    Type     string `json:"type"`
+    ClientID string `json:"client_id"`
+    Token    string `json:"token"`
+}
+
+func newClientIDRegistry(secret string, ttl time.Duration) *clientIDRegistry {
+    if ttl <= 0 {
+        ttl = 24 * time.Hour
+    }
+    return &clientIDRegistry{secret: []byte(secret), ttl: ttl, state: make(map[string]*clientIDState)}
+}
+
+func (r *clientIDRegistry) sign(id string) string {
+    mac := hmac.New(sha256.New, r.secret)
+    mac.Write([]byte(id))
+    return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the ID carried by a token with a valid signature.
+func (r *clientIDRegistry) verify(token string) (string, bool) {
+    id, _, ok := strings.Cut(token, ".")
+    if !ok || id == "" {
+        return "", false
+    }
+    if !hmac.Equal([]byte(r.sign(id)), []byte(token)) {
+        return "", false
+    }
+    return id, true
+}
+
+// acquire resolves the ID for a new connection: the one in resumeToken if it
+// is authentic and still retained, otherwise a freshly issued ID.
+func (r *clientIDRegistry) acquire(resumeToken string) (id, token string) {
+    now := time.Now()
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if resumed, ok := r.verify(resumeToken); ok {
+        if st, ok := r.state[resumed]; ok {
+            if !r.expired(st, now) {
+                st.conns++
+                st.lastSeen = now
+                return resumed, resumeToken
+            }
+            delete(r.state, resumed)
+        }
+    }
+    var b [16]byte
+    _, _ = rand.Read(b[:])
+    id = hex.EncodeToString(b[:])
+    r.state[id] = &clientIDState{lastSeen: now, conns: 1}
+    return id, r.sign(id)
+}
+
+// release marks one connection of id as gone; the TTL starts counting once
+// the last connection for the ID disconnects.
+func (r *clientIDRegistry) release(id string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if st, ok := r.state[id]; ok {
+        st.conns--
+        st.lastSeen = time.Now()
+    }
+}
+
+// expired reports whether st has had no connection for longer than the TTL.
+func (r *clientIDRegistry) expired(st *clientIDState, now time.Time) bool {
+    return st.conns <= 0 && now.Sub(st.lastSeen) > r.ttl
+}
+
+// sweep drops every expired ID.
+func (r *clientIDRegistry) sweep(now time.Time) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for id, st := range r.state {
+        if r.expired(st, now) {
+            delete(r.state, id)
+        }
+    }
+}
+
+// sweepLoop sweeps every half TTL until ctx ends.
+func (r *clientIDRegistry) sweepLoop(ctx context.Context) {
+    t := time.NewTicker(max(r.ttl/2, time.Millisecond))
+    defer t.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-t.C:
+            r.sweep(now)
+        }
+    }
+}
+
+func sessionFrame(id, token string) []byte {
+    b, _ := json.Marshal(SessionFrame{Type: "session", ClientID: id, Token: token})
+    return b
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "encoding/json"
+    "net/url"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestClientIDRegistry(t *testing.T) {
+    reg := newClientIDRegistry("secret", time.Hour)
+    id, token := reg.acquire("")
+    if id == "" || token == "" {
+        t.Fatal("expected an issued id and token")
+    }
+    reg.release(id)
+
+    if got, _ := reg.acquire(token); got != id {
+        t.Fatalf("resume returned %q, want %q", got, id)
+    }
+    reg.release(id)
+
+    tampered := token[:len(token)-1] + "x"
+    if token[len(token)-1] == 'x' {
+        tampered = token[:len(token)-1] + "y"
+    }
+    if got, _ := reg.acquire(tampered); got == id {
+        t.Fatal("tampered token must not resume the id")
+    }
+    other := newClientIDRegistry("other-secret", time.Hour)
+    if got, _ := other.acquire(token); got == id {
+        t.Fatal("token signed with another secret must not resume the id")
+    }
+}
+
+func TestClientIDExpiresAfterTTL(t *testing.T) {
+    reg := newClientIDRegistry("secret", 20*time.Millisecond)
+    id, token := reg.acquire("")
+    time.Sleep(40 * time.Millisecond)
+    // still connected: the TTL only runs once the ID has no connections
+    if got, _ := reg.acquire(token); got != id {
+        t.Fatal("connected id expired")
+    }
+    reg.release(id)
+    reg.release(id)
+    time.Sleep(40 * time.Millisecond)
+    if got, _ := reg.acquire(token); got == id {
+        t.Fatal("expected id to expire after TTL")
+    }
+}
+
+func readSession(t *testing.T, c *websocket.Conn) SessionFrame {
+    t.Helper()
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := c.ReadMessage()
+    if err != nil {
+        t.Fatalf("read session frame: %v", err)
+    }
+    var sf SessionFrame
+    if err := json.Unmarshal(raw, &sf); err != nil || sf.Type != "session" {
+        t.Fatalf("unexpected session frame %s (%v)", raw, err)
+    }
+    return sf
+}
+
+func TestClientIDSurvivesReconnect(t *testing.T) {
+    hub := NewHubWithConfig(Config{ClientIDSecret: "s3cret", ClientIDTTL: time.Minute})
+    base := startTestServer(t, hub)
+
+    first := dialWS(t, base+"/ws/r/alice")
+    sf := readSession(t, first)
+    first.Close()
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 0 })
+
+    again := dialWS(t, base+"/ws/r/alice?resume="+url.QueryEscape(sf.Token))
+    if got := readSession(t, again); got.ClientID != sf.ClientID {
+        t.Fatalf("reconnect got id %q, want %q", got.ClientID, sf.ClientID)
+    }
+
+    fresh := dialWS(t, base+"/ws/r/alice")
+    if got := readSession(t, fresh); got.ClientID == sf.ClientID {
+        t.Fatal("connection without resume token reused an id")
+    }
+}
+
+func TestClientIDSweepDropsExpiredIDs(t *testing.T) {
+    reg := newClientIDRegistry("secret", 20*time.Millisecond)
+    gone, _ := reg.acquire("")
+    reg.release(gone)
+    live, _ := reg.acquire("")
+    time.Sleep(40 * time.Millisecond)
+    reg.acquire("") // connecting leaves the sweep to the background
+    if len(reg.state) != 3 {
+        t.Fatalf("%d ids after a connect, want all 3 until the sweep", len(reg.state))
+    }
+    reg.sweep(time.Now())
+    if _, ok := reg.state[gone]; ok || len(reg.state) != 2 {
+        t.Fatalf("sweep kept %d ids, want only the 2 still connected", len(reg.state))
+    }
+    if _, ok := reg.state[live]; !ok {
+        t.Fatal("sweep dropped a connected id")
+    }
+}
+
+func TestClientIDInEnvelopesPresenceAndRoster(t *testing.T) {
+    hub := NewHubWithConfig(Config{ClientIDSecret: "s3cret", PresenceRooms: "r"})
+    base := startTestServer(t, hub)
+    bob := dialWS(t, base+"/ws/r/bob")
+    bobID := readSession(t, bob).ClientID
+    alice := dialWS(t, base+"/ws/r/alice")
+    aliceID := readSession(t, alice).ClientID
+
+    next := func() []byte {
+        t.Helper()
+        bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+        _, raw, err := bob.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        return raw
+    }
+    var env Envelope
+    var ev PresenceEvent
+    if err := json.Unmarshal(next(), &env); err != nil || env.Type != envelopePresence {
+        t.Fatalf("bob got %+v (%v), want alice's join", env, err)
+    }
+    if err := json.Unmarshal(env.Payload, &ev); err != nil || ev.ClientID != aliceID {
+        t.Fatalf("presence %s, want client_id %q", env.Payload, aliceID)
+    }
+
+    if err := alice.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+        t.Fatal(err)
+    }
+    if err := json.Unmarshal(next(), &env); err != nil || env.ClientID != aliceID {
+        t.Fatalf("bob got %+v (%v), want alice's message with client_id %q", env, err, aliceID)
+    }
+
+    if err := bob.WriteMessage(websocket.TextMessage, []byte(`{"op":"roster"}`)); err != nil {
+        t.Fatal(err)
+    }
+    raw := next()
+    var roster RosterFrame
+    json.Unmarshal(raw, &roster)
+    want := []RosterMember{{"alice", aliceID}, {"bob", bobID}}
+    if len(roster.Members) != 2 || roster.Members[0] != want[0] || roster.Members[1] != want[1] {
+        t.Fatalf("roster members %+v, want %+v", roster.Members, want)
+    }
+}
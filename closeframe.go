@@ -0,0 +1,44 @@
+package main
+
+import (
+    "errors"
+    "log"
+
+    "github.com/gorilla/websocket"
+)
+
+// Close frames from clients. The close handler records the code and
+// reason a client closes with (before answering it as gorilla would), so
+// the reader loop can tell a client that said goodbye (1000 normal,
+// 1001 going away) from a connection that failed, and log them apart.
+// With PRESENCE_CLOSE_REASON set, presence rooms pass the client's code
+// and reason on in its "leave" event.
+
+// peerClose is the close frame a client sent.
+type peerClose struct {
+    code   int
+    reason string
+}
+
+// watchClose installs c's close handler; call it before the reader loop.
+func (c *Client) watchClose() {
+    reply := c.conn.CloseHandler()
+    c.conn.SetCloseHandler(func(code int, text string) error {
+        c.closedBy = &peerClose{code: code, reason: text}
+        return reply(code, text)
+    })
+}
+
+// logReadEnd logs why c's reader loop ended with err.
+func (c *Client) logReadEnd(err error) {
+    if cause := c.stopped(); cause != nil {
+        log.Printf("reader stopped: %v: room=%s user=%s", cause, c.room.name, c.username)
+        return
+    }
+    var ce *websocket.CloseError
+    if errors.As(err, &ce) && !websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+        log.Printf("client closed normally: code=%d reason=%q room=%s user=%s", ce.Code, ce.Text, c.room.name, c.username)
+        return
+    }
+    log.Printf("connection closed abnormally: %v: room=%s user=%s", err, c.room.name, c.username)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "log"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// syncBuffer is a bytes.Buffer safe to log into from server goroutines.
+type syncBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.String()
+}
+
+func captureLog(t *testing.T) *syncBuffer {
+    var out syncBuffer
+    prev := log.Writer()
+    log.SetOutput(&out)
+    t.Cleanup(func() { log.SetOutput(prev) })
+    return &out
+}
+
+func TestNormalCloseLogged(t *testing.T) {
+    logs := captureLog(t)
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/r/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 })
+    msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "done for today")
+    if err := c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+        t.Fatal(err)
+    }
+    want := `client closed normally: code=1000 reason="done for today" room=r user=alice`
+    if !waitFor(2*time.Second, func() bool { return strings.Contains(logs.String(), want) }) {
+        t.Fatalf("log lacks %q:\n%s", want, logs.String())
+    }
+    // the server answers the close frame as before
+    c.SetReadDeadline(time.Now().Add(time.Second))
+    if _, _, err := c.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+        t.Fatalf("client read %v, want the echoed close", err)
+    }
+}
+
+func TestAbnormalCloseLogged(t *testing.T) {
+    logs := captureLog(t)
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/r/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 })
+    c.UnderlyingConn().Close() // no close frame
+    if !waitFor(2*time.Second, func() bool {
+        return strings.Contains(logs.String(), "connection closed abnormally")
+    }) {
+        t.Fatalf("abnormal close not logged:\n%s", logs.String())
+    }
+    if strings.Contains(logs.String(), "closed normally") {
+        t.Fatal("a dropped connection was logged as a normal close")
+    }
+}
+
+func TestPresenceLeaveCarriesCloseReason(t *testing.T) {
+    hub := NewHubWithConfig(Config{PresenceRooms: "lobby", PresenceCloseReason: true})
+    base := startTestServer(t, hub)
+    bob := dialWS(t, base+"/ws/lobby/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 1 })
+    alice := dialWS(t, base+"/ws/lobby/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 2 })
+    msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "tab closed")
+    alice.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+
+    for {
+        bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+        var env Envelope
+        if err := bob.ReadJSON(&env); err != nil {
+            t.Fatal(err)
+        }
+        var ev PresenceEvent
+        json.Unmarshal(env.Payload, &ev)
+        if ev.Event != presenceLeave {
+            continue
+        }
+        want := PresenceEvent{Event: presenceLeave, Username: "alice", Code: websocket.CloseGoingAway, Reason: "tab closed"}
+        if ev != want {
+            t.Fatalf("leave event %+v, want %+v", ev, want)
+        }
+        return
+    }
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Keyed coalescing for state-sync rooms (COALESCE_ROOMS). Messages that are
+// JSON objects with a string "key" field are held for COALESCE_WINDOW and
+// only the latest value per key is broadcast; superseded updates are
+// dropped. Messages without a key are relayed immediately.
+type coalescer struct {
+    room   *Room
+    window time.Duration
+
+    mu      sync.Mutex
+    pending map[string]coalescedMsg
+    order   []string // keys in first-seen order within the window
+}
+
+type coalescedMsg struct {
+    sender *Client
+    env    Envelope
+}
+
+func newCoalescer(room *Room, window time.Duration) *coalescer {
+    if window <= 0 {
+        window = 50 * time.Millisecond
+    }
+    return &coalescer{room: room, window: window}
+}
+
+// coalesceKey extracts the "key" field from a JSON object payload.
+func coalesceKey(msg []byte) string {
+    if len(msg) == 0 || msg[0] != '{' {
+        return ""
+    }
+    var tagged struct {
+        Key string `json:"key"`
+    }
+    if err := json.Unmarshal(msg, &tagged); err != nil {
+        return ""
+    }
+    return tagged.Key
+}
+
+// add buffers env under key, replacing any pending value; the first add in
+// a window arms the flush timer.
+func (co *coalescer) add(key string, sender *Client, env Envelope) {
+    co.mu.Lock()
+    defer co.mu.Unlock()
+    if co.pending == nil {
+        co.pending = make(map[string]coalescedMsg)
+        time.AfterFunc(co.window, co.flush)
+    }
+    if prev, ok := co.pending[key]; ok {
+        co.room.hub.dead.add(dropSuperseded, co.room.name, prev.env.Username, "", prev.env.Payload)
+    } else {
+        co.order = append(co.order, key)
+    }
+    co.pending[key] = coalescedMsg{sender: sender, env: env}
+}
+
+func (co *coalescer) flush() {
+    co.mu.Lock()
+    pending, order := co.pending, co.order
+    co.pending, co.order = nil, nil
+    co.mu.Unlock()
+    for _, key := range order {
+        m := pending[key]
+        co.room.publish(m.sender, m.env)
+    }
+}
+
+func inList(list, name string) bool {
+    for _, item := range strings.Split(list, ",") {
+        if strings.TrimSpace(item) == name {
+            return true
+        }
+    }
+    return false
+}
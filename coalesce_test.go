@@ -0,0 +1,71 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestCoalesceKey(t *testing.T) {
+    cases := map[string]string{
+        `{"key":"pos","x":1}`: "pos",
+        `{"x":1}`:             "",
+        `{"key":7}`:           "",
+        `hello`:               "",
+        ``:                    "",
+    }
+    for in, want := range cases {
+        if got := coalesceKey([]byte(in)); got != want {
+            t.Errorf("coalesceKey(%q) = %q, want %q", in, got, want)
+        }
+    }
+}
+
+func TestCoalescedRoomDeliversLatestPerKey(t *testing.T) {
+    hub := NewHubWithConfig(Config{CoalesceRooms: "state", CoalesceWindow: 100 * time.Millisecond})
+    base := startTestServer(t, hub)
+    sender := dialWS(t, base+"/ws/state/writer")
+    receiver := dialWS(t, base+"/ws/state/reader")
+    waitFor(time.Second, func() bool { return roomSize(hub, "state") == 2 })
+
+    for i := 0; i < 20; i++ {
+        msg := fmt.Sprintf(`{"key":"pos","v":%d}`, i)
+        if err := sender.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"key":"hp","v":3}`)); err != nil {
+        t.Fatal(err)
+    }
+    if err := sender.WriteMessage(websocket.TextMessage, []byte("unkeyed")); err != nil {
+        t.Fatal(err)
+    }
+
+    got := readEnvelopes(t, receiver, 500*time.Millisecond)
+    var payloads []string
+    for _, env := range got {
+        payloads = append(payloads, string(env.Payload))
+    }
+    want := []string{"unkeyed", `{"key":"pos","v":19}`, `{"key":"hp","v":3}`}
+    if fmt.Sprint(payloads) != fmt.Sprint(want) {
+        t.Fatalf("delivered %q, want %q", payloads, want)
+    }
+}
+
+func TestUncoalescedRoomRelaysEveryUpdate(t *testing.T) {
+    hub := NewHubWithConfig(Config{CoalesceRooms: "state"})
+    base := startTestServer(t, hub)
+    sender := dialWS(t, base+"/ws/other/writer")
+    receiver := dialWS(t, base+"/ws/other/reader")
+    waitFor(time.Second, func() bool { return roomSize(hub, "other") == 2 })
+    for i := 0; i < 5; i++ {
+        if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"key":"pos"}`)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if got := readEnvelopes(t, receiver, 300*time.Millisecond); len(got) != 5 {
+        t.Fatalf("got %d messages, want 5", len(got))
+    }
+}
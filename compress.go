@@ -0,0 +1,126 @@
+package main
+
+import (
+    "bufio"
+    "compress/flate"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "sync/atomic"
+)
+
+// Runtime write compression. permessage-deflate is negotiated with every
+// client that offers it, but writes start uncompressed; a client turns
+// compression on or off at any time with {"op":"compression","enabled":b}
+// (or the compression capability in the handshake), and the writer applies
+// it to the frames that follow. With COMPRESSION_LEVEL set, deflate
+// connections instead start compressed, at that level, and may turn it off.
+// Either way frames under COMPRESS_THRESHOLD bytes go out uncompressed:
+// deflating a tiny frame costs CPU and can even grow it.
+//
+// To report how well that pays off, deflate connections count the bytes
+// that reach the socket: for each frame written while compression is on,
+// the writer records its uncompressed and on-the-wire sizes, and /stats
+// reports their ratio per member and per room.
+
+// offersDeflate reports whether the upgrade request offers
+// permessage-deflate, which the upgrader then accepts.
+func offersDeflate(r *http.Request) bool {
+    for _, v := range r.Header.Values("Sec-Websocket-Extensions") {
+        for _, ext := range strings.Split(v, ",") {
+            name, _, _ := strings.Cut(ext, ";")
+            if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// validCompressionLevel checks COMPRESSION_LEVEL: 0 for the default, or a
+// flate level from -2 (Huffman only) to 9.
+func validCompressionLevel(level int) error {
+    if level < flate.HuffmanOnly || level > flate.BestCompression {
+        return fmt.Errorf("invalid COMPRESSION_LEVEL %d (want %d to %d)", level, flate.HuffmanOnly, flate.BestCompression)
+    }
+    return nil
+}
+
+// initCompression applies COMPRESSION_LEVEL and COMPRESS_THRESHOLD to a new
+// connection, before its writer starts; roomDefault is the compression
+// setting of its room's ROOM_DEFAULTS.
+func (c *Client) initCompression(cfg Config, roomDefault bool) {
+    c.compressMin = cfg.CompressThreshold
+    if !c.deflate {
+        return
+    }
+    if cfg.CompressionLevel != 0 {
+        if err := c.conn.SetCompressionLevel(cfg.CompressionLevel); err != nil {
+            log.Printf("compression level: %v", err)
+            return
+        }
+    }
+    c.compressDef = cfg.CompressionLevel != 0 || roomDefault
+    c.compressing = c.compressDef
+}
+
+// setWriteCompression asks the writer to toggle compression. It reports
+// false when the connection did not negotiate permessage-deflate. Only the
+// connection's handler goroutine calls it.
+func (c *Client) setWriteCompression(on bool) bool {
+    if !c.deflate {
+        return false
+    }
+    select {
+    case <-c.compressCh:
+    default:
+    }
+    c.compressCh <- on
+    return true
+}
+
+// wireCounter counts bytes written to a hijacked connection.
+type wireCounter struct {
+    net.Conn
+    written atomic.Int64
+}
+
+func (w *wireCounter) Write(p []byte) (int, error) {
+    n, err := w.Conn.Write(p)
+    w.written.Add(int64(n))
+    return n, err
+}
+
+// countingHijacker hands the upgrader a wireCounter in place of the raw
+// connection. conn is set once the upgrade has hijacked it.
+type countingHijacker struct {
+    http.ResponseWriter
+    conn *wireCounter
+}
+
+func (h *countingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    conn, rw, err := http.NewResponseController(h.ResponseWriter).Hijack()
+    if err != nil {
+        return nil, nil, err
+    }
+    h.conn = &wireCounter{Conn: conn}
+    return h.conn, rw, nil
+}
+
+// observeCompressed records a frame of raw bytes that went out as wire
+// bytes with compression on.
+func (cc *clientCounters) observeCompressed(raw int, wire int64) {
+    cc.rawCompressed.Add(int64(raw))
+    cc.wireCompressed.Add(wire)
+}
+
+// compressionRatio is wire bytes over uncompressed bytes for frames sent
+// compressed, or 0 when none were.
+func compressionRatio(raw, wire int64) float64 {
+    if raw == 0 {
+        return 0
+    }
+    return float64(wire) / float64(raw)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+    "encoding/json"
+    "net"
+    "net/http"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestOffersDeflate(t *testing.T) {
+    cases := map[string]bool{
+        "":                   false,
+        "permessage-deflate": true,
+        "x-foo, permessage-deflate; client_max_window_bits": true,
+        "x-permessage-deflate":                              false,
+    }
+    for header, want := range cases {
+        r, _ := http.NewRequest(http.MethodGet, "/ws", nil)
+        if header != "" {
+            r.Header.Set("Sec-WebSocket-Extensions", header)
+        }
+        if got := offersDeflate(r); got != want {
+            t.Errorf("offersDeflate(%q) = %v, want %v", header, got, want)
+        }
+    }
+}
+
+// countingConn counts bytes read off the wire, before decompression.
+type countingConn struct {
+    net.Conn
+    read *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+    n, err := c.Conn.Read(p)
+    c.read.Add(int64(n))
+    return n, err
+}
+
+func TestToggleWriteCompression(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    var wire atomic.Int64
+    dialer := websocket.Dialer{
+        EnableCompression: true,
+        NetDial: func(network, addr string) (net.Conn, error) {
+            c, err := net.Dial(network, addr)
+            return countingConn{Conn: c, read: &wire}, err
+        },
+    }
+    viewer, _, err := dialer.Dial(base+"/ws/r/viewer", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer viewer.Close()
+    sender := dialWS(t, base+"/ws/r/sender")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+
+    payload := make([]byte, 16<<10) // zeros: very compressible
+    receive := func() int64 {
+        t.Helper()
+        before := wire.Load()
+        if err := sender.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+            t.Fatal(err)
+        }
+        viewer.SetReadDeadline(time.Now().Add(2 * time.Second))
+        _, raw, err := viewer.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        var env Envelope
+        if err := json.Unmarshal(raw, &env); err != nil || len(env.Payload) != len(payload) {
+            t.Fatalf("bad envelope (%v)", err)
+        }
+        return wire.Load() - before
+    }
+    toggle := func(on bool) {
+        t.Helper()
+        b, _ := json.Marshal(ControlFrame{Op: "compression", Enabled: on})
+        if err := viewer.WriteMessage(websocket.TextMessage, b); err != nil {
+            t.Fatal(err)
+        }
+        time.Sleep(50 * time.Millisecond) // let the writer apply it
+    }
+
+    plain := receive()
+    if plain < int64(len(payload)) {
+        t.Fatalf("uncompressed by default expected, got %d wire bytes", plain)
+    }
+    toggle(true)
+    if got := receive(); got > plain/10 {
+        t.Fatalf("compression on: %d wire bytes, uncompressed was %d", got, plain)
+    }
+    toggle(false)
+    if got := receive(); got < int64(len(payload)) {
+        t.Fatalf("compression off again: %d wire bytes", got)
+    }
+}
+
+func TestCompressionOpRelayedWithoutDeflate(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    alice := dialWS(t, base+"/ws/r/alice") // default dialer does not offer deflate
+    bob := dialWS(t, base+"/ws/r/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+    op := `{"op":"compression","enabled":true}`
+    if err := alice.WriteMessage(websocket.TextMessage, []byte(op)); err != nil {
+        t.Fatal(err)
+    }
+    if got := readEnvelopes(t, bob, 200*time.Millisecond); len(got) != 1 || string(got[0].Payload) != op {
+        t.Fatalf("bob got %v, want the app frame relayed", got)
+    }
+}
+
+func TestCompressionRatioInStats(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "admin-token"})
+    base := startTestServer(t, hub)
+    dialer := websocket.Dialer{EnableCompression: true}
+    viewer, _, err := dialer.Dial(base+"/ws/r/viewer", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer viewer.Close()
+    sender := dialWS(t, base+"/ws/r/sender")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+
+    b, _ := json.Marshal(ControlFrame{Op: "compression", Enabled: true})
+    if err := viewer.WriteMessage(websocket.TextMessage, b); err != nil {
+        t.Fatal(err)
+    }
+    time.Sleep(50 * time.Millisecond) // let the writer apply it
+    payload := []byte(strings.Repeat("tick tock ", 1000))
+    for i := 0; i < 3; i++ {
+        if err := sender.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if got := readEnvelopes(t, viewer, 200*time.Millisecond); len(got) != 3 {
+        t.Fatalf("viewer got %d messages, want 3", len(got))
+    }
+
+    var rs RoomStats
+    statsRequest(t, base, http.MethodGet, "/stats?room=r", "admin-token", &rs)
+    if rs.CompressionRatio <= 0 || rs.CompressionRatio >= 1 {
+        t.Fatalf("room compression ratio = %v, want between 0 and 1", rs.CompressionRatio)
+    }
+    for _, m := range rs.Members {
+        switch m.Username {
+        case "viewer":
+            if m.CompressedBytes < 3*int64(len(payload)) || m.CompressionRatio <= 0 || m.CompressionRatio >= 0.5 {
+                t.Errorf("viewer stats %+v, want a ratio well below 1", m)
+            }
+        case "sender":
+            if m.CompressedBytes != 0 || m.CompressionRatio != 0 {
+                t.Errorf("sender never compressed, got %+v", m)
+            }
+        }
+    }
+}
+
+// dialCounting connects with permessage-deflate offered and counts the
+// bytes that arrive on the wire.
+func dialCounting(tb testing.TB, url string, wire *atomic.Int64) *websocket.Conn {
+    tb.Helper()
+    dialer := websocket.Dialer{
+        EnableCompression: true,
+        NetDial: func(network, addr string) (net.Conn, error) {
+            c, err := net.Dial(network, addr)
+            return countingConn{Conn: c, read: wire}, err
+        },
+    }
+    c, _, err := dialer.Dial(url, nil)
+    if err != nil {
+        tb.Fatal(err)
+    }
+    return c
+}
+
+func TestCompressionLevelAndThreshold(t *testing.T) {
+    hub := NewHubWithConfig(Config{CompressionLevel: 6, CompressThreshold: 1024})
+    base := startTestServer(t, hub)
+    var wire atomic.Int64
+    viewer := dialCounting(t, base+"/ws/r/viewer", &wire)
+    defer viewer.Close()
+    sender := dialWS(t, base+"/ws/r/sender")
+    defer sender.Close()
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+
+    receive := func(payload []byte) int64 {
+        t.Helper()
+        before := wire.Load()
+        if err := sender.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+            t.Fatal(err)
+        }
+        viewer.SetReadDeadline(time.Now().Add(2 * time.Second))
+        if _, _, err := viewer.ReadMessage(); err != nil {
+            t.Fatal(err)
+        }
+        return wire.Load() - before
+    }
+    // compressed from the start, without a compression op
+    if got := receive(make([]byte, 16<<10)); got > 2<<10 {
+        t.Fatalf("large frame: %d wire bytes, want it compressed", got)
+    }
+    // under the threshold the frame goes out as is
+    if got := receive(make([]byte, 512)); got < 512 {
+        t.Fatalf("small frame: %d wire bytes, want it uncompressed", got)
+    }
+}
+
+func TestValidCompressionLevel(t *testing.T) {
+    for _, level := range []int{-2, 0, 1, 9} {
+        if err := validCompressionLevel(level); err != nil {
+            t.Errorf("level %d: %v", level, err)
+        }
+    }
+    for _, level := range []int{-3, 10} {
+        if validCompressionLevel(level) == nil {
+            t.Errorf("level %d accepted", level)
+        }
+    }
+}
+
+// BenchmarkDeflateWireBytes relays JSON-heavy messages and reports the
+// bytes that reach the receiver per message, uncompressed and compressed.
+func BenchmarkDeflateWireBytes(b *testing.B) {
+    var payload strings.Builder
+    payload.WriteString("[")
+    for i := 0; i < 40; i++ {
+        if i > 0 {
+            payload.WriteString(",")
+        }
+        payload.WriteString(`{"symbol":"ACME","side":"buy","price":101.25,"qty":300,"venue":"XNAS"}`)
+    }
+    payload.WriteString("]")
+    msg := []byte(payload.String())
+    for _, bc := range []struct {
+        name string
+        cfg  Config
+    }{
+        {"off", Config{}},
+        {"deflate", Config{CompressionLevel: 1, CompressThreshold: 256}},
+    } {
+        b.Run(bc.name, func(b *testing.B) {
+            hub := NewHubWithConfig(bc.cfg)
+            base := startTestServer(b, hub)
+            var wire atomic.Int64
+            viewer := dialCounting(b, base+"/ws/r/viewer", &wire)
+            defer viewer.Close()
+            sender := dialWS(b, base+"/ws/r/sender")
+            defer sender.Close()
+            waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+            wire.Store(0)
+            b.SetBytes(int64(len(msg)))
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if err := sender.WriteMessage(websocket.TextMessage, msg); err != nil {
+                    b.Fatal(err)
+                }
+                if _, _, err := viewer.ReadMessage(); err != nil {
+                    b.Fatal(err)
+                }
+            }
+            b.ReportMetric(float64(wire.Load())/float64(b.N), "wire-B/msg")
+        })
+    }
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "runtime"
+    "sync/atomic"
+)
+
+// broadcastLimiter caps how many broadcasts render and fan out at once
+// (MAX_CONCURRENT_BROADCASTS, default GOMAXPROCS) so a spike across many
+// rooms queues briefly instead of oversubscribing the CPU.
+type broadcastLimiter struct {
+    sem    chan struct{}
+    active atomic.Int64
+    peak   atomic.Int64
+}
+
+func newBroadcastLimiter(limit int) *broadcastLimiter {
+    if limit <= 0 {
+        limit = runtime.GOMAXPROCS(0)
+    }
+    return &broadcastLimiter{sem: make(chan struct{}, limit)}
+}
+
+// acquire blocks until a broadcast slot is free.
+func (l *broadcastLimiter) acquire() {
+    l.sem <- struct{}{}
+    n := l.active.Add(1)
+    for {
+        p := l.peak.Load()
+        if n <= p || l.peak.CompareAndSwap(p, n) {
+            return
+        }
+    }
+}
+
+func (l *broadcastLimiter) release() {
+    l.active.Add(-1)
+    <-l.sem
+}
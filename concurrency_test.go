@@ -0,0 +1,75 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestBroadcastLimiterCapsConcurrency(t *testing.T) {
+    const limit, rooms, perRoom = 2, 16, 20
+    hub := NewHubWithConfig(Config{MaxBroadcasts: limit})
+    var recvs []*Client
+    for i := 0; i < rooms; i++ {
+        r := hub.getRoom(fmt.Sprintf("r%d", i))
+        for j := 0; j < 200; j++ {
+            recvs = append(recvs, fakeClient(r, fmt.Sprintf("u%d", j), perRoom))
+        }
+    }
+    var wg sync.WaitGroup
+    for i := 0; i < rooms; i++ {
+        wg.Add(1)
+        go func(r *Room) {
+            defer wg.Done()
+            for k := 0; k < perRoom; k++ {
+                r.publish(nil, NewEnvelope(r.name, "udp", []byte("tick")))
+            }
+        }(hub.getRoom(fmt.Sprintf("r%d", i)))
+    }
+    wg.Wait()
+
+    if peak := hub.broadcasts.peak.Load(); peak > limit || peak < 1 {
+        t.Fatalf("peak concurrent broadcasts %d, limit %d", peak, limit)
+    }
+    if n := hub.broadcasts.active.Load(); n != 0 {
+        t.Fatalf("%d broadcasts still active", n)
+    }
+    for _, c := range recvs {
+        if got := drain(c, perRoom, time.Second); len(got) != perRoom {
+            t.Fatalf("%s got %d of %d messages", c.username, len(got), perRoom)
+        }
+    }
+}
+
+func TestBroadcastLimiterBlocksAtLimit(t *testing.T) {
+    l := newBroadcastLimiter(1)
+    l.acquire()
+    acquired := make(chan struct{})
+    go func() {
+        l.acquire()
+        close(acquired)
+    }()
+    select {
+    case <-acquired:
+        t.Fatal("second broadcast ran past the limit")
+    case <-time.After(50 * time.Millisecond):
+    }
+    l.release()
+    select {
+    case <-acquired:
+    case <-time.After(time.Second):
+        t.Fatal("queued broadcast never ran")
+    }
+    l.release()
+}
+
+func TestBroadcastConcurrencyInStats(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxBroadcasts: 3})
+    base := startTestServer(t, hub)
+    var st HubStats
+    getJSON(t, base, "/stats", &st)
+    if st.MaxBroadcasts != 3 || st.Broadcasts != 0 {
+        t.Fatalf("got %+v", st)
+    }
+}
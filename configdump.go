@@ -0,0 +1,142 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "net/http"
+    "net/url"
+    "reflect"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// /config reports the effective configuration of a live instance, so an
+// operator can check what is actually active rather than what they meant
+// to set. It is an admin endpoint: it answers only when ADMIN_TOKEN is set
+// and the request carries it as a bearer token. Secrets are masked.
+
+const redactedValue = "[redacted]"
+
+// ConfigDump is the /config payload: every Config field by name, and the
+// current state of the specs loaded into the hub at startup.
+type ConfigDump struct {
+    Config map[string]any `json:"config"`
+    Loaded LoadedConfig   `json:"loaded"`
+}
+
+type LoadedConfig struct {
+    RoomTransforms map[string]*PayloadTransform `json:"room_transforms"`
+    RoomDefaults   map[string]RoomDefaults      `json:"room_defaults"`
+    RoomWeights    map[string]float64           `json:"room_weights"`
+    RoleTargets    map[string][]string          `json:"role_targets"`
+    FeatureFlags   map[string]FeatureRule       `json:"feature_flags"`
+    RedactPatterns []string                     `json:"redact_patterns"`
+}
+
+// isSecretField reports whether a Config field holds a credential.
+func isSecretField(name string) bool {
+    for _, s := range []string{"Secret", "Token", "Key", "Password"} {
+        if strings.Contains(name, s) {
+            return true
+        }
+    }
+    return false
+}
+
+// redactURL strips credentials from a URL value. HTTP URLs are webhooks,
+// which often carry their secret in the path or query (Slack-style hook
+// URLs), so only their scheme and host are kept; other URLs lose just the
+// password.
+func redactURL(raw string) string {
+    u, err := url.Parse(raw)
+    if err != nil {
+        return raw
+    }
+    if u.Scheme == "http" || u.Scheme == "https" {
+        return u.Scheme + "://" + u.Host
+    }
+    if u.User != nil {
+        return u.Redacted()
+    }
+    return raw
+}
+
+// configMap renders cfg field by field: durations as strings, secrets
+// masked, and webhook URLs cut down to their scheme and host.
+func configMap(cfg Config) map[string]any {
+    out := make(map[string]any)
+    v := reflect.ValueOf(cfg)
+    for i := 0; i < v.NumField(); i++ {
+        name := v.Type().Field(i).Name
+        switch f := v.Field(i).Interface().(type) {
+        case time.Duration:
+            out[name] = f.String()
+        case string:
+            switch {
+            case f != "" && isSecretField(name):
+                out[name] = redactedValue
+            case strings.Contains(f, "://"):
+                out[name] = redactURL(f)
+            default:
+                out[name] = f
+            }
+        default:
+            out[name] = f
+        }
+    }
+    return out
+}
+
+func (h *Hub) configDump() ConfigDump {
+    h.mu.RLock()
+    d := ConfigDump{
+        Config: configMap(h.cfg),
+        Loaded: LoadedConfig{
+            RoomTransforms: h.transforms,
+            RoomDefaults:   h.roomDefaults,
+            RoleTargets:    h.roleTargets,
+            FeatureFlags:   h.features,
+        },
+    }
+    var patterns []*regexp.Regexp
+    if h.redact != nil {
+        patterns = h.redact.patterns
+    }
+    h.mu.RUnlock()
+    for _, re := range patterns {
+        d.Loaded.RedactPatterns = append(d.Loaded.RedactPatterns, re.String())
+    }
+    if f := h.fair; f != nil {
+        f.mu.Lock()
+        d.Loaded.RoomWeights = f.weights
+        f.mu.Unlock()
+    }
+    return d
+}
+
+// authorizedAdmin reports whether r carries the admin token. With no token
+// configured, nothing is authorized.
+func authorizedAdmin(r *http.Request, token string) bool {
+    got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    return token != "" && ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// configHandler serves /config to requests bearing ADMIN_TOKEN.
+func configHandler(hub *Hub) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if hub.cfg.AdminToken == "" {
+            http.NotFound(w, r)
+            return
+        }
+        if !authorizedAdmin(r, hub.cfg.AdminToken) {
+            hub.audit(r, auditConfigRead, "", auditDenied)
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        hub.audit(r, auditConfigRead, "", auditOK)
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(hub.configDump())
+    }
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// Per-connection context. Each connection carries a context, derived from
+// its upgrade request, that is cancelled when something other than the
+// peer ends the connection, such as a newer connection replacing it.
+// ReadMessage cannot select on a context, so cancelling it closes the
+// connection instead: the writer flushes what is queued for up to
+// CLOSE_DRAIN_TIMEOUT (0 closes at once), sends the close frame and closes
+// the socket, which unblocks the read well before its deadline. The reader
+// loop then ends and cleans up as for any closed connection, logging the
+// cause.
+
+// closeCause is the cancellation cause of a connection's context: the close
+// frame its peer is sent.
+type closeCause struct {
+    code   int
+    reason string
+}
+
+func (e *closeCause) Error() string {
+    return fmt.Sprintf("closed with %d %q", e.code, e.reason)
+}
+
+// startContext gives c its context and closes c once it is cancelled. The
+// returned func stops that, for when the connection ends on its own.
+func (c *Client) startContext(parent context.Context, drain time.Duration) (stop func() bool) {
+    c.ctx, c.cancel = context.WithCancelCause(parent)
+    return context.AfterFunc(c.ctx, func() {
+        cc := &closeCause{code: websocket.CloseGoingAway, reason: "going away"}
+        errors.As(context.Cause(c.ctx), &cc)
+        c.drainAndClose(cc.code, cc.reason, time.Now().Add(drain))
+    })
+}
+
+// stop ends c's connection with a close frame of code and reason; it does
+// not wait for the reader loop to finish.
+func (c *Client) stop(code int, reason string) {
+    c.cancel(&closeCause{code: code, reason: reason})
+}
+
+// stopped returns why c's context was cancelled, or nil if it was not.
+func (c *Client) stopped() error {
+    if c.ctx == nil || c.ctx.Err() == nil {
+        return nil
+    }
+    return context.Cause(c.ctx)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestCancelledContextEndsReaderPromptly(t *testing.T) {
+    // a ping interval of a minute leaves the read deadline far off
+    hub := NewHubWithConfig(Config{PingInterval: time.Minute})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/r/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 })
+    clients := hub.liveClients()
+    if len(clients) != 1 {
+        t.Fatalf("%d live clients", len(clients))
+    }
+
+    start := time.Now()
+    clients[0].stop(websocket.ClosePolicyViolation, "kicked")
+    if !waitFor(time.Second, func() bool { return hub.metrics.connections.Load() == 0 }) {
+        t.Fatal("reader loop still running after its context was cancelled")
+    }
+    if d := time.Since(start); d > 500*time.Millisecond {
+        t.Fatalf("reader loop took %v to end", d)
+    }
+    var cc *closeCause
+    if !errors.As(clients[0].stopped(), &cc) || cc.reason != "kicked" {
+        t.Fatalf("stopped() = %v", clients[0].stopped())
+    }
+
+    c.SetReadDeadline(time.Now().Add(time.Second))
+    _, _, err := c.ReadMessage()
+    var ce *websocket.CloseError
+    if !errors.As(err, &ce) || ce.Code != websocket.ClosePolicyViolation || ce.Text != "kicked" {
+        t.Fatalf("client saw %v, want the close frame from stop", err)
+    }
+}
+
+func TestPeerCloseLeavesContextUncancelledByStop(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/r/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 })
+    client := hub.liveClients()[0]
+    c.Close()
+    if !waitFor(2*time.Second, func() bool { return hub.metrics.connections.Load() == 0 }) {
+        t.Fatal("connection not cleaned up")
+    }
+    // cleanup cancels the context without a close cause
+    var cc *closeCause
+    if errors.As(client.stopped(), &cc) {
+        t.Fatalf("peer close recorded as %v", cc)
+    }
+    if client.ctx.Err() == nil {
+        t.Fatal("context left running after the connection ended")
+    }
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "context"
+    "sync/atomic"
+    "time"
+)
+
+// connSlots caps live WebSocket connections (MAX_CONNECTIONS). An upgrade
+// over the cap may wait for a slot instead of being refused outright: up
+// to CONNECT_QUEUE_DEPTH requests are held for at most CONNECT_QUEUE_WAIT,
+// which smooths short bursts over the limit. The held request stays
+// un-upgraded, so only ReadHeaderTimeout applies to it and it has already
+// passed; a client that gives up cancels its request and leaves the queue.
+// A nil connSlots is unlimited.
+type connSlots struct {
+    sem     chan struct{}
+    waiting atomic.Int64
+    depth   int64
+    wait    time.Duration
+}
+
+func newConnSlots(limit, depth int, wait time.Duration) *connSlots {
+    if limit <= 0 {
+        return nil
+    }
+    return &connSlots{sem: make(chan struct{}, limit), depth: int64(max(depth, 0)), wait: wait}
+}
+
+// acquire takes a slot, queueing for one if allowed, and reports whether
+// it got one. Every successful acquire must be paired with release.
+func (s *connSlots) acquire(ctx context.Context) bool {
+    if s == nil {
+        return true
+    }
+    select {
+    case s.sem <- struct{}{}:
+        return true
+    default:
+    }
+    if s.wait <= 0 {
+        return false
+    }
+    defer s.waiting.Add(-1)
+    if s.waiting.Add(1) > s.depth {
+        return false
+    }
+    timer := time.NewTimer(s.wait)
+    defer timer.Stop()
+    select {
+    case s.sem <- struct{}{}:
+        return true
+    case <-timer.C:
+        return false
+    case <-ctx.Done():
+        return false
+    }
+}
+
+func (s *connSlots) release() {
+    if s == nil {
+        return
+    }
+    <-s.sem
+}
+
+// retryAfter is the hint sent with a refusal: a slot is expected within
+// one queue wait.
+func (s *connSlots) retryAfter() time.Duration {
+    return max(s.wait, time.Second)
+}
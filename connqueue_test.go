@@ -0,0 +1,87 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestConnectionQueuedUntilSlotFrees(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxConnections: 1, ConnectQueueDepth: 1, ConnectQueueWait: 3 * time.Second})
+    base := startTestServer(t, hub)
+    first := dialWS(t, base+"/ws/q/first")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "q") == 1 }) {
+        t.Fatal("first client did not join")
+    }
+
+    type dialResult struct {
+        c   *websocket.Conn
+        err error
+    }
+    queued := make(chan dialResult, 1)
+    go func() {
+        c, _, err := websocket.DefaultDialer.Dial(base+"/ws/q/second", nil)
+        queued <- dialResult{c, err}
+    }()
+    if !waitFor(time.Second, func() bool { return hub.slots.waiting.Load() == 1 }) {
+        t.Fatal("second upgrade was not queued")
+    }
+
+    // the queue is full: a third upgrade is refused at once
+    _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/q/third", nil)
+    if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "3" {
+        t.Fatalf("third dial: err=%v resp=%v", err, resp)
+    }
+
+    first.Close()
+    select {
+    case res := <-queued:
+        if res.err != nil {
+            t.Fatalf("queued dial failed: %v", res.err)
+        }
+        defer res.c.Close()
+    case <-time.After(2 * time.Second):
+        t.Fatal("queued upgrade was not admitted after a disconnect")
+    }
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "q") == 1 }) {
+        t.Fatal("queued client did not join")
+    }
+}
+
+func TestConnectionQueueWaitExpires(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxConnections: 1, ConnectQueueDepth: 4, ConnectQueueWait: 100 * time.Millisecond})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/q/first")
+
+    start := time.Now()
+    _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/q/second", nil)
+    if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+        t.Fatalf("dial over the limit: err=%v resp=%v", err, resp)
+    }
+    if took := time.Since(start); took < 100*time.Millisecond {
+        t.Fatalf("refused after %v, before the queue wait", took)
+    }
+    if n := hub.slots.waiting.Load(); n != 0 {
+        t.Fatalf("%d requests still queued", n)
+    }
+}
+
+func TestConnSlotsWaitingStaysBalanced(t *testing.T) {
+    for _, wait := range []time.Duration{0, 10 * time.Millisecond} {
+        s := newConnSlots(1, 1, wait)
+        if !s.acquire(context.Background()) {
+            t.Fatal("first acquire failed")
+        }
+        for i := 0; i < 3; i++ {
+            if s.acquire(context.Background()) {
+                t.Fatal("acquired a slot while full")
+            }
+        }
+        if n := s.waiting.Load(); n != 0 {
+            t.Fatalf("wait %s: waiting = %d after refusals, want 0", wait, n)
+        }
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "net"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// connectLimiter throttles how fast new upgrades are accepted, globally
+// (CONNECT_RATE) and per client IP (CONNECT_RATE_PER_IP), in connections
+// per second with one second of burst. It guards against connect/disconnect
+// storms, independent of how many connections are held open. Only opens
+// are counted: every close follows an open that was let through here, so
+// limiting opens bounds closes too.
+type connectLimiter struct {
+    global *tokenBucket // nil = unlimited
+
+    perIPRate float64 // 0 = unlimited
+    mu        sync.Mutex
+    perIP     map[string]*tokenBucket
+    lastSweep time.Time
+}
+
+func newConnectLimiter(rate, perIPRate float64) *connectLimiter {
+    l := &connectLimiter{perIPRate: perIPRate, perIP: make(map[string]*tokenBucket), lastSweep: time.Now()}
+    if rate > 0 {
+        l.global = newTokenBucket(rate, max(rate, 1))
+    }
+    return l
+}
+
+// allow reports whether a new connection from ip may be accepted now. A
+// connection takes a token from both budgets or from neither, so one
+// refused for the global rate does not count against its IP.
+func (l *connectLimiter) allow(ip string) bool {
+    if l == nil {
+        return true
+    }
+    var ipb *tokenBucket
+    if l.perIPRate > 0 {
+        if ipb = l.ipBucket(ip); !ipb.allow(1) {
+            return false
+        }
+    }
+    if l.global != nil && !l.global.allow(1) {
+        if ipb != nil {
+            ipb.refund(1)
+        }
+        return false
+    }
+    return true
+}
+
+// retryAfter estimates when a connection from ip refused by allow would
+// next be accepted.
+func (l *connectLimiter) retryAfter(ip string) time.Duration {
+    var d time.Duration
+    if l.perIPRate > 0 {
+        d = l.ipBucket(ip).wait(1)
+    }
+    if l.global != nil {
+        d = max(d, l.global.wait(1))
+    }
+    return d
+}
+
+func (l *connectLimiter) ipBucket(ip string) *tokenBucket {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    now := time.Now()
+    if now.Sub(l.lastSweep) > time.Minute {
+        // buckets idle long enough to have refilled carry no state
+        for k, b := range l.perIP {
+            b.mu.Lock()
+            idle := now.Sub(b.last) > time.Minute
+            b.mu.Unlock()
+            if idle {
+                delete(l.perIP, k)
+            }
+        }
+        l.lastSweep = now
+    }
+    b, ok := l.perIP[ip]
+    if !ok {
+        b = newTokenBucket(l.perIPRate, max(l.perIPRate, 1))
+        l.perIP[ip] = b
+    }
+    return b
+}
+
+// clientIP is the remote host of r, without the port.
+func clientIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
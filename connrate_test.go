@@ -0,0 +1,106 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestConnectLimiterPerIP(t *testing.T) {
+    l := newConnectLimiter(0, 2)
+    if !l.allow("10.0.0.1") || !l.allow("10.0.0.1") {
+        t.Fatal("burst of 2 should be allowed")
+    }
+    if l.allow("10.0.0.1") {
+        t.Fatal("third connect within the second should be throttled")
+    }
+    if !l.allow("10.0.0.2") {
+        t.Fatal("other IPs have their own budget")
+    }
+    var disabled *connectLimiter
+    if !disabled.allow("10.0.0.1") {
+        t.Fatal("nil limiter allows everything")
+    }
+}
+
+func TestConnectLimiterGlobal(t *testing.T) {
+    l := newConnectLimiter(3, 0)
+    allowed := 0
+    for i := 0; i < 10; i++ {
+        if l.allow(fmt.Sprintf("10.0.0.%d", i)) {
+            allowed++
+        }
+    }
+    if allowed != 3 {
+        t.Fatalf("allowed %d connects across IPs, want 3", allowed)
+    }
+    time.Sleep(400 * time.Millisecond)
+    if !l.allow("10.0.0.1") {
+        t.Fatal("global budget should refill over time")
+    }
+}
+
+func TestConnectLimiterGlobalRefusalKeepsIPToken(t *testing.T) {
+    l := newConnectLimiter(1, 2)
+    if !l.allow("10.0.0.1") {
+        t.Fatal("first connect refused")
+    }
+    for i := 0; i < 3; i++ {
+        if l.allow("10.0.0.1") {
+            t.Fatal("global budget exhausted, connect allowed")
+        }
+    }
+    if b := l.ipBucket("10.0.0.1"); b.wait(1) != 0 {
+        t.Fatal("connects refused by the global budget spent the IP's tokens")
+    }
+}
+
+func TestConnectLimitedBeforeAuth(t *testing.T) {
+    hub := NewHubWithConfig(Config{ConnectRatePerIP: 2, AuthToken: "secret"})
+    base := startTestServer(t, hub)
+    codes := map[int]int{}
+    for i := 0; i < 6; i++ {
+        _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/r/u", nil)
+        if err == nil || resp == nil {
+            t.Fatalf("dial %d without a token: %v", i, err)
+        }
+        codes[resp.StatusCode]++
+    }
+    if codes[http.StatusUnauthorized] != 2 || codes[http.StatusTooManyRequests] != 4 {
+        t.Fatalf("status codes %v, want 2 unauthorized then throttled", codes)
+    }
+}
+
+func TestClientIP(t *testing.T) {
+    r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+    r.RemoteAddr = "192.0.2.7:51234"
+    if ip := clientIP(r); ip != "192.0.2.7" {
+        t.Fatalf("clientIP = %q", ip)
+    }
+}
+
+func TestConnectBurstThrottled(t *testing.T) {
+    hub := NewHubWithConfig(Config{ConnectRatePerIP: 5})
+    base := startTestServer(t, hub)
+    accepted, throttled := 0, 0
+    for i := 0; i < 20; i++ {
+        c, resp, err := websocket.DefaultDialer.Dial(base+"/ws/r/u", nil)
+        if err == nil {
+            accepted++
+            c.Close()
+            continue
+        }
+        if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+            t.Fatalf("dial %d failed without 429: %v", i, err)
+        }
+        throttled++
+    }
+    // the burst is 5; allow a refill token or two if dialing is slow
+    if accepted < 5 || accepted > 7 || throttled == 0 {
+        t.Fatalf("accepted %d, throttled %d of 20 connects at 5/s", accepted, throttled)
+    }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "sync/atomic"
+    "time"
+)
+
+// clientCounters tracks one connection's traffic. Messages in are frames
+// read from the client; messages out are frames written to it.
+type clientCounters struct {
+    msgsIn   atomic.Int64
+    bytesIn  atomic.Int64
+    msgsOut  atomic.Int64
+    bytesOut atomic.Int64
+    drops    atomic.Int64 // messages for this client dropped by the relay
+    offered  atomic.Int64 // broadcasts addressed to this client, see fairness.go
+    // messages from this client dropped by MAX_MSGS_PER_SEC
+    throttled atomic.Int64
+    // smoothed time to write one frame to the socket, in nanoseconds
+    writeLatency atomic.Int64
+    // frames written with compression on: uncompressed and wire bytes
+    rawCompressed  atomic.Int64
+    wireCompressed atomic.Int64
+}
+
+// observeWrite records a written frame of n bytes that took d.
+func (cc *clientCounters) observeWrite(n int, d time.Duration) {
+    cc.msgsOut.Add(1)
+    cc.bytesOut.Add(int64(n))
+    // 1/8 gain, like TCP's SRTT; only the writer goroutine updates it
+    prev := cc.writeLatency.Load()
+    cc.writeLatency.Store(prev + (int64(d)-prev)/8)
+}
+
+// ConnStatsFrame is the periodic system envelope a client can opt in to
+// with ?stats=<interval> or the stats_interval_ms capability.
+type ConnStatsFrame struct {
+    Type           string  `json:"type"`
+    MessagesIn     int64   `json:"messages_in"`
+    BytesIn        int64   `json:"bytes_in"`
+    MessagesOut    int64   `json:"messages_out"`
+    BytesOut       int64   `json:"bytes_out"`
+    Drops          int64   `json:"drops"`
+    Throttled      int64   `json:"throttled"`
+    JitterMs       float64 `json:"jitter_ms"`
+    WriteLatencyMs float64 `json:"write_latency_ms"`
+}
+
+func (c *Client) connStatsFrame() []byte {
+    b, _ := json.Marshal(ConnStatsFrame{
+        Type:           "stats",
+        MessagesIn:     c.counters.msgsIn.Load(),
+        BytesIn:        c.counters.bytesIn.Load(),
+        MessagesOut:    c.counters.msgsOut.Load(),
+        BytesOut:       c.counters.bytesOut.Load(),
+        Drops:          c.counters.drops.Load(),
+        Throttled:      c.counters.throttled.Load(),
+        JitterMs:       float64(c.jitter.value()) / float64(time.Millisecond),
+        WriteLatencyMs: float64(c.counters.writeLatency.Load()) / float64(time.Millisecond),
+    })
+    return b
+}
+
+// parseStatsInterval validates a requested stats push interval and clamps
+// it to the server's minimum. Empty means no stats push.
+func parseStatsInterval(s string, floor time.Duration) (time.Duration, error) {
+    if s == "" {
+        return 0, nil
+    }
+    d, err := time.ParseDuration(s)
+    if err != nil || d <= 0 {
+        return 0, fmt.Errorf("invalid stats interval %q", s)
+    }
+    return max(d, floor), nil
+}
+
+// pushStats asks the writer to send connection stats every d (0 stops).
+// The writer owns the ticker, so stats frames never race the send queue.
+// Only the connection's handler goroutine calls it; a change the writer has
+// not picked up yet is replaced.
+func (c *Client) pushStats(d time.Duration) {
+    select {
+    case <-c.statsEvery:
+    default:
+    }
+    select {
+    case c.statsEvery <- d:
+    default:
+    }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestParseStatsInterval(t *testing.T) {
+    if d, err := parseStatsInterval("", time.Second); err != nil || d != 0 {
+        t.Fatalf("empty: %v, %v", d, err)
+    }
+    if d, err := parseStatsInterval("5s", time.Second); err != nil || d != 5*time.Second {
+        t.Fatalf("5s: %v, %v", d, err)
+    }
+    if d, err := parseStatsInterval("10ms", time.Second); err != nil || d != time.Second {
+        t.Fatalf("below floor should clamp, got %v, %v", d, err)
+    }
+    for _, bad := range []string{"x", "-1s", "0s"} {
+        if _, err := parseStatsInterval(bad, 0); err == nil {
+            t.Errorf("%q: expected error", bad)
+        }
+    }
+}
+
+// readStatsFrame reads until a stats frame arrives, skipping envelopes.
+func readStatsFrame(t *testing.T, c *websocket.Conn) ConnStatsFrame {
+    t.Helper()
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    for {
+        _, raw, err := c.ReadMessage()
+        if err != nil {
+            t.Fatalf("waiting for stats: %v", err)
+        }
+        var st ConnStatsFrame
+        if json.Unmarshal(raw, &st) == nil && st.Type == "stats" {
+            return st
+        }
+    }
+}
+
+func TestConnStatsPush(t *testing.T) {
+    hub := NewHubWithConfig(Config{MinStatsInterval: 50 * time.Millisecond})
+    base := startTestServer(t, hub)
+    if _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/r/bad?stats=soon", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+        t.Fatalf("expected 400 for a bad interval, got %v", resp)
+    }
+
+    watcher := dialWS(t, base+"/ws/r/watcher?stats=100ms")
+    peer := dialWS(t, base+"/ws/r/peer")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+
+    for i := 0; i < 3; i++ {
+        if err := watcher.WriteMessage(websocket.BinaryMessage, []byte("12345")); err != nil {
+            t.Fatal(err)
+        }
+        if err := peer.WriteMessage(websocket.BinaryMessage, []byte("abc")); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // the first push may predate the traffic; wait for one that counts it all
+    var st ConnStatsFrame
+    for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+        if st = readStatsFrame(t, watcher); st.MessagesIn == 3 && st.MessagesOut >= 3 {
+            break
+        }
+    }
+    if st.MessagesIn != 3 || st.BytesIn != 15 {
+        t.Errorf("in: %d msgs / %d bytes, want 3 / 15", st.MessagesIn, st.BytesIn)
+    }
+    if st.MessagesOut < 3 || st.BytesOut < 3*int64(len("abc")) {
+        t.Errorf("out: %d msgs / %d bytes, want at least the 3 relayed messages", st.MessagesOut, st.BytesOut)
+    }
+    if st.Drops != 0 || st.WriteLatencyMs < 0 || st.WriteLatencyMs > 1000 {
+        t.Errorf("implausible stats %+v", st)
+    }
+    if got := readEnvelopes(t, peer, 200*time.Millisecond); len(got) != 3 {
+        t.Errorf("peer without ?stats got %d frames, want only the 3 envelopes", len(got))
+    }
+}
+
+func TestConnStatsViaHandshake(t *testing.T) {
+    hub := NewHubWithConfig(Config{HandshakeTimeout: time.Second, MinStatsInterval: 100 * time.Millisecond})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/r/alice")
+    if err := c.WriteMessage(websocket.TextMessage, []byte(`{"op":"hello","capabilities":{"stats_interval_ms":10}}`)); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := c.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var wf WelcomeFrame
+    if err := json.Unmarshal(raw, &wf); err != nil || wf.Capabilities.StatsInterval != 100 {
+        t.Fatalf("expected stats interval clamped to 100ms, got %s", raw)
+    }
+    if st := readStatsFrame(t, c); st.MessagesIn != 1 {
+        t.Fatalf("stats should count the hello, got %+v", st)
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "mime"
+    "strings"
+)
+
+// Content-type aware compression. A connection opened with ?ctype=1 may
+// tag a message with a "ctype:<content-type>|" header (after any ttl:
+// header). The type travels in the envelope as content_type, and decides
+// whether deflate connections compress the message: text and JSON shrink
+// well, while JPEG, video and archives are already compressed and would
+// only cost CPU. COMPRESS_CONTENT_TYPES overrides the built-in policy with
+// comma-separated type=on|off entries, where type may be "major/*".
+// Untagged messages and unknown types are compressed as before.
+
+const ctypePrefix = "ctype:"
+
+// defaultCompressTypes is the built-in compressibility policy.
+var defaultCompressTypes = map[string]bool{
+    "text/*":                      true,
+    "application/json":            true,
+    "application/xml":             true,
+    "application/javascript":      true,
+    "image/svg+xml":               true,
+    "image/*":                     false,
+    "video/*":                     false,
+    "audio/*":                     false,
+    "application/zip":             false,
+    "application/gzip":            false,
+    "application/zstd":            false,
+    "application/x-7z-compressed": false,
+}
+
+// parseContentTypePrefix splits "ctype:<content-type>|<payload>". ok is
+// false when msg has no content-type header; err is set when it has a
+// malformed one. The type is returned lower-cased without parameters.
+func parseContentTypePrefix(msg []byte) (ctype string, payload []byte, ok bool, err error) {
+    rest, found := bytes.CutPrefix(msg, []byte(ctypePrefix))
+    if !found {
+        return "", msg, false, nil
+    }
+    spec, payload, found := bytes.Cut(rest, []byte("|"))
+    if found {
+        ctype, _, err = mime.ParseMediaType(string(spec))
+    }
+    if !found || err != nil {
+        return "", nil, true, fmt.Errorf("malformed ctype header; want %s<type/subtype>|<payload>", ctypePrefix)
+    }
+    return ctype, payload, true, nil
+}
+
+// LoadCompressTypes parses COMPRESS_CONTENT_TYPES over the built-in policy.
+func (h *Hub) LoadCompressTypes(spec string) error {
+    policy := make(map[string]bool, len(defaultCompressTypes))
+    for t, on := range defaultCompressTypes {
+        policy[t] = on
+    }
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        t, v, ok := strings.Cut(entry, "=")
+        t = strings.ToLower(strings.TrimSpace(t))
+        if !ok || !strings.Contains(t, "/") || (v != "on" && v != "off") {
+            return fmt.Errorf("invalid COMPRESS_CONTENT_TYPES entry %q (want type/subtype=on|off)", entry)
+        }
+        policy[t] = v == "on"
+    }
+    h.mu.Lock()
+    h.compressTypes = policy
+    h.mu.Unlock()
+    return nil
+}
+
+// compressible reports whether messages of content type ctype are worth
+// compressing: an exact match wins over a "major/*" one.
+func (h *Hub) compressible(ctype string) bool {
+    if ctype == "" {
+        return true
+    }
+    h.mu.RLock()
+    policy := h.compressTypes
+    h.mu.RUnlock()
+    if policy == nil {
+        policy = defaultCompressTypes
+    }
+    if on, ok := policy[ctype]; ok {
+        return on
+    }
+    major, _, _ := strings.Cut(ctype, "/")
+    if on, ok := policy[major+"/*"]; ok {
+        return on
+    }
+    return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "encoding/json"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestParseContentTypePrefix(t *testing.T) {
+    ctype, payload, ok, err := parseContentTypePrefix([]byte("ctype:Application/JSON; charset=utf-8|{}"))
+    if err != nil || !ok || ctype != "application/json" || string(payload) != "{}" {
+        t.Fatalf("got %q %q %v %v", ctype, payload, ok, err)
+    }
+    if _, payload, ok, _ := parseContentTypePrefix([]byte("plain")); ok || string(payload) != "plain" {
+        t.Fatal("untagged message changed")
+    }
+    for _, bad := range []string{"ctype:image/jpeg", "ctype:|x", "ctype:/|x"} {
+        if _, _, _, err := parseContentTypePrefix([]byte(bad)); err == nil {
+            t.Errorf("%q accepted", bad)
+        }
+    }
+}
+
+func TestCompressiblePolicy(t *testing.T) {
+    hub := NewHub()
+    cases := map[string]bool{
+        "":                      true,
+        "text/plain":            true,
+        "application/json":      true,
+        "image/jpeg":            false,
+        "image/svg+xml":         true,
+        "video/mp4":             false,
+        "application/x-unknown": true,
+    }
+    for ctype, want := range cases {
+        if got := hub.compressible(ctype); got != want {
+            t.Errorf("compressible(%q) = %v, want %v", ctype, got, want)
+        }
+    }
+    if err := hub.LoadCompressTypes("image/bmp=on, text/*=off"); err != nil {
+        t.Fatal(err)
+    }
+    if !hub.compressible("image/bmp") || hub.compressible("image/png") || hub.compressible("text/csv") {
+        t.Fatal("overrides not applied over the built-in policy")
+    }
+    for _, bad := range []string{"image=on", "image/png=yes", "image/png"} {
+        if err := hub.LoadCompressTypes(bad); err == nil {
+            t.Errorf("%q accepted", bad)
+        }
+    }
+}
+
+func TestContentTypeSelectsCompression(t *testing.T) {
+    hub := NewHubWithConfig(Config{CompressionLevel: 6})
+    base := startTestServer(t, hub)
+    var wire atomic.Int64
+    viewer := dialCounting(t, base+"/ws/r/viewer", &wire)
+    defer viewer.Close()
+    sender := dialWS(t, base+"/ws/r/sender?ctype=1")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+
+    payload := make([]byte, 16<<10) // compressible whatever it is tagged as
+    receive := func(ctype string) int64 {
+        t.Helper()
+        before := wire.Load()
+        msg := append([]byte("ctype:"+ctype+"|"), payload...)
+        if err := sender.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+            t.Fatal(err)
+        }
+        viewer.SetReadDeadline(time.Now().Add(2 * time.Second))
+        _, raw, err := viewer.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        var env Envelope
+        if err := json.Unmarshal(raw, &env); err != nil || env.ContentType != ctype || len(env.Payload) != len(payload) {
+            t.Fatalf("bad envelope %+v (%v)", env.ContentType, err)
+        }
+        return wire.Load() - before
+    }
+    if got := receive("application/json"); got > 2<<10 {
+        t.Fatalf("json: %d wire bytes, want it compressed", got)
+    }
+    if got := receive("image/jpeg"); got < int64(len(payload)) {
+        t.Fatalf("jpeg: %d wire bytes, want it sent uncompressed", got)
+    }
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "cmp"
+    "encoding/json"
+    "fmt"
+    "slices"
+
+    "github.com/gorilla/websocket"
+)
+
+// ControlFrame is a request to the relay itself rather than a message for
+// the room: a JSON text frame carrying an "op" field.
+type ControlFrame struct {
+    Op   string `json:"op"`
+    Room string `json:"room,omitempty"`
+    Seq  uint64 `json:"seq,omitempty"`
+
+    Enabled      bool          `json:"enabled,omitempty"`      // compression only
+    Capabilities *Capabilities `json:"capabilities,omitempty"` // hello only
+}
+
+// parseControl reports whether msg is a control frame. Binary frames are
+// always data.
+func parseControl(msgType int, msg []byte) (ControlFrame, bool) {
+    var cf ControlFrame
+    if msgType != websocket.TextMessage || len(msg) == 0 || msg[0] != '{' {
+        return cf, false
+    }
+    if err := json.Unmarshal(msg, &cf); err != nil || cf.Op == "" {
+        return cf, false
+    }
+    return cf, true
+}
+
+// controlOp reports whether the relay handles op on c's connection. Each
+// op is only taken out of the data stream where the setting or negotiation
+// it belongs to is on, so application JSON that happens to carry an "op"
+// is relayed untouched everywhere else.
+func (h *Hub) controlOp(c *Client, op string) bool {
+    switch op {
+    case "subscribe", "unsubscribe":
+        return h.cfg.SingleRoomOnly
+    case "hello":
+        return h.cfg.HandshakeTimeout > 0
+    case "ack":
+        return c.acks != nil
+    case "compression":
+        return c.deflate
+    case "roster":
+        return true
+    }
+    return false
+}
+
+// handleControl applies a control op for c. It returns false when the
+// frame is not handled by the relay and should be relayed as data.
+func (h *Hub) handleControl(c *Client, cf ControlFrame) bool {
+    if !h.controlOp(c, cf.Op) {
+        return false
+    }
+    switch cf.Op {
+    case "subscribe", "unsubscribe":
+        c.sendError("single_room_only", fmt.Sprintf("connection is bound to room %q; %s is not allowed", c.room.name, cf.Op))
+    case "hello":
+        c.sendError("unexpected_hello", "hello is only accepted as the first frame")
+    case "compression":
+        c.setWriteCompression(cf.Enabled)
+    case "ack":
+        c.acks.ack(cf.Seq)
+    case "roster":
+        c.trySend(c.room.rosterFrame())
+    }
+    return true
+}
+
+// RosterFrame answers {"op":"roster"} with the room's current members.
+// Members is only filled in with client IDs enabled, one entry per
+// connection, since one username may be connected more than once.
+type RosterFrame struct {
+    Type    string         `json:"type"`
+    Users   []string       `json:"users"`
+    Members []RosterMember `json:"members,omitempty"`
+}
+
+// RosterMember is one connection in a RosterFrame.
+type RosterMember struct {
+    Username string `json:"username"`
+    ClientID string `json:"client_id"`
+}
+
+// rosterFrame lists r's members by username, sorted, each name once; for
+// the default room, the members of all its shards.
+func (r *Room) rosterFrame() []byte {
+    all := r.groupMembers()
+    users := make([]string, 0, len(all))
+    var members []RosterMember
+    for _, c := range all {
+        users = append(users, c.username)
+        if c.id != "" {
+            members = append(members, RosterMember{Username: c.username, ClientID: c.id})
+        }
+    }
+    slices.Sort(users)
+    slices.SortFunc(members, func(a, b RosterMember) int {
+        return cmp.Or(cmp.Compare(a.Username, b.Username), cmp.Compare(a.ClientID, b.ClientID))
+    })
+    b, _ := json.Marshal(RosterFrame{Type: "roster", Users: slices.Compact(users), Members: members})
+    return b
+}
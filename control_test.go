@@ -0,0 +1,118 @@
+package main
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestParseControl(t *testing.T) {
+    cases := []struct {
+        msgType int
+        msg     string
+        op      string
+        ok      bool
+    }{
+        {websocket.TextMessage, `{"op":"subscribe","room":"x"}`, "subscribe", true},
+        {websocket.TextMessage, `{"text":"hi"}`, "", false},
+        {websocket.TextMessage, `hello`, "", false},
+        {websocket.BinaryMessage, `{"op":"subscribe"}`, "", false},
+    }
+    for _, tc := range cases {
+        cf, ok := parseControl(tc.msgType, []byte(tc.msg))
+        if ok != tc.ok || cf.Op != tc.op {
+            t.Errorf("parseControl(%d, %s) = %q, %v; want %q, %v", tc.msgType, tc.msg, cf.Op, ok, tc.op, tc.ok)
+        }
+    }
+}
+
+func TestSingleRoomOnlyRejectsSubscribe(t *testing.T) {
+    hub := NewHubWithConfig(Config{SingleRoomOnly: true})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/lobby/alice")
+    other := dialWS(t, base+"/ws/lobby/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 2 })
+
+    if err := c.WriteMessage(websocket.TextMessage, []byte(`{"op":"subscribe","room":"other"}`)); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := c.ReadMessage()
+    if err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    var ef ErrorFrame
+    if err := json.Unmarshal(raw, &ef); err != nil || ef.Type != "error" || ef.Code != "single_room_only" {
+        t.Fatalf("unexpected reply %s (%v)", raw, err)
+    }
+    if got := readEnvelopes(t, other, 200*time.Millisecond); len(got) != 0 {
+        t.Fatalf("rejected control frame was relayed: %+v", got)
+    }
+}
+
+func TestSubscribeRelayedWithoutSingleRoomOnly(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/lobby/alice")
+    other := dialWS(t, base+"/ws/lobby/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 2 })
+    if err := c.WriteMessage(websocket.TextMessage, []byte(`{"op":"subscribe","room":"other"}`)); err != nil {
+        t.Fatal(err)
+    }
+    if got := readEnvelopes(t, other, 300*time.Millisecond); len(got) != 1 {
+        t.Fatalf("got %d messages, want the frame relayed as data", len(got))
+    }
+}
+
+func TestRosterListsMembersToRequesterOnly(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    alice := dialWS(t, base+"/ws/lobby/alice")
+    bob := dialWS(t, base+"/ws/lobby/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 2 })
+    if err := bob.WriteMessage(websocket.TextMessage, []byte(`{"op":"roster"}`)); err != nil {
+        t.Fatal(err)
+    }
+    bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+    var got RosterFrame
+    if err := bob.ReadJSON(&got); err != nil {
+        t.Fatal(err)
+    }
+    if got.Type != "roster" || !reflect.DeepEqual(got.Users, []string{"alice", "bob"}) {
+        t.Fatalf("roster %+v", got)
+    }
+    // binary data is never taken for a control frame
+    if err := bob.WriteMessage(websocket.BinaryMessage, []byte(`{"op":"roster"}`)); err != nil {
+        t.Fatal(err)
+    }
+    envs := readEnvelopes(t, alice, 300*time.Millisecond)
+    if len(envs) != 1 || string(envs[0].Payload) != `{"op":"roster"}` {
+        t.Fatalf("alice got %+v, want only the binary frame relayed", envs)
+    }
+}
+
+func TestUnconfiguredOpsRelayedAsData(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    alice := dialWS(t, base+"/ws/lobby/alice")
+    bob := dialWS(t, base+"/ws/lobby/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 2 })
+    ops := []string{`{"op":"hello"}`, `{"op":"subscribe","room":"x"}`, `{"op":"ack","seq":1}`, `{"op":"move","to":"e4"}`}
+    for _, op := range ops {
+        if err := alice.WriteMessage(websocket.TextMessage, []byte(op)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := readEnvelopes(t, bob, 300*time.Millisecond)
+    if len(got) != len(ops) {
+        t.Fatalf("bob got %d of %d frames", len(got), len(ops))
+    }
+    for i, env := range got {
+        if string(env.Payload) != ops[i] {
+            t.Fatalf("frame %d relayed as %s, want %s", i, env.Payload, ops[i])
+        }
+    }
+}
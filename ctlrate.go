@@ -0,0 +1,29 @@
+package main
+
+import "math"
+
+// Per-client control rate limit (MAX_CONTROL_PER_SEC). Control frames
+// (subscribe, unsubscribe, acks, compression toggles) change server state,
+// so they draw from their own token bucket, apart from MAX_MSGS_PER_SEC:
+// a client spamming control ops loses those ops without touching its data
+// allowance, and vice versa. A rejected op gets a control_rate_limited
+// error frame and is otherwise ignored.
+
+const errControlRateLimited = "control_rate_limited"
+
+func newControlLimiter(perSec float64) *tokenBucket {
+    if perSec <= 0 {
+        return nil
+    }
+    return newTokenBucket(perSec, math.Max(perSec, 1))
+}
+
+// allowControl reports whether c may apply another control op now. Only
+// c's reader loop calls it.
+func (c *Client) allowControl() bool {
+    if c.ctlLimit == nil || c.ctlLimit.allow(1) {
+        return true
+    }
+    c.sendError(errControlRateLimited, "too many control frames; slow down")
+    return false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestControlRateLimitLeavesDataAlone(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxControlPerSec: 5, MaxMsgsPerSec: 100})
+    base := startTestServer(t, hub)
+    recv := dialWS(t, base+"/ws/ctl/recv")
+    sender := dialWS(t, base+"/ws/ctl/sender")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "ctl") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+
+    for i := 0; i < 30; i++ {
+        if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"op":"roster"}`)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for i := 0; i < 20; i++ {
+        if err := sender.WriteMessage(websocket.TextMessage, []byte("data")); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    codes := map[string]int{}
+    for {
+        sender.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+        _, raw, err := sender.ReadMessage()
+        if err != nil {
+            break
+        }
+        var ef ErrorFrame
+        if json.Unmarshal(raw, &ef) == nil && ef.Type == "error" {
+            codes[ef.Code]++
+        } else if ef.Type == "roster" {
+            codes["roster"]++
+        }
+    }
+    // the one-second burst is let through, the rest rejected
+    if n := codes["roster"]; n < 5 || n > 7 {
+        t.Fatalf("%d control ops applied, want about the 5-op burst (%v)", n, codes)
+    }
+    if codes[errControlRateLimited]+codes["roster"] != 30 {
+        t.Fatalf("error frames %v, want one per control op", codes)
+    }
+    if got := readEnvelopes(t, recv, 300*time.Millisecond); len(got) != 20 {
+        t.Fatalf("received %d of 20 data messages", len(got))
+    }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Dead-letter reasons.
+const (
+    dropQueueFull       = "queue_full"
+    dropEgressBudget    = "egress_budget"
+    dropSchemaViolation = "schema_violation"
+    dropSuperseded      = "superseded"
+)
+
+// DeadLetter records a message the relay dropped instead of delivering.
+type DeadLetter struct {
+    Time    time.Time `json:"time"`
+    Reason  string    `json:"reason"`
+    Room    string    `json:"room"`
+    From    string    `json:"from"`
+    To      string    `json:"to,omitempty"` // set when a single recipient missed it
+    Payload []byte    `json:"payload"`
+}
+
+// deadLetterSink hands dropped messages to a consumer through a bounded
+// queue; when the consumer falls behind, dead letters are counted and
+// discarded rather than buffered without limit. A nil sink is disabled.
+type deadLetterSink struct {
+    ch   chan DeadLetter
+    lost atomic.Int64
+}
+
+func newDeadLetterSink(size int, consume func(DeadLetter)) *deadLetterSink {
+    if size <= 0 {
+        size = 1024
+    }
+    d := &deadLetterSink{ch: make(chan DeadLetter, size)}
+    go func() {
+        for dl := range d.ch {
+            consume(dl)
+        }
+    }()
+    return d
+}
+
+func (d *deadLetterSink) add(reason, room, from, to string, payload []byte) {
+    if d == nil {
+        return
+    }
+    select {
+    case d.ch <- DeadLetter{Time: time.Now(), Reason: reason, Room: room, From: from, To: to, Payload: payload}:
+    default:
+        d.lost.Add(1)
+    }
+}
+
+// deadLetterConsumer builds a consumer from DEADLETTER_SINK: "log",
+// "file:<path>" (JSON lines) or an http(s) webhook URL.
+func deadLetterConsumer(spec string) (func(DeadLetter), error) {
+    switch {
+    case spec == "log":
+        return func(dl DeadLetter) {
+            log.Printf("dead letter: reason=%s room=%s from=%s to=%s bytes=%d", dl.Reason, dl.Room, dl.From, dl.To, len(dl.Payload))
+        }, nil
+    case strings.HasPrefix(spec, "file:"):
+        f, err := os.OpenFile(strings.TrimPrefix(spec, "file:"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+        if err != nil {
+            return nil, err
+        }
+        return jsonLinesConsumer(f), nil
+    case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+        client := &http.Client{Timeout: 5 * time.Second}
+        return func(dl DeadLetter) {
+            b, _ := json.Marshal(dl)
+            resp, err := client.Post(spec, "application/json", bytes.NewReader(b))
+            if err != nil {
+                log.Printf("dead letter webhook: %v", err)
+                return
+            }
+            resp.Body.Close()
+        }, nil
+    }
+    return nil, fmt.Errorf("unknown DEADLETTER_SINK %q", spec)
+}
+
+func jsonLinesConsumer(w io.Writer) func(DeadLetter) {
+    enc := json.NewEncoder(w)
+    return func(dl DeadLetter) {
+        if err := enc.Encode(dl); err != nil {
+            log.Printf("dead letter write: %v", err)
+        }
+    }
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func collectDeadLetters(hub *Hub) chan DeadLetter {
+    got := make(chan DeadLetter, 16)
+    hub.dead = newDeadLetterSink(16, func(dl DeadLetter) { got <- dl })
+    return got
+}
+
+func expectDeadLetter(t *testing.T, got chan DeadLetter, reason string) DeadLetter {
+    t.Helper()
+    select {
+    case dl := <-got:
+        if dl.Reason != reason {
+            t.Fatalf("dead letter reason = %q, want %q", dl.Reason, reason)
+        }
+        return dl
+    case <-time.After(2 * time.Second):
+        t.Fatalf("no dead letter with reason %q", reason)
+    }
+    return DeadLetter{}
+}
+
+func TestDeadLetterQueueFull(t *testing.T) {
+    hub := NewHub()
+    got := collectDeadLetters(hub)
+    room := hub.getRoom("r")
+    slow := &Client{username: "slow", room: room, sendCh: make(chan outbound, 1)}
+    room.join(slow)
+
+    room.broadcast(nil, NewEnvelope("r", "alice", []byte("first")))
+    room.broadcast(nil, NewEnvelope("r", "alice", []byte("second")))
+
+    dl := expectDeadLetter(t, got, dropQueueFull)
+    if dl.Room != "r" || dl.From != "alice" || dl.To != "slow" || string(dl.Payload) != "second" {
+        t.Fatalf("unexpected dead letter %+v", dl)
+    }
+}
+
+func TestDeadLetterEgressBudget(t *testing.T) {
+    hub := NewHubWithConfig(Config{RoomEgressBudget: 1})
+    got := collectDeadLetters(hub)
+    room := hub.getRoom("r")
+    room.join(&Client{username: "bob", room: room, sendCh: make(chan outbound, 8)})
+
+    room.broadcast(nil, NewEnvelope("r", "alice", []byte("first")))
+    room.broadcast(nil, NewEnvelope("r", "alice", []byte("shed")))
+    if dl := expectDeadLetter(t, got, dropEgressBudget); string(dl.Payload) != "shed" {
+        t.Fatalf("unexpected dead letter %+v", dl)
+    }
+}
+
+func TestDeadLetterSchemaViolation(t *testing.T) {
+    hub := NewHub()
+    got := collectDeadLetters(hub)
+    if err := hub.SetRoomSchema("chat", []byte(chatSchema)); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/chat/alice")
+    if err := c.WriteMessage(websocket.TextMessage, []byte("nope")); err != nil {
+        t.Fatal(err)
+    }
+    if dl := expectDeadLetter(t, got, dropSchemaViolation); dl.From != "alice" || string(dl.Payload) != "nope" {
+        t.Fatalf("unexpected dead letter %+v", dl)
+    }
+}
+
+func TestDeadLetterSinkIsBounded(t *testing.T) {
+    block := make(chan struct{})
+    defer close(block)
+    d := newDeadLetterSink(2, func(DeadLetter) { <-block })
+    for i := 0; i < 10; i++ {
+        d.add(dropQueueFull, "r", "a", "b", nil)
+    }
+    // one in the consumer, two queued, the rest counted as lost
+    if lost := d.lost.Load(); lost < 7 {
+        t.Fatalf("lost = %d, want at least 7", lost)
+    }
+    var disabled *deadLetterSink
+    disabled.add(dropQueueFull, "r", "a", "b", nil) // must not panic
+}
+
+func TestDeadLetterFileConsumer(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "dead.jsonl")
+    consume, err := deadLetterConsumer("file:" + path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    consume(DeadLetter{Reason: dropQueueFull, Room: "r", From: "a", To: "b", Payload: []byte("x")})
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    if !sc.Scan() {
+        t.Fatal("no line written")
+    }
+    var dl DeadLetter
+    if err := json.Unmarshal(sc.Bytes(), &dl); err != nil || dl.Reason != dropQueueFull || dl.To != "b" {
+        t.Fatalf("unexpected line %s (%v)", sc.Bytes(), err)
+    }
+    if _, err := deadLetterConsumer("carrier-pigeon"); err == nil {
+        t.Fatal("expected error for unknown sink")
+    }
+}
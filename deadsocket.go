@@ -0,0 +1,67 @@
+package main
+
+import (
+    "errors"
+    "log"
+    "net"
+)
+
+// Dead socket detection. A half-open peer (gone without a RST) stops
+// reading, so once the socket buffers fill every write blocks until its
+// deadline, and a writer that kept going would pay WRITE_TIMEOUT for each
+// queued frame. Instead the first write that times out marks the client
+// dead: broadcasts stop selecting it, whatever is still queued is dropped
+// as dead_socket dead letters, and the socket is closed so the reader loop
+// runs its cleanup at once.
+
+const dropDeadSocket = "dead_socket"
+
+// writeFailed inspects an error from writing to c's socket and tears c
+// down when it was a timeout. It returns err.
+func (c *Client) writeFailed(err error) error {
+    var ne net.Error
+    if errors.As(err, &ne) && ne.Timeout() {
+        c.markDead()
+    }
+    return err
+}
+
+// markDead closes c's socket and discards its queues; only the writer
+// goroutine calls it.
+func (c *Client) markDead() {
+    if !c.dead.CompareAndSwap(false, true) {
+        return
+    }
+    log.Printf("write timed out, dropping dead connection: room=%s user=%s", c.room.name, c.username)
+    c.conn.Close()
+    drop := func(o outbound) {
+        c.queued.Add(-int64(len(o.msg)))
+        if o.env != nil {
+            c.countDrop()
+            c.room.hub.dead.add(dropDeadSocket, o.env.Room, o.env.Username, c.username, o.env.Payload)
+        }
+    }
+    if c.batch != nil {
+        for _, o := range c.batch.pending {
+            c.countDrop()
+            c.room.hub.dead.add(dropDeadSocket, o.env.Room, o.env.Username, c.username, o.env.Payload)
+        }
+        c.batch.pending = nil
+    }
+    for _, o := range c.takeSpilled() {
+        drop(o)
+    }
+    for {
+        select {
+        case o := <-c.sendCh:
+            drop(o)
+        default:
+            select {
+            case o := <-c.bulkCh:
+                drop(o)
+            default:
+                return
+            }
+        }
+    }
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestDeadSocketTornDownOnFirstWriteTimeout(t *testing.T) {
+    hub := NewHubWithConfig(Config{WriteTimeout: 200 * time.Millisecond})
+    dead := make(chan DeadLetter, 1024)
+    hub.dead = newDeadLetterSink(1024, func(dl DeadLetter) { dead <- dl })
+    base := startTestServer(t, hub)
+
+    // a peer that never reads stands in for a half-open connection: once
+    // the socket buffers fill, every write to it blocks
+    stuck := dialWS(t, base+"/ws/media/stuck")
+    defer stuck.Close()
+    sender := dialWS(t, base+"/ws/media/sender")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "media") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+
+    frame := bytes.Repeat([]byte("x"), 256<<10)
+    start := time.Now()
+    for i := 0; i < 128 && roomSize(hub, "media") == 2; i++ {
+        if err := sender.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // one timeout is enough: without detection the writer would spend
+    // the write timeout on each of the frames still queued
+    if !waitFor(3*time.Second, func() bool { return roomSize(hub, "media") == 1 }) {
+        t.Fatal("stuck client was not torn down")
+    }
+    if took := time.Since(start); took > 5*time.Second {
+        t.Fatalf("teardown took %v", took)
+    }
+
+    deadline := time.After(time.Second)
+    for {
+        select {
+        case dl := <-dead:
+            if dl.Reason == dropDeadSocket && dl.To == "stuck" {
+                return
+            }
+        case <-deadline:
+            t.Fatal("queued messages were not dropped as dead_socket")
+        }
+    }
+}
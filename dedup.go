@@ -0,0 +1,51 @@
+package main
+
+import (
+    "hash/maphash"
+    "sync"
+    "time"
+)
+
+// Payload deduplication (DEDUP_ROOMS). Publishers that resend on doubt
+// need no message IDs: an inbound message whose payload hashes the same as
+// one accepted within DEDUP_WINDOW is dropped as a "duplicate" dead letter
+// instead of being fanned out again. Each room keeps the hashes it accepted
+// in the last window; a dropped duplicate does not extend its original's.
+const dropDuplicate = "duplicate"
+
+type dedupWindow struct {
+    window time.Duration
+    seed   maphash.Seed
+
+    mu        sync.Mutex
+    seen      map[uint64]time.Time // payload hash -> when accepted
+    lastSweep time.Time
+}
+
+func newDedupWindow(window time.Duration) *dedupWindow {
+    if window <= 0 {
+        window = time.Second
+    }
+    return &dedupWindow{window: window, seed: maphash.MakeSeed(), seen: make(map[uint64]time.Time), lastSweep: time.Now()}
+}
+
+// duplicate reports whether payload was accepted within the window, and
+// records it as accepted at now otherwise.
+func (d *dedupWindow) duplicate(payload []byte, now time.Time) bool {
+    h := maphash.Bytes(d.seed, payload)
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if now.Sub(d.lastSweep) > d.window {
+        for k, at := range d.seen {
+            if now.Sub(at) >= d.window {
+                delete(d.seen, k)
+            }
+        }
+        d.lastSweep = now
+    }
+    if at, ok := d.seen[h]; ok && now.Sub(at) < d.window {
+        return true
+    }
+    d.seen[h] = now
+    return false
+}
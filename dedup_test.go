@@ -0,0 +1,73 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestDedupWindow(t *testing.T) {
+    d := newDedupWindow(time.Second)
+    t0 := time.Now()
+    if d.duplicate([]byte("a"), t0) {
+        t.Fatal("first sighting is not a duplicate")
+    }
+    if !d.duplicate([]byte("a"), t0.Add(500*time.Millisecond)) {
+        t.Fatal("resend within the window should be a duplicate")
+    }
+    if d.duplicate([]byte("b"), t0.Add(500*time.Millisecond)) {
+        t.Fatal("a different payload is not a duplicate")
+    }
+    // the dropped resend did not extend the window
+    if d.duplicate([]byte("a"), t0.Add(1100*time.Millisecond)) {
+        t.Fatal("resend after the window should pass")
+    }
+    if !d.duplicate([]byte("a"), t0.Add(1200*time.Millisecond)) {
+        t.Fatal("the accepted resend opens a new window")
+    }
+    d.duplicate([]byte("c"), t0.Add(5*time.Second)) // sweeps expired hashes
+    if len(d.seen) != 1 {
+        t.Fatalf("%d hashes kept after a sweep, want 1", len(d.seen))
+    }
+}
+
+func TestDedupRoomDropsResends(t *testing.T) {
+    hub := NewHubWithConfig(Config{DedupRooms: "orders", DedupWindow: 150 * time.Millisecond})
+    dead := make(chan DeadLetter, 4)
+    hub.dead = newDeadLetterSink(4, func(dl DeadLetter) { dead <- dl })
+    base := startTestServer(t, hub)
+    pub := dialWS(t, base+"/ws/orders/pub")
+    sub := dialWS(t, base+"/ws/orders/sub")
+    other := dialWS(t, base+"/ws/chat/pub")
+    otherSub := dialWS(t, base+"/ws/chat/sub")
+    waitFor(time.Second, func() bool { return roomSize(hub, "orders") == 2 && roomSize(hub, "chat") == 2 })
+
+    send := func(c *websocket.Conn, m string) {
+        t.Helper()
+        if err := c.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    send(pub, "buy 1")
+    send(pub, "buy 1") // within the window: dropped
+    send(other, "hi")
+    send(other, "hi") // chat has no dedup
+    select {
+    case dl := <-dead:
+        if dl.Reason != dropDuplicate || dl.Room != "orders" || string(dl.Payload) != "buy 1" {
+            t.Fatalf("dead letter %+v", dl)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("resend within the window was not dropped")
+    }
+    time.Sleep(200 * time.Millisecond)
+    send(pub, "buy 1") // outside the window: relayed
+
+    if got := readEnvelopes(t, sub, 200*time.Millisecond); len(got) != 2 {
+        t.Fatalf("orders subscriber got %d messages, want 2", len(got))
+    }
+    if got := readEnvelopes(t, otherSub, 200*time.Millisecond); len(got) != 2 {
+        t.Fatalf("chat subscriber got %d messages, want 2", len(got))
+    }
+}
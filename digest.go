@@ -0,0 +1,111 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Digest rooms (DIGEST_ROOMS). Busy, low-priority rooms can skip live
+// fan-out: messages are held and each member instead gets one "digest"
+// frame per interval carrying everything sent since the previous one,
+// trading latency for far fewer frames. The spec is comma-separated
+// room=interval entries, e.g. "ticks=1s,logs=500ms".
+
+// DigestFrame batches a room's messages for one member. Like other system
+// frames it is always JSON, whatever envelope version the client chose.
+type DigestFrame struct {
+    Type     string     `json:"type"`
+    Room     string     `json:"room"`
+    Messages []Envelope `json:"messages"`
+}
+
+type digester struct {
+    room     *Room
+    interval time.Duration
+
+    mu      sync.Mutex
+    pending []coalescedMsg // reuses the coalescer's sender+envelope pair
+}
+
+func newDigester(room *Room, interval time.Duration) *digester {
+    return &digester{room: room, interval: interval}
+}
+
+// LoadDigestRooms parses DIGEST_ROOMS. It applies to rooms created after
+// the call.
+func (h *Hub) LoadDigestRooms(spec string) error {
+    digests := map[string]time.Duration{}
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        room, v, ok := strings.Cut(entry, "=")
+        d, err := time.ParseDuration(v)
+        if !ok || room == "" || err != nil || d <= 0 {
+            return fmt.Errorf("invalid DIGEST_ROOMS entry %q (want room=positive interval)", entry)
+        }
+        digests[room] = d
+    }
+    h.mu.Lock()
+    h.digests = digests
+    h.mu.Unlock()
+    return nil
+}
+
+// add holds env for the next digest; the first add after a flush arms the
+// timer, so an idle room sends nothing. Payloads are redacted and
+// transformed here, as broadcast would.
+func (d *digester) add(sender *Client, env Envelope) {
+    r := d.room
+    if r.hub.redact != nil && env.text && !r.e2ee {
+        env.Payload = r.hub.redact.apply(env.Payload)
+    }
+    if r.transform != nil {
+        env.Payload = r.transform.apply(env.Payload)
+    }
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if d.pending == nil {
+        time.AfterFunc(d.interval, d.flush)
+    }
+    d.pending = append(d.pending, coalescedMsg{sender: sender, env: env})
+}
+
+// flush sends each member the held messages it would have received live,
+// in every shard of the default room.
+func (d *digester) flush() {
+    d.mu.Lock()
+    pending := d.pending
+    d.pending = nil
+    d.mu.Unlock()
+    if len(pending) == 0 {
+        return
+    }
+    r := d.room
+    members := r.groupMembers()
+    var shared []byte // frame with every message, for the common case
+    for _, c := range members {
+        var msgs []Envelope
+        for _, m := range pending {
+            if (m.sender != c || c.echo) && c.receives(m.sender) {
+                msgs = append(msgs, m.env)
+            }
+        }
+        if len(msgs) == 0 || c.dead.Load() {
+            continue
+        }
+        frame := shared
+        if len(msgs) < len(pending) {
+            frame, _ = json.Marshal(DigestFrame{Type: "digest", Room: r.logicalName(), Messages: msgs})
+        } else if shared == nil {
+            shared, _ = json.Marshal(DigestFrame{Type: "digest", Room: r.logicalName(), Messages: msgs})
+            frame = shared
+        }
+        c.counters.offered.Add(1)
+        c.offer(outbound{msg: frame})
+    }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func readDigest(t *testing.T, c *websocket.Conn) (DigestFrame, time.Time) {
+    t.Helper()
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, b, err := c.ReadMessage()
+    if err != nil {
+        t.Fatalf("no digest: %v", err)
+    }
+    var f DigestFrame
+    if err := json.Unmarshal(b, &f); err != nil || f.Type != "digest" {
+        t.Fatalf("want a digest frame, got %s", b)
+    }
+    return f, time.Now()
+}
+
+func TestDigestRoomBatchesAtInterval(t *testing.T) {
+    const interval = 300 * time.Millisecond
+    hub := NewHubWithConfig(Config{})
+    if err := hub.LoadDigestRooms("ticks=" + interval.String()); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    recv := dialWS(t, base+"/ws/ticks/recv")
+    defer recv.Close()
+    send := dialWS(t, base+"/ws/ticks/send")
+    defer send.Close()
+    waitFor(time.Second, func() bool { return roomSize(hub, "ticks") == 2 })
+
+    start := time.Now()
+    for i := 0; i < 10; i++ {
+        send.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("m%d", i)))
+    }
+    f, at := readDigest(t, recv)
+    if len(f.Messages) != 10 || f.Room != "ticks" {
+        t.Fatalf("first digest has %d messages, want all 10 in one frame", len(f.Messages))
+    }
+    for i, env := range f.Messages {
+        if string(env.Payload) != fmt.Sprintf("m%d", i) || env.Username != "send" {
+            t.Fatalf("message %d = %+v", i, env)
+        }
+    }
+    if at.Sub(start) < interval-50*time.Millisecond {
+        t.Fatalf("digest after %v, before the %v interval", at.Sub(start), interval)
+    }
+
+    start = time.Now()
+    for i := 0; i < 3; i++ {
+        send.WriteMessage(websocket.TextMessage, []byte("late"))
+    }
+    f, at = readDigest(t, recv)
+    if len(f.Messages) != 3 || at.Sub(start) < interval-50*time.Millisecond {
+        t.Fatalf("second digest: %d messages after %v", len(f.Messages), at.Sub(start))
+    }
+
+    // the sender is not sent its own messages back
+    send.SetReadDeadline(time.Now().Add(interval + 200*time.Millisecond))
+    if _, b, err := send.ReadMessage(); err == nil {
+        t.Fatalf("sender got %s", b)
+    }
+}
+
+func TestLoadDigestRoomsRejectsBadEntries(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    for _, spec := range []string{"ticks", "ticks=soon", "ticks=0s", "=1s"} {
+        if err := hub.LoadDigestRooms(spec); err == nil {
+            t.Errorf("%q accepted", spec)
+        }
+    }
+    if err := hub.LoadDigestRooms(" ticks=1s, logs=500ms "); err != nil {
+        t.Fatal(err)
+    }
+    if hub.digests["logs"] != 500*time.Millisecond {
+        t.Fatalf("digests %v", hub.digests)
+    }
+}
@@ -0,0 +1,60 @@
+package main
+
+import "time"
+
+// Graceful close. Instead of cutting a connection off with messages still
+// queued, drainAndClose has its writer flush the queues for up to
+// CLOSE_DRAIN_TIMEOUT and only then send the close frame. Every orderly
+// close goes through it; refusals of connections that never joined close
+// at once.
+
+type closeRequest struct {
+    code     int
+    reason   string
+    deadline time.Time
+}
+
+// drainAndClose asks c's writer to flush what is queued until deadline,
+// then send a close frame with code and reason. It does not wait. If the
+// writer is still busy at the deadline (a paced or stuck write), the close
+// frame is sent and the socket closed from here instead; either way c's
+// reader loop ends and runs its cleanup.
+func (c *Client) drainAndClose(code int, reason string, deadline time.Time) {
+    c.closeOnce.Do(func() {
+        c.closing.Store(true)
+        c.closeReq <- closeRequest{code: code, reason: reason, deadline: deadline}
+        time.AfterFunc(time.Until(deadline), func() {
+            writeClose(c.conn, code, reason)
+            c.conn.Close()
+        })
+    })
+}
+
+// flushQueued writes queued messages, regular queue first, then any
+// spilled ones, then the bulk queue, until all are empty or deadline
+// passes. Only the writer goroutine calls it.
+func (c *Client) flushQueued(deadline time.Time) {
+    for time.Now().Before(deadline) {
+        var o outbound
+        select {
+        case o = <-c.sendCh:
+        default:
+            if spilled := c.takeSpilled(); len(spilled) > 0 {
+                for _, o := range spilled {
+                    if !time.Now().Before(deadline) || c.writeQueued(o) != nil {
+                        return
+                    }
+                }
+                continue
+            }
+            select {
+            case o = <-c.bulkCh:
+            default:
+                return
+            }
+        }
+        if c.writeQueued(o) != nil {
+            return
+        }
+    }
+}
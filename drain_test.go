@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// readUntilClose collects envelope payloads from c until the server closes it.
+func readUntilClose(t *testing.T, c *websocket.Conn) ([]string, *websocket.CloseError) {
+    t.Helper()
+    var got []string
+    c.SetReadDeadline(time.Now().Add(5 * time.Second))
+    for {
+        _, raw, err := c.ReadMessage()
+        var ce *websocket.CloseError
+        if errors.As(err, &ce) {
+            return got, ce
+        }
+        if err != nil {
+            t.Fatalf("read: %v", err)
+        }
+        var env Envelope
+        if err := json.Unmarshal(raw, &env); err != nil {
+            t.Fatalf("decode %s: %v", raw, err)
+        }
+        got = append(got, string(env.Payload))
+    }
+}
+
+func TestReplacedConnectionDrainsQueueBeforeClose(t *testing.T) {
+    for _, tc := range []struct {
+        name    string
+        timeout time.Duration
+        all     bool
+    }{
+        {"in time", 3 * time.Second, true},
+        {"deadline passed", 0, false},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            hub := NewHubWithConfig(Config{DuplicatePolicy: DuplicateCloseOld, CloseDrainTimeout: tc.timeout})
+            base := startTestServer(t, hub)
+            // ~80 byte envelopes at 200 B/s: most of them are still queued
+            old := dialWS(t, base+"/ws/r/alice?pace=200")
+            sender := dialWS(t, base+"/ws/r/bob")
+            waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+            want := []string{"m0", "m1", "m2", "m3", "m4", "m5"}
+            for _, m := range want {
+                if err := sender.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            waitFor(time.Second, func() bool { return hub.metrics.msgsIn.Load() == int64(len(want)) })
+            time.Sleep(50 * time.Millisecond) // let the broadcasts reach the queue
+
+            dialWS(t, base+"/ws/r/alice")
+            got, ce := readUntilClose(t, old)
+            if ce.Code != closeReplaced {
+                t.Fatalf("close code = %d, want %d", ce.Code, closeReplaced)
+            }
+            if tc.all && len(got) != len(want) {
+                t.Fatalf("got %v before the close, want all of %v", got, want)
+            }
+            if !tc.all && len(got) == len(want) {
+                t.Fatalf("got every message with no time to drain: %v", got)
+            }
+            for i, m := range got {
+                if m != want[i] {
+                    t.Fatalf("got %v, want a prefix of %v", got, want)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestEchoSelfOptIn(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/echo/alice?echo=1")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "echo") == 1 }) {
+        t.Fatal("client did not join")
+    }
+    if err := c.WriteMessage(websocket.TextMessage, []byte("mine")); err != nil {
+        t.Fatal(err)
+    }
+    got := readEnvelopes(t, c, 300*time.Millisecond)
+    if len(got) != 1 || got[0].Username != "alice" || string(got[0].Payload) != "mine" {
+        t.Fatalf("echo=1 sender got %+v, want its own envelope", got)
+    }
+}
+
+func TestEchoSelfSuppressedByDefault(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/echo/alice")
+    peer := dialWS(t, base+"/ws/echo/bob")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "echo") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+    if err := c.WriteMessage(websocket.TextMessage, []byte("mine")); err != nil {
+        t.Fatal(err)
+    }
+    if got := readEnvelopes(t, peer, 300*time.Millisecond); len(got) != 1 {
+        t.Fatalf("peer got %d messages, want 1", len(got))
+    }
+    if got := readEnvelopes(t, c, 100*time.Millisecond); len(got) != 0 {
+        t.Fatalf("sender got its own message back: %+v", got)
+    }
+}
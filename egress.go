@@ -0,0 +1,57 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+// egressMeter tracks a room's fan-out amplification: bytes accepted from
+// senders versus bytes queued to recipients, in one-second windows. With a
+// budget (ROOM_EGRESS_BUDGET, bytes out per second) it sheds broadcasts that
+// would push the current window over the budget.
+type egressMeter struct {
+    mu          sync.Mutex
+    windowStart time.Time
+    in, out     int64 // current window
+    lastIn      int64 // previous window
+    lastOut     int64
+    shed        int64 // broadcasts shed since start
+}
+
+func (m *egressMeter) rollLocked(now time.Time) {
+    elapsed := now.Sub(m.windowStart)
+    if elapsed < time.Second {
+        return
+    }
+    if elapsed < 2*time.Second {
+        m.lastIn, m.lastOut = m.in, m.out
+    } else {
+        m.lastIn, m.lastOut = 0, 0
+    }
+    m.in, m.out = 0, 0
+    m.windowStart = now
+}
+
+// admit accounts for a broadcast of in bytes fanned out as out bytes. A
+// broadcast is always admitted into an empty window so oversized messages
+// still trickle through at one per second.
+func (m *egressMeter) admit(in, out, budget int64) bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.rollLocked(time.Now())
+    if budget > 0 && m.out > 0 && m.out+out > budget {
+        m.shed++
+        return false
+    }
+    m.in += in
+    m.out += out
+    return true
+}
+
+// snapshot returns bytes in/out over the last complete window and total sheds.
+func (m *egressMeter) snapshot() (in, out, shed int64) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.rollLocked(time.Now())
+    return m.lastIn, m.lastOut, m.shed
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestEgressMeterBudget(t *testing.T) {
+    var m egressMeter
+    if !m.admit(10, 600, 1000) {
+        t.Fatal("first broadcast in a window must be admitted")
+    }
+    if m.admit(10, 600, 1000) {
+        t.Fatal("broadcast over budget must be shed")
+    }
+    if !m.admit(10, 300, 1000) {
+        t.Fatal("broadcast within remaining budget must be admitted")
+    }
+    if !m.admit(10, 5000, 0) {
+        t.Fatal("zero budget means unlimited")
+    }
+    if _, _, shed := m.snapshot(); shed != 1 {
+        t.Fatalf("shed = %d, want 1", shed)
+    }
+}
+
+func TestEgressBudgetShedsLargeRoomOnly(t *testing.T) {
+    hub := NewHubWithConfig(Config{RoomEgressBudget: 3000})
+    base := startTestServer(t, hub)
+
+    bigSender := dialWS(t, base+"/ws/big/sender")
+    var bigReceivers []*websocket.Conn
+    for i := 0; i < 10; i++ {
+        bigReceivers = append(bigReceivers, dialWS(t, fmt.Sprintf("%s/ws/big/r%d", base, i)))
+    }
+    smallSender := dialWS(t, base+"/ws/small/sender")
+    smallReceiver := dialWS(t, base+"/ws/small/r")
+    waitFor(time.Second, func() bool { return roomSize(hub, "big") == 11 && roomSize(hub, "small") == 2 })
+
+    payload := []byte(strings.Repeat("x", 50))
+    for i := 0; i < 10; i++ {
+        if err := bigSender.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+            t.Fatal(err)
+        }
+        if err := smallSender.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if got := len(readEnvelopes(t, smallReceiver, 300*time.Millisecond)); got != 10 {
+        t.Fatalf("small room delivered %d messages, want 10", got)
+    }
+    if got := len(readEnvelopes(t, bigReceivers[0], 300*time.Millisecond)); got == 0 || got >= 10 {
+        t.Fatalf("big room delivered %d messages, want some shed", got)
+    }
+
+    // amplification is reported once the one-second window completes
+    rooms := map[string]RoomStats{}
+    ok := waitFor(2*time.Second, func() bool {
+        var st HubStats
+        getJSON(t, base, "/stats", &st)
+        for _, rs := range st.Rooms {
+            rooms[rs.Room] = rs
+        }
+        return rooms["big"].Amplification > 0
+    })
+    if !ok {
+        t.Fatalf("no amplification reported: %+v", rooms)
+    }
+    if rooms["big"].Amplification <= rooms["small"].Amplification {
+        t.Fatalf("big room amplification %.1f not above small room %.1f", rooms["big"].Amplification, rooms["small"].Amplification)
+    }
+    if rooms["big"].ShedBroadcasts == 0 || rooms["small"].ShedBroadcasts != 0 {
+        t.Fatalf("unexpected shed counts: %+v", rooms)
+    }
+}
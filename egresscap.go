@@ -0,0 +1,84 @@
+package main
+
+import (
+    "log"
+    "sync"
+    "time"
+)
+
+// Hard per-room egress cap (ROOM_EGRESS_CAP bytes per
+// ROOM_EGRESS_CAP_WINDOW). ROOM_EGRESS_BUDGET smooths a room's rate one
+// second at a time; this is a safety cutoff for a runaway room instead.
+// Once a room's fan-out would take it past the cap, every further
+// broadcast in it is dropped as an egress_cap dead letter until the window
+// ends. Tripping the cap logs a warning, counts in
+// relay_room_egress_cutoffs_total and POSTs ROOM_EGRESS_ALERT_WEBHOOK.
+
+const dropEgressCap = "egress_cap"
+
+type egressCap struct {
+    limit  int64
+    window time.Duration
+
+    mu      sync.Mutex
+    start   time.Time
+    used    int64
+    tripped bool // cut off for the rest of the window
+}
+
+// newEgressCap returns nil when limit is 0; the window defaults to a minute.
+func newEgressCap(limit int64, window time.Duration) *egressCap {
+    if limit <= 0 {
+        return nil
+    }
+    if window <= 0 {
+        window = time.Minute
+    }
+    return &egressCap{limit: limit, window: window}
+}
+
+// admit reports whether r may fan out another out bytes in the current
+// window, raising the alert on the first refusal of a window. It returns the
+// start of the window the bytes were charged to, for refund.
+func (ec *egressCap) admit(r *Room, out int64) (time.Time, bool) {
+    if ec == nil {
+        return time.Time{}, true
+    }
+    now := time.Now()
+    ec.mu.Lock()
+    if now.Sub(ec.start) >= ec.window {
+        if ec.tripped {
+            log.Printf("room %s egress cap window reset; broadcasting resumed", r.name)
+        }
+        ec.start, ec.used, ec.tripped = now, 0, false
+    }
+    if ec.tripped || ec.used+out > ec.limit {
+        alert := !ec.tripped
+        ec.tripped = true
+        ec.mu.Unlock()
+        if alert {
+            log.Printf("room %s hit its egress cap of %d bytes per %s; broadcasts stopped until the window resets", r.name, ec.limit, ec.window)
+            r.hub.metrics.egressCutoffs.Add(1)
+            r.hub.hooks.egressCapped(r.name, ec.limit)
+        }
+        return time.Time{}, false
+    }
+    ec.used += out
+    start := ec.start
+    ec.mu.Unlock()
+    return start, true
+}
+
+// refund returns out bytes admitted by admit, in the window starting at
+// window, for a broadcast a later stage refused. A window that has reset
+// since has already forgotten them, so then there is nothing to return.
+func (ec *egressCap) refund(window time.Time, out int64) {
+    if ec == nil {
+        return
+    }
+    ec.mu.Lock()
+    if ec.start.Equal(window) && ec.used >= out {
+        ec.used -= out
+    }
+    ec.mu.Unlock()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestRoomEgressCapCutsOffUntilWindowResets(t *testing.T) {
+    alerts := make(chan RoomEvent, 4)
+    fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var ev RoomEvent
+        json.NewDecoder(r.Body).Decode(&ev)
+        alerts <- ev
+    }))
+    defer fake.Close()
+
+    payload := []byte("0123456789")
+    size := int64(len(renderEnvelopeV1(NewEnvelope("capped", "sender", payload))))
+    hub := NewHubWithConfig(Config{RoomEgressCap: 3*size + size/2, RoomEgressCapWindow: 300 * time.Millisecond, RoomEgressAlertWebhook: fake.URL})
+    dead := make(chan DeadLetter, 16)
+    hub.dead = newDeadLetterSink(16, func(dl DeadLetter) { dead <- dl })
+    room := hub.getRoom("capped")
+    sender := fakeClient(room, "sender", 16)
+    recv := fakeClient(room, "recv", 16)
+
+    for i := 0; i < 5; i++ {
+        room.broadcast(sender, NewEnvelope("capped", "sender", payload))
+    }
+    if got := drain(recv, 5, 100*time.Millisecond); len(got) != 3 {
+        t.Fatalf("delivered %d broadcasts, want the 3 under the cap", len(got))
+    }
+    for i := 0; i < 2; i++ {
+        select {
+        case dl := <-dead:
+            if dl.Reason != dropEgressCap || dl.Room != "capped" {
+                t.Fatalf("dead letter %+v", dl)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("missing egress_cap dead letter")
+        }
+    }
+    select {
+    case ev := <-alerts:
+        if ev.Event != "room_egress_cap" || ev.Room != "capped" || ev.Limit != 3*size+size/2 {
+            t.Fatalf("alert %+v", ev)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("no egress cap alert")
+    }
+    if n := hub.metrics.egressCutoffs.Load(); n != 1 {
+        t.Fatalf("cutoffs = %d, want one per window", n)
+    }
+
+    // a small message would fit under the cap, but the room stays cut off
+    room.broadcast(sender, NewEnvelope("capped", "sender", nil))
+    if got := drain(recv, 1, 50*time.Millisecond); len(got) != 0 {
+        t.Fatal("broadcast delivered after the cutoff")
+    }
+
+    time.Sleep(300 * time.Millisecond)
+    room.broadcast(sender, NewEnvelope("capped", "sender", payload))
+    if got := drain(recv, 1, 100*time.Millisecond); len(got) != 1 {
+        t.Fatal("broadcasting did not resume after the window")
+    }
+}
+
+func TestRefusedBroadcastLeavesNoTrace(t *testing.T) {
+    payload := []byte("0123456789")
+    size := int64(len(renderEnvelopeV1(NewEnvelope("capped", "sender", payload))))
+    hub := NewHubWithConfig(Config{AckRooms: "capped", RoomEgressCap: 2*size + size/2, RoomEgressCapWindow: time.Minute})
+    hub.events = &eventStream{}
+    room := hub.getRoom("capped")
+    sender := fakeClient(room, "sender", 16)
+    fakeClient(room, "recv", 16)
+    for i := 0; i < 4; i++ {
+        room.broadcast(sender, NewEnvelope("capped", "sender", payload))
+    }
+    if n := room.ackSeq.Load(); n != 2 {
+        t.Fatalf("ack seq %d, want only the 2 delivered numbered", n)
+    }
+    if n := room.traffic.msgs.Load(); n != 2 {
+        t.Fatalf("event traffic counts %d broadcasts, want 2", n)
+    }
+}
+
+func TestRefusedBroadcastLeavesEgressCapUncharged(t *testing.T) {
+    hub := NewHubWithConfig(Config{RoomEgressCap: 1000, RoomEgressCapWindow: time.Minute, RoomEgressBudget: 100, GlobalEgressBudget: 150})
+    room := hub.getRoom("capped")
+    env := NewEnvelope("capped", "sender", []byte("x"))
+    if !room.admit(env, 80) {
+        t.Fatal("first broadcast of the window refused")
+    }
+    // refused by the global budget, then by the room's egress budget
+    if room.admit(env, 80) || room.admit(env, 30) {
+        t.Fatal("broadcast over budget admitted")
+    }
+    room.egressCap.mu.Lock()
+    used := room.egressCap.used
+    room.egressCap.mu.Unlock()
+    if used != 80 {
+        t.Fatalf("egress cap charged %d bytes, want only the admitted 80", used)
+    }
+}
+
+func TestEgressCapRefundAfterResetIsIgnored(t *testing.T) {
+    hub := NewHubWithConfig(Config{RoomEgressCap: 100, RoomEgressCapWindow: time.Minute})
+    room := hub.getRoom("capped")
+    ec := room.egressCap
+    old, ok := ec.admit(room, 60)
+    if !ok {
+        t.Fatal("admit refused")
+    }
+    // the window resets and new traffic is charged before the refund lands
+    ec.mu.Lock()
+    ec.start = ec.start.Add(-time.Minute)
+    ec.mu.Unlock()
+    if _, ok := ec.admit(room, 70); !ok {
+        t.Fatal("admit refused in a fresh window")
+    }
+    ec.refund(old, 60)
+    ec.mu.Lock()
+    used := ec.used
+    ec.mu.Unlock()
+    if used != 70 {
+        t.Fatalf("new window used %d bytes, want 70: a stale refund was applied", used)
+    }
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+)
+
+// Envelope wire formats. Clients pick one at connect with ?ver=N so the
+// format can evolve without breaking older clients; version 1 is the
+// original JSON envelope and stays the default.
+const defaultEnvelopeVersion = 1
+
+var envelopeRenderers = map[int]func(Envelope) []byte{
+    1: renderEnvelopeV1,
+    2: renderEnvelopeV2,
+    3: renderEnvelopeV3,
+    4: renderEnvelopeV4,
+}
+
+// envelopeVersionsByOverhead lists the formats from richest to most
+// compact, the order in which ?max_overhead= tries them. Version 4 is
+// left out so existing budgets keep resolving to the same formats.
+var envelopeVersionsByOverhead = []int{1, 2, 3}
+
+func renderEnvelopeV1(env Envelope) []byte {
+    b, _ := json.Marshal(env)
+    return b
+}
+
+// envelopeV2 is the slim format: short keys plus an explicit version tag.
+type envelopeV2 struct {
+    V       int    `json:"v"`
+    Room    string `json:"r"`
+    User    string `json:"u"`
+    Ts      int64  `json:"t"`
+    Payload []byte `json:"p"`
+    Seq     uint64 `json:"s,omitempty"`
+    Sender  uint64 `json:"q,omitempty"` // Envelope.SenderSeq
+    Client  string `json:"i,omitempty"` // Envelope.ClientID
+}
+
+func renderEnvelopeV2(env Envelope) []byte {
+    b, _ := json.Marshal(envelopeV2{V: 2, Room: env.Room, User: env.Username, Ts: env.Ts, Payload: env.Payload, Seq: env.Seq, Sender: env.SenderSeq, Client: env.ClientID})
+    return b
+}
+
+// renderEnvelopeV3 is the binary format: a 0x03 tag, uvarint-prefixed room
+// and username, the timestamp as 8 big-endian bytes, then the raw payload.
+func renderEnvelopeV3(env Envelope) []byte {
+    b := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(env.Room)+len(env.Username)+8+len(env.Payload))
+    b = append(b, 3)
+    b = binary.AppendUvarint(b, uint64(len(env.Room)))
+    b = append(b, env.Room...)
+    b = binary.AppendUvarint(b, uint64(len(env.Username)))
+    b = append(b, env.Username...)
+    b = binary.BigEndian.AppendUint64(b, uint64(env.Ts))
+    return append(b, env.Payload...)
+}
+
+// Version 4 is the extensible binary format: a 0x04 tag followed by fields,
+// each a uvarint field number, a uvarint length and that many bytes. Zero
+// fields are omitted and decoders skip numbers they do not know, so fields
+// can be added without a new version and without breaking older readers.
+const (
+    v4Room      = 1
+    v4User      = 2
+    v4Ts        = 3 // 8 bytes, big-endian
+    v4Seq       = 4 // uvarint
+    v4SenderSeq = 5 // uvarint
+    v4Payload   = 6
+    v4ClientID  = 7
+)
+
+func renderEnvelopeV4(env Envelope) []byte {
+    b := make([]byte, 0, 1+7*2*binary.MaxVarintLen64+len(env.Room)+len(env.Username)+8+len(env.Payload)+len(env.ClientID))
+    b = append(b, 4)
+    field := func(num uint64, v []byte) {
+        b = binary.AppendUvarint(b, num)
+        b = binary.AppendUvarint(b, uint64(len(v)))
+        b = append(b, v...)
+    }
+    uvarint := func(num, v uint64) {
+        if v != 0 {
+            field(num, binary.AppendUvarint(nil, v))
+        }
+    }
+    field(v4Room, []byte(env.Room))
+    field(v4User, []byte(env.Username))
+    field(v4Ts, binary.BigEndian.AppendUint64(nil, uint64(env.Ts)))
+    uvarint(v4Seq, env.Seq)
+    uvarint(v4SenderSeq, env.SenderSeq)
+    field(v4Payload, env.Payload)
+    if env.ClientID != "" {
+        field(v4ClientID, []byte(env.ClientID))
+    }
+    return b
+}
+
+var errTruncatedEnvelope = errors.New("truncated envelope")
+
+// DecodeEnvelope parses an envelope in any of the formats the relay
+// renders, telling them apart by their first byte. It is tolerant in both
+// directions: unknown JSON keys and unknown v4 fields are ignored, and
+// fields an older sender did not write decode as zero.
+func DecodeEnvelope(b []byte) (Envelope, error) {
+    if len(b) == 0 {
+        return Envelope{}, errTruncatedEnvelope
+    }
+    switch b[0] {
+    case '{':
+        return readEnvelopeJSON(b)
+    case 3:
+        return readEnvelopeV3(b[1:])
+    case 4:
+        return readEnvelopeV4(b[1:])
+    }
+    return Envelope{}, fmt.Errorf("unknown envelope format 0x%02x", b[0])
+}
+
+// readEnvelopeJSON reads version 1, or version 2 when "v" says so.
+func readEnvelopeJSON(b []byte) (Envelope, error) {
+    var probe struct {
+        V int `json:"v"`
+    }
+    if err := json.Unmarshal(b, &probe); err != nil {
+        return Envelope{}, err
+    }
+    switch probe.V {
+    case 0, 1:
+        var env Envelope
+        err := json.Unmarshal(b, &env)
+        return env, err
+    case 2:
+        var v2 envelopeV2
+        err := json.Unmarshal(b, &v2)
+        return Envelope{Room: v2.Room, Username: v2.User, Ts: v2.Ts, Payload: v2.Payload, Seq: v2.Seq, SenderSeq: v2.Sender, ClientID: v2.Client}, err
+    }
+    return Envelope{}, fmt.Errorf("unsupported envelope version %d", probe.V)
+}
+
+// readLenField reads a uvarint-length-prefixed field off the front of b.
+func readLenField(b []byte) (field, rest []byte, err error) {
+    n, k := binary.Uvarint(b)
+    if k <= 0 || uint64(len(b)-k) < n {
+        return nil, nil, errTruncatedEnvelope
+    }
+    return b[k : k+int(n)], b[k+int(n):], nil
+}
+
+func readEnvelopeV3(b []byte) (Envelope, error) {
+    room, b, err := readLenField(b)
+    if err != nil {
+        return Envelope{}, err
+    }
+    user, b, err := readLenField(b)
+    if err != nil || len(b) < 8 {
+        return Envelope{}, errTruncatedEnvelope
+    }
+    return Envelope{Room: string(room), Username: string(user), Ts: int64(binary.BigEndian.Uint64(b)), Payload: b[8:]}, nil
+}
+
+func readEnvelopeV4(b []byte) (Envelope, error) {
+    var env Envelope
+    for len(b) > 0 {
+        num, k := binary.Uvarint(b)
+        if k <= 0 {
+            return Envelope{}, errTruncatedEnvelope
+        }
+        v, rest, err := readLenField(b[k:])
+        if err != nil {
+            return Envelope{}, err
+        }
+        b = rest
+        switch num {
+        case v4Room:
+            env.Room = string(v)
+        case v4User:
+            env.Username = string(v)
+        case v4Ts:
+            if len(v) != 8 {
+                return Envelope{}, fmt.Errorf("v4 ts field is %d bytes", len(v))
+            }
+            env.Ts = int64(binary.BigEndian.Uint64(v))
+        case v4Seq:
+            env.Seq, _ = binary.Uvarint(v)
+        case v4SenderSeq:
+            env.SenderSeq, _ = binary.Uvarint(v)
+        case v4Payload:
+            env.Payload = v
+        case v4ClientID:
+            env.ClientID = string(v)
+        }
+        // any other field is from a newer sender: skipped
+    }
+    return env, nil
+}
+
+// parseEnvelopeVersion validates the ?ver query value; empty means default.
+func parseEnvelopeVersion(s string) (int, error) {
+    if s == "" {
+        return defaultEnvelopeVersion, nil
+    }
+    v, err := strconv.Atoi(s)
+    if err != nil || envelopeRenderers[v] == nil {
+        return 0, fmt.Errorf("unsupported envelope version %q", s)
+    }
+    return v, nil
+}
+
+// parseMaxOverhead validates the ?max_overhead query value: the most
+// envelope bytes a client will accept per message on top of the raw
+// payload. Empty means no budget; the client keeps its ?ver format.
+func parseMaxOverhead(s string) (int, error) {
+    if s == "" {
+        return 0, nil
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil || n <= 0 {
+        return 0, fmt.Errorf("invalid max_overhead %q", s)
+    }
+    return n, nil
+}
+
+// envelopeCache renders an envelope at most once per version during a fan-out.
+type envelopeCache struct {
+    env      Envelope
+    rendered map[int][]byte
+}
+
+func (ec *envelopeCache) render(version int) []byte {
+    if version == 0 {
+        version = defaultEnvelopeVersion
+    }
+    if b, ok := ec.rendered[version]; ok {
+        return b
+    }
+    if ec.rendered == nil {
+        ec.rendered = make(map[int][]byte, 1)
+    }
+    b := envelopeRenderers[version](ec.env)
+    ec.rendered[version] = b
+    return b
+}
+
+// fit returns the richest format whose overhead for this envelope is within
+// budget, or the most compact one when none is. JSON formats base64 the
+// payload, so their overhead grows with it and the choice is per message.
+func (ec *envelopeCache) fit(budget int) int {
+    for _, v := range envelopeVersionsByOverhead {
+        if len(ec.render(v))-len(ec.env.Payload) <= budget {
+            return v
+        }
+    }
+    return envelopeVersionsByOverhead[len(envelopeVersionsByOverhead)-1]
+}
+
+// envelopeFor renders ec in the format c receives.
+func (c *Client) envelopeFor(ec *envelopeCache) []byte {
+    if c.maxOverhead > 0 {
+        return ec.render(ec.fit(c.maxOverhead))
+    }
+    return ec.render(c.envVersion)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestParseEnvelopeVersion(t *testing.T) {
+    if v, err := parseEnvelopeVersion(""); err != nil || v != defaultEnvelopeVersion {
+        t.Fatalf("default: got %d, %v", v, err)
+    }
+    if v, err := parseEnvelopeVersion("2"); err != nil || v != 2 {
+        t.Fatalf("ver=2: got %d, %v", v, err)
+    }
+    for _, bad := range []string{"0", "99", "x"} {
+        if _, err := parseEnvelopeVersion(bad); err == nil {
+            t.Errorf("ver=%s: expected error", bad)
+        }
+    }
+}
+
+func TestUnsupportedEnvelopeVersionRejected(t *testing.T) {
+    url := startTestServer(t, NewHub())
+    _, resp, err := websocket.DefaultDialer.Dial(url+"/ws/r/alice?ver=99", nil)
+    if err == nil {
+        t.Fatal("expected dial to fail")
+    }
+    if resp == nil || resp.StatusCode != http.StatusBadRequest {
+        t.Fatalf("expected 400, got %v", resp)
+    }
+}
+
+func TestMixedEnvelopeVersions(t *testing.T) {
+    hub := NewHub()
+    url := startTestServer(t, hub)
+    v1 := dialWS(t, url+"/ws/r/old?ver=1")
+    v2 := dialWS(t, url+"/ws/r/new?ver=2")
+    sender := dialWS(t, url+"/ws/r/sender")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 3 })
+
+    if err := sender.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+        t.Fatal(err)
+    }
+
+    v1.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := v1.ReadMessage()
+    if err != nil {
+        t.Fatalf("v1 read: %v", err)
+    }
+    var old Envelope
+    if err := json.Unmarshal(raw, &old); err != nil {
+        t.Fatal(err)
+    }
+    if old.Room != "r" || old.Username != "sender" || string(old.Payload) != "hello" {
+        t.Fatalf("unexpected v1 envelope %s", raw)
+    }
+
+    v2.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err = v2.ReadMessage()
+    if err != nil {
+        t.Fatalf("v2 read: %v", err)
+    }
+    if strings.Contains(string(raw), `"username"`) {
+        t.Fatalf("v2 envelope uses v1 keys: %s", raw)
+    }
+    var slim envelopeV2
+    if err := json.Unmarshal(raw, &slim); err != nil {
+        t.Fatal(err)
+    }
+    if slim.V != 2 || slim.Room != "r" || slim.User != "sender" || string(slim.Payload) != "hello" {
+        t.Fatalf("unexpected v2 envelope %s", raw)
+    }
+    if slim.Ts != old.Ts {
+        t.Fatalf("versions rendered different messages: ts %d vs %d", slim.Ts, old.Ts)
+    }
+}
+
+// decodeEnvelopeV3 is the client side of renderEnvelopeV3.
+func decodeEnvelopeV3(t *testing.T, b []byte) Envelope {
+    t.Helper()
+    if len(b) == 0 || b[0] != 3 {
+        t.Fatalf("not a v3 envelope: %q", b)
+    }
+    b = b[1:]
+    field := func() string {
+        n, k := binary.Uvarint(b)
+        if k <= 0 || uint64(len(b)-k) < n {
+            t.Fatalf("truncated v3 envelope")
+        }
+        s := string(b[k : k+int(n)])
+        b = b[k+int(n):]
+        return s
+    }
+    env := Envelope{Room: field(), Username: field()}
+    if len(b) < 8 {
+        t.Fatalf("truncated v3 envelope")
+    }
+    env.Ts = int64(binary.BigEndian.Uint64(b))
+    env.Payload = b[8:]
+    return env
+}
+
+func TestEnvelopeV3RoundTrip(t *testing.T) {
+    env := NewEnvelope("room", "alice", []byte{0, 1, 2, 0xff})
+    got := decodeEnvelopeV3(t, renderEnvelopeV3(env))
+    if got.Room != env.Room || got.Username != env.Username || got.Ts != env.Ts || !bytes.Equal(got.Payload, env.Payload) {
+        t.Fatalf("round trip: got %+v, want %+v", got, env)
+    }
+}
+
+func TestEnvelopeFitsOverheadBudget(t *testing.T) {
+    ec := envelopeCache{env: NewEnvelope("r", "sender", []byte("hello"))}
+    overhead := func(v int) int { return len(ec.render(v)) - len(ec.env.Payload) }
+    if !(overhead(1) > overhead(2) && overhead(2) > overhead(3)) {
+        t.Fatalf("formats not ordered by overhead: %d, %d, %d", overhead(1), overhead(2), overhead(3))
+    }
+    cases := []struct {
+        budget int
+        want   int
+    }{
+        {1 << 20, 1},
+        {overhead(1), 1},
+        {overhead(1) - 1, 2},
+        {overhead(2), 2},
+        {overhead(2) - 1, 3},
+        {overhead(3), 3},
+        {1, 3}, // nothing fits: most compact
+    }
+    for _, tc := range cases {
+        if got := ec.fit(tc.budget); got != tc.want {
+            t.Errorf("fit(%d) = v%d, want v%d", tc.budget, got, tc.want)
+        }
+    }
+
+    // base64 makes JSON overhead grow with the payload
+    big := envelopeCache{env: NewEnvelope("r", "sender", make([]byte, 3000))}
+    if got := big.fit(overhead(1)); got == 1 {
+        t.Errorf("large payload should not fit the JSON budget of a small one")
+    }
+}
+
+func TestMaxOverheadNegotiation(t *testing.T) {
+    hub := NewHub()
+    url := startTestServer(t, hub)
+    for _, q := range []string{"?max_overhead=0", "?max_overhead=x", "?max_overhead=10&ver=1"} {
+        _, resp, err := websocket.DefaultDialer.Dial(url+"/ws/r/bad"+q, nil)
+        if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+            t.Fatalf("%s: expected 400, got %v", q, resp)
+        }
+    }
+
+    rich := dialWS(t, url+"/ws/r/rich?max_overhead=4096")
+    slim := dialWS(t, url+"/ws/r/slim?max_overhead=70")
+    tight := dialWS(t, url+"/ws/r/tight?max_overhead=20")
+    sender := dialWS(t, url+"/ws/r/sender")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 4 })
+    if err := sender.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+        t.Fatal(err)
+    }
+
+    read := func(c *websocket.Conn, budget int) []byte {
+        t.Helper()
+        c.SetReadDeadline(time.Now().Add(2 * time.Second))
+        _, raw, err := c.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(raw)-len("hello") > budget {
+            t.Errorf("envelope overhead %d exceeds budget %d: %q", len(raw)-len("hello"), budget, raw)
+        }
+        return raw
+    }
+    if raw := read(rich, 4096); !strings.Contains(string(raw), `"username":"sender"`) {
+        t.Errorf("rich client should get v1 JSON, got %s", raw)
+    }
+    var s envelopeV2
+    if raw := read(slim, 70); json.Unmarshal(raw, &s) != nil || s.V != 2 || string(s.Payload) != "hello" {
+        t.Errorf("slim client should get v2, got %s", raw)
+    }
+    if env := decodeEnvelopeV3(t, read(tight, 20)); env.Username != "sender" || string(env.Payload) != "hello" {
+        t.Errorf("tight client got %+v", env)
+    }
+}
+
+func TestSenderSeqIncreasesPerSender(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    url := startTestServer(t, hub)
+    recv := dialWS(t, url+"/ws/seq/recv")
+    alice := dialWS(t, url+"/ws/seq/alice")
+    bob := dialWS(t, url+"/ws/seq/bob?ver=2")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "seq") == 3 }) {
+        t.Fatal("clients did not join")
+    }
+    for i := 0; i < 100; i++ {
+        for _, c := range []*websocket.Conn{alice, bob} {
+            if err := c.WriteMessage(websocket.TextMessage, []byte("m")); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    envs := readEnvelopes(t, recv, 300*time.Millisecond)
+    if len(envs) != 200 {
+        t.Fatalf("received %d envelopes, want 200", len(envs))
+    }
+    last := map[string]uint64{}
+    for _, env := range envs {
+        if env.SenderSeq != last[env.Username]+1 {
+            t.Fatalf("%s: seq %d after %d", env.Username, env.SenderSeq, last[env.Username])
+        }
+        last[env.Username] = env.SenderSeq
+    }
+
+    // v2 carries the same number as "q"
+    bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := bob.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var v2 envelopeV2
+    if err := json.Unmarshal(raw, &v2); err != nil || v2.User != "alice" || v2.Sender != 1 {
+        t.Fatalf("bob's first v2 envelope %s (%v), want alice's message 1", raw, err)
+    }
+}
+
+func TestDecodeEnvelopeRoundTripsEveryVersion(t *testing.T) {
+    env := NewEnvelope("room", "alice", []byte("hi\x00there"))
+    env.Seq, env.SenderSeq, env.ClientID = 7, 42, "c0ffee"
+    for v, render := range envelopeRenderers {
+        got, err := DecodeEnvelope(render(env))
+        if err != nil {
+            t.Fatalf("v%d: %v", v, err)
+        }
+        want := env
+        if v == 3 { // v3 carries no sequence numbers or client ID
+            want.Seq, want.SenderSeq, want.ClientID = 0, 0, ""
+        }
+        if got.Room != want.Room || got.Username != want.Username || got.Ts != want.Ts || got.Seq != want.Seq || got.SenderSeq != want.SenderSeq || got.ClientID != want.ClientID || !bytes.Equal(got.Payload, want.Payload) {
+            t.Fatalf("v%d round trip: got %+v, want %+v", v, got, want)
+        }
+    }
+}
+
+func TestDecodeEnvelopeToleratesExtraAndMissingFields(t *testing.T) {
+    cases := []struct {
+        name string
+        in   string
+        want Envelope
+    }{
+        {"v1 newer fields", `{"room":"r","username":"u","ts":5,"payload":"aGk=","topic":"t","role":"admin","instance":{"id":3},"sender_seq":2}`,
+            Envelope{Room: "r", Username: "u", Ts: 5, Payload: []byte("hi"), SenderSeq: 2}},
+        {"v1 older sender", `{"room":"r","username":"u","ts":5}`, Envelope{Room: "r", Username: "u", Ts: 5}},
+        {"v2 newer fields", `{"v":2,"r":"r","u":"u","t":5,"p":"aGk=","s":9,"topic":"t","x":[1,2]}`,
+            Envelope{Room: "r", Username: "u", Ts: 5, Payload: []byte("hi"), Seq: 9}},
+        {"v2 older sender", `{"v":2,"r":"r","u":"u","t":5,"p":"aGk="}`, Envelope{Room: "r", Username: "u", Ts: 5, Payload: []byte("hi")}},
+    }
+    for _, tc := range cases {
+        got, err := DecodeEnvelope([]byte(tc.in))
+        if err != nil {
+            t.Fatalf("%s: %v", tc.name, err)
+        }
+        if got.Room != tc.want.Room || got.Username != tc.want.Username || got.Ts != tc.want.Ts || got.Seq != tc.want.Seq || got.SenderSeq != tc.want.SenderSeq || !bytes.Equal(got.Payload, tc.want.Payload) {
+            t.Fatalf("%s: got %+v, want %+v", tc.name, got, tc.want)
+        }
+    }
+    if _, err := DecodeEnvelope([]byte(`{"v":9,"r":"r"}`)); err == nil {
+        t.Fatal("unknown JSON version decoded")
+    }
+}
+
+func TestEnvelopeV4SkipsUnknownFields(t *testing.T) {
+    env := NewEnvelope("room", "bob", []byte("payload"))
+    env.SenderSeq = 3
+    b := renderEnvelopeV4(env)
+    // a newer sender's field 9, placed before the ones this reader knows
+    newer := append([]byte{4, 9, 3, 'x', 'y', 'z'}, b[1:]...)
+    got, err := DecodeEnvelope(newer)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Room != "room" || got.Username != "bob" || got.Ts != env.Ts || got.SenderSeq != 3 || string(got.Payload) != "payload" {
+        t.Fatalf("got %+v", got)
+    }
+
+    // an older sender that wrote only room and payload
+    older := []byte{4, v4Room, 1, 'r', v4Payload, 2, 'h', 'i'}
+    if got, err := DecodeEnvelope(older); err != nil || got.Room != "r" || got.Username != "" || got.Ts != 0 || string(got.Payload) != "hi" {
+        t.Fatalf("older v4: %+v, %v", got, err)
+    }
+
+    if _, err := DecodeEnvelope(b[:len(b)-3]); err == nil {
+        t.Fatal("truncated v4 envelope decoded")
+    }
+    if _, err := DecodeEnvelope([]byte{3, 5, 'a'}); err == nil {
+        t.Fatal("truncated v3 envelope decoded")
+    }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// expectErrorFrame reads frames from c until an error frame arrives and
+// checks its code.
+func expectErrorFrame(t *testing.T, c *websocket.Conn, code string) ErrorFrame {
+    t.Helper()
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    for {
+        _, raw, err := c.ReadMessage()
+        if err != nil {
+            t.Fatalf("no %s error frame: %v", code, err)
+        }
+        var ef ErrorFrame
+        if json.Unmarshal(raw, &ef) == nil && ef.Type == "error" {
+            if ef.Code != code || ef.Message == "" {
+                t.Fatalf("error frame %+v, want code %s", ef, code)
+            }
+            return ef
+        }
+    }
+}
+
+func TestRateLimitedSenderGetsErrorFrame(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxMsgsPerSec: 1})
+    base := startTestServer(t, hub)
+    recv := dialWS(t, base+"/ws/rl/recv")
+    c := dialWS(t, base+"/ws/rl/sender")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "rl") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+    for i := 0; i < 5; i++ {
+        if err := c.WriteMessage(websocket.TextMessage, []byte("x")); err != nil {
+            t.Fatal(err)
+        }
+    }
+    expectErrorFrame(t, c, errCodeRateLimited)
+    // one per streak of drops, and never relayed to others
+    if got := readEnvelopes(t, c, 200*time.Millisecond); len(got) != 0 {
+        t.Fatalf("sender got %d more frames", len(got))
+    }
+    for _, env := range readEnvelopes(t, recv, 200*time.Millisecond) {
+        if env.Type != "" && env.Type != envelopeMessage {
+            t.Fatalf("receiver got %+v", env)
+        }
+    }
+}
+
+func TestOversizedSenderGetsErrorFrameBeforeClose(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxMessageBytes: 1024})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/big/sender")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "big") == 1 }) {
+        t.Fatal("sender did not join")
+    }
+    if err := c.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 4096))); err != nil {
+        t.Fatal(err)
+    }
+    expectErrorFrame(t, c, errCodeMessageTooLarge)
+    if ce := expectClose(t, c); ce.Code != websocket.CloseMessageTooBig {
+        t.Fatalf("close code %d, want %d", ce.Code, websocket.CloseMessageTooBig)
+    }
+}
+
+func TestRoomFullGetsErrorFrameBeforeClose(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxClientsPerRoom: 1})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/full/alice")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "full") == 1 }) {
+        t.Fatal("alice did not join")
+    }
+    bob := dialWS(t, base+"/ws/full/bob")
+    expectErrorFrame(t, bob, errCodeRoomFull)
+    if ce := expectClose(t, bob); ce.Code != websocket.CloseTryAgainLater {
+        t.Fatalf("close code %d, want %d", ce.Code, websocket.CloseTryAgainLater)
+    }
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sync/atomic"
+    "time"
+)
+
+// Lifecycle events. With EVENT_SINK set, joins and leaves are published to
+// an external sink as they happen, and every EVENT_SUMMARY_INTERVAL each
+// room that carried traffic is published as a message_summary with the
+// messages and payload bytes broadcast in it since the previous one.
+// Events are queued in a buffer of EVENT_BUFFER and handed to the sink by
+// a single goroutine, so a slow or unreachable sink never holds up a
+// connection: with EVENT_POLICY=drop (the default) an event that finds the
+// buffer full is dropped at once, with block the caller waits up to
+// EVENT_BLOCK_TIMEOUT for room before dropping it. The default sink is a
+// no-op, for which no buffer is kept and nothing is counted.
+
+const (
+    eventJoin           = "join"
+    eventLeave          = "leave"
+    eventMessageSummary = "message_summary"
+)
+
+const (
+    EventPolicyDrop  = "drop"
+    EventPolicyBlock = "block"
+)
+
+// LifecycleEvent is what a sink receives.
+type LifecycleEvent struct {
+    Type     string `json:"type"` // join, leave or message_summary
+    Room     string `json:"room"`
+    Username string `json:"username,omitempty"`
+    ClientID string `json:"client_id,omitempty"`
+    Role     string `json:"role,omitempty"`
+    Members  int    `json:"members"`             // room size after a join or leave, at the end of a summary window
+    Messages int64  `json:"messages,omitempty"`  // message_summary only
+    Bytes    int64  `json:"bytes,omitempty"`     // message_summary only
+    WindowMs int64  `json:"window_ms,omitempty"` // message_summary only
+    Ts       int64  `json:"ts"`
+}
+
+// EventSink publishes lifecycle events somewhere outside the relay. Emit
+// is only ever called from one goroutine at a time; Close may be called
+// while an Emit is still in progress, at shutdown.
+type EventSink interface {
+    Emit(ev LifecycleEvent) error
+    Close() error
+}
+
+// noopSink is the default sink; it discards everything.
+type noopSink struct{}
+
+func (noopSink) Emit(LifecycleEvent) error { return nil }
+func (noopSink) Close() error              { return nil }
+
+// newEventSink returns the sink named by EVENT_SINK.
+func newEventSink(kind, url, subject, caFile string) (EventSink, error) {
+    switch kind {
+    case "", "none":
+        return noopSink{}, nil
+    case "nats":
+        return newNATSSink(url, subject, caFile)
+    }
+    return nil, fmt.Errorf("unknown event sink %q", kind)
+}
+
+// eventStream queues events for its sink.
+type eventStream struct {
+    sink      EventSink
+    ch        chan LifecycleEvent
+    blockWait time.Duration // 0 under the drop policy
+    stop      chan struct{}
+    done      chan struct{}
+
+    published atomic.Int64
+    dropped   atomic.Int64 // buffer full
+    failed    atomic.Int64 // rejected by the sink
+}
+
+// newEventStream starts delivering to sink. It returns nil for the no-op
+// sink.
+func newEventStream(sink EventSink, buffer int, policy string, blockWait time.Duration) (*eventStream, error) {
+    switch policy {
+    case "", EventPolicyDrop:
+        blockWait = 0
+    case EventPolicyBlock:
+        if blockWait <= 0 {
+            blockWait = 100 * time.Millisecond
+        }
+    default:
+        return nil, fmt.Errorf("unknown event policy %q", policy)
+    }
+    if _, ok := sink.(noopSink); ok {
+        return nil, nil
+    }
+    s := &eventStream{
+        sink:      sink,
+        ch:        make(chan LifecycleEvent, max(buffer, 1)),
+        blockWait: blockWait,
+        stop:      make(chan struct{}),
+        done:      make(chan struct{}),
+    }
+    go s.run()
+    return s, nil
+}
+
+// emit queues ev, stamping it if needed; it never waits longer than the
+// block policy allows.
+func (s *eventStream) emit(ev LifecycleEvent) {
+    if s == nil {
+        return
+    }
+    if ev.Ts == 0 {
+        ev.Ts = time.Now().UnixNano()
+    }
+    select {
+    case s.ch <- ev:
+        return
+    default:
+    }
+    if s.blockWait > 0 {
+        t := time.NewTimer(s.blockWait)
+        defer t.Stop()
+        select {
+        case s.ch <- ev:
+            return
+        case <-t.C:
+        }
+    }
+    s.dropped.Add(1)
+}
+
+func (s *eventStream) run() {
+    defer close(s.done)
+    var failing bool
+    deliver := func(ev LifecycleEvent) {
+        if err := s.sink.Emit(ev); err != nil {
+            s.failed.Add(1)
+            if !failing {
+                log.Printf("event sink: %v", err)
+            }
+            failing = true
+            return
+        }
+        if failing {
+            log.Printf("event sink: recovered")
+        }
+        failing = false
+        s.published.Add(1)
+    }
+    for {
+        select {
+        case ev := <-s.ch:
+            deliver(ev)
+        case <-s.stop:
+            for {
+                select {
+                case ev := <-s.ch:
+                    deliver(ev)
+                default:
+                    return
+                }
+            }
+        }
+    }
+}
+
+// close delivers what is still buffered, until ctx expires, and closes
+// the sink. Events emitted afterwards are dropped once the buffer fills.
+func (s *eventStream) close(ctx context.Context) error {
+    if s == nil {
+        return nil
+    }
+    close(s.stop)
+    select {
+    case <-s.done:
+    case <-ctx.Done():
+        s.sink.Close()
+        return ctx.Err()
+    }
+    return s.sink.Close()
+}
+
+// roomTraffic counts what a room broadcast for the next message_summary.
+type roomTraffic struct {
+    msgs  atomic.Int64
+    bytes atomic.Int64
+}
+
+func (t *roomTraffic) add(n int) {
+    t.msgs.Add(1)
+    t.bytes.Add(int64(n))
+}
+
+// runEventSummaries publishes room summaries every interval.
+func (h *Hub) runEventSummaries(interval time.Duration) {
+    if interval <= 0 {
+        interval = 10 * time.Second
+    }
+    last := time.Now()
+    for now := range time.Tick(interval) {
+        h.emitSummaries(now.Sub(last))
+        last = now
+    }
+}
+
+// emitSummaries publishes a message_summary for every room that broadcast
+// anything since the previous call, window being the time since then.
+// Traffic in a room collected during the window is not reported. The
+// default room's shards are reported together, as the default room.
+func (h *Hub) emitSummaries(window time.Duration) {
+    if h.events == nil {
+        return
+    }
+    now := time.Now().UnixNano()
+    h.forEachRoom(func(r *Room) {
+        if r.primary() != r {
+            return
+        }
+        msgs := r.traffic.msgs.Swap(0)
+        bytes := r.traffic.bytes.Swap(0)
+        if msgs == 0 {
+            return
+        }
+        rooms := r.shards
+        if rooms == nil {
+            rooms = []*Room{r}
+        }
+        members := 0
+        for _, room := range rooms {
+            room.mu.RLock()
+            members += len(room.clients)
+            room.mu.RUnlock()
+        }
+        h.events.emit(LifecycleEvent{
+            Type:     eventMessageSummary,
+            Room:     r.logicalName(),
+            Members:  members,
+            Messages: msgs,
+            Bytes:    bytes,
+            WindowMs: window.Milliseconds(),
+            Ts:       now,
+        })
+    })
+}
+
+// memberEvent is a join or leave of c, leaving the room with members. A
+// shard of the default room reports as the default room, its members
+// counted with those of the other shards, as summaries do.
+func memberEvent(kind string, r *Room, c *Client, members int) LifecycleEvent {
+    for _, room := range r.group() {
+        if room != r {
+            room.mu.RLock()
+            members += len(room.clients)
+            room.mu.RUnlock()
+        }
+    }
+    return LifecycleEvent{
+        Type:     kind,
+        Room:     r.logicalName(),
+        Username: c.username,
+        ClientID: c.id,
+        Role:     c.role,
+        Members:  members,
+    }
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// mockSink records the events it is given; with gate set, Emit waits on it.
+type mockSink struct {
+    mu     sync.Mutex
+    events []LifecycleEvent
+    gate   chan struct{}
+    closed bool
+}
+
+func (m *mockSink) Emit(ev LifecycleEvent) error {
+    if m.gate != nil {
+        <-m.gate
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.events = append(m.events, ev)
+    return nil
+}
+
+func (m *mockSink) Close() error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.closed = true
+    return nil
+}
+
+func (m *mockSink) ofType(kind string) []LifecycleEvent {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var out []LifecycleEvent
+    for _, ev := range m.events {
+        if ev.Type == kind {
+            out = append(out, ev)
+        }
+    }
+    return out
+}
+
+func withEvents(t *testing.T, hub *Hub, sink EventSink, buffer int, policy string) {
+    t.Helper()
+    s, err := newEventStream(sink, buffer, policy, 20*time.Millisecond)
+    if err != nil {
+        t.Fatal(err)
+    }
+    hub.events = s
+    t.Cleanup(func() { s.close(context.Background()) })
+}
+
+func TestNoopSinkIsDefault(t *testing.T) {
+    sink, err := newEventSink("", "", "", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := sink.(noopSink); !ok {
+        t.Fatalf("default sink is %T", sink)
+    }
+    s, err := newEventStream(sink, 16, EventPolicyDrop, 0)
+    if err != nil || s != nil {
+        t.Fatalf("no-op sink got a stream (%v)", err)
+    }
+    s.emit(LifecycleEvent{Type: eventJoin}) // nil stream: ignored
+
+    if _, err := newEventSink("kafka", "", "", ""); err == nil {
+        t.Fatal("unknown sink accepted")
+    }
+    if _, err := newEventStream(&mockSink{}, 16, "wait", 0); err == nil {
+        t.Fatal("unknown policy accepted")
+    }
+}
+
+func TestJoinLeaveEvents(t *testing.T) {
+    hub := NewHubWithConfig(Config{ClientIDSecret: "s3cret"})
+    sink := &mockSink{}
+    withEvents(t, hub, sink, 64, EventPolicyDrop)
+    base := startTestServer(t, hub)
+
+    alice := dialWS(t, base+"/ws/lobby/alice?role=admin")
+    dialWS(t, base+"/ws/lobby/bob")
+    if !waitFor(time.Second, func() bool { return len(sink.ofType(eventJoin)) == 2 }) {
+        t.Fatalf("joins: %+v", sink.ofType(eventJoin))
+    }
+    first := sink.ofType(eventJoin)[0]
+    if first.Room != "lobby" || first.Username != "alice" || first.Role != "admin" || first.Members != 1 {
+        t.Fatalf("first join %+v", first)
+    }
+    if first.ClientID == "" || first.Ts == 0 {
+        t.Fatalf("join missing client id or timestamp: %+v", first)
+    }
+
+    alice.Close()
+    if !waitFor(time.Second, func() bool { return len(sink.ofType(eventLeave)) == 1 }) {
+        t.Fatal("no leave event")
+    }
+    leave := sink.ofType(eventLeave)[0]
+    if leave.Username != "alice" || leave.Room != "lobby" || leave.Members != 1 || leave.ClientID != first.ClientID {
+        t.Fatalf("leave %+v", leave)
+    }
+}
+
+func TestJoinEventsNameTheDefaultRoomNotItsShards(t *testing.T) {
+    const n = 2
+    hub := NewHubWithConfig(Config{DefaultRoomShards: n})
+    sink := &mockSink{}
+    withEvents(t, hub, sink, 64, EventPolicyDrop)
+    base := startTestServer(t, hub)
+    alice, bob := "alice", ""
+    for i := 0; bob == ""; i++ {
+        if name := fmt.Sprintf("bob%d", i); shardIndex(name, n) != shardIndex(alice, n) {
+            bob = name
+        }
+    }
+    dialWS(t, base+"/ws/global/"+alice)
+    if !waitFor(time.Second, func() bool { return len(sink.ofType(eventJoin)) == 1 }) {
+        t.Fatal("no join event")
+    }
+    dialWS(t, base+"/ws/global/"+bob)
+    if !waitFor(time.Second, func() bool { return len(sink.ofType(eventJoin)) == 2 }) {
+        t.Fatal("no second join event")
+    }
+    for i, ev := range sink.ofType(eventJoin) {
+        // bob lands in the other shard; the room counts both
+        if ev.Room != defaultRoom || ev.Members != i+1 {
+            t.Fatalf("join %d = %+v, want room %s with %d members", i, ev, defaultRoom, i+1)
+        }
+    }
+}
+
+func TestMessageSummaryEvents(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    sink := &mockSink{}
+    withEvents(t, hub, sink, 64, EventPolicyDrop)
+    base := startTestServer(t, hub)
+
+    alice := dialWS(t, base+"/ws/busy/alice")
+    dialWS(t, base+"/ws/busy/bob")
+    dialWS(t, base+"/ws/quiet/carol")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "busy") == 2 && roomSize(hub, "quiet") == 1 }) {
+        t.Fatal("clients did not join")
+    }
+    for _, msg := range []string{"hello", "world!"} {
+        if err := alice.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    busy := hub.lookupRoom("busy")
+    if !waitFor(time.Second, func() bool { return busy.traffic.msgs.Load() == 2 }) {
+        t.Fatal("broadcasts not counted")
+    }
+
+    hub.emitSummaries(5 * time.Second)
+    if !waitFor(time.Second, func() bool { return len(sink.ofType(eventMessageSummary)) == 1 }) {
+        t.Fatalf("summaries: %+v", sink.ofType(eventMessageSummary))
+    }
+    sum := sink.ofType(eventMessageSummary)[0]
+    want := LifecycleEvent{Type: eventMessageSummary, Room: "busy", Members: 2, Messages: 2, Bytes: 11, WindowMs: 5000, Ts: sum.Ts}
+    if sum != want || sum.Ts == 0 {
+        t.Fatalf("summary %+v, want %+v", sum, want)
+    }
+
+    // counters were reset: an idle window publishes nothing
+    hub.emitSummaries(time.Second)
+    time.Sleep(50 * time.Millisecond)
+    if n := len(sink.ofType(eventMessageSummary)); n != 1 {
+        t.Fatalf("%d summaries after an idle window", n)
+    }
+}
+
+func TestEventDropPolicy(t *testing.T) {
+    sink := &mockSink{gate: make(chan struct{})}
+    s, err := newEventStream(sink, 2, EventPolicyDrop, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    start := time.Now()
+    for i := 0; i < 10; i++ {
+        s.emit(LifecycleEvent{Type: eventJoin, Room: "r"})
+    }
+    if d := time.Since(start); d > 100*time.Millisecond {
+        t.Fatalf("emit blocked for %s on a stuck sink", d)
+    }
+    // one event is held by the stuck Emit, two are buffered
+    if !waitFor(time.Second, func() bool { return s.dropped.Load() >= 7 }) {
+        t.Fatalf("dropped %d", s.dropped.Load())
+    }
+    close(sink.gate)
+    if err := s.close(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    if got := int64(len(sink.ofType(eventJoin))); got+s.dropped.Load() != 10 || got != s.published.Load() {
+        t.Fatalf("published %d, dropped %d of 10", got, s.dropped.Load())
+    }
+    if !sink.closed {
+        t.Fatal("sink not closed")
+    }
+}
+
+func TestEventBlockPolicyWaitsThenDrops(t *testing.T) {
+    sink := &mockSink{gate: make(chan struct{})}
+    s, err := newEventStream(sink, 1, EventPolicyBlock, 100*time.Millisecond)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s.emit(LifecycleEvent{Type: eventJoin}) // taken by the stuck Emit
+    if !waitFor(time.Second, func() bool { return len(s.ch) == 0 }) {
+        t.Fatal("first event not picked up")
+    }
+    s.emit(LifecycleEvent{Type: eventJoin}) // buffered
+
+    start := time.Now()
+    s.emit(LifecycleEvent{Type: eventJoin})
+    if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+        t.Fatalf("blocked for %s, want about 100ms", d)
+    }
+    if s.dropped.Load() != 1 {
+        t.Fatalf("dropped %d, want 1", s.dropped.Load())
+    }
+
+    // room freed while waiting: the event is queued, not dropped
+    done := make(chan struct{})
+    go func() {
+        s.emit(LifecycleEvent{Type: eventLeave})
+        close(done)
+    }()
+    time.Sleep(10 * time.Millisecond)
+    close(sink.gate)
+    <-done
+    s.close(context.Background())
+    if s.dropped.Load() != 1 || len(sink.ofType(eventLeave)) != 1 {
+        t.Fatalf("dropped %d, leaves %d", s.dropped.Load(), len(sink.ofType(eventLeave)))
+    }
+}
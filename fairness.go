@@ -0,0 +1,33 @@
+package main
+
+// Fan-out fairness. Every broadcast message addressed to a member counts
+// as offered to it, and every one the relay then drops for it (full queue,
+// expiry, dead socket...) as dropped, so a member's delivery ratio is the
+// share of its offered messages that were not dropped. A room's fairness
+// is Jain's index over the ratios of members offered anything: 1 when all receive alike,
+// falling towards 1/n as one member is starved while the rest are served.
+
+// deliveryRatio is the share of offered messages not dropped, 1 when
+// nothing was offered.
+func deliveryRatio(offered, dropped int64) float64 {
+    if offered <= 0 {
+        return 1
+    }
+    if dropped >= offered {
+        return 0
+    }
+    return float64(offered-dropped) / float64(offered)
+}
+
+// jainIndex is (Σx)² / (n·Σx²) over xs, 1 for no samples or all zeros.
+func jainIndex(xs []float64) float64 {
+    var sum, sq float64
+    for _, x := range xs {
+        sum += x
+        sq += x * x
+    }
+    if sq == 0 {
+        return 1
+    }
+    return sum * sum / (float64(len(xs)) * sq)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "testing"
+)
+
+func TestJainIndex(t *testing.T) {
+    cases := []struct {
+        xs   []float64
+        want float64
+    }{
+        {nil, 1},
+        {[]float64{1, 1, 1}, 1},
+        {[]float64{1, 0}, 0.5},
+        {[]float64{1, 0, 0, 0}, 0.25},
+    }
+    for _, tc := range cases {
+        if got := jainIndex(tc.xs); math.Abs(got-tc.want) > 1e-9 {
+            t.Errorf("jainIndex(%v) = %v, want %v", tc.xs, got, tc.want)
+        }
+    }
+}
+
+func TestFairnessReflectsSlowClient(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "admin-token"})
+    base := startTestServer(t, hub)
+    room := hub.getRoom("fair")
+    sender := fakeClient(room, "sender", 64)
+    fakeClient(room, "fast", 64)
+    fakeClient(room, "slow", 2)
+    for i := 0; i < 20; i++ {
+        room.broadcast(sender, NewEnvelope("fair", "sender", []byte(fmt.Sprintf("m%d", i))))
+    }
+
+    var rs RoomStats
+    statsRequest(t, base, http.MethodGet, "/stats?room=fair", "admin-token", &rs)
+    ratios := map[string]ClientStats{}
+    for _, m := range rs.Members {
+        ratios[m.Username] = m
+    }
+    if fast := ratios["fast"]; fast.Offered != 20 || fast.Dropped != 0 || fast.DeliveryRatio != 1 {
+        t.Fatalf("fast member %+v", fast)
+    }
+    if slow := ratios["slow"]; slow.Offered != 20 || slow.Dropped != 18 || math.Abs(slow.DeliveryRatio-0.1) > 1e-9 {
+        t.Fatalf("slow member %+v", slow)
+    }
+    // the sender is offered nothing, so only fast and slow count
+    if want := jainIndex([]float64{1, 0.1}); math.Abs(rs.Fairness-want) > 1e-9 || rs.Fairness > 0.8 {
+        t.Fatalf("fairness = %v, want %v", rs.Fairness, want)
+    }
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Weighted fair egress across rooms. With GLOBAL_EGRESS_BUDGET (bytes out
+// per second over all rooms) each room that is broadcasting is entitled to
+// a share of the budget proportional to its weight (ROOM_WEIGHTS, default
+// 1), in one-second windows like the per-room egressMeter. A room may go
+// past its share only into capacity the other active rooms are not using,
+// so a busy low-weight room cannot crowd out a high-weight one. Broadcasts
+// that do not fit are shed as "global_egress" dead letters.
+const dropGlobalEgress = "global_egress"
+
+type fairEgress struct {
+    budget int64
+
+    mu          sync.Mutex
+    weights     map[string]float64
+    windowStart time.Time
+    total       int64 // bytes admitted this window
+    rooms       map[*Room]*fairShare
+    weightSum   float64 // of rooms, kept as they come and go
+}
+
+// fairShare is one room's standing in the current and previous windows.
+type fairShare struct {
+    weight            float64
+    active, wasActive bool // broadcast this window / the last one
+    used, lastUsed    int64
+    lastAllocated     int64
+}
+
+func newFairEgress(budget int64) *fairEgress {
+    if budget <= 0 {
+        return nil
+    }
+    return &fairEgress{budget: budget, weights: map[string]float64{}, windowStart: time.Now(), rooms: map[*Room]*fairShare{}}
+}
+
+// LoadRoomWeights parses ROOM_WEIGHTS, comma-separated room=weight entries.
+func (h *Hub) LoadRoomWeights(spec string) error {
+    weights := map[string]float64{}
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        room, w, ok := strings.Cut(entry, "=")
+        weight, err := strconv.ParseFloat(w, 64)
+        if !ok || room == "" || err != nil || weight <= 0 {
+            return fmt.Errorf("invalid ROOM_WEIGHTS entry %q (want room=positive weight)", entry)
+        }
+        weights[room] = weight
+    }
+    if f := h.fair; f != nil {
+        f.mu.Lock()
+        f.weights = weights
+        for r, s := range f.rooms {
+            s.weight = f.weightLocked(r.name)
+        }
+        f.sumWeightsLocked()
+        f.mu.Unlock()
+    }
+    return nil
+}
+
+func (f *fairEgress) weightLocked(room string) float64 {
+    if w, ok := f.weights[room]; ok {
+        return w
+    }
+    return 1
+}
+
+func (f *fairEgress) rollLocked(now time.Time) {
+    elapsed := now.Sub(f.windowStart)
+    if elapsed < time.Second {
+        return
+    }
+    for _, s := range f.rooms {
+        s.lastUsed, s.lastAllocated = 0, 0
+        if elapsed < 2*time.Second && s.active {
+            s.lastUsed, s.lastAllocated = s.used, f.allocationLocked(s)
+        }
+    }
+    for r, s := range f.rooms {
+        s.wasActive = s.active && elapsed < 2*time.Second
+        s.active, s.used = false, 0
+        if !s.wasActive {
+            delete(f.rooms, r)
+        }
+    }
+    f.sumWeightsLocked()
+    f.total = 0
+    f.windowStart = now
+}
+
+// sumWeightsLocked recomputes weightSum from scratch, so additions and
+// removals do not accumulate rounding error across windows.
+func (f *fairEgress) sumWeightsLocked() {
+    f.weightSum = 0
+    for _, s := range f.rooms {
+        f.weightSum += s.weight
+    }
+}
+
+// allocationLocked is s's weighted share of the budget among the rooms
+// active in this window or the last.
+func (f *fairEgress) allocationLocked(s *fairShare) int64 {
+    return int64(float64(f.budget) * s.weight / f.weightSum)
+}
+
+// admit accounts for out bytes fanned out by r and reports whether they fit
+// the budget within r's share, or in capacity no other active room is
+// entitled to. As with the per-room budget, the first broadcast of a window
+// is always admitted. A room that starts broadcasting after the budget is
+// spent waits for the next window, where its share is held for it. It
+// returns the start of the window the bytes were charged to, for refund.
+func (f *fairEgress) admit(r *Room, out int64) (time.Time, bool) {
+    if f == nil {
+        return time.Time{}, true
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.rollLocked(time.Now())
+    s := f.rooms[r]
+    if s == nil {
+        s = &fairShare{weight: f.weightLocked(r.name)}
+        f.rooms[r] = s
+        f.weightSum += s.weight
+    }
+    s.active = true
+    ok := f.total == 0
+    if !ok && f.total+out <= f.budget {
+        var reserved int64 // unused shares of the other active rooms
+        if s.used+out > f.allocationLocked(s) {
+            for _, o := range f.rooms {
+                if o != s {
+                    reserved += max(0, f.allocationLocked(o)-o.used)
+                }
+            }
+        }
+        ok = f.total+out+reserved <= f.budget
+    }
+    if ok {
+        s.used += out
+        f.total += out
+    }
+    return f.windowStart, ok
+}
+
+// refund returns out bytes admitted for r, in the window starting at
+// window, that a later stage refused. A window that has rolled over since
+// has already forgotten them, so then there is nothing to return.
+func (f *fairEgress) refund(r *Room, window time.Time, out int64) {
+    if f == nil {
+        return
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if !f.windowStart.Equal(window) {
+        return
+    }
+    if s := f.rooms[r]; s != nil && s.used >= out && f.total >= out {
+        s.used -= out
+        f.total -= out
+    }
+}
+
+// snapshot returns r's allocated and used egress over the last complete
+// window, in bytes per second.
+func (f *fairEgress) snapshot(r *Room) (allocated, used int64) {
+    if f == nil {
+        return 0, 0
+    }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.rollLocked(time.Now())
+    if s := f.rooms[r]; s != nil {
+        return s.lastAllocated, s.lastUsed
+    }
+    return 0, 0
+}
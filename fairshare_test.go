@@ -0,0 +1,164 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestLoadRoomWeights(t *testing.T) {
+    hub := NewHubWithConfig(Config{GlobalEgressBudget: 1000})
+    if err := hub.LoadRoomWeights("vip=3, bulk=0.5"); err != nil {
+        t.Fatal(err)
+    }
+    if w := hub.fair.weightLocked("vip"); w != 3 {
+        t.Fatalf("vip weight = %v", w)
+    }
+    if w := hub.fair.weightLocked("other"); w != 1 {
+        t.Fatalf("default weight = %v", w)
+    }
+    for _, bad := range []string{"vip", "vip=", "=2", "vip=0", "vip=-1", "vip=x"} {
+        if err := hub.LoadRoomWeights(bad); err == nil {
+            t.Errorf("%q: expected error", bad)
+        }
+    }
+    if err := NewHub().LoadRoomWeights("vip=3"); err != nil {
+        t.Fatalf("weights without a global budget: %v", err)
+    }
+}
+
+func TestFairEgressSplitsByWeightUnderContention(t *testing.T) {
+    hub := NewHubWithConfig(Config{GlobalEgressBudget: 10000})
+    if err := hub.LoadRoomWeights("vip=3"); err != nil {
+        t.Fatal(err)
+    }
+    vip, bulk := hub.getRoom("vip"), hub.getRoom("bulk")
+    sent := map[*Room]int64{}
+    for i := 0; i < 200; i++ {
+        for _, r := range []*Room{bulk, vip} {
+            if _, ok := hub.fair.admit(r, 100); ok {
+                sent[r] += 100
+            }
+        }
+    }
+    if sent[vip] != 7500 || sent[bulk] != 2500 {
+        t.Fatalf("vip got %d, bulk got %d bytes of 10000; want 7500 and 2500", sent[vip], sent[bulk])
+    }
+
+    // the shares show up in /stats for the completed window
+    hub.fair.mu.Lock()
+    hub.fair.windowStart = time.Now().Add(-1500 * time.Millisecond)
+    hub.fair.mu.Unlock()
+    if rs := vip.stats(false); rs.EgressAllocated != 7500 || rs.EgressUsed != 7500 {
+        t.Fatalf("vip stats allocated=%d used=%d", rs.EgressAllocated, rs.EgressUsed)
+    }
+    if rs := bulk.stats(false); rs.EgressAllocated != 2500 || rs.EgressUsed != 2500 {
+        t.Fatalf("bulk stats allocated=%d used=%d", rs.EgressAllocated, rs.EgressUsed)
+    }
+}
+
+func TestFairEgressLendsIdleCapacity(t *testing.T) {
+    hub := NewHubWithConfig(Config{GlobalEgressBudget: 10000})
+    if err := hub.LoadRoomWeights("vip=3"); err != nil {
+        t.Fatal(err)
+    }
+    bulk := hub.getRoom("bulk")
+    var sent int64
+    for i := 0; i < 200; i++ {
+        if _, ok := hub.fair.admit(bulk, 100); ok {
+            sent += 100
+        }
+    }
+    if sent != 10000 {
+        t.Fatalf("an uncontended room got %d of 10000 bytes", sent)
+    }
+    // a room that shows up once the budget is spent waits for the next
+    // window, where its share is held even while it is quiet
+    if _, ok := hub.fair.admit(hub.getRoom("vip"), 100); ok {
+        t.Fatal("admitted past the global budget")
+    }
+    hub.fair.mu.Lock()
+    hub.fair.windowStart = time.Now().Add(-1500 * time.Millisecond)
+    hub.fair.mu.Unlock()
+    sent = 0
+    for i := 0; i < 200; i++ {
+        if _, ok := hub.fair.admit(bulk, 100); ok {
+            sent += 100
+        }
+    }
+    if sent != 2500 {
+        t.Fatalf("bulk got %d bytes next to a quiet vip room, want its 2500 share", sent)
+    }
+    var disabled *fairEgress
+    if _, ok := disabled.admit(bulk, 1<<30); !ok {
+        t.Fatal("nil scheduler admits everything")
+    }
+}
+
+func TestFairEgressWeightSumFollowsRooms(t *testing.T) {
+    hub := NewHubWithConfig(Config{GlobalEgressBudget: 10000})
+    if err := hub.LoadRoomWeights("vip=3"); err != nil {
+        t.Fatal(err)
+    }
+    f := hub.fair
+    f.admit(hub.getRoom("vip"), 10)
+    f.admit(hub.getRoom("a"), 10)
+    f.admit(hub.getRoom("a"), 10)
+    if f.weightSum != 4 {
+        t.Fatalf("weight sum %v, want 4", f.weightSum)
+    }
+    if err := hub.LoadRoomWeights("vip=1,a=2"); err != nil {
+        t.Fatal(err)
+    }
+    if f.weightSum != 3 {
+        t.Fatalf("weight sum %v after reweighting, want 3", f.weightSum)
+    }
+    // two idle windows later both rooms have dropped out
+    f.mu.Lock()
+    f.windowStart = time.Now().Add(-3 * time.Second)
+    f.mu.Unlock()
+    f.admit(hub.getRoom("b"), 10)
+    if f.weightSum != 1 || len(f.rooms) != 1 {
+        t.Fatalf("weight sum %v over %d rooms, want only b's", f.weightSum, len(f.rooms))
+    }
+}
+
+func TestFairEgressRefundedWhenRoomBudgetRefuses(t *testing.T) {
+    hub := NewHubWithConfig(Config{GlobalEgressBudget: 10000, RoomEgressBudget: 100})
+    room := hub.getRoom("budgeted")
+    env := NewEnvelope("budgeted", "sender", []byte("x"))
+    if !room.admit(env, 80) {
+        t.Fatal("first broadcast of the window refused")
+    }
+    if room.admit(env, 80) {
+        t.Fatal("broadcast over the room's egress budget admitted")
+    }
+    f := hub.fair
+    f.mu.Lock()
+    used, total := f.rooms[room].used, f.total
+    f.mu.Unlock()
+    if used != 80 || total != 80 {
+        t.Fatalf("fair share charged used=%d total=%d, want only the admitted 80", used, total)
+    }
+}
+
+func TestFairEgressRefundAfterRollIsIgnored(t *testing.T) {
+    hub := NewHubWithConfig(Config{GlobalEgressBudget: 10000})
+    room := hub.getRoom("a")
+    f := hub.fair
+    old, ok := f.admit(room, 60)
+    if !ok {
+        t.Fatal("admit refused")
+    }
+    // the window rolls over and new traffic is charged before the refund lands
+    f.mu.Lock()
+    f.windowStart = f.windowStart.Add(-time.Second)
+    f.mu.Unlock()
+    f.admit(room, 70)
+    f.refund(room, old, 60)
+    f.mu.Lock()
+    used, total := f.rooms[room].used, f.total
+    f.mu.Unlock()
+    if used != 70 || total != 70 {
+        t.Fatalf("new window used=%d total=%d, want 70: a stale refund was applied", used, total)
+    }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "hash/fnv"
+    "os"
+    "slices"
+    "sort"
+    "strings"
+)
+
+// Connection-scoped feature flags (FEATURE_FLAGS) for gradual rollouts.
+// Each flag is on for a percentage of usernames, chosen by a stable hash so
+// a reconnecting user keeps the same assignment, plus any identities listed
+// explicitly; "exclude" wins over both. Flags are evaluated once at connect
+// and kept on the Client, where handlers check them with c.feature(name).
+type FeatureRule struct {
+    Percent    float64  `json:"percent"`
+    Identities []string `json:"identities"`
+    Exclude    []string `json:"exclude"`
+}
+
+// LoadFeatureFlags parses FEATURE_FLAGS: a JSON object of flag name to rule,
+// e.g. {"batching":{"percent":10,"identities":["alice"]}}, or "file:<path>"
+// naming a file holding one.
+func (h *Hub) LoadFeatureFlags(spec string) error {
+    if spec == "" {
+        return nil
+    }
+    raw := []byte(spec)
+    if path, ok := strings.CutPrefix(spec, "file:"); ok {
+        b, err := os.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        raw = b
+    }
+    var rules map[string]FeatureRule
+    if err := json.Unmarshal(raw, &rules); err != nil {
+        return fmt.Errorf("invalid FEATURE_FLAGS: %w", err)
+    }
+    for name, rule := range rules {
+        if rule.Percent < 0 || rule.Percent > 100 {
+            return fmt.Errorf("invalid FEATURE_FLAGS: %s percent %v is not within 0-100", name, rule.Percent)
+        }
+    }
+    h.mu.Lock()
+    h.features = rules
+    h.mu.Unlock()
+    return nil
+}
+
+// featuresFor returns the flags enabled for identity, sorted.
+func (h *Hub) featuresFor(identity string) []string {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    var on []string
+    for name, rule := range h.features {
+        if rule.enabled(name, identity) {
+            on = append(on, name)
+        }
+    }
+    sort.Strings(on)
+    return on
+}
+
+func (r FeatureRule) enabled(flag, identity string) bool {
+    if slices.Contains(r.Exclude, identity) {
+        return false
+    }
+    if slices.Contains(r.Identities, identity) {
+        return true
+    }
+    return rolloutBucket(flag, identity) < r.Percent
+}
+
+// rolloutBucket places identity in [0, 100) for flag. Hashing the flag name
+// too keeps rollouts of different flags independent.
+func rolloutBucket(flag, identity string) float64 {
+    f := fnv.New32a()
+    f.Write([]byte(flag + "\x00" + identity))
+    return float64(f.Sum32()%10000) / 100
+}
+
+// feature reports whether flag is on for c.
+func (c *Client) feature(flag string) bool {
+    return slices.Contains(c.features, flag)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestFeatureFlagAssignment(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    spec := `{"batching":{"percent":25,"identities":["vip"],"exclude":["canary"]},"off":{"percent":0}}`
+    if err := hub.LoadFeatureFlags(spec); err != nil {
+        t.Fatal(err)
+    }
+
+    on := 0
+    const n = 10000
+    for i := 0; i < n; i++ {
+        flags := hub.featuresFor(fmt.Sprintf("user-%d", i))
+        if reflect.DeepEqual(flags, []string{"batching"}) {
+            on++
+        } else if len(flags) != 0 {
+            t.Fatalf("user-%d got %v", i, flags)
+        }
+    }
+    if got := float64(on) / n * 100; got < 22 || got > 28 {
+        t.Fatalf("batching on for %.1f%% of users, want ~25%%", got)
+    }
+
+    // assignment is stable across connects
+    for i := 0; i < 100; i++ {
+        id := fmt.Sprintf("user-%d", i)
+        if !reflect.DeepEqual(hub.featuresFor(id), hub.featuresFor(id)) {
+            t.Fatalf("%s flipped between evaluations", id)
+        }
+    }
+
+    // listed identities are always on, excluded ones always off
+    if got := hub.featuresFor("vip"); !reflect.DeepEqual(got, []string{"batching"}) {
+        t.Fatalf("vip got %v", got)
+    }
+    all := `{"batching":{"percent":100,"exclude":["canary"]}}`
+    if err := hub.LoadFeatureFlags(all); err != nil {
+        t.Fatal(err)
+    }
+    if got := hub.featuresFor("canary"); len(got) != 0 {
+        t.Fatalf("excluded identity got %v", got)
+    }
+}
+
+func TestLoadFeatureFlagsSpecs(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "flags.json")
+    if err := os.WriteFile(path, []byte(`{"x":{"identities":["alice"]}}`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    hub := NewHubWithConfig(Config{})
+    if err := hub.LoadFeatureFlags("file:" + path); err != nil {
+        t.Fatal(err)
+    }
+    if got := hub.featuresFor("alice"); !reflect.DeepEqual(got, []string{"x"}) {
+        t.Fatalf("alice got %v", got)
+    }
+    for _, bad := range []string{`{"x":{"percent":101}}`, `{"x":{"percent":-1}}`, `not json`, "file:" + path + ".missing"} {
+        if err := hub.LoadFeatureFlags(bad); err == nil {
+            t.Errorf("LoadFeatureFlags(%q) accepted", bad)
+        }
+    }
+}
+
+func TestFeatureFlagsAtConnect(t *testing.T) {
+    hub := NewHubWithConfig(Config{HandshakeTimeout: 2 * time.Second})
+    if err := hub.LoadFeatureFlags(`{"beta":{"identities":["alice"]}}`); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/lobby/alice")
+    if err := c.WriteMessage(websocket.TextMessage, []byte(`{"op":"hello","capabilities":{}}`)); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := c.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var wf WelcomeFrame
+    if err := json.Unmarshal(raw, &wf); err != nil || !reflect.DeepEqual(wf.Features, []string{"beta"}) {
+        t.Fatalf("welcome %s (%v), want features [beta]", raw, err)
+    }
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 1 }) {
+        t.Fatal("client did not join")
+    }
+    hub.mu.RLock()
+    room := hub.lookupRoom("lobby")
+    hub.mu.RUnlock()
+    room.mu.RLock()
+    defer room.mu.RUnlock()
+    for cl := range room.clients {
+        if !cl.feature("beta") || cl.feature("other") {
+            t.Fatalf("client flags %v", cl.features)
+        }
+    }
+}
+
+func TestBatchingFeatureFlag(t *testing.T) {
+    hub := NewHub()
+    if err := hub.LoadFeatureFlags(`{"batching":{"identities":["alice","carol"]}}`); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/lobby/alice")
+    dialWS(t, base+"/ws/lobby/bob")
+    dialWS(t, base+"/ws/lobby/carol?batch=10ms")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 3 }) {
+        t.Fatal("clients did not join")
+    }
+    windows := map[string]time.Duration{}
+    room := hub.lookupRoom("lobby")
+    room.mu.RLock()
+    for cl := range room.clients {
+        if cl.batch != nil {
+            windows[cl.username] = cl.batch.window
+        }
+    }
+    room.mu.RUnlock()
+    want := map[string]time.Duration{"alice": featureBatchWindow, "carol": 10 * time.Millisecond}
+    if !reflect.DeepEqual(windows, want) {
+        t.Fatalf("batch windows %v, want %v", windows, want)
+    }
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "os"
+)
+
+// Listener handoff for zero-downtime restarts (HANDOFF_SOCKET). A running
+// relay listens on the unix socket at that path. Its successor, started
+// with the same setting, connects there first and receives the HTTP
+// listener and UDP sockets over SCM_RIGHTS instead of binding its own; the
+// predecessor then shuts down as on SIGTERM. The sockets stay open in the
+// kernel throughout, so connection attempts during the restart wait in the
+// accept backlog rather than being refused. A relay that finds nobody on
+// the socket binds normally.
+
+// handedOff is the set of sockets passed from one process to the next.
+type handedOff struct {
+    http net.Listener
+    udp  []*net.UDPConn
+}
+
+// takeOverListeners fetches the predecessor's sockets from path. It
+// returns nil, nil when no predecessor is listening there.
+func takeOverListeners(path string) (*handedOff, error) {
+    conn, err := net.Dial("unix", path)
+    if err != nil {
+        return nil, nil
+    }
+    defer conn.Close()
+    names, files, err := recvFiles(conn.(*net.UnixConn))
+    if err != nil {
+        return nil, fmt.Errorf("receiving listeners: %w", err)
+    }
+    defer func() {
+        for _, f := range files {
+            f.Close() // the net values hold their own copies
+        }
+    }()
+    var h handedOff
+    for i, name := range names {
+        switch name {
+        case "http":
+            if h.http, err = net.FileListener(files[i]); err != nil {
+                return nil, err
+            }
+        case "udp":
+            pc, err := net.FilePacketConn(files[i])
+            if err != nil {
+                return nil, err
+            }
+            udp, ok := pc.(*net.UDPConn)
+            if !ok {
+                return nil, fmt.Errorf("handed-off socket %d is not UDP", i)
+            }
+            h.udp = append(h.udp, udp)
+        }
+    }
+    if h.http == nil {
+        return nil, fmt.Errorf("predecessor sent no HTTP listener")
+    }
+    return &h, nil
+}
+
+// serveHandoff waits on path for a successor, sends it ln and udp, and
+// then calls done, which is expected to shut this process down. It gives
+// up when ctx ends.
+func serveHandoff(ctx context.Context, path string, ln net.Listener, udp []*net.UDPConn, done func()) error {
+    os.Remove(path) // left over from a predecessor
+    l, err := net.Listen("unix", path)
+    if err != nil {
+        return err
+    }
+    // by the time this closes, the path belongs to the successor
+    l.(*net.UnixListener).SetUnlinkOnClose(false)
+    go func() {
+        <-ctx.Done()
+        l.Close()
+    }()
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            return ctx.Err()
+        }
+        err = sendListeners(conn.(*net.UnixConn), ln, udp)
+        conn.Close()
+        if err != nil {
+            log.Printf("handoff: %v", err)
+            continue
+        }
+        l.Close()
+        log.Printf("handed listeners off to successor")
+        done()
+        return nil
+    }
+}
+
+func sendListeners(conn *net.UnixConn, ln net.Listener, udp []*net.UDPConn) error {
+    type filer interface{ File() (*os.File, error) }
+    names := []string{"http"}
+    socks := []filer{ln.(filer)}
+    for _, u := range udp {
+        names = append(names, "udp")
+        socks = append(socks, u)
+    }
+    files := make([]*os.File, 0, len(socks))
+    defer func() {
+        for _, f := range files {
+            f.Close()
+        }
+    }()
+    for _, s := range socks {
+        f, err := s.File()
+        if err != nil {
+            return err
+        }
+        files = append(files, f)
+    }
+    return sendFiles(conn, names, files)
+}
@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+    "errors"
+    "net"
+    "os"
+)
+
+const handoffSupported = false
+
+var errNoHandoff = errors.New("listener handoff needs SCM_RIGHTS, which this platform lacks")
+
+func sendFiles(conn *net.UnixConn, names []string, files []*os.File) error {
+    return errNoHandoff
+}
+
+func recvFiles(conn *net.UnixConn) ([]string, []*os.File, error) {
+    return nil, nil, errNoHandoff
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// TestHandoffSuccessor is the successor process of TestListenerHandoff;
+// it only runs when re-executed with RELAY_HANDOFF_SOCKET set.
+func TestHandoffSuccessor(t *testing.T) {
+    path := os.Getenv("RELAY_HANDOFF_SOCKET")
+    if path == "" {
+        t.Skip("helper process for TestListenerHandoff")
+    }
+    h, err := takeOverListeners(path)
+    if err != nil || h == nil || len(h.udp) != 1 {
+        fmt.Fprintf(os.Stderr, "takeover: %+v, %v\n", h, err)
+        os.Exit(1)
+    }
+    go http.Serve(h.http, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, "successor %d", os.Getpid())
+    }))
+    buf := make([]byte, 64)
+    for {
+        h.udp[0].SetReadDeadline(time.Now().Add(10 * time.Second))
+        n, from, err := h.udp[0].ReadFromUDP(buf)
+        if err != nil {
+            os.Exit(0)
+        }
+        h.udp[0].WriteToUDP(append([]byte("echo "), buf[:n]...), from)
+    }
+}
+
+func TestListenerHandoff(t *testing.T) {
+    if !handoffSupported {
+        t.Skip("no SCM_RIGHTS on this platform")
+    }
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(t.TempDir(), "handoff.sock")
+    if h, err := takeOverListeners(path); h != nil || err != nil {
+        t.Fatalf("takeover with no predecessor: %+v, %v", h, err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    handedOff := make(chan struct{})
+    go serveHandoff(ctx, path, ln, []*net.UDPConn{udp}, func() { close(handedOff) })
+    if !waitFor(time.Second, func() bool { _, err := os.Stat(path); return err == nil }) {
+        t.Fatal("handoff socket not created")
+    }
+
+    cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffSuccessor$")
+    cmd.Env = append(os.Environ(), "RELAY_HANDOFF_SOCKET="+path)
+    cmd.Stderr = os.Stderr
+    if err := cmd.Start(); err != nil {
+        t.Fatal(err)
+    }
+    defer cmd.Process.Kill()
+    select {
+    case <-handedOff:
+    case <-time.After(5 * time.Second):
+        t.Fatal("successor never took the listeners")
+    }
+    // the predecessor lets go of its copies; the sockets stay bound
+    ln.Close()
+    udp.Close()
+
+    resp, err := http.Get("http://" + ln.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if want := fmt.Sprintf("successor %d", cmd.Process.Pid); string(body) != want {
+        t.Fatalf("served %q, want %q", body, want)
+    }
+
+    c, err := net.DialUDP("udp", nil, udp.LocalAddr().(*net.UDPAddr))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    c.Write([]byte("ping"))
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    buf := make([]byte, 64)
+    n, err := c.Read(buf)
+    if err != nil || string(buf[:n]) != "echo ping" {
+        t.Fatalf("udp reply %q, %v", buf[:n], err)
+    }
+}
@@ -0,0 +1,61 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net"
+    "os"
+
+    "golang.org/x/sys/unix"
+)
+
+const handoffSupported = true
+
+// sendFiles passes files over conn as SCM_RIGHTS, with their names as the
+// message body.
+func sendFiles(conn *net.UnixConn, names []string, files []*os.File) error {
+    body, err := json.Marshal(names)
+    if err != nil {
+        return err
+    }
+    fds := make([]int, len(files))
+    for i, f := range files {
+        fds[i] = int(f.Fd())
+    }
+    _, _, err = conn.WriteMsgUnix(body, unix.UnixRights(fds...), nil)
+    return err
+}
+
+// recvFiles is the receiving end of sendFiles.
+func recvFiles(conn *net.UnixConn) ([]string, []*os.File, error) {
+    body := make([]byte, 4096)
+    oob := make([]byte, unix.CmsgSpace(64*4))
+    n, oobn, _, _, err := conn.ReadMsgUnix(body, oob)
+    if err != nil {
+        return nil, nil, err
+    }
+    msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+    if err != nil {
+        return nil, nil, err
+    }
+    var files []*os.File
+    for _, m := range msgs {
+        fds, err := unix.ParseUnixRights(&m)
+        if err != nil {
+            continue
+        }
+        for _, fd := range fds {
+            files = append(files, os.NewFile(uintptr(fd), "handoff"))
+        }
+    }
+    var names []string
+    if err := json.Unmarshal(body[:n], &names); err != nil || len(names) != len(files) {
+        for _, f := range files {
+            f.Close()
+        }
+        return nil, nil, fmt.Errorf("malformed handoff: %d names for %d descriptors", len(names), len(files))
+    }
+    return names, files, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+    "encoding/json"
+    "sync"
+    "time"
+)
+
+// Capability handshake. With HANDSHAKE_TIMEOUT set, a client may open with
+// {"op":"hello","capabilities":{...}} and the server answers with a welcome
+// frame carrying what it agreed to. The connection joins its room once the
+// handshake completes; a first frame that is not a hello, or no frame
+// within the timeout, joins it with the query-parameter defaults instead.
+
+// Capabilities is both the client's request and the negotiated result.
+type Capabilities struct {
+    Envelope      int      `json:"envelope,omitempty"`
+    MaxOverhead   int      `json:"max_overhead,omitempty"`
+    Compression   bool     `json:"compression"`
+    Codec         string   `json:"codec,omitempty"`
+    MaxSize       int64    `json:"max_size,omitempty"`
+    Echo          bool     `json:"echo"`
+    Subscriptions []string `json:"subscriptions"`
+    StatsInterval int64    `json:"stats_interval_ms,omitempty"`
+    BinaryControl bool     `json:"binary_control,omitempty"`
+}
+
+// payloadCodec is the only codec: payloads are relayed as opaque bytes.
+const payloadCodec = "raw"
+
+// WelcomeFrame answers a hello with the negotiated capabilities.
+type WelcomeFrame struct {
+    Type         string       `json:"type"`
+    Capabilities Capabilities `json:"capabilities"`
+    Features     []string     `json:"features,omitempty"` // feature flags on for the connection
+}
+
+type handshake struct {
+    mu    sync.Mutex
+    done  bool
+    timer *time.Timer
+    join  func()
+}
+
+// startHandshake defers join until the handshake completes or times out.
+func startHandshake(timeout time.Duration, join func()) *handshake {
+    hs := &handshake{join: join}
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    hs.timer = time.AfterFunc(timeout, func() { hs.finish(nil) })
+    return hs
+}
+
+// finish runs negotiate (if given) and joins, once. It reports false when
+// the handshake had already completed.
+func (hs *handshake) finish(negotiate func()) bool {
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    if hs.done {
+        return false
+    }
+    hs.done = true
+    hs.timer.Stop()
+    if negotiate != nil {
+        negotiate()
+    }
+    hs.join()
+    return true
+}
+
+// abort gives up on a handshake whose connection closed before joining.
+func (hs *handshake) abort() {
+    hs.mu.Lock()
+    defer hs.mu.Unlock()
+    hs.done = true
+    hs.timer.Stop()
+}
+
+// negotiate applies the requested capabilities the relay supports and
+// queues the welcome frame. namesCompression is whether the hello said
+// anything about compression; if not, the connection keeps its default. It
+// runs before the client joins its room, so nothing else reads the fields
+// it sets.
+func (c *Client) negotiate(req Capabilities, namesCompression bool) {
+    if _, ok := envelopeRenderers[req.Envelope]; ok && req.MaxOverhead == 0 && !(c.room.acks != nil && req.Envelope == 3) {
+        c.envVersion, c.maxOverhead = req.Envelope, 0
+    }
+    if req.MaxOverhead > 0 && c.room.acks == nil {
+        c.maxOverhead = req.MaxOverhead
+    }
+    maxSize := req.MaxSize
+    if maxSize > 0 {
+        maxSize = c.setReadLimit(maxSize)
+    }
+    c.echo = req.Echo
+    c.binControl = c.binControl || req.BinaryControl
+    on := c.compressDef
+    if namesCompression {
+        on = req.Compression
+    }
+    compression := c.setWriteCompression(on) && on
+    var stats time.Duration
+    if req.StatsInterval > 0 {
+        stats = max(time.Duration(req.StatsInterval)*time.Millisecond, c.room.hub.cfg.MinStatsInterval)
+        c.pushStats(stats)
+    }
+    got := Capabilities{
+        Envelope:      c.envVersion,
+        MaxOverhead:   c.maxOverhead,
+        Compression:   compression,
+        Codec:         payloadCodec,
+        MaxSize:       maxSize,
+        Echo:          c.echo,
+        Subscriptions: []string{c.room.name},
+        StatsInterval: stats.Milliseconds(),
+        BinaryControl: c.binControl,
+    }
+    if got.MaxOverhead > 0 {
+        got.Envelope = 0
+    } else if got.Envelope == 0 {
+        got.Envelope = defaultEnvelopeVersion
+    }
+    b, _ := json.Marshal(WelcomeFrame{Type: "welcome", Capabilities: got, Features: c.features})
+    c.trySend(b)
+}
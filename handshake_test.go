@@ -0,0 +1,91 @@
+package main
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestHandshakeNegotiation(t *testing.T) {
+    hub := NewHubWithConfig(Config{HandshakeTimeout: 2 * time.Second})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/lobby/alice")
+
+    hello := `{"op":"hello","capabilities":{"envelope":2,"compression":true,"codec":"msgpack","max_size":1024,"echo":true,"subscriptions":["lobby","other"]}}`
+    if err := c.WriteMessage(websocket.TextMessage, []byte(hello)); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := c.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var wf WelcomeFrame
+    if err := json.Unmarshal(raw, &wf); err != nil || wf.Type != "welcome" {
+        t.Fatalf("expected welcome, got %s (%v)", raw, err)
+    }
+    want := Capabilities{Envelope: 2, Codec: payloadCodec, MaxSize: 1024, Echo: true, Subscriptions: []string{"lobby"}}
+    if !reflect.DeepEqual(wf.Capabilities, want) {
+        t.Fatalf("negotiated %+v, want %+v", wf.Capabilities, want)
+    }
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 1 }) {
+        t.Fatal("client did not join after the handshake")
+    }
+
+    // negotiated echo and envelope apply to the client's own messages
+    if err := c.WriteMessage(websocket.BinaryMessage, []byte("hi")); err != nil {
+        t.Fatal(err)
+    }
+    _, raw, err = c.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var slim envelopeV2
+    if err := json.Unmarshal(raw, &slim); err != nil || slim.V != 2 || string(slim.Payload) != "hi" {
+        t.Fatalf("expected own message as v2, got %s (%v)", raw, err)
+    }
+
+    // a second hello is refused
+    if err := c.WriteMessage(websocket.TextMessage, []byte(hello)); err != nil {
+        t.Fatal(err)
+    }
+    _, raw, err = c.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ef ErrorFrame
+    if err := json.Unmarshal(raw, &ef); err != nil || ef.Code != "unexpected_hello" {
+        t.Fatalf("expected unexpected_hello, got %s", raw)
+    }
+}
+
+func TestHandshakeOmitted(t *testing.T) {
+    hub := NewHubWithConfig(Config{HandshakeTimeout: 300 * time.Millisecond})
+    base := startTestServer(t, hub)
+
+    // a silent client joins with defaults once the handshake times out
+    quiet := dialWS(t, base+"/ws/lobby/quiet")
+    time.Sleep(50 * time.Millisecond)
+    if n := roomSize(hub, "lobby"); n != 0 {
+        t.Fatalf("joined before the handshake timeout: %d members", n)
+    }
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 1 }) {
+        t.Fatal("silent client never joined")
+    }
+
+    // a client whose first frame is data joins at once and the frame is relayed
+    talker := dialWS(t, base+"/ws/lobby/talker")
+    if err := talker.WriteMessage(websocket.BinaryMessage, []byte("first")); err != nil {
+        t.Fatal(err)
+    }
+    got := readEnvelopes(t, quiet, 300*time.Millisecond)
+    if len(got) != 1 || got[0].Username != "talker" || string(got[0].Payload) != "first" {
+        t.Fatalf("quiet client got %+v", got)
+    }
+    if got := readEnvelopes(t, talker, 100*time.Millisecond); len(got) != 0 {
+        t.Fatalf("echo should be off by default, got %+v", got)
+    }
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+)
+
+// HealthChecker is a dependency check aggregated into /health. Check should
+// return promptly once ctx is done; each check runs with its own timeout.
+type HealthChecker interface {
+    Name() string
+    Check(ctx context.Context) error
+}
+
+type healthCheckFunc struct {
+    name string
+    fn   func(context.Context) error
+}
+
+func (c healthCheckFunc) Name() string                    { return c.name }
+func (c healthCheckFunc) Check(ctx context.Context) error { return c.fn(ctx) }
+
+// NewHealthCheck adapts a function to HealthChecker.
+func NewHealthCheck(name string, fn func(context.Context) error) HealthChecker {
+    return healthCheckFunc{name: name, fn: fn}
+}
+
+// CheckResult is one check's entry in the /health response.
+type CheckResult struct {
+    Status     string  `json:"status"`
+    Error      string  `json:"error,omitempty"`
+    DurationMs float64 `json:"duration_ms"`
+}
+
+type healthChecks struct {
+    mu     sync.RWMutex
+    checks []HealthChecker
+}
+
+// RegisterHealthCheck adds a check to /health.
+func (h *Hub) RegisterHealthCheck(c HealthChecker) {
+    h.health.mu.Lock()
+    defer h.health.mu.Unlock()
+    h.health.checks = append(h.health.checks, c)
+}
+
+// runHealthChecks runs every registered check concurrently, each bounded by
+// timeout, and reports whether all passed.
+func (h *Hub) runHealthChecks(ctx context.Context, timeout time.Duration) (map[string]CheckResult, bool) {
+    h.health.mu.RLock()
+    checks := append([]HealthChecker(nil), h.health.checks...)
+    h.health.mu.RUnlock()
+    if timeout <= 0 {
+        timeout = 2 * time.Second
+    }
+
+    results := make([]CheckResult, len(checks))
+    var wg sync.WaitGroup
+    for i, c := range checks {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            ctx, cancel := context.WithTimeout(ctx, timeout)
+            defer cancel()
+            start := time.Now()
+            done := make(chan error, 1)
+            go func() { done <- c.Check(ctx) }()
+            var err error
+            select {
+            case err = <-done:
+            case <-ctx.Done():
+                err = ctx.Err() // the check ignored its context
+            }
+            res := CheckResult{Status: "ok", DurationMs: float64(time.Since(start)) / float64(time.Millisecond)}
+            if err != nil {
+                res.Status, res.Error = "fail", err.Error()
+            }
+            results[i] = res
+        }()
+    }
+    wg.Wait()
+
+    byName := make(map[string]CheckResult, len(checks))
+    healthy := true
+    for i, c := range checks {
+        byName[c.Name()] = results[i]
+        healthy = healthy && results[i].Status == "ok"
+    }
+    return byName, healthy
+}
+
+// healthHandler serves /health: build info plus registered checks. Any
+// failing check turns the status to "fail" and the response to 503.
+func healthHandler(hub *Hub) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        applyCORSHeaders(w, os.Getenv("ALLOWED_ORIGIN"))
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        checks, healthy := hub.runHealthChecks(r.Context(), hub.cfg.HealthCheckTimeout)
+        status, code := "ok", http.StatusOK
+        if !healthy {
+            status, code = "fail", http.StatusServiceUnavailable
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(code)
+        _ = json.NewEncoder(w).Encode(map[string]any{
+            "status":      status,
+            "commit":      CommitHash,
+            "build_time":  BuildTime,
+            "server_time": time.Now().UTC().Format(time.RFC3339),
+            "checks":      checks,
+        })
+    }
+}
+
+// udpRelayCheck fails when the UDP relay could not start or its read loop
+// has exited.
+func udpRelayCheck(hub *Hub, startErr error) HealthChecker {
+    return NewHealthCheck("udp_relay", func(context.Context) error {
+        if startErr != nil {
+            return startErr
+        }
+        if !hub.udpUp.Load() {
+            return errors.New("udp relay stopped")
+        }
+        return nil
+    })
+}
+
+// httpListenerCheck dials the HTTP listener to confirm it accepts connections.
+func httpListenerCheck(port string) HealthChecker {
+    return NewHealthCheck("http_listener", func(ctx context.Context) error {
+        var d net.Dialer
+        conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", port))
+        if err != nil {
+            return err
+        }
+        return conn.Close()
+    })
+}
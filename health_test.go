@@ -0,0 +1,101 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net"
+    "net/http"
+    "testing"
+    "time"
+)
+
+type healthResponse struct {
+    Status string                 `json:"status"`
+    Checks map[string]CheckResult `json:"checks"`
+}
+
+func getHealth(t *testing.T, wsBase string) (int, healthResponse) {
+    t.Helper()
+    resp, err := http.Get("http" + wsBase[len("ws"):] + "/health")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    var hr healthResponse
+    if err := json.NewDecoder(resp.Body).Decode(&hr); err != nil {
+        t.Fatal(err)
+    }
+    return resp.StatusCode, hr
+}
+
+func TestHealthChecksPassing(t *testing.T) {
+    hub := NewHub()
+    hub.RegisterHealthCheck(NewHealthCheck("redis", func(context.Context) error { return nil }))
+    base := startTestServer(t, hub)
+    code, hr := getHealth(t, base)
+    if code != http.StatusOK || hr.Status != "ok" || hr.Checks["redis"].Status != "ok" {
+        t.Fatalf("got %d %+v", code, hr)
+    }
+}
+
+func TestHealthChecksFailing(t *testing.T) {
+    hub := NewHubWithConfig(Config{HealthCheckTimeout: 100 * time.Millisecond})
+    hub.RegisterHealthCheck(NewHealthCheck("redis", func(context.Context) error { return nil }))
+    hub.RegisterHealthCheck(NewHealthCheck("webhook", func(context.Context) error { return errors.New("connection refused") }))
+    hub.RegisterHealthCheck(NewHealthCheck("stuck", func(context.Context) error {
+        time.Sleep(time.Second) // ignores its context
+        return nil
+    }))
+    base := startTestServer(t, hub)
+
+    start := time.Now()
+    code, hr := getHealth(t, base)
+    if took := time.Since(start); took > 500*time.Millisecond {
+        t.Fatalf("health took %v despite the check timeout", took)
+    }
+    if code != http.StatusServiceUnavailable || hr.Status != "fail" {
+        t.Fatalf("got %d %s, want 503 fail", code, hr.Status)
+    }
+    if hr.Checks["redis"].Status != "ok" {
+        t.Errorf("redis: %+v", hr.Checks["redis"])
+    }
+    if c := hr.Checks["webhook"]; c.Status != "fail" || c.Error != "connection refused" {
+        t.Errorf("webhook: %+v", c)
+    }
+    if c := hr.Checks["stuck"]; c.Status != "fail" || c.Error != context.DeadlineExceeded.Error() {
+        t.Errorf("stuck: %+v", c)
+    }
+}
+
+func TestBuiltinHealthChecks(t *testing.T) {
+    hub := NewHub()
+    if err := udpRelayCheck(hub, errors.New("bind failed")).Check(context.Background()); err == nil {
+        t.Error("udp check should report a failed start")
+    }
+    udp, err := StartUDPRelay(context.Background(), "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    check := udpRelayCheck(hub, nil)
+    if err := check.Check(context.Background()); err != nil {
+        t.Fatalf("running relay: %v", err)
+    }
+    udp.Close()
+    if !waitFor(time.Second, func() bool { return check.Check(context.Background()) != nil }) {
+        t.Error("udp check still passing after the relay stopped")
+    }
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, port, _ := net.SplitHostPort(ln.Addr().String())
+    if err := httpListenerCheck(port).Check(context.Background()); err != nil {
+        t.Fatalf("listener up: %v", err)
+    }
+    ln.Close()
+    if err := httpListenerCheck(port).Check(context.Background()); err == nil {
+        t.Error("listener check passing after close")
+    }
+}
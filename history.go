@@ -0,0 +1,231 @@
+package main
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Message history (HISTORY_SIZE). Each room keeps its last N broadcast
+// envelopes, and a client joining the room is first sent that backlog, in
+// order, so it sees what was said before it arrived. The backlog goes
+// through the client's queue like live traffic, so a backlog larger than
+// the queue is cut short by the overflow policy instead of blocking the
+// join; HISTORY_REPLAY_MAX_BYTES further bounds it by payload size, so a
+// room of large messages cannot flood a joiner. Messages sent only to the
+// most recent members (recent:) are not kept. With HISTORY_MAX_AGE set,
+// messages older than that are dropped too, whichever limit is hit first:
+// on each append, before a replay and by a periodic sweep, so a quiet room
+// does not replay stale messages.
+//
+// A broadcast records its message while holding the room lock it picks
+// recipients under, and join queues the backlog while holding the same
+// lock exclusively, so a joining client gets each message exactly once:
+// from the backlog or live. Only what replay needs is kept of the sender,
+// not the connection itself, so a departed client is not held in memory.
+//
+// When an empty room is removed its history is kept for HISTORY_RETAIN in
+// the room's registry bucket and handed to the room if it is created again
+// within that time, so the next joiner still gets the backlog. Kept
+// histories count toward the memory estimate, whose walk drops them once
+// they expire.
+
+// HistoryStore holds a room's recorded broadcasts. historyRing keeps them as
+// they are; compressedHistory (HISTORY_COMPRESS, see historycompress.go)
+// deflates each payload and restores it on replay. A room without history
+// has a nil store.
+type HistoryStore interface {
+    add(sender *Client, env Envelope)
+    messages() []historyMsg // oldest first
+    expire(now time.Time)   // drops messages older than HISTORY_MAX_AGE
+    size() int64            // bytes held, for the memory estimate
+}
+
+// newHistoryStore returns the store HISTORY_SIZE, HISTORY_MAX_AGE and
+// HISTORY_COMPRESS configure; nil when HISTORY_SIZE is 0.
+func newHistoryStore(cfg Config) HistoryStore {
+    ring := newHistoryRing(cfg.HistorySize, cfg.HistoryMaxAge)
+    switch {
+    case ring == nil:
+        return nil
+    case cfg.HistoryCompress:
+        return &compressedHistory{ring: ring}
+    }
+    return ring
+}
+
+// historySize returns the bytes h holds; zero for a room without history.
+func historySize(h HistoryStore) int64 {
+    if h == nil {
+        return 0
+    }
+    return h.size()
+}
+
+// historyMsg is a recorded broadcast.
+type historyMsg struct {
+    env     Envelope
+    targets []string  // the sender's role targets, see receives
+    at      time.Time // when it was recorded, for HISTORY_MAX_AGE
+}
+
+type historyRing struct {
+    mu     sync.Mutex // broadcasts record under a shared room lock
+    buf    []historyMsg
+    start  int // oldest message
+    n      int
+    maxAge time.Duration // 0 = messages only leave by count
+    bytes  atomic.Int64  // payload bytes held, for the memory estimate
+}
+
+// newHistoryRing returns nil, keeping no history, when size is 0.
+func newHistoryRing(size int, maxAge time.Duration) *historyRing {
+    if size <= 0 {
+        return nil
+    }
+    return &historyRing{buf: make([]historyMsg, size), maxAge: maxAge}
+}
+
+func (h *historyRing) add(sender *Client, env Envelope) {
+    now := time.Now()
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.expireLocked(now)
+    if h.n == len(h.buf) {
+        h.dropOldestLocked()
+    }
+    m := historyMsg{env: env, at: now}
+    if sender != nil {
+        m.targets = sender.targets
+    }
+    h.buf[(h.start+h.n)%len(h.buf)] = m
+    h.n++
+    h.bytes.Add(int64(len(env.Payload)))
+}
+
+func (h *historyRing) dropOldestLocked() {
+    h.bytes.Add(-int64(len(h.buf[h.start].env.Payload)))
+    h.buf[h.start] = historyMsg{}
+    h.start = (h.start + 1) % len(h.buf)
+    h.n--
+}
+
+// expireLocked drops messages older than maxAge.
+func (h *historyRing) expireLocked(now time.Time) {
+    if h.maxAge <= 0 {
+        return
+    }
+    for h.n > 0 && now.Sub(h.buf[h.start].at) > h.maxAge {
+        h.dropOldestLocked()
+    }
+}
+
+// expire drops messages older than HISTORY_MAX_AGE. Appends do this too;
+// the sweep catches rooms that have gone quiet.
+func (h *historyRing) expire(now time.Time) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.expireLocked(now)
+}
+
+// size returns the payload bytes held.
+func (h *historyRing) size() int64 {
+    return h.bytes.Load()
+}
+
+// messages returns the recorded messages, oldest first.
+func (h *historyRing) messages() []historyMsg {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    out := make([]historyMsg, 0, h.n)
+    for i := 0; i < h.n; i++ {
+        out = append(out, h.buf[(h.start+i)%len(h.buf)])
+    }
+    return out
+}
+
+// runHistorySweep expires old history messages every interval, until the
+// process exits.
+func (h *Hub) runHistorySweep(interval time.Duration) {
+    for now := range time.Tick(interval) {
+        h.forEachRoom(func(r *Room) {
+            if r.history != nil {
+                r.history.expire(now)
+            }
+        })
+    }
+}
+
+// replayHistoryLocked queues r's backlog for c, which just joined; r.mu
+// must be held for writing. c cannot have sent any of it, so there is no
+// echo to suppress. With HISTORY_REPLAY_MAX_BYTES set only the most recent
+// messages whose payloads fit in it are sent, still oldest first.
+func (r *Room) replayHistoryLocked(c *Client) {
+    r.history.expire(time.Now())
+    var backlog []historyMsg
+    for _, m := range r.history.messages() {
+        if c.receivesTargets(m.targets) {
+            backlog = append(backlog, m)
+        }
+    }
+    if limit := r.hub.cfg.HistoryReplayMaxBytes; limit > 0 {
+        from, total := len(backlog), int64(0)
+        for from > 0 && total+int64(len(backlog[from-1].env.Payload)) <= limit {
+            from--
+            total += int64(len(backlog[from].env.Payload))
+        }
+        backlog = backlog[from:]
+    }
+    for _, m := range backlog {
+        out := envelopeCache{env: m.env}
+        c.offer(outbound{msg: c.envelopeFor(&out), expires: m.env.expires, env: &m.env})
+    }
+}
+
+// keptHistory is the history of a removed room, held until it expires.
+type keptHistory struct {
+    store HistoryStore
+    until time.Time
+}
+
+// keepHistoryLocked holds the history of r, which is being removed, for
+// HISTORY_RETAIN; s is r's bucket and its lock is held.
+func (h *Hub) keepHistoryLocked(s *roomShard, r *Room) {
+    if h.cfg.HistoryRetain <= 0 || historySize(r.history) == 0 {
+        return
+    }
+    s.kept[r.name] = keptHistory{store: r.history, until: time.Now().Add(h.cfg.HistoryRetain)}
+}
+
+// takeHistoryLocked hands a room being created the history it was removed
+// with, if that has not expired; s is its bucket and its lock is held.
+func (s *roomShard) takeHistoryLocked(name string) HistoryStore {
+    k, ok := s.kept[name]
+    if !ok {
+        return nil
+    }
+    delete(s.kept, name)
+    if time.Now().After(k.until) {
+        return nil
+    }
+    return k.store
+}
+
+// keptHistorySize returns the payload bytes of kept histories, dropping
+// those that have expired.
+func (h *Hub) keptHistorySize(now time.Time) int64 {
+    var total int64
+    for i := range h.roomShards {
+        s := &h.roomShards[i]
+        s.mu.Lock()
+        for name, k := range s.kept {
+            if now.After(k.until) {
+                delete(s.kept, name)
+                continue
+            }
+            total += k.store.size()
+        }
+        s.mu.Unlock()
+    }
+    return total
+}
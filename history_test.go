@@ -0,0 +1,253 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestHistoryRingKeepsLastN(t *testing.T) {
+    h := newHistoryRing(3, 0)
+    for i := 0; i < 5; i++ {
+        h.add(nil, NewEnvelope("r", "a", []byte(fmt.Sprint(i))))
+    }
+    var got []string
+    for _, m := range h.messages() {
+        got = append(got, string(m.env.Payload))
+    }
+    if fmt.Sprint(got) != "[2 3 4]" {
+        t.Fatalf("history %v, want the last 3 oldest first", got)
+    }
+    if newHistoryRing(0, 0) != nil {
+        t.Fatal("HISTORY_SIZE 0 should keep no history")
+    }
+}
+
+func TestHistoryRingEvictsByCountAndAge(t *testing.T) {
+    h := newHistoryRing(3, time.Minute)
+    payloads := func() string {
+        var got []string
+        for _, m := range h.messages() {
+            got = append(got, string(m.env.Payload))
+        }
+        return fmt.Sprint(got)
+    }
+    for _, p := range []string{"old1", "old2"} {
+        h.add(nil, NewEnvelope("r", "a", []byte(p)))
+    }
+    for i := range h.buf {
+        h.buf[i].at = h.buf[i].at.Add(-2 * time.Minute)
+    }
+    // the append drops what has aged out, though the count has room for it
+    h.add(nil, NewEnvelope("r", "a", []byte("new1")))
+    if got := payloads(); got != "[new1]" || h.size() != 4 {
+        t.Fatalf("after append history %v (%d bytes), want [new1]", got, h.size())
+    }
+    for _, p := range []string{"new2", "new3", "new4"} {
+        h.add(nil, NewEnvelope("r", "a", []byte(p)))
+    }
+    // the count still applies to fresh messages
+    if got := payloads(); got != "[new2 new3 new4]" || h.size() != 12 {
+        t.Fatalf("history %v (%d bytes), want the last 3", got, h.size())
+    }
+    h.expire(time.Now().Add(30 * time.Second))
+    if got := payloads(); got != "[new2 new3 new4]" {
+        t.Fatalf("sweep dropped fresh messages: %v", got)
+    }
+    // the sweep empties a room that went quiet
+    h.expire(time.Now().Add(2 * time.Minute))
+    if got := payloads(); got != "[]" || h.size() != 0 {
+        t.Fatalf("after sweep history %v (%d bytes), want none", got, h.size())
+    }
+}
+
+func TestHistoryReplaySkipsAgedOutMessages(t *testing.T) {
+    hub := NewHubWithConfig(Config{HistorySize: 10, HistoryMaxAge: time.Minute})
+    room := hub.getRoom("r")
+    sender := fakeClient(room, "a", 4)
+    room.broadcast(sender, NewEnvelope("r", "a", []byte("stale")))
+    room.history.(*historyRing).buf[0].at = time.Now().Add(-2 * time.Minute)
+    room.broadcast(sender, NewEnvelope("r", "a", []byte("fresh")))
+    room.history.(*historyRing).buf[1].at = time.Now().Add(-2 * time.Minute)
+    // nothing was appended since "fresh" aged out; the replay itself drops it
+    late := &Client{username: "late", room: room, sendCh: make(chan outbound, 4)}
+    if err := room.join(late); err != nil {
+        t.Fatal(err)
+    }
+    if n := len(late.sendCh); n != 0 {
+        t.Fatalf("late joiner got %d aged-out messages", n)
+    }
+}
+
+func TestHistoryReplayedOnJoin(t *testing.T) {
+    hub := NewHubWithConfig(Config{HistorySize: 3})
+    base := startTestServer(t, hub)
+    a := dialWS(t, base+"/ws/chat/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "chat") == 1 })
+    for i := 0; i < 5; i++ {
+        if err := a.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("m%d", i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+    waitFor(time.Second, func() bool {
+        r := hub.existingRoomFor("chat", "alice")
+        return r != nil && len(r.history.messages()) == 3 && string(r.history.messages()[2].env.Payload) == "m4"
+    })
+
+    b := dialWS(t, base+"/ws/chat/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "chat") == 2 })
+    a.WriteMessage(websocket.TextMessage, []byte("live"))
+    var got []string
+    for len(got) < 4 {
+        b.SetReadDeadline(time.Now().Add(2 * time.Second))
+        var env Envelope
+        if err := b.ReadJSON(&env); err != nil {
+            t.Fatalf("after %v: %v", got, err)
+        }
+        if env.Username != "alice" {
+            t.Fatalf("unexpected envelope %+v", env)
+        }
+        got = append(got, string(env.Payload))
+    }
+    if fmt.Sprint(got) != "[m2 m3 m4 live]" {
+        t.Fatalf("bob got %v, want the backlog in order then live traffic", got)
+    }
+}
+
+func TestHistoryReplayRespectsOverflow(t *testing.T) {
+    hub := NewHubWithConfig(Config{HistorySize: 50, OverflowPolicy: OverflowDropOld})
+    room := hub.getRoom("r")
+    sender := fakeClient(room, "a", 4)
+    for i := 0; i < 50; i++ {
+        room.broadcast(sender, NewEnvelope("r", "a", []byte(fmt.Sprint(i))))
+    }
+    late := &Client{username: "late", room: room, sendCh: make(chan outbound, 4)}
+    done := make(chan error, 1)
+    go func() { done <- room.join(late) }()
+    select {
+    case err := <-done:
+        if err != nil {
+            t.Fatal(err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("join blocked on a backlog larger than the send queue")
+    }
+    var got []string
+    for _, msg := range drain(late, 4, time.Second) {
+        var env Envelope
+        if err := json.Unmarshal([]byte(msg), &env); err != nil {
+            t.Fatal(err)
+        }
+        got = append(got, string(env.Payload))
+    }
+    if fmt.Sprint(got) != "[46 47 48 49]" {
+        t.Fatalf("queue holds %v, want the newest backlog under drop_old", got)
+    }
+}
+
+func TestHistorySkipsRefusedBroadcasts(t *testing.T) {
+    payload := []byte("0123456789")
+    size := int64(len(renderEnvelopeV1(NewEnvelope("capped", "sender", payload))))
+    hub := NewHubWithConfig(Config{HistorySize: 10, RoomEgressCap: 2*size + size/2, RoomEgressCapWindow: time.Minute})
+    room := hub.getRoom("capped")
+    sender := fakeClient(room, "sender", 16)
+    fakeClient(room, "recv", 16)
+    for i := 0; i < 4; i++ {
+        room.broadcast(sender, NewEnvelope("capped", "sender", payload))
+    }
+    if n := len(room.history.messages()); n != 2 {
+        t.Fatalf("history holds %d messages, want only the 2 delivered", n)
+    }
+}
+
+func TestHistoryKeepsNoSenderConnection(t *testing.T) {
+    hub := NewHubWithConfig(Config{HistorySize: 4})
+    room := hub.getRoom("r")
+    sender := fakeClient(room, "a", 4)
+    sender.role, sender.targets = "agent", []string{"agent"}
+    room.broadcast(sender, NewEnvelope("r", "a", []byte("m")))
+    msgs := room.history.messages()
+    if len(msgs) != 1 || fmt.Sprint(msgs[0].targets) != "[agent]" {
+        t.Fatalf("history %+v, want the message with its sender's role targets", msgs)
+    }
+    customer := &Client{role: "customer"}
+    agent := &Client{role: "agent"}
+    if customer.receivesTargets(msgs[0].targets) || !agent.receivesTargets(msgs[0].targets) {
+        t.Fatal("role filtering lost on replay")
+    }
+}
+
+func TestHistoryOutlivesEmptyRoom(t *testing.T) {
+    hub := NewHubWithConfig(Config{HistorySize: 3, HistoryRetain: time.Minute})
+    base := startTestServer(t, hub)
+    a := dialWS(t, base+"/ws/chat/alice")
+    a.WriteMessage(websocket.TextMessage, []byte("before"))
+    waitFor(time.Second, func() bool {
+        r := hub.lookupRoom("chat")
+        return r != nil && len(r.history.messages()) == 1
+    })
+    a.Close()
+    if !waitFor(2*time.Second, func() bool { return hub.lookupRoom("chat") == nil }) {
+        t.Fatal("empty room not removed")
+    }
+    if n := hub.estimateMemory(); n < int64(len("before")) {
+        t.Fatalf("memory estimate %d does not count the kept history", n)
+    }
+
+    b := dialWS(t, base+"/ws/chat/bob")
+    b.SetReadDeadline(time.Now().Add(2 * time.Second))
+    var env Envelope
+    if err := b.ReadJSON(&env); err != nil || string(env.Payload) != "before" {
+        t.Fatalf("joiner of the recreated room got %+v (%v), want the kept backlog", env, err)
+    }
+
+    // past the retention the history is dropped
+    b.Close()
+    waitFor(2*time.Second, func() bool { return hub.lookupRoom("chat") == nil })
+    if n := hub.keptHistorySize(time.Now().Add(2 * time.Minute)); n != 0 {
+        t.Fatalf("expired history still counted: %d bytes", n)
+    }
+    if s := hub.shardOf("chat"); len(s.kept) != 0 {
+        t.Fatal("expired history not dropped")
+    }
+}
+
+func TestHistoryDiscardedWithRoomWithoutRetain(t *testing.T) {
+    hub := NewHubWithConfig(Config{HistorySize: 3})
+    room := hub.getRoom("chat")
+    room.history.add(nil, NewEnvelope("chat", "a", []byte("gone")))
+    hub.removeRoomIfEmpty("chat")
+    if hub.lookupRoom("chat") != nil {
+        t.Fatal("empty room not removed")
+    }
+    if r := hub.getRoom("chat"); len(r.history.messages()) != 0 {
+        t.Fatal("history kept with HISTORY_RETAIN 0")
+    }
+}
+
+func TestHistoryReplayCappedInBytes(t *testing.T) {
+    hub := NewHubWithConfig(Config{HistorySize: 10, HistoryReplayMaxBytes: 2500})
+    room := hub.getRoom("r")
+    sender := fakeClient(room, "a", 16)
+    for i := 0; i < 5; i++ {
+        payload := make([]byte, 1000)
+        payload[0] = byte('0' + i)
+        room.broadcast(sender, NewEnvelope("r", "a", payload))
+    }
+    late := &Client{username: "late", room: room, sendCh: make(chan outbound, 16)}
+    if err := room.join(late); err != nil {
+        t.Fatal(err)
+    }
+    // five 1000-byte messages are under the count cap; only the newest two fit 2500 bytes
+    var got []string
+    for len(late.sendCh) > 0 {
+        o := <-late.sendCh
+        got = append(got, string(o.env.Payload[:1]))
+    }
+    if fmt.Sprint(got) != "[3 4]" {
+        t.Fatalf("replayed %v, want the newest messages that fit, oldest first", got)
+    }
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "bytes"
+    "compress/flate"
+    "io"
+    "log"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Compressed history (HISTORY_COMPRESS). Each payload is deflated on its
+// own as it is recorded and inflated again when the backlog is replayed,
+// trading CPU on every broadcast for a smaller history (see
+// BenchmarkHistoryMemory). Chat-sized messages are too short to shrink on
+// their own, so they are deflated against a preset dictionary: the first
+// historyDictSize bytes of payloads the room records, which carry the keys
+// and phrasing its later messages repeat. Only BestCompression gets much
+// out of a dictionary for inputs this small, so that is the level used.
+// Messages recorded while the dictionary is still being sampled, and any
+// that deflate would not shrink, are stored as they are. The first byte of
+// a stored payload says which: storedRaw or storedDeflate. Entries stay
+// independent, so the ring's count and age limits apply unchanged.
+
+const (
+    storedRaw = iota
+    storedDeflate
+)
+
+const historyDictSize = 4 << 10
+
+// compressedHistory is a HistoryStore over a ring of deflated payloads.
+type compressedHistory struct {
+    ring   *historyRing
+    mu     sync.Mutex // guards sample
+    sample []byte
+    codec  atomic.Pointer[historyCodec] // nil until the dictionary is sampled
+}
+
+// historyCodec deflates and inflates payloads against a fixed dictionary.
+type historyCodec struct {
+    dict    []byte
+    writers sync.Pool
+}
+
+func newHistoryCodec(dict []byte) *historyCodec {
+    c := &historyCodec{dict: dict}
+    c.writers.New = func() any {
+        w, _ := flate.NewWriterDict(nil, flate.BestCompression, dict)
+        return w
+    }
+    return c
+}
+
+// pack returns p as stored: tagged, and deflated when that is shorter.
+func (c *historyCodec) pack(p []byte) []byte {
+    var buf bytes.Buffer
+    buf.WriteByte(storedDeflate)
+    w := c.writers.Get().(*flate.Writer)
+    w.Reset(&buf)
+    w.Write(p)
+    w.Close()
+    c.writers.Put(w)
+    if buf.Len() < len(p)+1 {
+        return bytes.Clone(buf.Bytes())
+    }
+    return append([]byte{storedRaw}, p...)
+}
+
+// unpack reverses pack; c may be nil when stored is raw.
+func (c *historyCodec) unpack(stored []byte) ([]byte, error) {
+    if len(stored) == 0 || stored[0] == storedRaw {
+        return stored[min(len(stored), 1):], nil
+    }
+    r := flate.NewReaderDict(bytes.NewReader(stored[1:]), c.dict)
+    defer r.Close()
+    return io.ReadAll(r)
+}
+
+// codecFor samples p into the dictionary until it is full and returns the
+// codec once it exists.
+func (h *compressedHistory) codecFor(p []byte) *historyCodec {
+    if c := h.codec.Load(); c != nil {
+        return c
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if c := h.codec.Load(); c != nil {
+        return c
+    }
+    h.sample = append(h.sample, p[:min(len(p), historyDictSize-len(h.sample))]...)
+    if len(h.sample) < historyDictSize {
+        return nil
+    }
+    c := newHistoryCodec(h.sample)
+    h.sample = nil
+    h.codec.Store(c)
+    return c
+}
+
+func (h *compressedHistory) add(sender *Client, env Envelope) {
+    if c := h.codecFor(env.Payload); c != nil {
+        env.Payload = c.pack(env.Payload)
+    } else {
+        env.Payload = append([]byte{storedRaw}, env.Payload...)
+    }
+    h.ring.add(sender, env)
+}
+
+func (h *compressedHistory) messages() []historyMsg {
+    c := h.codec.Load()
+    msgs := h.ring.messages()
+    out := msgs[:0]
+    for _, m := range msgs {
+        payload, err := c.unpack(m.env.Payload)
+        if err != nil {
+            // only pack wrote it; this is a bug, not bad input
+            log.Printf("history: dropping corrupt entry in room %s: %v", m.env.Room, err)
+            continue
+        }
+        m.env.Payload = payload
+        out = append(out, m)
+    }
+    return out
+}
+
+func (h *compressedHistory) expire(now time.Time) { h.ring.expire(now) }
+
+// size returns the stored bytes, dictionary included.
+func (h *compressedHistory) size() int64 {
+    return h.ring.size() + historyDictSize
+}
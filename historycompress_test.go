@@ -0,0 +1,107 @@
+package main
+
+import (
+    "bytes"
+    "crypto/rand"
+    "fmt"
+    "testing"
+)
+
+func TestCompressedHistoryRoundTrip(t *testing.T) {
+    random := make([]byte, 512)
+    rand.Read(random)
+    var payloads [][]byte
+    // enough chat to sample the dictionary and then compress against it
+    for i := 0; i < 500; i++ {
+        payloads = append(payloads, []byte(fmt.Sprintf(`{"user":"user%d","text":"message number %d","reactions":[]}`, i%7, i)))
+    }
+    payloads = append(payloads,
+        bytes.Repeat([]byte(`{"symbol":"ACME","side":"buy","price":101.25}`), 20),
+        random, // does not shrink, stored raw
+        []byte{},
+        []byte{storedDeflate}, // looks like a tag
+    )
+    h := newHistoryStore(Config{HistorySize: len(payloads), HistoryCompress: true})
+    plain := newHistoryStore(Config{HistorySize: len(payloads)})
+    for _, p := range payloads {
+        env := NewEnvelope("r", "a", p)
+        h.add(nil, env)
+        plain.add(nil, env)
+    }
+    got := h.messages()
+    if len(got) != len(payloads) {
+        t.Fatalf("%d messages, want %d", len(got), len(payloads))
+    }
+    for i, m := range got {
+        if !bytes.Equal(m.env.Payload, payloads[i]) {
+            t.Fatalf("message %d = %q, want %q", i, m.env.Payload, payloads[i])
+        }
+        if m.env.Username != "a" || m.env.Room != "r" {
+            t.Fatalf("message %d lost its envelope: %+v", i, m.env)
+        }
+    }
+    stored := h.(*compressedHistory).ring.messages()
+    if stored[0].env.Payload[0] != storedRaw || stored[len(payloads)-4].env.Payload[0] != storedDeflate || stored[len(payloads)-3].env.Payload[0] != storedRaw {
+        t.Fatal("entries not stored raw while sampling, deflated after, raw when incompressible")
+    }
+    if h.size() >= plain.size() {
+        t.Fatalf("compressed history holds %d bytes, uncompressed %d", h.size(), plain.size())
+    }
+}
+
+func TestCompressedHistoryReplayedOnJoin(t *testing.T) {
+    hub := NewHubWithConfig(Config{HistorySize: 5, HistoryCompress: true})
+    room := hub.getRoom("r")
+    if _, ok := room.history.(*compressedHistory); !ok {
+        t.Fatalf("history store %T, want compressed", room.history)
+    }
+    sender := fakeClient(room, "a", 16)
+    var want []string
+    for i := 0; i < 7; i++ {
+        p := fmt.Sprintf(`{"n":%d,"text":"%s"}`, i, bytes.Repeat([]byte("la"), 100))
+        want = append(want, p)
+        room.broadcast(sender, NewEnvelope("r", "a", []byte(p)))
+    }
+    late := &Client{username: "late", room: room, sendCh: make(chan outbound, 16)}
+    if err := room.join(late); err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for len(late.sendCh) > 0 {
+        got = append(got, string((<-late.sendCh).env.Payload))
+    }
+    if fmt.Sprint(got) != fmt.Sprint(want[2:]) {
+        t.Fatalf("replayed %q, want the last 5 byte for byte", got)
+    }
+}
+
+// BenchmarkHistoryMemory fills a history with chat-like JSON messages and
+// reports the bytes it holds per message, uncompressed and compressed.
+func BenchmarkHistoryMemory(b *testing.B) {
+    users := []string{"alice", "bob", "carol", "dave"}
+    texts := []string{
+        "anyone around for the standup?",
+        "deploy finished, dashboards look fine",
+        "can you review the relay PR when you get a minute",
+        "lunch at noon? the usual place",
+        "reverting, the canary is throwing 502s",
+    }
+    msgs := make([][]byte, 1000)
+    for i := range msgs {
+        msgs[i] = []byte(fmt.Sprintf(`{"id":"%08x","user":"%s","text":"%s","ts":%d,"reactions":[]}`,
+            i*2654435761, users[i%len(users)], texts[i%len(texts)], 1700000000000+int64(i)*1337))
+    }
+    for _, compress := range []bool{false, true} {
+        b.Run(fmt.Sprintf("compress=%v", compress), func(b *testing.B) {
+            var held int64
+            for i := 0; i < b.N; i++ {
+                h := newHistoryStore(Config{HistorySize: len(msgs), HistoryCompress: compress})
+                for _, m := range msgs {
+                    h.add(nil, NewEnvelope("chat", "a", m))
+                }
+                held = h.size()
+            }
+            b.ReportMetric(float64(held)/float64(len(msgs)), "held-B/msg")
+        })
+    }
+}
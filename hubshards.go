@@ -0,0 +1,91 @@
+package main
+
+import "sync"
+
+// Room registry sharding. Rooms are spread over HUB_SHARDS buckets (default
+// 32) by an FNV hash of their name, each with its own lock and map, so
+// lookups and room churn in one bucket do not contend with the others.
+// h.mu still guards the hub's loaded specs; a bucket lock is only taken
+// after it, never before. Not to be confused with DEFAULT_ROOM_SHARDS,
+// which splits the default room itself, see shards.go.
+
+const defaultHubShards = 32
+
+type roomShard struct {
+    mu    sync.RWMutex
+    rooms map[string]*Room
+    kept  map[string]keptHistory // histories of removed rooms, see history.go
+}
+
+func (cfg Config) hubShards() int {
+    if cfg.HubShards > 0 {
+        return cfg.HubShards
+    }
+    return defaultHubShards
+}
+
+func newRoomShards(n int) []roomShard {
+    shards := make([]roomShard, n)
+    for i := range shards {
+        shards[i].rooms = make(map[string]*Room)
+        shards[i].kept = make(map[string]keptHistory)
+    }
+    return shards
+}
+
+// shardOf returns the bucket holding the named room.
+func (h *Hub) shardOf(name string) *roomShard {
+    return &h.roomShards[shardIndex(name, len(h.roomShards))]
+}
+
+// lookupRoom returns the named room, or nil when it does not exist.
+func (h *Hub) lookupRoom(name string) *Room {
+    s := h.shardOf(name)
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.rooms[name]
+}
+
+// forEachRoom calls fn for every room. Buckets are read one at a time and
+// fn runs with no hub lock held, so rooms created or removed meanwhile may
+// or may not be seen.
+func (h *Hub) forEachRoom(fn func(*Room)) {
+    for i := range h.roomShards {
+        s := &h.roomShards[i]
+        s.mu.RLock()
+        rooms := make([]*Room, 0, len(s.rooms))
+        for _, r := range s.rooms {
+            rooms = append(rooms, r)
+        }
+        s.mu.RUnlock()
+        for _, r := range rooms {
+            fn(r)
+        }
+    }
+}
+
+// roomCount is the number of rooms held by the hub.
+func (h *Hub) roomCount() int {
+    n := 0
+    for i := range h.roomShards {
+        s := &h.roomShards[i]
+        s.mu.RLock()
+        n += len(s.rooms)
+        s.mu.RUnlock()
+    }
+    return n
+}
+
+// lockAllShards write-locks every bucket, in order, for creating rooms
+// that land in several buckets at once.
+func (h *Hub) lockAllShards() {
+    for i := range h.roomShards {
+        h.roomShards[i].mu.Lock()
+    }
+}
+
+func (h *Hub) unlockAllShards() {
+    for i := range h.roomShards {
+        h.roomShards[i].mu.Unlock()
+    }
+}
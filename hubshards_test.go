@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "testing"
+)
+
+func TestRoomsSpreadOverShards(t *testing.T) {
+    hub := NewHubWithConfig(Config{HubShards: 8})
+    for i := 0; i < 100; i++ {
+        hub.getRoom(fmt.Sprintf("room-%d", i))
+    }
+    used := 0
+    for i := range hub.roomShards {
+        if len(hub.roomShards[i].rooms) > 0 {
+            used++
+        }
+    }
+    if used < 4 {
+        t.Fatalf("100 rooms landed in %d of 8 shards", used)
+    }
+    if n := hub.roomCount(); n != 100 {
+        t.Fatalf("roomCount = %d", n)
+    }
+    var names []string
+    hub.forEachRoom(func(r *Room) { names = append(names, r.name) })
+    sort.Strings(names)
+    if len(names) != 100 || names[0] != "room-0" {
+        t.Fatalf("forEachRoom saw %d rooms", len(names))
+    }
+    if hub.lookupRoom("room-42") != hub.getRoom("room-42") || hub.lookupRoom("nope") != nil {
+        t.Fatal("lookupRoom disagrees with getRoom")
+    }
+}
+
+func TestConcurrentGetRoomReturnsOneRoom(t *testing.T) {
+    hub := NewHub()
+    var wg sync.WaitGroup
+    got := make([]*Room, 64)
+    for i := range got {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            got[i] = hub.getRoom("contended")
+        }()
+    }
+    wg.Wait()
+    for _, r := range got {
+        if r != got[0] {
+            t.Fatal("concurrent getRoom created the room twice")
+        }
+    }
+}
+
+func TestDefaultRoomShardsCreatedTogether(t *testing.T) {
+    hub := NewHubWithConfig(Config{DefaultRoomShards: 4})
+    r := hub.getRoom(shardName(2))
+    if len(r.shards) != 4 {
+        t.Fatalf("shard has %d siblings", len(r.shards))
+    }
+    for i := 0; i < 4; i++ {
+        if hub.lookupRoom(shardName(i)) != r.shards[i] {
+            t.Fatalf("shard %d not registered", i)
+        }
+    }
+}
+
+// BenchmarkGetRoom calls getRoom from many goroutines at once, through
+// one registry bucket and through the default 32: "lookup" finds existing
+// rooms, "churn" removes each room again so every call creates one.
+func BenchmarkGetRoom(b *testing.B) {
+    names := make([]string, 4096)
+    for i := range names {
+        names[i] = fmt.Sprintf("room-%d", i)
+    }
+    for _, churn := range []bool{false, true} {
+        for _, shards := range []int{1, defaultHubShards} {
+            mode := "lookup"
+            if churn {
+                mode = "churn"
+            }
+            b.Run(fmt.Sprintf("%s/shards=%d", mode, shards), func(b *testing.B) {
+                hub := NewHubWithConfig(Config{HubShards: shards})
+                b.SetParallelism(16)
+                b.ResetTimer()
+                b.RunParallel(func(pb *testing.PB) {
+                    i := 0
+                    for pb.Next() {
+                        name := names[i%len(names)]
+                        hub.getRoom(name)
+                        if churn {
+                            hub.removeRoomIfEmpty(name)
+                        }
+                        i += 7
+                    }
+                })
+            })
+        }
+    }
+}
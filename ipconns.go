@@ -0,0 +1,89 @@
+package main
+
+import (
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ipConnLimiter caps the connections held open at once from one client IP
+// (MAX_CONNS_PER_IP). Unlike connectLimiter, which paces new connections,
+// it bounds how many a single address can accumulate.
+type ipConnLimiter struct {
+    max    int
+    mu     sync.Mutex
+    counts map[string]int
+}
+
+// newIPConnLimiter returns nil, which admits everyone, when max is 0.
+func newIPConnLimiter(max int) *ipConnLimiter {
+    if max <= 0 {
+        return nil
+    }
+    return &ipConnLimiter{max: max, counts: make(map[string]int)}
+}
+
+// acquire reserves a connection for ip, reporting false when ip already
+// holds the maximum. Each successful acquire must be paired with release.
+func (l *ipConnLimiter) acquire(ip string) bool {
+    if l == nil {
+        return true
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.counts[ip] >= l.max {
+        return false
+    }
+    l.counts[ip]++
+    return true
+}
+
+func (l *ipConnLimiter) release(ip string) {
+    if l == nil {
+        return
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.counts[ip] <= 1 {
+        delete(l.counts, ip)
+        return
+    }
+    l.counts[ip]--
+}
+
+// retryAfter is the hint sent with a refusal. When one of the address's
+// connections will close cannot be known, so clients are asked for the
+// minimum back-off rather than left to retry at once.
+func (l *ipConnLimiter) retryAfter() time.Duration {
+    return time.Second
+}
+
+// held is the number of connections open from ip.
+func (l *ipConnLimiter) held(ip string) int {
+    if l == nil {
+        return 0
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.counts[ip]
+}
+
+// remoteIP is the client address the per-IP limits key on. Behind a proxy
+// (TRUST_PROXY) that is the last X-Forwarded-For entry, the one the proxy
+// itself appended; earlier entries come from the client and can be forged.
+func (h *Hub) remoteIP(r *http.Request) string {
+    if h.cfg.TrustProxy {
+        if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+            last := xff[len(xff)-1]
+            if i := strings.LastIndexByte(last, ','); i >= 0 {
+                last = last[i+1:]
+            }
+            if ip := strings.TrimSpace(last); net.ParseIP(ip) != nil {
+                return ip
+            }
+        }
+    }
+    return clientIP(r)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestMaxConnsPerIPEnforced(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxConnsPerIP: 2})
+    base := startTestServer(t, hub)
+    a := dialWS(t, base+"/ws/r/a")
+    defer a.Close()
+    b := dialWS(t, base+"/ws/r/b")
+    defer b.Close()
+    c, resp, err := websocket.DefaultDialer.Dial(base+"/ws/r/c", nil)
+    if err == nil {
+        c.Close()
+        t.Fatal("third connection from one IP was accepted")
+    }
+    if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+        t.Fatalf("third connection failed without 429: %v", err)
+    }
+    if ra := resp.Header.Get("Retry-After"); ra != "1" {
+        t.Fatalf("Retry-After = %q, want 1", ra)
+    }
+}
+
+func TestMaxConnsPerIPReleasedOnDisconnect(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxConnsPerIP: 1})
+    base := startTestServer(t, hub)
+    a := dialWS(t, base+"/ws/r/a")
+    if n := hub.ipConns.held("127.0.0.1"); n != 1 {
+        t.Fatalf("held %d connections after connect, want 1", n)
+    }
+    a.Close()
+    if !waitFor(2*time.Second, func() bool { return hub.ipConns.held("127.0.0.1") == 0 }) {
+        t.Fatalf("counter not released on disconnect: %d", hub.ipConns.held("127.0.0.1"))
+    }
+    b := dialWS(t, base+"/ws/r/b")
+    b.Close()
+}
+
+func TestRemoteIPTrustProxy(t *testing.T) {
+    r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+    r.RemoteAddr = "10.0.0.1:40000"
+    r.Header.Add("X-Forwarded-For", "198.51.100.9, 203.0.113.5")
+    if ip := NewHubWithConfig(Config{}).remoteIP(r); ip != "10.0.0.1" {
+        t.Fatalf("untrusted proxy: remoteIP = %q", ip)
+    }
+    hub := NewHubWithConfig(Config{TrustProxy: true})
+    if ip := hub.remoteIP(r); ip != "203.0.113.5" {
+        t.Fatalf("trusted proxy: remoteIP = %q, want the entry the proxy appended", ip)
+    }
+    r.Header.Set("X-Forwarded-For", "not-an-ip")
+    if ip := hub.remoteIP(r); ip != "10.0.0.1" {
+        t.Fatalf("malformed header: remoteIP = %q", ip)
+    }
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+// jitterEstimator tracks inter-arrival jitter of a client's messages the
+// way RTP does (RFC 3550): a running average, with gain 1/16, of how much
+// each gap between arrivals differs from the previous gap.
+type jitterEstimator struct {
+    mu      sync.Mutex
+    last    time.Time
+    lastGap time.Duration
+    jitter  float64 // seconds
+}
+
+func (j *jitterEstimator) observe(now time.Time) {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if j.last.IsZero() {
+        j.last = now
+        return
+    }
+    gap := now.Sub(j.last)
+    j.last = now
+    if j.lastGap != 0 {
+        d := (gap - j.lastGap).Seconds()
+        if d < 0 {
+            d = -d
+        }
+        j.jitter += (d - j.jitter) / 16
+    }
+    j.lastGap = gap
+}
+
+func (j *jitterEstimator) value() time.Duration {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return time.Duration(j.jitter * float64(time.Second))
+}
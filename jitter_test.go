@@ -0,0 +1,57 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestJitterEstimator(t *testing.T) {
+    var steady jitterEstimator
+    now := time.Now()
+    for i := 0; i < 50; i++ {
+        steady.observe(now.Add(time.Duration(i) * 20 * time.Millisecond))
+    }
+    if v := steady.value(); v != 0 {
+        t.Fatalf("regular arrivals produced jitter %v", v)
+    }
+
+    var irregular jitterEstimator
+    at := now
+    for _, gap := range []int{10, 50, 5, 80, 20, 60, 15, 90, 30, 40} {
+        at = at.Add(time.Duration(gap) * time.Millisecond)
+        irregular.observe(at)
+    }
+    if v := irregular.value(); v <= 0 {
+        t.Fatalf("irregular arrivals produced no jitter")
+    }
+}
+
+func TestJitterInStats(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "admin-token"})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/media/cam")
+    for _, gap := range []time.Duration{0, 5, 40, 2, 60, 10} {
+        time.Sleep(gap * time.Millisecond)
+        if err := c.WriteMessage(websocket.BinaryMessage, []byte("frame")); err != nil {
+            t.Fatal(err)
+        }
+    }
+    ok := waitFor(time.Second, func() bool {
+        var st HubStats
+        statsRequest(t, base, http.MethodGet, "/stats", "admin-token", &st)
+        for _, rs := range st.Rooms {
+            for _, m := range rs.Members {
+                if rs.Room == "media" && m.Username == "cam" && m.JitterMs > 0 {
+                    return true
+                }
+            }
+        }
+        return false
+    })
+    if !ok {
+        t.Fatal("no jitter reported for irregular sender")
+    }
+}
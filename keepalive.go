@@ -0,0 +1,27 @@
+package main
+
+import (
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// Keepalive. The writer pings every client each PING_INTERVAL and every
+// pong pushes the read deadline out, so idle connections stay open through
+// proxies while dead peers are dropped after two missed intervals. With
+// pings off the reader just times out after READ_TIMEOUT of silence,
+// defaultReadTimeout unless configured.
+const defaultReadTimeout = 60 * time.Second
+
+// readTimeout is how long the reader waits for any frame, pongs included.
+func readTimeout(pingInterval time.Duration) time.Duration {
+    if pingInterval <= 0 {
+        return defaultReadTimeout
+    }
+    return 2 * pingInterval
+}
+
+// ping sends a ping frame; only the writer goroutine calls it.
+func (c *Client) ping() error {
+    return c.writeFailed(c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeWait)))
+}
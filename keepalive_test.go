@@ -0,0 +1,60 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestReadTimeout(t *testing.T) {
+    if d := readTimeout(0); d != defaultReadTimeout {
+        t.Fatalf("pings off: %v", d)
+    }
+    if d := readTimeout(30 * time.Second); d != time.Minute {
+        t.Fatalf("30s pings: %v", d)
+    }
+}
+
+// With a short interval the read timeout is 100ms, so a second of silence
+// stands in for minutes of idling behind a proxy.
+func TestPingsKeepIdleConnectionsAlive(t *testing.T) {
+    hub := NewHubWithConfig(Config{PingInterval: 50 * time.Millisecond})
+    base := startTestServer(t, hub)
+    idle := dialWS(t, base+"/ws/r/idle")
+    var pings int
+    idle.SetPingHandler(func(data string) error {
+        pings++
+        return idle.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+    })
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 })
+
+    // the client only reads (answering pings) and never sends
+    if got := readEnvelopes(t, idle, time.Second); len(got) != 0 {
+        t.Fatalf("unexpected messages %v", got)
+    }
+    if pings < 5 {
+        t.Fatalf("saw %d pings in a second at a 50ms interval", pings)
+    }
+    if n := roomSize(hub, "r"); n != 1 {
+        t.Fatal("idle client that answers pings was dropped")
+    }
+}
+
+func TestUnansweredPingsDropConnection(t *testing.T) {
+    hub := NewHubWithConfig(Config{PingInterval: 50 * time.Millisecond})
+    base := startTestServer(t, hub)
+    deaf := dialWS(t, base+"/ws/r/deaf")
+    deaf.SetPingHandler(func(string) error { return nil }) // never pongs
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 })
+    go func() { // gorilla only runs the ping handler while reading
+        for {
+            if _, _, err := deaf.ReadMessage(); err != nil {
+                return
+            }
+        }
+    }()
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "r") == 0 }) {
+        t.Fatal("a client that never answers pings should be dropped")
+    }
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "flag"
     "fmt"
     "log"
     "net"
     "net/http"
     "os"
+    "os/signal"
+    "strconv"
     "strings"
     "sync"
+    "sync/atomic"
+    "syscall"
     "time"
 
     "github.com/gorilla/websocket"
@@ -23,94 +29,645 @@ var (
 
 // Config via env/flags
 type Config struct {
-    HTTPPort      string
-    UDPPort       string
-    AllowedOrigin string
+    HTTPPort           string
+    UDPPort            string
+    UDPListeners       int  // sockets sharing UDPPort via SO_REUSEPORT
+    UDPStatus          bool // answer "OP:STATUS" datagrams
+    UDPStrict          bool // refuse datagrams without ROOM and USER, see udpframe.go
+    AllowedOrigin      string
+    DuplicatePolicy    string
+    RoomSchemas        string
+    ClientIDSecret     string
+    ClientIDTTL        time.Duration
+    CoalesceRooms      string
+    CoalesceWindow     time.Duration
+    RoomEgressBudget   int64 // bytes out per room per second; 0 = unlimited
+    GlobalEgressBudget int64 // bytes out per second over all rooms, shared by weight; 0 = unlimited
+    RoomWeights        string
+    SendPaceBytes      int64 // max bytes per second written to one client; 0 = unpaced
+    DeadLetterSink     string
+    DeadLetterBuffer   int
+    AuditSink          string // where admin actions are recorded, see audit.go; "" = nowhere
+    StrictOrderRooms   string
+    DefaultRoomShards  int
+    SingleRoomOnly     bool
+    SnapshotRooms      string  // rooms whose broadcasts iterate a copy-on-write member snapshot
+    ConnectRate        float64 // new connections per second, all clients; 0 = unlimited
+    ConnectRatePerIP   float64 // new connections per second per client IP; 0 = unlimited
+    RoomTransforms     string
+    ReplayRooms        string
+    ReplayWindow       uint64
+    DedupRooms         string
+    E2EERooms          string // rooms whose payloads are never redacted
+    RedactPatterns     string
+    RedactMask         string
+    DedupWindow        time.Duration
+    MemorySoftLimit    int64 // estimated hub bytes; 0 = no limit
+    MemoryLimitAction  string
+    MemoryInterval     time.Duration
+    AckRooms           string
+    AckTimeout         time.Duration
+    AckRetries         int
+    AckMaxPending      int
+    HandshakeTimeout   time.Duration // 0 = no handshake; clients join at once
+    CloseDrainTimeout  time.Duration // how long a graceful close flushes queued messages
+    ShutdownTimeout    time.Duration // grace period for connections to close on SIGTERM
+    PingInterval       time.Duration // 0 = no pings; reads time out after ReadTimeout
+    RoleTargets        string
+    FeatureFlags       string
+    MinStatsInterval   time.Duration // floor for client-requested stats pushes
+    LargeMessageBytes  int           // envelopes above this size use a separate queue; 0 = off
+    LargeQueueSize     int
+    StartupRampWindow  time.Duration // pace upgrades for this long after startup; 0 = off
+    StartupRampPace    time.Duration
+    StartupRampMaxWait time.Duration // longest an upgrade is held by the ramp before it is refused
+    HealthCheckTimeout time.Duration
+    MaxBroadcasts      int     // concurrent broadcast fan-outs; 0 = GOMAXPROCS
+    MaxClientsPerRoom  int     // 0 = unlimited
+    MaxConnections     int     // live WebSocket connections; 0 = unlimited
+    MaxMsgsPerSec      float64 // data frames per second per client; 0 = unlimited
+    MaxControlPerSec   float64 // control frames per second per client; 0 = unlimited
+    MaxMessageBytes    int64   // largest inbound frame; 0 = unlimited
+    DropWarnThreshold  int64   // log a client every time this many more of its messages are dropped
+    ConnectQueueDepth  int     // upgrades held for a slot over MaxConnections; 0 = refuse at once
+    ConnectQueueWait   time.Duration
+    StatsdAddr         string
+    StatsdPrefix       string
+    StatsdInterval     time.Duration
+    AdminToken         string // bearer token for admin endpoints; unset disables them
+    AuthToken          string // bearer token required to connect; unset leaves /ws open
+    ObserverToken      string // bearer token that admits read-only (observer) connections
+    RoomCreateWebhook  string
+    RoomDestroyWebhook string
+    // room webhook requests in flight at once; further events are dropped
+    RoomWebhookConcurrency int
+    MetricLabels           string // connection label keys exported as metric labels, see metriclabels.go
+    MetricLabelMaxSeries   int
+    // hard cap on a room's bytes out per RoomEgressCapWindow; 0 = none
+    RoomEgressCap          int64
+    RoomEgressCapWindow    time.Duration
+    RoomEgressAlertWebhook string
+    OverflowPolicy         string  // see OverflowDropNew
+    HandoffSocket          string  // unix socket for passing listeners to a successor
+    CaptureSampleRate      float64 // fraction of broadcasts captured for /capture; 0 = off
+    CaptureMax             int     // captured samples kept
+    PresenceRooms          string
+    ClockSkewTolerance     time.Duration // client send timestamps further off are not profiled
+    UniqueUsernames        bool          // refuse a second member with the same name in a room
+    UDPPeerTTL             time.Duration // forget UDP peers silent this long; 0 = never
+    UDPPeerMax             int           // UDP peers kept per room, least recently seen evicted; 0 = no cap
+    MaxConnsPerIP          int           // connections held open per client IP; 0 = unlimited
+    TrustProxy             bool          // take the client IP from X-Forwarded-For
+    CompressionLevel       int           // deflate level; non-zero also compresses writes from the start
+    CompressThreshold      int           // frames smaller than this are never compressed
+    SendBufferSize         int           // messages queued per client; 0 = 256
+    DigestRooms            string
+    HistorySize            int
+    HistoryRetain          time.Duration // how long an empty room's history outlives it; 0 = not at all
+    HistoryMaxAge          time.Duration // history messages older than this are dropped; 0 = kept by count only
+    HistoryReplayMaxBytes  int64         // payload bytes of history a joiner is sent at most; 0 = no limit
+    HistoryCompress        bool          // deflate history payloads in memory, see historycompress.go
+    PresenceCloseReason    bool // leave events carry the client's close code and reason
+    RoomDefaults           string
+    WriteTimeout           time.Duration
+    ReadTimeout            time.Duration // silence allowed while pings are off
+    CompressContentTypes   string
+    VIPUsers               string        // usernames whose broadcasts are never dropped, see priority.go
+    VIPRoles               string        // roles likewise
+    VIPSecret              string        // signs the ?vip= proofs VIP connections must present
+    VIPSpillMax            int           // messages spilled per VIP connection; 0 = 10000
+    BatchWindow            time.Duration // batch messages to connections that fall behind; 0 = off
+    UDPBridgeBidirectional bool          // broadcasts from WebSocket clients also reach the room's UDP peers
+    MaxRoomsPerIdentity    int           // rooms one username may be in at once; 0 = unlimited
+    HubShards              int           // room registry buckets; 0 = 32
+    EventSink              string        // where lifecycle events go: none or nats, see events.go
+    EventSinkURL           string
+    EventSinkCA            string // PEM CA bundle for a TLS event sink; empty = system roots
+    EventSubject           string
+    EventBuffer            int
+    EventPolicy            string // drop or block when the event buffer is full
+    EventBlockTimeout      time.Duration
+    EventSummaryInterval   time.Duration
 }
 
+// Duplicate connection policies for DUPLICATE_POLICY. The identity of a
+// connection is its username.
+const (
+    DuplicateAllow     = "allow"
+    DuplicateRejectNew = "reject_new"
+    DuplicateCloseOld  = "close_old"
+)
+
+// validDuplicatePolicy checks DUPLICATE_POLICY; an unknown value is an
+// error rather than a silent allow.
+func validDuplicatePolicy(p string) error {
+    switch p {
+    case DuplicateAllow, DuplicateRejectNew, DuplicateCloseOld:
+        return nil
+    }
+    return fmt.Errorf("unknown DUPLICATE_POLICY %q (want %s, %s or %s)", p, DuplicateAllow, DuplicateRejectNew, DuplicateCloseOld)
+}
+
+// closeReplaced is sent to a connection displaced by a newer one (close_old).
+const closeReplaced = 4000
+
 // Hub manages rooms and broadcasting
 type Hub struct {
-    mu    sync.RWMutex
-    rooms map[string]*Room
+    mu            sync.RWMutex
+    roomShards    []roomShard // rooms by name, see hubshards.go
+    transforms    map[string]*PayloadTransform
+    digests       map[string]time.Duration // digest interval per room, see DIGEST_ROOMS
+    compressTypes map[string]bool          // compressibility per content type; nil = built-in policy
+    roomDefaults  map[string]RoomDefaults  // delivery profile per room, see ROOM_DEFAULTS
+    roleTargets   map[string][]string      // roles each sending role reaches, see ROLE_TARGETS
+    features      map[string]FeatureRule
+    cfg           Config
+
+    // live connections per identity, used to enforce DUPLICATE_POLICY
+    idMu       sync.Mutex
+    identities map[string]map[*Client]bool
+
+    schemas roomSchemas
+
+    // nil unless CLIENT_ID_SECRET is set
+    ids *clientIDRegistry
+    // nil unless DEADLETTER_SINK is set
+    dead *deadLetterSink
+    // nil unless AUDIT_SINK is set
+    audits *auditLog
+    // nil unless a connect rate is configured
+    connects *connectLimiter
+    // nil unless MAX_CONNS_PER_IP is set
+    ipConns *ipConnLimiter
+    // rooms each identity belongs to; nil unless MAX_ROOMS_PER_IDENTITY is set
+    memberships *roomMemberships
+    // nil unless STARTUP_RAMP_WINDOW is set
+    ramp *startupRamp
+    // nil unless MAX_CONNECTIONS is set
+    slots *connSlots
+    // nil unless a room webhook is set
+    hooks *roomHooks
+    // nil unless CAPTURE_SAMPLE_RATE is set
+    capture *captureRing
+    // nil unless EVENT_SINK is set
+    events *eventStream
+    // nil unless METRIC_LABELS is set
+    labels *labelMetrics
+
+    mem     memoryGuard
+    health  healthChecks
+    udpUp   atomic.Bool                 // UDP relay read loop running
+    udp     atomic.Pointer[udpRegistry] // peers broadcasts are bridged to, see udpbridge.go; nil when off
+    started time.Time
+
+    broadcasts *broadcastLimiter
+    fair       *fairEgress // nil unless GLOBAL_EGRESS_BUDGET is set
+    redact     *redactor   // nil unless REDACT_PATTERNS is set
+    metrics    hubMetrics
 }
 
 type Room struct {
     name    string
+    hub     *Hub
     mu      sync.RWMutex
     clients map[*Client]bool
+
+    coalesce *coalescer   // nil unless the room is in COALESCE_ROOMS
+    digest   *digester    // nil unless the room is in DIGEST_ROOMS
+    history  HistoryStore // nil unless HISTORY_SIZE is set
+
+    egress       egressMeter
+    egressBudget int64
+    egressCap    *egressCap // nil unless ROOM_EGRESS_CAP is set
+    sizes        sizeStats  // payload size profile for /stats
+    latency      latencyStats
+    traffic      roomTraffic // broadcasts since the last message_summary event
+
+    transform    *PayloadTransform // nil unless configured in ROOM_TRANSFORMS
+    e2ee         bool              // payloads are end-to-end encrypted and never redacted (E2EE_ROOMS)
+    presence     bool              // members are told of joins and leaves (PRESENCE_ROOMS)
+    replayWindow uint64            // 0 = replay protection off
+    dedup        *dedupWindow      // nil unless the room is in DEDUP_ROOMS
+    acks         *ackConfig        // nil unless the room is in ACK_ROOMS
+    ackSeq       atomic.Uint64
+
+    strict *strictQueue // nil for best-effort rooms
+    pins   atomic.Int64 // connections bound to the room, joined or not; see enterRoom
+
+    cow     bool                      // broadcasts read members instead of locking clients (SNAPSHOT_ROOMS)
+    members atomic.Pointer[[]*Client] // copy-on-write snapshot of clients; set when cow
+    shards  []*Room                   // all shards of the default room, including this one
 }
 
 type Client struct {
-    username string
-    room     *Room
-    conn     *websocket.Conn
-    sendCh   chan []byte
+    id          string // server-assigned, stable across reconnects; empty if disabled
+    username    string
+    room        *Room
+    conn        *websocket.Conn
+    sendCh      chan outbound // never closed: a broadcast may still hold the client after it left
+    done        chan struct{} // closed when the reader loop exits; stops the writer
+    envVersion  int           // negotiated envelope format, see envelopeRenderers
+    maxOverhead int           // envelope byte budget; when set the format is picked per message
+    pacer       *tokenBucket  // smooths writes to the client; nil when unpaced
+    msgLimit    *tokenBucket  // data frames the client may send; nil when unlimited
+    floodStreak int           // data frames dropped in a row by msgLimit; owned by the reader
+    ctlLimit    *tokenBucket  // control frames the client may send; nil when unlimited
+    jitter      jitterEstimator
+    lastSeq     map[string]uint64 // last sequence accepted per replay-protected room
+    queued      atomic.Int64 // bytes waiting in sendCh
+    acks        *ackTracker  // nil outside ack rooms
+    echo        bool         // receive own broadcasts
+    hs          *handshake   // pending capability handshake; nil once joined
+    role        string
+    targets     []string // roles this client's messages reach; nil = everyone
+    readOnly    bool     // observer: data frames are dropped, never broadcast
+    features    []string // feature flags on for this connection, see FEATURE_FLAGS
+    counters    clientCounters
+    statsEvery  chan time.Duration // stats push interval changes, read by the writer
+    bulkCh      chan outbound      // large messages, sent after sendCh drains; nil when off
+    bulkOver    int                // size above which a message goes to bulkCh
+    routes      bool               // honour "room:<name>|" prefixes (?route=1)
+    ttls        bool               // honour "ttl:<duration>|" headers (?ttl=1)
+    ctypes      bool               // honour "ctype:<content-type>|" headers (?ctype=1)
+    recents     bool               // honour "recent:<N>|" headers (?recent=1)
+    sentTs      bool               // honour "sent:<unix-ms>|" headers (?sent=1)
+    overflow    string             // ?overflow= policy for a full queue; "" = OVERFLOW_POLICY
+    binControl  bool               // binary frames starting with 0xFF are control, see bincontrol.go
+    lastActive  atomic.Int64       // unix nanos of the last frame read, or of the connect
+    seq         atomic.Uint64      // messages relayed from this connection, see Envelope.SenderSeq
+    deflate     bool               // permessage-deflate negotiated
+    compressCh  chan bool          // write compression changes, read by the writer
+    wire        *wireCounter       // socket byte count; nil without deflate
+    closeOnce   sync.Once
+    closing     atomic.Bool       // drainAndClose was called; frames read since are dropped
+    readLimit   int64             // largest inbound message, see MAX_MESSAGE_BYTES; 0 = unlimited
+    member      atomic.Bool       // counted in hub.memberships
+    closeReq    chan closeRequest // graceful close, read by the writer
+    compressing bool              // write compression on; owned by the writer
+    dead        atomic.Bool       // a write timed out; the connection is being torn down
+    compressMin int               // smallest frame compressed while compressing, see COMPRESS_THRESHOLD
+    compressDef bool              // compression on unless a hello says otherwise
+    ctx         context.Context   // cancelled to end the connection, see connctx.go
+    cancel      context.CancelCauseFunc
+    closedBy    *peerClose    // close frame the client sent; owned by the reader
+    writeWait   time.Duration // deadline of each write, see WRITE_TIMEOUT
+    priority    int           // priorityHigh never drops broadcasts, see VIP_USERS
+    spillMu     sync.Mutex
+    spilled     []outbound    // high priority: broadcasts that did not fit sendCh
+    spillReady  chan struct{} // spilled messages are waiting; nil below high priority
+    batch       *batcher      // pending batch frame, see batching.go; nil when not batching
+    labelStats  *labelSeries  // counters for the connection's metric labels; nil without METRIC_LABELS
 }
 
 func NewHub() *Hub {
-    return &Hub{rooms: make(map[string]*Room)}
+    return NewHubWithConfig(Config{})
+}
+
+func NewHubWithConfig(cfg Config) *Hub {
+    h := &Hub{
+        started:    time.Now(),
+        roomShards: newRoomShards(cfg.hubShards()),
+        cfg:        cfg,
+        identities: make(map[string]map[*Client]bool),
+    }
+    if cfg.ClientIDSecret != "" {
+        h.ids = newClientIDRegistry(cfg.ClientIDSecret, cfg.ClientIDTTL)
+    }
+    if cfg.ConnectRate > 0 || cfg.ConnectRatePerIP > 0 {
+        h.connects = newConnectLimiter(cfg.ConnectRate, cfg.ConnectRatePerIP)
+    }
+    h.ipConns = newIPConnLimiter(cfg.MaxConnsPerIP)
+    h.memberships = newRoomMemberships(cfg.MaxRoomsPerIdentity)
+    h.ramp = newStartupRamp(cfg.StartupRampWindow, cfg.StartupRampPace, cfg.StartupRampMaxWait)
+    h.slots = newConnSlots(cfg.MaxConnections, cfg.ConnectQueueDepth, cfg.ConnectQueueWait)
+    h.capture = newCaptureRing(cfg.CaptureSampleRate, cfg.CaptureMax)
+    h.hooks = newRoomHooks(cfg.RoomCreateWebhook, cfg.RoomDestroyWebhook, cfg.RoomEgressAlertWebhook, cfg.RoomWebhookConcurrency)
+    h.broadcasts = newBroadcastLimiter(cfg.MaxBroadcasts)
+    h.fair = newFairEgress(cfg.GlobalEgressBudget)
+    return h
+}
+
+var errDuplicateConn = errors.New("duplicate connection")
+
+// trackIdentity registers c under its username according to the duplicate
+// policy. It returns the connections displaced by c (close_old) or
+// errDuplicateConn when c must be refused (reject_new).
+func (h *Hub) trackIdentity(c *Client) ([]*Client, error) {
+    h.idMu.Lock()
+    defer h.idMu.Unlock()
+    conns := h.identities[c.username]
+    var displaced []*Client
+    if len(conns) > 0 {
+        switch h.cfg.DuplicatePolicy {
+        case DuplicateRejectNew:
+            return nil, errDuplicateConn
+        case DuplicateCloseOld:
+            for old := range conns {
+                displaced = append(displaced, old)
+            }
+            conns = nil
+        }
+    }
+    if conns == nil {
+        conns = make(map[*Client]bool)
+        h.identities[c.username] = conns
+    }
+    conns[c] = true
+    return displaced, nil
+}
+
+func (h *Hub) untrackIdentity(c *Client) {
+    h.idMu.Lock()
+    defer h.idMu.Unlock()
+    if conns, ok := h.identities[c.username]; ok {
+        delete(conns, c)
+        if len(conns) == 0 {
+            delete(h.identities, c.username)
+        }
+    }
 }
 
 func (h *Hub) getRoom(name string) *Room {
-    h.mu.Lock()
-    defer h.mu.Unlock()
-    r, ok := h.rooms[name]
-    if !ok {
-        r = &Room{name: name, clients: make(map[*Client]bool)}
-        h.rooms[name] = r
+    return h.getRoomAs(name, "")
+}
+
+// getRoomAs is getRoom on behalf of creator, who is reported to the room
+// creation webhook if the room is new.
+func (h *Hub) getRoomAs(name, creator string) *Room {
+    if r := h.lookupRoom(name); r != nil {
+        return r
+    }
+    // new rooms pick up the loaded specs, which h.mu guards
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    if h.isDefaultShard(name) {
+        h.lockAllShards()
+        defer h.unlockAllShards()
+        if r := h.shardOf(name).rooms[name]; r == nil {
+            h.createShardsLocked(creator)
+        }
+        return h.shardOf(name).rooms[name]
+    }
+    s := h.shardOf(name)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if r := s.rooms[name]; r != nil {
+        return r
+    }
+    return h.newRoomLocked(name, creator)
+}
+
+// newRoomLocked creates and registers a room; caller holds h.mu for
+// reading and the room's bucket lock, see hubshards.go.
+func (h *Hub) newRoomLocked(name, creator string) *Room {
+    r := &Room{name: name, hub: h, clients: make(map[*Client]bool), egressBudget: h.cfg.RoomEgressBudget, transform: h.transforms[name]}
+    r.egressCap = newEgressCap(h.cfg.RoomEgressCap, h.cfg.RoomEgressCapWindow)
+    if r.history = h.shardOf(name).takeHistoryLocked(name); r.history == nil {
+        r.history = newHistoryStore(h.cfg)
+    }
+    if inList(h.cfg.StrictOrderRooms, name) {
+        r.strict = &strictQueue{}
+    }
+    if inList(h.cfg.CoalesceRooms, name) {
+        r.coalesce = newCoalescer(r, h.cfg.CoalesceWindow)
+    }
+    // room-wide frames span the default room's shards, see groupMembers
+    logical := name
+    if h.isDefaultShard(name) {
+        logical = defaultRoom
+    }
+    if d, ok := h.digests[logical]; ok {
+        r.digest = newDigester(r, d)
+    }
+    if inList(h.cfg.AckRooms, name) {
+        r.acks = newAckConfig(h.cfg)
     }
+    if inList(h.cfg.ReplayRooms, name) {
+        r.replayWindow = max(h.cfg.ReplayWindow, 1)
+    }
+    if inList(h.cfg.DedupRooms, name) {
+        r.dedup = newDedupWindow(h.cfg.DedupWindow)
+    }
+    r.e2ee = inList(h.cfg.E2EERooms, name)
+    r.presence = inList(h.cfg.PresenceRooms, logical)
+    if inList(h.cfg.SnapshotRooms, name) {
+        r.cow = true
+        r.members.Store(&[]*Client{})
+    }
+    h.shardOf(name).rooms[name] = r
+    h.hooks.created(name, creator)
     return r
 }
 
-func (r *Room) join(c *Client) {
+// join adds c to the room, or returns errRoomFull when the room already
+// holds MAX_CLIENTS_PER_ROOM clients and errUsernameTaken when
+// UNIQUE_USERNAMES is on and another member has c's name.
+func (r *Room) join(c *Client) error {
     r.mu.Lock()
+    if limit := r.hub.cfg.MaxClientsPerRoom; limit > 0 && len(r.clients) >= limit && !r.clients[c] {
+        r.mu.Unlock()
+        return errRoomFull
+    }
+    if r.hub.cfg.UniqueUsernames && r.usernameTakenLocked(c) {
+        r.mu.Unlock()
+        return errUsernameTaken
+    }
+    joined := !r.clients[c]
     r.clients[c] = true
+    r.snapshotLocked()
+    if joined && r.history != nil {
+        r.replayHistoryLocked(c)
+    }
+    members := len(r.clients)
     r.mu.Unlock()
+    if joined && r.presence {
+        r.announce(c, presenceJoin)
+    }
+    if joined {
+        r.hub.events.emit(memberEvent(eventJoin, r, c, members))
+    }
+    return nil
 }
 
 func (r *Room) leave(c *Client) {
     r.mu.Lock()
+    left := r.clients[c]
     delete(r.clients, c)
+    r.snapshotLocked()
+    members := len(r.clients)
     r.mu.Unlock()
+    if left && r.presence {
+        r.announce(c, presenceLeave)
+    }
+    if left {
+        r.hub.events.emit(memberEvent(eventLeave, r, c, members))
+    }
 }
 
-func (r *Room) broadcast(sender *Client, msg []byte) {
-    r.mu.RLock()
-    for c := range r.clients {
-        if c != sender { // echo suppression; comment to echo self
-            select {
-            case c.sendCh <- msg:
-            default:
-                // drop if slow
-            }
-        }
+// admit applies the room's egress cap, the global egress budget and the
+// room's egress budget to a broadcast of fanout bytes, recording a refused
+// one as a dead letter. A stage that refuses hands back what the stages
+// before it charged, so nothing is spent on a broadcast that is not sent.
+func (r *Room) admit(env Envelope, fanout int64) bool {
+    capWindow, ok := r.egressCap.admit(r, fanout)
+    if !ok {
+        r.hub.dead.add(dropEgressCap, r.name, env.Username, "", env.Payload)
+        return false
+    }
+    fairWindow, ok := r.hub.fair.admit(r, fanout)
+    if !ok {
+        r.egressCap.refund(capWindow, fanout)
+        r.hub.dead.add(dropGlobalEgress, r.name, env.Username, "", env.Payload)
+        return false
     }
-    r.mu.RUnlock()
+    if !r.egress.admit(int64(len(env.Payload)), fanout, r.egressBudget) {
+        r.hub.fair.refund(r, fairWindow, fanout)
+        r.egressCap.refund(capWindow, fanout)
+        r.hub.dead.add(dropEgressBudget, r.name, env.Username, "", env.Payload)
+        return false
+    }
+    return true
 }
 
-var upgrader = websocket.Upgrader{
-    ReadBufferSize:  8192,
-    WriteBufferSize: 8192,
-    CheckOrigin: func(r *http.Request) bool {
-        // CORS is handled via headers; allow upgrade but enforce via ALLOWED_ORIGIN if needed
-        return true
-    },
+// delivered runs what an admitted broadcast means for the room as a whole
+// rather than for one shard of it, under the room's logical name and once
+// per message however many shards deliver it.
+func (r *Room) delivered(sender *Client, env Envelope) {
+    p := r.primary()
+    p.sizes.observe(len(env.Payload))
+    if r.hub.events != nil {
+        p.traffic.add(len(env.Payload))
+    }
+    r.hub.capture.sample(r, env)
+    r.bridgeToUDP(sender, env)
+    r.hub.metrics.broadcasts.Add(1)
+    r.hub.metrics.broadcastBytes.Add(int64(len(env.Payload)))
+}
+
+func (r *Room) broadcast(sender *Client, env Envelope) {
+    if r.hub.shedBroadcasts() {
+        r.hub.dead.add(dropMemoryLimit, r.name, env.Username, "", env.Payload)
+        return
+    }
+    r.hub.broadcasts.acquire()
+    defer r.hub.broadcasts.release()
+    if r.hub.redact != nil && env.text && !r.e2ee {
+        env.Payload = r.hub.redact.apply(env.Payload)
+    }
+    if r.transform != nil {
+        env.Payload = r.transform.apply(env.Payload)
+    }
+    out := envelopeCache{env: env}
+    var recipients []*Client
+    add := func(c *Client) {
+        if (c != sender || c.echo) && c.receives(sender) && !c.dead.Load() { // echo suppression unless the client asked for it
+            recipients = append(recipients, c)
+        }
+    }
+    if r.cow {
+        if r.history != nil {
+            // joins are excluded while the message is recorded, see history.go
+            r.mu.RLock()
+        }
+        members := *r.members.Load()
+        recipients = make([]*Client, 0, len(members))
+        for _, c := range members {
+            add(c)
+        }
+    } else {
+        r.mu.RLock()
+        defer r.mu.RUnlock()
+        recipients = make([]*Client, 0, len(r.clients))
+        for c := range r.clients {
+            add(c)
+        }
+    }
+    if env.recent > 0 {
+        recipients = mostRecent(recipients, env.recent)
+    }
+    for _, c := range recipients {
+        c.envelopeFor(&out) // render up front: fan-out may run in parallel
+    }
+    fanout := int64(len(out.render(defaultEnvelopeVersion))) * int64(len(recipients))
+    admitted := r.admit(env, fanout)
+    if admitted && r.acks != nil {
+        // numbered once admitted, so a refused broadcast leaves no gap
+        env.Seq = r.ackSeq.Add(1)
+        out = envelopeCache{env: env}
+        for _, c := range recipients {
+            c.envelopeFor(&out)
+        }
+    }
+    // only what is delivered is replayed to later joiners
+    if admitted && r.history != nil && env.recent == 0 {
+        r.history.add(sender, env)
+    }
+    if r.cow && r.history != nil {
+        r.mu.RUnlock()
+    }
+    if !admitted {
+        return
+    }
+    if env.shared != nil {
+        env.shared.Do(func() { r.delivered(sender, env) })
+    } else {
+        r.delivered(sender, env)
+    }
+    noCompress := !r.hub.compressible(env.ContentType)
+    r.fanout(recipients, func(c *Client) {
+        c.counters.offered.Add(1)
+        msg := c.envelopeFor(&out)
+        if c.acks != nil && !c.acks.track(env, msg) {
+            c.countDrop()
+            r.hub.dead.add(dropAckOverflow, r.name, env.Username, c.username, env.Payload)
+            return
+        }
+        o := outbound{msg: msg, expires: env.expires, env: &env, noCompress: noCompress}
+        if c.acks != nil {
+            // in ack rooms a dropped send is retried by the tracker
+            c.enqueue(o)
+            return
+        }
+        c.offer(o)
+    })
 }
 
 // HandleWebSocket handles /ws/{room}/{username}
 func HandleWebSocket(hub *Hub, allowedOrigin string) http.HandlerFunc {
+    upgrader := newUpgrader(allowedOrigin)
     return func(w http.ResponseWriter, r *http.Request) {
         applyCORSHeaders(w, allowedOrigin)
         if r.Method == http.MethodOptions {
             w.WriteHeader(http.StatusNoContent)
             return
         }
+        // throttled first, so a storm of bad upgrades is as cheap as any
+        ip := hub.remoteIP(r)
+        if !hub.connects.allow(ip) {
+            rejectOverload(w, http.StatusTooManyRequests, "too many connection attempts", hub.connects.retryAfter(ip))
+            return
+        }
+        if !upgrader.CheckOrigin(r) {
+            http.Error(w, "origin not allowed", http.StatusForbidden)
+            return
+        }
+        observer := hub.cfg.ObserverToken != "" && authorizedClient(r, hub.cfg.ObserverToken)
+        if !observer && !authorizedClient(r, hub.cfg.AuthToken) {
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        if hub.refuseConnections() {
+            // the estimate is refreshed every MEMORY_ESTIMATE_INTERVAL
+            rejectOverload(w, http.StatusServiceUnavailable, "server over memory limit", hub.cfg.MemoryInterval)
+            return
+        }
 
         // Parse path: /ws/{room}/{username}
         // If missing, defaults: room="global", username="anon-<ts>"
         path := strings.TrimPrefix(r.URL.Path, "/ws")
         parts := splitTrim(path, '/')
-        roomName := "global"
+        roomName := defaultRoom
         username := fmt.Sprintf("anon-%d", time.Now().UnixNano())
         if len(parts) >= 1 && parts[0] != "" {
             roomName = parts[0]
@@ -118,131 +675,741 @@ func HandleWebSocket(hub *Hub, allowedOrigin string) http.HandlerFunc {
         if len(parts) >= 2 && parts[1] != "" {
             username = parts[1]
         }
+        envVersion, err := parseEnvelopeVersion(r.URL.Query().Get("ver"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        defaults := hub.defaultsFor(roomName)
+        maxOverhead, err := parseMaxOverhead(r.URL.Query().Get("max_overhead"))
+        if err == nil && maxOverhead > 0 && r.URL.Query().Has("ver") {
+            err = fmt.Errorf("ver and max_overhead are mutually exclusive")
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if defaults.Envelope != 0 && !r.URL.Query().Has("ver") && maxOverhead == 0 {
+            envVersion = defaults.Envelope
+        }
+        if inList(hub.cfg.AckRooms, roomName) && (envVersion == 3 || maxOverhead > 0) {
+            http.Error(w, "ack rooms need a JSON envelope (ver=1 or ver=2)", http.StatusBadRequest)
+            return
+        }
+        statsInterval, err := parseStatsInterval(r.URL.Query().Get("stats"), hub.cfg.MinStatsInterval)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        pace, err := sendPace(hub.cfg.SendPaceBytes, r.URL.Query().Get("pace"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        overflow, err := parseOverflowPolicy(r.URL.Query().Get("overflow"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        batchWindow, err := parseBatchWindow(r.URL.Query().Get("batch"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
 
+        if !hub.ipConns.acquire(ip) {
+            rejectOverload(w, http.StatusTooManyRequests, "too many connections from this address", hub.ipConns.retryAfter())
+            return
+        }
+        // held until the handler returns with the connection
+        defer hub.ipConns.release(ip)
+        if !hub.slots.acquire(r.Context()) {
+            rejectOverload(w, http.StatusServiceUnavailable, "too many connections", hub.slots.retryAfter())
+            return
+        }
+        // the handler returns when the connection ends, freeing the slot
+        defer hub.slots.release()
+        if retry, ok := hub.ramp.wait(r.Context()); !ok {
+            if retry > 0 {
+                rejectOverload(w, http.StatusServiceUnavailable, "server is ramping up", retry)
+            }
+            return
+        }
+        deflate := offersDeflate(r)
+        var hijacker *countingHijacker
+        if deflate {
+            hijacker = &countingHijacker{ResponseWriter: w}
+            w = hijacker
+        }
         conn, err := upgrader.Upgrade(w, r, nil)
         if err != nil {
             log.Printf("websocket upgrade error: %v", err)
             return
         }
 
-        room := hub.getRoom(roomName)
+        room := hub.enterRoom(roomName, username)
+        role := r.URL.Query().Get("role")
+        if observer {
+            role = observerRole
+        }
         client := &Client{
-            username: username,
-            room:     room,
-            conn:     conn,
-            sendCh:   make(chan []byte, 256),
+            username:    username,
+            room:        room,
+            conn:        conn,
+            sendCh:      make(chan outbound, hub.cfg.sendBufferSize()),
+            writeWait:   hub.cfg.writeTimeout(),
+            done:        make(chan struct{}),
+            envVersion:  envVersion,
+            maxOverhead: maxOverhead,
+            role:        role,
+            targets:     hub.targetsFor(role),
+            readOnly:    role == observerRole,
+            features:    hub.featuresFor(username),
+            labelStats:  hub.labels.seriesFor(parseConnLabels(r.URL.Query().Get("labels"))),
+            statsEvery:  make(chan time.Duration, 1),
+            routes:      r.URL.Query().Get("route") == "1",
+            ttls:        r.URL.Query().Get("ttl") == "1",
+            ctypes:      r.URL.Query().Get("ctype") == "1",
+            echo:        r.URL.Query().Get("echo") == "1",
+            recents:     r.URL.Query().Get("recent") == "1",
+            sentTs:      r.URL.Query().Get("sent") == "1",
+            overflow:    overflow,
+            binControl:  conn.Subprotocol() == binaryControlProtocol, // or negotiated in the handshake
+            deflate:     deflate,
+            compressCh:  make(chan bool, 1),
+            closeReq:    make(chan closeRequest, 1),
+            priority:    hub.cfg.priorityFor(username, role, r.URL.Query().Get("vip")),
+            batch:       newBatcher(batchWindow, hub.cfg.BatchWindow),
+        }
+        if batchWindow == 0 && client.feature("batching") {
+            client.batch = newBatcher(featureBatchWindow, 0)
+        }
+        if client.priority == priorityHigh {
+            client.spillReady = make(chan struct{}, 1)
+        }
+        if hijacker != nil {
+            client.wire = hijacker.conn
+        }
+        client.initCompression(hub.cfg, defaults.Compression)
+        if statsInterval > 0 {
+            client.pushStats(statsInterval)
+        }
+        if hub.cfg.LargeMessageBytes > 0 {
+            client.bulkCh = make(chan outbound, max(hub.cfg.LargeQueueSize, 1))
+            client.bulkOver = hub.cfg.LargeMessageBytes
+        }
+        if room.acks != nil {
+            client.acks = newAckTracker(client, *room.acks)
+        }
+        if pace > 0 {
+            // one second of burst so short exchanges are not delayed
+            client.pacer = newTokenBucket(float64(pace), float64(pace))
+        }
+        client.msgLimit = newMessageLimiter(hub.cfg.MaxMsgsPerSec)
+        client.ctlLimit = newControlLimiter(hub.cfg.MaxControlPerSec)
+        displaced, err := hub.trackIdentity(client)
+        if err != nil {
+            log.Printf("rejecting duplicate connection: room=%s user=%s", roomName, username)
+            writeClose(conn, websocket.ClosePolicyViolation, err.Error())
+            conn.Close()
+            hub.leaveRoom(room, client)
+            return
+        }
+        stopWatch := client.startContext(r.Context(), hub.cfg.CloseDrainTimeout)
+        for _, old := range displaced {
+            // closing the socket unblocks the old reader loop, which runs its own cleanup
+            log.Printf("replacing connection: room=%s user=%s", old.room.name, old.username)
+            old.stop(closeReplaced, "replaced")
+        }
+        if hub.ids != nil {
+            var token string
+            client.id, token = hub.ids.acquire(r.URL.Query().Get("resume"))
+            client.trySend(sessionFrame(client.id, token))
+        }
+        hub.metrics.connections.Add(1)
+        hub.metrics.connectsTotal.Add(1)
+        if client.labelStats != nil {
+            client.labelStats.connections.Add(1)
+            client.labelStats.connects.Add(1)
+        }
+        client.lastActive.Store(time.Now().UnixNano())
+        join := func() {
+            if !hub.memberships.acquire(username, roomName) {
+                log.Printf("rejecting connection: room limit: room=%s user=%s", roomName, username)
+                client.sendError(errCodeRoomLimit, fmt.Sprintf("%s is already in %d rooms", username, hub.cfg.MaxRoomsPerIdentity))
+                reason := overloadCloseReason(OverloadReason{Code: errCodeRoomLimit, MaxRooms: hub.cfg.MaxRoomsPerIdentity}, limitRetryAfter)
+                client.drainAndClose(websocket.ClosePolicyViolation, reason, time.Now().Add(client.writeWait))
+                return
+            }
+            client.member.Store(true)
+            if err := room.join(client); err != nil {
+                // closing the socket ends the reader loop, which runs the cleanup
+                log.Printf("rejecting connection: %v: room=%s user=%s", err, roomName, username)
+                if err == errUsernameTaken {
+                    writeClose(conn, websocket.ClosePolicyViolation, "username taken")
+                    conn.Close()
+                    return
+                }
+                client.sendError(errCodeRoomFull, fmt.Sprintf("room %s holds at most %d clients", roomName, hub.cfg.MaxClientsPerRoom))
+                client.drainAndClose(websocket.CloseTryAgainLater, roomFullReason(hub.cfg.MaxClientsPerRoom), time.Now().Add(client.writeWait))
+                return
+            }
+            log.Printf("client joined: room=%s user=%s", roomName, username)
+        }
+        if hub.cfg.HandshakeTimeout > 0 {
+            client.hs = startHandshake(hub.cfg.HandshakeTimeout, join)
+        } else {
+            join()
         }
-        room.join(client)
-        log.Printf("client joined: room=%s user=%s", roomName, username)
 
         // Start writer
         go func() {
+            var stats *time.Ticker
+            var statsTick, pingTick <-chan time.Time
+            if hub.cfg.PingInterval > 0 {
+                pinger := time.NewTicker(hub.cfg.PingInterval)
+                defer pinger.Stop()
+                pingTick = pinger.C
+            }
             defer func() {
+                if stats != nil {
+                    stats.Stop()
+                }
                 client.conn.Close()
             }()
-            for msg := range client.sendCh {
-                client.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-                if err := client.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+            for {
+                // the bulk queue is only served while the main queue is empty
+                select {
+                case msg := <-client.sendCh:
+                    if client.writeQueued(msg) != nil {
+                        return
+                    }
+                    continue
+                default:
+                }
+                select {
+                case <-client.done:
+                    return
+                case msg := <-client.sendCh:
+                    if client.writeQueued(msg) != nil {
+                        return
+                    }
+                case <-client.batchDue():
+                    if client.flushBatch() != nil {
+                        return
+                    }
+                case <-client.spillReady:
+                    if client.writeSpilled() != nil {
+                        return
+                    }
+                case msg := <-client.bulkCh:
+                    if client.writeQueued(msg) != nil {
+                        return
+                    }
+                case d := <-client.statsEvery:
+                    if stats != nil {
+                        stats.Stop()
+                        stats, statsTick = nil, nil
+                    }
+                    if d > 0 {
+                        stats = time.NewTicker(d)
+                        statsTick = stats.C
+                    }
+                case req := <-client.closeReq:
+                    client.flushQueued(req.deadline)
+                    client.flushBatch()
+                    writeClose(client.conn, req.code, req.reason)
                     return
+                case on := <-client.compressCh:
+                    client.compressing = on
+                case <-pingTick:
+                    if err := client.ping(); err != nil {
+                        return
+                    }
+                case <-statsTick:
+                    if err := client.write(client.connStatsFrame()); err != nil {
+                        return
+                    }
                 }
             }
         }()
 
         // Reader loop
+        client.setReadLimit(0)
+        client.watchClose()
+        readWait := hub.cfg.readWait()
+        client.conn.SetPongHandler(func(string) error {
+            return client.conn.SetReadDeadline(time.Now().Add(readWait))
+        })
         for {
-            client.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-            msgType, msg, err := client.conn.ReadMessage()
+            client.conn.SetReadDeadline(time.Now().Add(readWait))
+            msgType, msg, err := client.readMessage()
+            if errors.Is(err, errMessageTooLarge) {
+                if !client.closing.Load() {
+                    client.rejectOversized()
+                }
+                continue
+            }
             if err != nil {
+                client.logReadEnd(err)
                 break
             }
-            _ = msgType // treat both text/binary same; broadcast raw
+            client.jitter.observe(time.Now())
+            client.lastActive.Store(time.Now().UnixNano())
+            client.countIn(len(msg))
+            if client.closing.Load() {
+                continue // the connection is being closed
+            }
+            if client.hs != nil {
+                cf, ok := parseControl(msgType, msg)
+                hello := ok && cf.Op == "hello" && client.hs.finish(func() {
+                    var req Capabilities
+                    if cf.Capabilities != nil {
+                        req = *cf.Capabilities
+                    }
+                    client.negotiate(req, helloNames(msg, "compression"))
+                })
+                client.hs.finish(nil) // any other first frame joins with defaults
+                client.hs = nil
+                if hello {
+                    continue
+                }
+            }
+            cf, ok, err := client.control(msgType, msg)
+            if err != nil {
+                client.sendError("bad_control", err.Error())
+                continue
+            }
+            if ok && !hub.controlOp(client, cf.Op) && client.reservedControl(msgType, msg) {
+                client.sendError("bad_control", fmt.Sprintf("%s is not available on this connection", cf.Op))
+                continue
+            }
+            ok = ok && hub.controlOp(client, cf.Op)
+            if ok && !client.allowControl() {
+                continue
+            }
+            if ok && hub.handleControl(client, cf) {
+                continue
+            }
+            // anything else is data: text and binary are relayed the same, raw
+            if client.readOnly {
+                hub.dead.add(dropReadOnly, roomName, client.username, "", msg)
+                continue
+            }
+            if drop, closeConn := client.throttle(); drop {
+                hub.dead.add(dropRateLimited, roomName, client.username, "", msg)
+                if client.floodStreak == 1 {
+                    client.sendError(errCodeRateLimited, fmt.Sprintf("message rate above %g/s; messages are being dropped", client.msgLimit.rate))
+                }
+                if closeConn {
+                    log.Printf("closing flooding connection: room=%s user=%s", roomName, username)
+                    client.closeFlooding()
+                    break
+                }
+                continue
+            }
+            if client.sentTs {
+                sent, payload, ok, err := parseSentPrefix(msg)
+                if err != nil {
+                    client.sendError("bad_sent", err.Error())
+                    continue
+                }
+                if ok {
+                    room.latency.observe(sent, time.Now(), hub.cfg.ClockSkewTolerance)
+                }
+                msg = payload
+            }
+            var ttl time.Duration
+            if client.ttls {
+                d, payload, _, err := parseTTLPrefix(msg)
+                if err != nil {
+                    client.sendError("bad_ttl", err.Error())
+                    continue
+                }
+                ttl, msg = d, payload
+            }
+            var ctype string
+            if client.ctypes {
+                t, payload, _, err := parseContentTypePrefix(msg)
+                if err != nil {
+                    client.sendError("bad_ctype", err.Error())
+                    continue
+                }
+                ctype, msg = t, payload
+            }
+            var recent int
+            if client.recents {
+                n, payload, _, err := parseRecentPrefix(msg)
+                if err != nil {
+                    client.sendError("bad_recent", err.Error())
+                    continue
+                }
+                recent, msg = n, payload
+            }
+            dest, destName := room, roomName
+            if client.routes {
+                name, payload, ok, err := parseRoutePrefix(msg)
+                if err != nil {
+                    client.sendError("bad_route", err.Error())
+                    continue
+                }
+                if ok && name != roomName {
+                    if hub.cfg.SingleRoomOnly {
+                        client.sendError("single_room_only", fmt.Sprintf("connection is bound to room %q", roomName))
+                        continue
+                    }
+                    // routing reaches rooms, it does not make them
+                    if dest = hub.existingRoomFor(name, client.username); dest == nil {
+                        client.sendError("unknown_room", fmt.Sprintf("no room %q to route to", name))
+                        continue
+                    }
+                    destName = name
+                }
+                msg = payload
+            }
+            if err := hub.validateMessage(destName, msg); err != nil {
+                hub.dead.add(dropSchemaViolation, destName, client.username, "", msg)
+                client.sendError("schema_violation", err.Error())
+                continue
+            }
+            if dest.replayWindow > 0 {
+                if code, err := client.acceptSequence(destName, msg, dest.replayWindow); err != nil {
+                    hub.dead.add(code, destName, client.username, "", msg)
+                    client.sendError(code, err.Error())
+                    continue
+                }
+            }
+            if dest.dedup != nil && dest.dedup.duplicate(msg, time.Now()) {
+                hub.dead.add(dropDuplicate, destName, client.username, "", msg)
+                continue
+            }
             // Optional: wrap with minimal header
-            envelope := MarshalEnvelope(roomName, client.username, msg)
-            room.broadcast(client, envelope)
+            env := NewEnvelope(destName, client.username, msg)
+            env.SenderSeq = client.seq.Add(1)
+            env.ClientID = client.id
+            if ttl > 0 {
+                env.expires = time.Unix(0, env.Ts).Add(ttl)
+            }
+            env.text = msgType == websocket.TextMessage
+            env.recent = recent
+            env.ContentType = ctype
+            if dest.coalesce != nil {
+                if key := coalesceKey(msg); key != "" {
+                    dest.coalesce.add(key, client, env)
+                    continue
+                }
+            }
+            if dest.digest != nil {
+                dest.digest.add(client, env)
+                continue
+            }
+            dest.publish(client, env)
         }
 
         // cleanup
-        room.leave(client)
-        close(client.sendCh)
+        stopWatch()
+        client.cancel(nil)
+        if client.hs != nil {
+            client.hs.abort()
+        }
+        hub.leaveRoom(room, client)
+        if client.member.Load() {
+            hub.memberships.release(username, roomName)
+        }
+        if client.acks != nil {
+            client.acks.close() // no retransmissions once the connection is gone
+        }
+        hub.untrackIdentity(client)
+        if hub.ids != nil {
+            hub.ids.release(client.id)
+        }
+        close(client.done)
+        hub.metrics.connections.Add(-1)
+        if client.labelStats != nil {
+            client.labelStats.connections.Add(-1)
+        }
         log.Printf("client left: room=%s user=%s", roomName, username)
     }
 }
 
+// writeClose sends a close frame; safe to call concurrently with the writer.
+func writeClose(conn *websocket.Conn, code int, reason string) {
+    _ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+// Error frame codes for refusals the reader loop reports; see also the
+// codes of control.go and ctlrate.go.
+const (
+    errCodeRateLimited     = "rate_limited"
+    errCodeMessageTooLarge = "message_too_large"
+    errCodeRoomFull        = "room_full"
+    errCodeRoomLimit       = "room_limit"
+)
+
+// ErrorFrame reports a refused message back to its sender only.
+type ErrorFrame struct {
+    Type    string `json:"type"`
+    Code    string `json:"code"`
+    Message string `json:"message"`
+}
+
+// sendError queues an error frame for c.
+func (c *Client) sendError(code, message string) {
+    b, _ := json.Marshal(ErrorFrame{Type: "error", Code: code, Message: message})
+    c.trySend(b)
+}
+
+// writeQueued paces and writes a message taken off one of c's queues,
+// dropping it instead if its TTL ran out while it waited.
+func (c *Client) writeQueued(o outbound) error {
+    c.queued.Add(-int64(len(o.msg)))
+    if c.pacer != nil && !o.expired(time.Now()) {
+        time.Sleep(c.pacer.reserve(float64(len(o.msg))))
+    }
+    if o.expired(time.Now()) {
+        c.countDrop()
+        c.room.hub.dead.add(dropExpired, o.env.Room, o.env.Username, c.username, o.env.Payload)
+        return nil
+    }
+    if held, err := c.hold(o); held || err != nil {
+        return err
+    }
+    if err := c.flushBatch(); err != nil {
+        return err
+    }
+    return c.writeFrame(o.msg, !o.noCompress)
+}
+
+// write sends one frame to the socket; only the writer goroutine calls it.
+func (c *Client) write(msg []byte) error {
+    return c.writeFrame(msg, true)
+}
+
+// writeFrame is write for a frame that may be exempt from compression.
+func (c *Client) writeFrame(msg []byte, compressible bool) error {
+    start := time.Now()
+    c.conn.SetWriteDeadline(start.Add(c.writeWait))
+    var wireBefore int64
+    compress := c.compressing && compressible && len(msg) >= c.compressMin
+    if c.deflate {
+        c.conn.EnableWriteCompression(compress)
+    }
+    if compress {
+        wireBefore = c.wire.written.Load()
+    }
+    if err := c.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+        return c.writeFailed(err)
+    }
+    if compress {
+        c.counters.observeCompressed(len(msg), c.wire.written.Load()-wireBefore)
+    }
+    c.countWrite(len(msg), time.Since(start))
+    return nil
+}
+
+// trySend queues b without blocking and reports whether it was queued.
+func (c *Client) trySend(b []byte) bool {
+    return c.enqueue(outbound{msg: b})
+}
+
+// enqueue is trySend for a frame that may carry an expiry. Messages over
+// LARGE_MESSAGE_BYTES go to the lower-priority bulk queue. A client whose
+// socket was found dead takes nothing more.
+func (c *Client) enqueue(o outbound) bool {
+    if c.dead.Load() {
+        return false
+    }
+    ch := c.sendCh
+    if c.bulkCh != nil && len(o.msg) > c.bulkOver {
+        ch = c.bulkCh
+    }
+    select {
+    case ch <- o:
+        c.queued.Add(int64(len(o.msg)))
+        return true
+    default:
+        return false
+    }
+}
+
+// Envelope types: relayed traffic, and join/leave notices (see presence.go).
+const (
+    envelopeMessage  = "message"
+    envelopePresence = "presence"
+)
+
 type Envelope struct {
-    Room     string `json:"room"`
-    Username string `json:"username"`
-    Ts       int64  `json:"ts"`
-    Payload  []byte `json:"payload"`
+    Type        string `json:"type,omitempty"`
+    Room        string `json:"room"`
+    Username    string `json:"username"`
+    Ts          int64  `json:"ts"`
+    Payload     []byte `json:"payload"`
+    Seq         uint64 `json:"seq,omitempty"`          // set in ack rooms; echo it back in an ack
+    SenderSeq   uint64 `json:"sender_seq,omitempty"`   // counts the sender connection's messages from 1; a gap means loss
+    ContentType string `json:"content_type,omitempty"` // from a ctype: header, see contenttype.go
+    ClientID    string `json:"client_id,omitempty"`    // the sender's server-assigned ID, see clientid.go
+
+    expires time.Time  // sender-set TTL deadline; zero when none
+    text    bool       // sent as a text frame; only text payloads are redacted
+    recent  int        // deliver to this many most recently active members; 0 = all
+    viaUDP  bool       // came in over the UDP relay, never bridged back to it
+    shared  *sync.Once // set when published to every shard, see delivered
+}
+
+func NewEnvelope(room, user string, payload []byte) Envelope {
+    return Envelope{Type: envelopeMessage, Room: room, Username: user, Ts: time.Now().UnixNano(), Payload: payload}
 }
 
+// MarshalEnvelope renders a new envelope in the default (version 1) format.
 func MarshalEnvelope(room, user string, payload []byte) []byte {
-    env := Envelope{Room: room, Username: user, Ts: time.Now().UnixNano(), Payload: payload}
-    b, _ := json.Marshal(env)
-    return b
+    return MarshalEnvelopeSeq(room, user, payload, 0)
+}
+
+// MarshalEnvelopeSeq is MarshalEnvelope for the senderSeq-th message of its
+// sender; 0 leaves the sequence number out.
+func MarshalEnvelopeSeq(room, user string, payload []byte, senderSeq uint64) []byte {
+    env := NewEnvelope(room, user, payload)
+    env.SenderSeq = senderSeq
+    return renderEnvelopeV1(env)
 }
 
 // UDP Relay: experimental, minimal broadcast of raw datagrams per-room.
 // Protocol: first line "ROOM:<name>;USER:<username>\n" followed by binary.
-func StartUDPRelay(udpPort string, hub *Hub) (*net.UDPConn, error) {
-    addr, err := net.ResolveUDPAddr("udp", ":"+udpPort)
+// An optional "TO:udp" or "TO:ws" header field limits a frame to the room's
+// UDP peers or its WebSocket clients; by default it reaches both.
+// The read loops stop, and their sockets close, when ctx is done.
+func StartUDPRelay(ctx context.Context, udpPort string, hub *Hub) (*net.UDPConn, error) {
+    conns, err := StartUDPRelays(ctx, udpPort, hub, 1)
     if err != nil {
         return nil, err
     }
-    conn, err := net.ListenUDP("udp", addr)
+    return conns[0], nil
+}
+
+// StartUDPRelays binds n UDP sockets to udpPort, sharing it with
+// SO_REUSEPORT when n > 1 so the kernel spreads peers across them, and
+// runs a read loop per socket. All loops share one peer registry.
+func StartUDPRelays(ctx context.Context, udpPort string, hub *Hub, n int) ([]*net.UDPConn, error) {
+    conns, err := listenUDP(udpPort, max(n, 1))
     if err != nil {
         return nil, err
     }
+    return serveUDPRelays(ctx, conns, hub), nil
+}
 
-    type peer struct {
-        addr *net.UDPAddr
-        last time.Time
+// serveUDPRelays runs the UDP relay on already bound sockets, closing them
+// when ctx ends.
+func serveUDPRelays(ctx context.Context, conns []*net.UDPConn, hub *Hub) []*net.UDPConn {
+    reg := newUDPRegistry(hub.cfg.UDPPeerTTL, hub.cfg.UDPPeerMax)
+    hub.udpUp.Store(true)
+    if hub.cfg.UDPBridgeBidirectional {
+        hub.udp.Store(reg)
     }
-    // simple peer registry per room
-    var (
-        mu    sync.Mutex
-        rooms = map[string]map[string]*peer{} // room -> username -> peer
-    )
-
+    for _, conn := range conns {
+        go reg.serve(ctx, conn, hub)
+    }
+    go reg.sweepLoop(ctx)
     go func() {
-        defer conn.Close()
-        buf := make([]byte, 64*1024)
-        for {
-            n, remote, err := conn.ReadFromUDP(buf)
-            if err != nil {
+        <-ctx.Done()
+        for _, conn := range conns {
+            conn.Close()
+        }
+    }()
+    return conns
+}
+
+type udpPeer struct {
+    name string
+    addr *net.UDPAddr
+    last time.Time
+    conn *net.UDPConn // socket the peer talks to; replies go out through it
+}
+
+// udpRegistry is the peer registry shared by every UDP read loop.
+type udpRegistry struct {
+    mu       sync.Mutex
+    rooms    map[string]map[string]*udpPeer // room -> username -> peer
+    lru      map[string]*udpRoomPeers       // room -> peers by recency
+    ttl      time.Duration                  // 0 = peers never expire
+    maxPeers int                            // per room; 0 = no cap
+}
+
+func newUDPRegistry(ttl time.Duration, maxPeers int) *udpRegistry {
+    return &udpRegistry{
+        rooms:    map[string]map[string]*udpPeer{},
+        lru:      map[string]*udpRoomPeers{},
+        ttl:      ttl,
+        maxPeers: maxPeers,
+    }
+}
+
+func (reg *udpRegistry) serve(ctx context.Context, conn *net.UDPConn, hub *Hub) {
+    defer conn.Close()
+    defer hub.udpUp.Store(false)
+    buf := make([]byte, 64*1024)
+    for {
+        n, remote, err := conn.ReadFromUDP(buf)
+        if err != nil {
+            if ctx.Err() == nil {
                 log.Printf("udp read error: %v", err)
-                return
-            }
-            data := buf[:n]
-            roomName, username, payload := parseUDPFrame(data)
-            if roomName == "" {
-                roomName = "global"
-            }
-            if username == "" {
-                username = fmt.Sprintf("udp-%d", time.Now().UnixNano())
             }
-            mu.Lock()
-            if _, ok := rooms[roomName]; !ok {
-                rooms[roomName] = map[string]*peer{}
-            }
-            rooms[roomName][username] = &peer{addr: remote, last: time.Now()}
-            // broadcast to all peers in room except sender
-            for uname, p := range rooms[roomName] {
-                if uname == username {
-                    continue
-                }
-                _, _ = conn.WriteToUDP(payload, p.addr)
+            return
+        }
+        data := buf[:n]
+        hdr, payload, err := readUDPFrame(data, hub.cfg.UDPStrict)
+        if err != nil {
+            hub.metrics.countUDPReject(err)
+            continue
+        }
+        if hdr.op == udpOpStatus && hub.cfg.UDPStatus {
+            _, _ = conn.WriteToUDP(reg.status(hub), remote)
+            continue
+        }
+        roomName, username := hdr.room, hdr.user
+        if roomName == "" {
+            roomName = defaultRoom
+        }
+        if username == "" {
+            username = fmt.Sprintf("udp-%d", time.Now().UnixNano())
+        }
+        reg.mu.Lock()
+        peers := reg.touchLocked(roomName, username, remote, conn, time.Now())
+        // broadcast to all peers in room except sender
+        for uname, p := range peers {
+            if uname == username || hdr.to == udpRouteWS {
+                continue
             }
-            mu.Unlock()
+            _, _ = p.conn.WriteToUDP(payload, p.addr)
+        }
+        reg.mu.Unlock()
 
-            // also broadcast into websocket room
-            env := MarshalEnvelope(roomName, username, payload)
-            hub.getRoom(roomName).broadcast(nil, env)
+        // also broadcast into the websocket room, if it has clients;
+        // UDP-only traffic must not create rooms
+        if hdr.to == udpRouteUDP {
+            continue
         }
-    }()
-    return conn, nil
+        if room := hub.existingRoomFor(roomName, username); room != nil && room.hasClients() {
+            env := NewEnvelope(roomName, username, payload)
+            env.viaUDP = true
+            room.publish(nil, env)
+        }
+    }
+}
+
+// Values of the UDP "TO" header field.
+const (
+    udpRouteUDP = "udp"
+    udpRouteWS  = "ws"
+)
+
+// udpHeader is the parsed first line of a UDP frame.
+type udpHeader struct {
+    room, user string
+    op         string // "STATUS" for a status query
+    to         string // transports to reach: udpRouteUDP, udpRouteWS or "" for both
 }
 
-func parseUDPFrame(b []byte) (room, user string, payload []byte) {
+func parseUDPFrame(b []byte) (h udpHeader, payload []byte) {
     s := string(b)
     if i := strings.Index(s, "\n"); i >= 0 {
         header := s[:i]
@@ -256,14 +1423,18 @@ func parseUDPFrame(b []byte) (room, user string, payload []byte) {
             v := strings.TrimSpace(kv[1])
             switch k {
             case "ROOM":
-                room = v
+                h.room = v
             case "USER":
-                user = v
+                h.user = v
+            case "OP":
+                h.op = strings.ToUpper(v)
+            case "TO":
+                h.to = strings.ToLower(v)
             }
         }
         return
     }
-    return "", "", b
+    return udpHeader{}, b
 }
 
 func applyCORSHeaders(w http.ResponseWriter, allowedOrigin string) {
@@ -276,32 +1447,144 @@ func applyCORSHeaders(w http.ResponseWriter, allowedOrigin string) {
     w.Header().Set("Access-Control-Max-Age", "3600")
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-    applyCORSHeaders(w, os.Getenv("ALLOWED_ORIGIN"))
-    if r.Method == http.MethodOptions {
-        w.WriteHeader(http.StatusNoContent)
-        return
-    }
-    w.Header().Set("Content-Type", "application/json")
-    _ = json.NewEncoder(w).Encode(map[string]any{
-        "status":      "ok",
-        "commit":      CommitHash,
-        "build_time":  BuildTime,
-        "server_time": time.Now().UTC().Format(time.RFC3339),
-    })
-}
-
 func parseConfig() Config {
     cfg := Config{
-        HTTPPort:      getenvDefault("PORT", "8080"),
-        UDPPort:       getenvDefault("UDP_PORT", "8081"),
-        AllowedOrigin: getenvDefault("ALLOWED_ORIGIN", "*"),
+        HTTPPort:               getenvDefault("PORT", "8080"),
+        UDPPort:                getenvDefault("UDP_PORT", "8081"),
+        UDPListeners:           int(getenvInt64("UDP_LISTENERS", 1)),
+        UDPStatus:              getenvBool("UDP_STATUS", false),
+        UDPStrict:              getenvBool("UDP_STRICT", false),
+        UDPBridgeBidirectional: getenvBool("UDP_BRIDGE_BIDIRECTIONAL", false),
+        AllowedOrigin:          getenvDefault("ALLOWED_ORIGIN", "*"),
+        DuplicatePolicy:        getenvDefault("DUPLICATE_POLICY", DuplicateAllow),
+        RoomSchemas:            os.Getenv("ROOM_SCHEMAS"),
+        ClientIDSecret:         os.Getenv("CLIENT_ID_SECRET"),
+        ClientIDTTL:            getenvDuration("CLIENT_ID_TTL", 24*time.Hour),
+        CoalesceRooms:          os.Getenv("COALESCE_ROOMS"),
+        CoalesceWindow:         getenvDuration("COALESCE_WINDOW", 50*time.Millisecond),
+        RoomEgressBudget:       getenvInt64("ROOM_EGRESS_BUDGET", 0),
+        GlobalEgressBudget:     getenvInt64("GLOBAL_EGRESS_BUDGET", 0),
+        RoomEgressCap:          getenvInt64("ROOM_EGRESS_CAP", 0),
+        RoomEgressCapWindow:    getenvDuration("ROOM_EGRESS_CAP_WINDOW", time.Minute),
+        RoomEgressAlertWebhook: os.Getenv("ROOM_EGRESS_ALERT_WEBHOOK"),
+        OverflowPolicy:         getenvDefault("OVERFLOW_POLICY", OverflowDropNew),
+        HandoffSocket:          os.Getenv("HANDOFF_SOCKET"),
+        CaptureSampleRate:      getenvFloat("CAPTURE_SAMPLE_RATE", 0),
+        CaptureMax:             int(getenvInt64("CAPTURE_MAX", 1000)),
+        PresenceRooms:          os.Getenv("PRESENCE_ROOMS"),
+        ClockSkewTolerance:     getenvDuration("CLOCK_SKEW_TOLERANCE", 30*time.Second),
+        UniqueUsernames:        getenvBool("UNIQUE_USERNAMES", false),
+        UDPPeerTTL:             getenvDuration("UDP_PEER_TTL", 30*time.Second),
+        UDPPeerMax:             int(getenvInt64("UDP_PEER_MAX", 0)),
+        MaxConnsPerIP:          int(getenvInt64("MAX_CONNS_PER_IP", 0)),
+        TrustProxy:             getenvBool("TRUST_PROXY", false),
+        DigestRooms:            os.Getenv("DIGEST_ROOMS"),
+        HistorySize:            int(getenvInt64("HISTORY_SIZE", 0)),
+        HistoryRetain:          getenvDuration("HISTORY_RETAIN", 5*time.Minute),
+        HistoryMaxAge:          getenvDuration("HISTORY_MAX_AGE", 0),
+        HistoryReplayMaxBytes:  getenvInt64("HISTORY_REPLAY_MAX_BYTES", 0),
+        HistoryCompress:        getenvBool("HISTORY_COMPRESS", false),
+        PresenceCloseReason:    getenvBool("PRESENCE_CLOSE_REASON", false),
+        RoomDefaults:           os.Getenv("ROOM_DEFAULTS"),
+        WriteTimeout:           getenvDuration("WRITE_TIMEOUT", defaultWriteTimeout),
+        ReadTimeout:            getenvDuration("READ_TIMEOUT", defaultReadTimeout),
+        CompressionLevel:       int(getenvInt64("COMPRESSION_LEVEL", 0)),
+        CompressThreshold:      int(getenvInt64("COMPRESS_THRESHOLD", 0)),
+        CompressContentTypes:   os.Getenv("COMPRESS_CONTENT_TYPES"),
+        VIPUsers:               os.Getenv("VIP_USERS"),
+        VIPRoles:               os.Getenv("VIP_ROLES"),
+        VIPSecret:              os.Getenv("VIP_SECRET"),
+        VIPSpillMax:            int(getenvInt64("VIP_SPILL_MAX", defaultSpillMax)),
+        BatchWindow:            getenvDuration("BATCH_WINDOW", 0),
+        MaxRoomsPerIdentity:    int(getenvInt64("MAX_ROOMS_PER_IDENTITY", 0)),
+        HubShards:              int(getenvInt64("HUB_SHARDS", defaultHubShards)),
+        EventSink:              os.Getenv("EVENT_SINK"),
+        EventSinkURL:           getenvDefault("EVENT_SINK_URL", "nats://127.0.0.1:4222"),
+        EventSinkCA:            os.Getenv("EVENT_SINK_CA"),
+        EventSubject:           getenvDefault("EVENT_SUBJECT", "relay.events"),
+        EventBuffer:            int(getenvInt64("EVENT_BUFFER", 1024)),
+        EventPolicy:            getenvDefault("EVENT_POLICY", EventPolicyDrop),
+        EventBlockTimeout:      getenvDuration("EVENT_BLOCK_TIMEOUT", 100*time.Millisecond),
+        EventSummaryInterval:   getenvDuration("EVENT_SUMMARY_INTERVAL", 10*time.Second),
+        SendBufferSize:         int(getenvInt64("SEND_BUFFER_SIZE", 256)),
+        RoomWeights:            os.Getenv("ROOM_WEIGHTS"),
+        SendPaceBytes:          getenvInt64("SEND_PACE_BYTES", 0),
+        DeadLetterSink:         os.Getenv("DEADLETTER_SINK"),
+        DeadLetterBuffer:       int(getenvInt64("DEADLETTER_BUFFER", 1024)),
+        AuditSink:              getenvDefault("AUDIT_SINK", "stdout"),
+        StrictOrderRooms:       os.Getenv("STRICT_ORDER_ROOMS"),
+        DefaultRoomShards:      int(getenvInt64("DEFAULT_ROOM_SHARDS", 0)),
+        SingleRoomOnly:         getenvBool("SINGLE_ROOM_ONLY", false),
+        SnapshotRooms:          os.Getenv("SNAPSHOT_ROOMS"),
+        ConnectRate:            getenvFloat("CONNECT_RATE", 0),
+        ConnectRatePerIP:       getenvFloat("CONNECT_RATE_PER_IP", 0),
+        RoomTransforms:         os.Getenv("ROOM_TRANSFORMS"),
+        ReplayRooms:            os.Getenv("REPLAY_PROTECT_ROOMS"),
+        ReplayWindow:           uint64(getenvInt64("REPLAY_WINDOW", 1000)),
+        DedupRooms:             os.Getenv("DEDUP_ROOMS"),
+        E2EERooms:              os.Getenv("E2EE_ROOMS"),
+        RedactPatterns:         os.Getenv("REDACT_PATTERNS"),
+        RedactMask:             getenvDefault("REDACT_MASK", "[redacted]"),
+        DedupWindow:            getenvDuration("DEDUP_WINDOW", time.Second),
+        MemorySoftLimit:        getenvInt64("MEMORY_SOFT_LIMIT", 0),
+        MemoryLimitAction:      getenvDefault("MEMORY_LIMIT_ACTION", MemoryActionReject),
+        MemoryInterval:         getenvDuration("MEMORY_ESTIMATE_INTERVAL", time.Second),
+        AckRooms:               os.Getenv("ACK_ROOMS"),
+        AckTimeout:             getenvDuration("ACK_TIMEOUT", 2*time.Second),
+        AckRetries:             int(getenvInt64("ACK_RETRIES", 3)),
+        AckMaxPending:          int(getenvInt64("ACK_MAX_PENDING", 256)),
+        HandshakeTimeout:       getenvDuration("HANDSHAKE_TIMEOUT", 0),
+        CloseDrainTimeout:      getenvDuration("CLOSE_DRAIN_TIMEOUT", time.Second),
+        ShutdownTimeout:        getenvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+        PingInterval:           getenvDuration("PING_INTERVAL", 30*time.Second),
+        RoleTargets:            os.Getenv("ROLE_TARGETS"),
+        FeatureFlags:           os.Getenv("FEATURE_FLAGS"),
+        MinStatsInterval:       getenvDuration("CLIENT_STATS_MIN_INTERVAL", time.Second),
+        LargeMessageBytes:      int(getenvInt64("LARGE_MESSAGE_BYTES", 0)),
+        LargeQueueSize:         int(getenvInt64("LARGE_QUEUE_SIZE", 64)),
+        StartupRampWindow:      getenvDuration("STARTUP_RAMP_WINDOW", 0),
+        StartupRampPace:        getenvDuration("STARTUP_RAMP_PACE", 10*time.Millisecond),
+        StartupRampMaxWait:     getenvDuration("STARTUP_RAMP_MAX_WAIT", 5*time.Second),
+        HealthCheckTimeout:     getenvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+        MaxBroadcasts:          int(getenvInt64("MAX_CONCURRENT_BROADCASTS", 0)),
+        MaxClientsPerRoom:      int(getenvInt64("MAX_CLIENTS_PER_ROOM", 0)),
+        MaxConnections:         int(getenvInt64("MAX_CONNECTIONS", 0)),
+        MaxMsgsPerSec:          getenvFloat("MAX_MSGS_PER_SEC", 0),
+        MaxControlPerSec:       getenvFloat("MAX_CONTROL_PER_SEC", 0),
+        MaxMessageBytes:        getenvInt64("MAX_MESSAGE_BYTES", 1<<20),
+        DropWarnThreshold:      getenvInt64("DROP_WARN_THRESHOLD", 100),
+        ConnectQueueDepth:      int(getenvInt64("CONNECT_QUEUE_DEPTH", 0)),
+        ConnectQueueWait:       getenvDuration("CONNECT_QUEUE_WAIT", 5*time.Second),
+        StatsdAddr:             os.Getenv("STATSD_ADDR"),
+        StatsdPrefix:           getenvDefault("STATSD_PREFIX", "relay"),
+        StatsdInterval:         getenvDuration("STATSD_INTERVAL", 10*time.Second),
+        AdminToken:             os.Getenv("ADMIN_TOKEN"),
+        AuthToken:              os.Getenv("AUTH_TOKEN"),
+        ObserverToken:          os.Getenv("OBSERVER_TOKEN"),
+        RoomCreateWebhook:      os.Getenv("ROOM_CREATE_WEBHOOK"),
+        RoomDestroyWebhook:     os.Getenv("ROOM_DESTROY_WEBHOOK"),
+        RoomWebhookConcurrency: int(getenvInt64("ROOM_WEBHOOK_CONCURRENCY", 4)),
+        MetricLabels:           os.Getenv("METRIC_LABELS"),
+        MetricLabelMaxSeries:   int(getenvInt64("METRIC_LABEL_MAX_SERIES", defaultMaxLabelSeries)),
     }
     // allow flags for local runs
     flag.StringVar(&cfg.HTTPPort, "port", cfg.HTTPPort, "HTTP port")
     flag.StringVar(&cfg.UDPPort, "udp", cfg.UDPPort, "UDP port")
     flag.StringVar(&cfg.AllowedOrigin, "origin", cfg.AllowedOrigin, "Allowed CORS origin")
+    flag.DurationVar(&cfg.PingInterval, "ping", cfg.PingInterval, "WebSocket ping interval (0 disables pings)")
     flag.Parse()
+    if err := validDuplicatePolicy(cfg.DuplicatePolicy); err != nil {
+        log.Fatalf("config: %v", err)
+    }
+    if err := validOverflowPolicy(cfg.OverflowPolicy); err != nil {
+        log.Fatalf("config: OVERFLOW_POLICY: %v", err)
+    }
+    if err := validVIPConfig(cfg); err != nil {
+        log.Fatalf("config: %v", err)
+    }
+    if err := validObserverConfig(cfg); err != nil {
+        log.Fatalf("config: %v", err)
+    }
     return cfg
 }
 
@@ -312,6 +1595,46 @@ func getenvDefault(k, d string) string {
     return d
 }
 
+func getenvBool(k string, d bool) bool {
+    if v := os.Getenv(k); v != "" {
+        if parsed, err := strconv.ParseBool(v); err == nil {
+            return parsed
+        }
+        log.Printf("invalid %s=%q, using %t", k, v, d)
+    }
+    return d
+}
+
+func getenvFloat(k string, d float64) float64 {
+    if v := os.Getenv(k); v != "" {
+        if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+            return parsed
+        }
+        log.Printf("invalid %s=%q, using %g", k, v, d)
+    }
+    return d
+}
+
+func getenvInt64(k string, d int64) int64 {
+    if v := os.Getenv(k); v != "" {
+        if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+            return parsed
+        }
+        log.Printf("invalid %s=%q, using %d", k, v, d)
+    }
+    return d
+}
+
+func getenvDuration(k string, d time.Duration) time.Duration {
+    if v := os.Getenv(k); v != "" {
+        if parsed, err := time.ParseDuration(v); err == nil {
+            return parsed
+        }
+        log.Printf("invalid %s=%q, using %s", k, v, d)
+    }
+    return d
+}
+
 func splitTrim(s string, sep rune) []string {
     s = strings.TrimSpace(s)
     if s == "" {
@@ -342,24 +1665,161 @@ func splitTrim(s string, sep rune) []string {
 
 func main() {
     cfg := parseConfig()
-    hub := NewHub()
+    if err := cfg.validateTimeouts(); err != nil {
+        log.Fatalf("config: %v", err)
+    }
+    hub := NewHubWithConfig(cfg)
+    if err := hub.LoadRoomSchemas(cfg.RoomSchemas); err != nil {
+        log.Fatalf("room schemas: %v", err)
+    }
+    if err := hub.LoadRoomTransforms(cfg.RoomTransforms); err != nil {
+        log.Fatalf("room transforms: %v", err)
+    }
+    if err := hub.LoadRoomDefaults(cfg.RoomDefaults); err != nil {
+        log.Fatalf("room defaults: %v", err)
+    }
+    if err := hub.LoadRoomWeights(cfg.RoomWeights); err != nil {
+        log.Fatalf("room weights: %v", err)
+    }
+    if err := hub.LoadDigestRooms(cfg.DigestRooms); err != nil {
+        log.Fatalf("digest rooms: %v", err)
+    }
+    if err := hub.LoadCompressTypes(cfg.CompressContentTypes); err != nil {
+        log.Fatalf("compress content types: %v", err)
+    }
+    if err := hub.LoadRoleTargets(cfg.RoleTargets); err != nil {
+        log.Fatalf("role targets: %v", err)
+    }
+    if err := hub.LoadRedactPatterns(cfg.RedactPatterns, cfg.RedactMask); err != nil {
+        log.Fatalf("redaction: %v", err)
+    }
+    if err := hub.LoadFeatureFlags(cfg.FeatureFlags); err != nil {
+        log.Fatalf("feature flags: %v", err)
+    }
+    if err := validCompressionLevel(cfg.CompressionLevel); err != nil {
+        log.Fatalf("compression: %v", err)
+    }
+    if err := hub.LoadMetricLabels(cfg.MetricLabels, cfg.MetricLabelMaxSeries); err != nil {
+        log.Fatalf("metric labels: %v", err)
+    }
+    if cfg.DeadLetterSink != "" {
+        consume, err := deadLetterConsumer(cfg.DeadLetterSink)
+        if err != nil {
+            log.Fatalf("dead letters: %v", err)
+        }
+        hub.dead = newDeadLetterSink(cfg.DeadLetterBuffer, consume)
+    }
+    audits, err := newAuditLog(cfg.AuditSink)
+    if err != nil {
+        log.Fatalf("audit: %v", err)
+    }
+    hub.audits = audits
+
+    var inherited *handedOff
+    if cfg.HandoffSocket != "" {
+        var err error
+        if inherited, err = takeOverListeners(cfg.HandoffSocket); err != nil {
+            log.Fatalf("handoff: %v", err)
+        }
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    go hub.runMemoryEstimator(cfg.MemoryInterval)
+    if cfg.HistorySize > 0 && cfg.HistoryMaxAge > 0 {
+        go hub.runHistorySweep(max(cfg.HistoryMaxAge/2, time.Second))
+    }
+    if hub.ids != nil {
+        go hub.ids.sweepLoop(ctx)
+    }
+    if cfg.StatsdAddr != "" {
+        statsd, err := newStatsdEmitter(hub, cfg.StatsdAddr, cfg.StatsdPrefix)
+        if err != nil {
+            log.Fatalf("statsd: %v", err)
+        }
+        go statsd.run(cfg.StatsdInterval)
+    }
+    sink, err := newEventSink(cfg.EventSink, cfg.EventSinkURL, cfg.EventSubject, cfg.EventSinkCA)
+    if err != nil {
+        log.Fatalf("event sink: %v", err)
+    }
+    if hub.events, err = newEventStream(sink, cfg.EventBuffer, cfg.EventPolicy, cfg.EventBlockTimeout); err != nil {
+        log.Fatalf("event sink: %v", err)
+    }
+    if hub.events != nil {
+        go hub.runEventSummaries(cfg.EventSummaryInterval)
+    }
 
     // HTTP routes
-    http.HandleFunc("/health", healthHandler)
+    http.HandleFunc("/health", healthHandler(hub))
+    http.HandleFunc("/stats", statsHandler(hub))
+    http.HandleFunc("/rooms", roomsHandler(hub))
+    http.HandleFunc("/config", configHandler(hub))
+    http.HandleFunc("/schemas", schemasHandler(hub))
+    http.HandleFunc("/metrics", metricsHandler(hub))
+    http.HandleFunc("/capture", captureHandler(hub))
+    http.HandleFunc("/capabilities", capabilitiesHandler(hub))
     http.HandleFunc("/ws", HandleWebSocket(hub, cfg.AllowedOrigin))
+    http.HandleFunc("/ws/", HandleWebSocket(hub, cfg.AllowedOrigin))
 
     // UDP relay
-    if _, err := StartUDPRelay(cfg.UDPPort, hub); err != nil {
-        log.Printf("UDP relay error: %v", err)
+    var udpConns []*net.UDPConn
+    var udpErr error
+    if inherited != nil && len(inherited.udp) > 0 {
+        udpConns = serveUDPRelays(ctx, inherited.udp, hub)
+    } else {
+        udpConns, udpErr = StartUDPRelays(ctx, cfg.UDPPort, hub, cfg.UDPListeners)
+    }
+    if udpErr != nil {
+        log.Printf("UDP relay error: %v", udpErr)
+        hub.RegisterHealthCheck(udpRelayCheck(hub, udpErr))
     } else {
-        log.Printf("UDP relay listening on :%s", cfg.UDPPort)
+        log.Printf("UDP relay listening on :%s (%d listeners)", cfg.UDPPort, len(udpConns))
+        hub.RegisterHealthCheck(udpRelayCheck(hub, nil))
     }
+    hub.RegisterHealthCheck(httpListenerCheck(cfg.HTTPPort))
 
     addr := ":" + cfg.HTTPPort
+    var ln net.Listener
+    if inherited != nil {
+        ln = inherited.http
+        log.Printf("took over listeners from predecessor via %s", cfg.HandoffSocket)
+    } else {
+        var err error
+        if ln, err = net.Listen("tcp", addr); err != nil {
+            log.Fatalf("http listen: %v", err)
+        }
+    }
     log.Printf("starting server on %s (commit=%s build=%s)", addr, CommitHash, BuildTime)
     srv := &http.Server{Addr: addr, ReadHeaderTimeout: 10 * time.Second}
-    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-        log.Fatalf("http server error: %v", err)
+    go func() {
+        if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+            log.Fatalf("http server error: %v", err)
+        }
+    }()
+    if cfg.HandoffSocket != "" && handoffSupported {
+        // a successor taking the listeners over ends this process like SIGTERM
+        go func() {
+            if err := serveHandoff(ctx, cfg.HandoffSocket, ln, udpConns, stop); err != nil && ctx.Err() == nil {
+                log.Printf("handoff: %v", err)
+            }
+        }()
+    }
+
+    <-ctx.Done()
+    log.Printf("shutting down (grace %s)", cfg.ShutdownTimeout)
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+    defer cancel()
+    // stop accepting first; upgraded connections are not the server's to wait for
+    if err := srv.Shutdown(shutdownCtx); err != nil {
+        log.Printf("http shutdown: %v", err)
+    }
+    if err := hub.Shutdown(shutdownCtx); err != nil {
+        log.Printf("closing connections: %v", err)
+    }
+    if err := hub.events.close(shutdownCtx); err != nil {
+        log.Printf("event sink: %v", err)
     }
 }
 
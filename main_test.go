@@ -5,6 +5,7 @@ import (
     "encoding/json"
     "flag"
     "fmt"
+    "net"
     "net/http"
     "net/http/httptest"
     "os"
@@ -64,8 +65,9 @@ func TestFunctional(t *testing.T) {
         // Spin up in-process HTTP server
         hub := NewHub()
         mux := http.NewServeMux()
-        mux.HandleFunc("/health", healthHandler)
+        mux.HandleFunc("/health", healthHandler(hub))
         mux.HandleFunc("/ws", HandleWebSocket(hub, "*"))
+        mux.HandleFunc("/ws/", HandleWebSocket(hub, "*"))
         ts := httptest.NewServer(mux)
         defer ts.Close()
         wsURL = "ws" + ts.URL[len("http"):]
@@ -234,6 +236,169 @@ func TestFunctional(t *testing.T) {
     }
 }
 
+// startTestServer serves hub in-process and returns its ws:// base URL.
+func startTestServer(t testing.TB, hub *Hub) string {
+    t.Helper()
+    mux := http.NewServeMux()
+    mux.HandleFunc("/health", healthHandler(hub))
+    mux.HandleFunc("/stats", statsHandler(hub))
+    mux.HandleFunc("/rooms", roomsHandler(hub))
+    mux.HandleFunc("/config", configHandler(hub))
+    mux.HandleFunc("/schemas", schemasHandler(hub))
+    mux.HandleFunc("/metrics", metricsHandler(hub))
+    mux.HandleFunc("/capture", captureHandler(hub))
+    mux.HandleFunc("/capabilities", capabilitiesHandler(hub))
+    mux.HandleFunc("/ws", HandleWebSocket(hub, "*"))
+    mux.HandleFunc("/ws/", HandleWebSocket(hub, "*"))
+    ts := httptest.NewServer(mux)
+    t.Cleanup(ts.Close)
+    return "ws" + ts.URL[len("http"):]
+}
+
+// getJSON fetches path from the test server behind wsBase and decodes it into v.
+func getJSON(t *testing.T, wsBase, path string, v any) {
+    t.Helper()
+    resp, err := http.Get("http" + wsBase[len("ws"):] + path)
+    if err != nil {
+        t.Fatalf("GET %s: %v", path, err)
+    }
+    defer resp.Body.Close()
+    if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+        t.Fatalf("decode %s: %v", path, err)
+    }
+}
+
+func dialWS(t testing.TB, url string) *websocket.Conn {
+    t.Helper()
+    c, _, err := websocket.DefaultDialer.Dial(url, nil)
+    if err != nil {
+        t.Fatalf("dial %s: %v", url, err)
+    }
+    t.Cleanup(func() { c.Close() })
+    return c
+}
+
+// waitFor polls cond until it holds or timeout expires.
+func waitFor(timeout time.Duration, cond func() bool) bool {
+    deadline := time.Now().Add(timeout)
+    for time.Now().Before(deadline) {
+        if cond() {
+            return true
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    return cond()
+}
+
+func roomSize(hub *Hub, name string) int {
+    r := hub.getRoom(name)
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return len(r.clients)
+}
+
+// expectClose reads from c until the server closes it and returns the close error.
+func expectClose(t *testing.T, c *websocket.Conn) *websocket.CloseError {
+    t.Helper()
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    for {
+        _, _, err := c.ReadMessage()
+        if err == nil {
+            continue
+        }
+        ce, ok := err.(*websocket.CloseError)
+        if !ok {
+            t.Fatalf("expected close frame, got %v", err)
+        }
+        return ce
+    }
+}
+
+// readEnvelopes collects v1 envelopes from c until it stays quiet for idle.
+func readEnvelopes(t *testing.T, c *websocket.Conn, idle time.Duration) []Envelope {
+    t.Helper()
+    var out []Envelope
+    for {
+        c.SetReadDeadline(time.Now().Add(idle))
+        _, raw, err := c.ReadMessage()
+        if err != nil {
+            return out
+        }
+        var env Envelope
+        if err := json.Unmarshal(raw, &env); err != nil {
+            t.Fatalf("decode %s: %v", raw, err)
+        }
+        out = append(out, env)
+    }
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+    t.Run("allow", func(t *testing.T) {
+        hub := NewHubWithConfig(Config{DuplicatePolicy: DuplicateAllow})
+        url := startTestServer(t, hub)
+        dialWS(t, url+"/ws/r/alice")
+        dialWS(t, url+"/ws/r/alice")
+        if !waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 }) {
+            t.Fatalf("expected both connections kept, room has %d", roomSize(hub, "r"))
+        }
+    })
+
+    t.Run("reject_new", func(t *testing.T) {
+        hub := NewHubWithConfig(Config{DuplicatePolicy: DuplicateRejectNew})
+        url := startTestServer(t, hub)
+        dialWS(t, url+"/ws/r/alice")
+        if !waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 }) {
+            t.Fatal("first connection never joined")
+        }
+        second := dialWS(t, url+"/ws/r/alice")
+        if ce := expectClose(t, second); ce.Code != websocket.ClosePolicyViolation {
+            t.Fatalf("close code = %d, want %d", ce.Code, websocket.ClosePolicyViolation)
+        }
+        if n := roomSize(hub, "r"); n != 1 {
+            t.Fatalf("room size = %d, want 1", n)
+        }
+    })
+
+    t.Run("close_old", func(t *testing.T) {
+        hub := NewHubWithConfig(Config{DuplicatePolicy: DuplicateCloseOld})
+        url := startTestServer(t, hub)
+        old := dialWS(t, url+"/ws/r/alice")
+        if !waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 }) {
+            t.Fatal("first connection never joined")
+        }
+        current := dialWS(t, url+"/ws/r/alice")
+        ce := expectClose(t, old)
+        if ce.Code != closeReplaced || ce.Text != "replaced" {
+            t.Fatalf("close = %d %q, want %d \"replaced\"", ce.Code, ce.Text, closeReplaced)
+        }
+        // the displaced connection must be fully torn down
+        ok := waitFor(time.Second, func() bool {
+            hub.idMu.Lock()
+            defer hub.idMu.Unlock()
+            return roomSize(hub, "r") == 1 && len(hub.identities["alice"]) == 1
+        })
+        if !ok {
+            t.Fatalf("displaced connection not cleaned up: room size %d", roomSize(hub, "r"))
+        }
+
+        // the replacement still receives traffic
+        bob := dialWS(t, url+"/ws/r/bob")
+        waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+        if err := bob.WriteMessage(websocket.BinaryMessage, []byte("hi")); err != nil {
+            t.Fatal(err)
+        }
+        current.SetReadDeadline(time.Now().Add(2 * time.Second))
+        _, msg, err := current.ReadMessage()
+        if err != nil {
+            t.Fatalf("replacement read: %v", err)
+        }
+        var env Envelope
+        if err := json.Unmarshal(msg, &env); err != nil || string(env.Payload) != "hi" {
+            t.Fatalf("unexpected envelope %s (%v)", msg, err)
+        }
+    })
+}
+
 func writeJSON(path string, v any) error {
     if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
         return err
@@ -296,3 +461,157 @@ func sortFloats(a []float64) {
         a[j+1] = v
     }
 }
+
+func TestLargeMessagesUseBulkQueue(t *testing.T) {
+    c := &Client{sendCh: make(chan outbound, 4), bulkCh: make(chan outbound, 4), bulkOver: 10}
+    c.trySend(make([]byte, 11))
+    c.trySend(make([]byte, 10))
+    if len(c.bulkCh) != 1 || len(c.sendCh) != 1 {
+        t.Fatalf("bulk=%d main=%d, want 1 and 1", len(c.bulkCh), len(c.sendCh))
+    }
+}
+
+func TestSmallMessagesOvertakeLargeOnes(t *testing.T) {
+    hub := NewHubWithConfig(Config{LargeMessageBytes: 1024})
+    base := startTestServer(t, hub)
+    sender := dialWS(t, base+"/ws/media/sender")
+    // a slow link: the first large frame exhausts the pace budget, so the
+    // rest queue up behind it
+    viewer := dialWS(t, base+"/ws/media/viewer?pace=2000")
+    waitFor(time.Second, func() bool { return roomSize(hub, "media") == 2 })
+
+    large := make([]byte, 2000)
+    for _, msg := range [][]byte{large, large, []byte(`{"op_hint":"pause"}`)} {
+        if err := sender.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+            t.Fatal(err)
+        }
+    }
+    var sizes []int
+    viewer.SetReadDeadline(time.Now().Add(5 * time.Second))
+    for i := 0; i < 2; i++ {
+        _, raw, err := viewer.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        var env Envelope
+        if err := json.Unmarshal(raw, &env); err != nil {
+            t.Fatal(err)
+        }
+        sizes = append(sizes, len(env.Payload))
+    }
+    if sizes[0] != 2000 || sizes[1] == 2000 {
+        t.Fatalf("payload sizes in arrival order %v: the small message should overtake the second large one", sizes)
+    }
+}
+
+func TestUDPDoesNotCreateRooms(t *testing.T) {
+    hub := NewHub()
+    udp, err := StartUDPRelay(context.Background(), "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer udp.Close()
+    base := startTestServer(t, hub)
+    ws := dialWS(t, base+"/ws/mixed/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "mixed") == 1 })
+
+    out, err := net.DialUDP("udp", nil, udp.LocalAddr().(*net.UDPAddr))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer out.Close()
+    for _, frame := range []string{"ROOM:udponly;USER:sensor\nreading", "ROOM:mixed;USER:sensor\nreading"} {
+        if _, err := out.Write([]byte(frame)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := readEnvelopes(t, ws, 300*time.Millisecond)
+    if len(got) != 1 || got[0].Username != "sensor" || string(got[0].Payload) != "reading" {
+        t.Fatalf("websocket client in mixed got %+v", got)
+    }
+    hub.mu.RLock()
+    created := hub.lookupRoom("udponly") != nil
+    hub.mu.RUnlock()
+    if created {
+        t.Fatal("UDP-only traffic created a websocket room")
+    }
+}
+
+func TestParseUDPFrame(t *testing.T) {
+    h, payload := parseUDPFrame([]byte("ROOM:r; USER:sensor; TO:WS\nreading"))
+    if h.room != "r" || h.user != "sensor" || h.to != udpRouteWS || string(payload) != "reading" {
+        t.Fatalf("got %+v %q", h, payload)
+    }
+    if h, payload := parseUDPFrame([]byte("raw")); h != (udpHeader{}) || string(payload) != "raw" {
+        t.Fatalf("headerless frame: %+v %q", h, payload)
+    }
+}
+
+func TestUDPRoutingFlag(t *testing.T) {
+    hub := NewHub()
+    udp, err := StartUDPRelay(context.Background(), "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer udp.Close()
+    base := startTestServer(t, hub)
+    ws := dialWS(t, base+"/ws/hybrid/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "hybrid") == 1 })
+
+    addr := udp.LocalAddr().(*net.UDPAddr)
+    peer, err := net.DialUDP("udp", nil, addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer peer.Close()
+    sender, err := net.DialUDP("udp", nil, addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer sender.Close()
+    peer.Write([]byte("ROOM:hybrid;USER:peer;TO:udp\njoin"))
+    time.Sleep(50 * time.Millisecond)
+    for _, frame := range []string{
+        "ROOM:hybrid;USER:sensor;TO:ws\nws only",
+        "ROOM:hybrid;USER:sensor;TO:udp\nudp only",
+        "ROOM:hybrid;USER:sensor\nboth",
+    } {
+        if _, err := sender.Write([]byte(frame)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    var udpGot []string
+    buf := make([]byte, 64)
+    for {
+        peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+        n, err := peer.Read(buf)
+        if err != nil {
+            break
+        }
+        udpGot = append(udpGot, string(buf[:n]))
+    }
+    var wsGot []string
+    for _, env := range readEnvelopes(t, ws, 200*time.Millisecond) {
+        wsGot = append(wsGot, string(env.Payload))
+    }
+    if len(udpGot) != 2 || udpGot[0] != "udp only" || udpGot[1] != "both" {
+        t.Errorf("UDP peer got %q, want [udp only both]", udpGot)
+    }
+    if len(wsGot) != 2 || wsGot[0] != "ws only" || wsGot[1] != "both" {
+        t.Errorf("websocket client got %q, want [ws only both]", wsGot)
+    }
+}
+
+func TestValidDuplicatePolicy(t *testing.T) {
+    for _, p := range []string{DuplicateAllow, DuplicateRejectNew, DuplicateCloseOld} {
+        if err := validDuplicatePolicy(p); err != nil {
+            t.Errorf("%s: %v", p, err)
+        }
+    }
+    for _, p := range []string{"", "reject", "CLOSE_OLD"} {
+        if err := validDuplicatePolicy(p); err == nil {
+            t.Errorf("%q accepted", p)
+        }
+    }
+}
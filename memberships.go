@@ -0,0 +1,71 @@
+package main
+
+import "sync"
+
+// roomMemberships caps the rooms one identity (username) belongs to at
+// once across all its connections (MAX_ROOMS_PER_IDENTITY), so a single
+// user cannot fan in from an unreasonable number of rooms. Several
+// connections of an identity to the same room are one membership. A join
+// over the cap is refused with a room_limit error frame and a 1008 close
+// whose reason is an OverloadReason.
+type roomMemberships struct {
+    max   int
+    mu    sync.Mutex
+    rooms map[string]map[string]int // identity -> room -> connections
+}
+
+// newRoomMemberships returns nil, which admits every join, when max is 0.
+func newRoomMemberships(max int) *roomMemberships {
+    if max <= 0 {
+        return nil
+    }
+    return &roomMemberships{max: max, rooms: make(map[string]map[string]int)}
+}
+
+// acquire records a connection of identity joining room, reporting false
+// when that would take identity over the cap. Each successful acquire must
+// be paired with release.
+func (m *roomMemberships) acquire(identity, room string) bool {
+    if m == nil {
+        return true
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    rooms := m.rooms[identity]
+    if rooms[room] == 0 && len(rooms) >= m.max {
+        return false
+    }
+    if rooms == nil {
+        rooms = make(map[string]int)
+        m.rooms[identity] = rooms
+    }
+    rooms[room]++
+    return true
+}
+
+func (m *roomMemberships) release(identity, room string) {
+    if m == nil {
+        return
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    rooms := m.rooms[identity]
+    if rooms[room] > 1 {
+        rooms[room]--
+        return
+    }
+    delete(rooms, room)
+    if len(rooms) == 0 {
+        delete(m.rooms, identity)
+    }
+}
+
+// count is the number of rooms identity belongs to.
+func (m *roomMemberships) count(identity string) int {
+    if m == nil {
+        return 0
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return len(m.rooms[identity])
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestRoomMembershipsCountDistinctRooms(t *testing.T) {
+    m := newRoomMemberships(2)
+    if !m.acquire("alice", "a") || !m.acquire("alice", "a") || !m.acquire("alice", "b") {
+        t.Fatal("joins within the cap refused")
+    }
+    if m.acquire("alice", "c") {
+        t.Fatal("third room admitted")
+    }
+    if !m.acquire("bob", "c") {
+        t.Fatal("another identity was limited")
+    }
+    m.release("alice", "a")
+    if m.acquire("alice", "c") {
+        t.Fatal("room a still has a connection; c must wait")
+    }
+    m.release("alice", "a")
+    if !m.acquire("alice", "c") || m.count("alice") != 2 {
+        t.Fatalf("after leaving a: %d rooms", m.count("alice"))
+    }
+    if newRoomMemberships(0) != nil {
+        t.Fatal("a zero cap should disable tracking")
+    }
+}
+
+func TestRoomLimitAcrossConnections(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxRoomsPerIdentity: 2})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/r1/alice")
+    dialWS(t, base+"/ws/r1/alice") // same room again: still one membership
+    r2 := dialWS(t, base+"/ws/r2/alice")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "r1") == 2 && roomSize(hub, "r2") == 1 }) {
+        t.Fatal("alice did not join r1 and r2")
+    }
+
+    r3 := dialWS(t, base+"/ws/r3/alice")
+    expectErrorFrame(t, r3, errCodeRoomLimit)
+    ce := expectClose(t, r3)
+    if ce.Code != websocket.ClosePolicyViolation {
+        t.Fatalf("close code %d, want %d", ce.Code, websocket.ClosePolicyViolation)
+    }
+    var reason OverloadReason
+    if err := json.Unmarshal([]byte(ce.Text), &reason); err != nil || reason != (OverloadReason{Code: errCodeRoomLimit, MaxRooms: 2, RetryAfterMs: 1000}) {
+        t.Fatalf("close reason %q (%v)", ce.Text, err)
+    }
+    if n := roomSize(hub, "r3"); n != 0 {
+        t.Fatalf("r3 has %d members", n)
+    }
+    dialWS(t, base+"/ws/r3/bob") // the cap is per identity
+
+    r2.Close()
+    if !waitFor(time.Second, func() bool { return hub.memberships.count("alice") == 1 }) {
+        t.Fatal("leaving r2 did not free a membership")
+    }
+    dialWS(t, base+"/ws/r3/alice")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "r3") == 2 }) {
+        t.Fatal("alice could not join r3 after leaving r2")
+    }
+}
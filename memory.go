@@ -0,0 +1,88 @@
+package main
+
+import (
+    "log"
+    "sync/atomic"
+    "time"
+)
+
+// Coarse per-object costs for the hub memory estimate. They approximate a
+// connection's read/write buffers and send queue, and a room's maps and
+// meters; queued payload bytes and those kept in room history, including
+// the history of removed rooms, are counted exactly. This is a guardrail
+// against runaway growth, not an accounting of the Go heap.
+const (
+    roomMemoryOverhead   = 1 << 10
+    clientMemoryOverhead = 16 << 10
+)
+
+// Protective actions for MEMORY_LIMIT_ACTION once the estimate exceeds
+// MEMORY_SOFT_LIMIT.
+const (
+    MemoryActionReject = "reject" // refuse new connections (and so new rooms)
+    MemoryActionShed   = "shed"   // drop broadcasts to dead letters
+)
+
+const dropMemoryLimit = "memory_limit"
+
+// memoryGuard holds the latest estimate, refreshed by runMemoryEstimator.
+type memoryGuard struct {
+    estimate atomic.Int64
+    over     atomic.Bool
+}
+
+// estimateMemory walks rooms and clients and returns the hub's estimated
+// footprint in bytes.
+func (h *Hub) estimateMemory() int64 {
+    var total int64
+    h.forEachRoom(func(r *Room) {
+        total += roomMemoryOverhead + historySize(r.history)
+        r.mu.RLock()
+        for c := range r.clients {
+            total += clientMemoryOverhead + c.queued.Load()
+        }
+        r.mu.RUnlock()
+    })
+    return total + h.keptHistorySize(time.Now())
+}
+
+// updateMemoryEstimate refreshes the estimate and the over-limit flag.
+func (h *Hub) updateMemoryEstimate() {
+    est := h.estimateMemory()
+    h.mem.estimate.Store(est)
+    over := h.cfg.MemorySoftLimit > 0 && est > h.cfg.MemorySoftLimit
+    if h.mem.over.Swap(over) != over {
+        if over {
+            log.Printf("memory estimate %d bytes over soft limit %d: action=%s", est, h.cfg.MemorySoftLimit, h.memoryAction())
+        } else {
+            log.Printf("memory estimate %d bytes back under soft limit %d", est, h.cfg.MemorySoftLimit)
+        }
+    }
+}
+
+func (h *Hub) runMemoryEstimator(interval time.Duration) {
+    if interval <= 0 {
+        interval = time.Second
+    }
+    for range time.Tick(interval) {
+        h.updateMemoryEstimate()
+    }
+}
+
+// memoryAction is the configured action; anything but shed rejects.
+func (h *Hub) memoryAction() string {
+    if h.cfg.MemoryLimitAction == MemoryActionShed {
+        return MemoryActionShed
+    }
+    return MemoryActionReject
+}
+
+// refuseConnections reports whether new connections must be turned away.
+func (h *Hub) refuseConnections() bool {
+    return h.mem.over.Load() && h.memoryAction() == MemoryActionReject
+}
+
+// shedBroadcasts reports whether broadcasts must be dropped.
+func (h *Hub) shedBroadcasts() bool {
+    return h.mem.over.Load() && h.memoryAction() == MemoryActionShed
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+)
+
+// Connection labels as Prometheus labels. A connection may label itself
+// with ?labels=tenant:acme,region:eu; keys listed in METRIC_LABELS become
+// labels on the relay_labeled_* series in /metrics, so connections,
+// messages and bytes can be broken down per tenant. Other keys are
+// ignored. To bound cardinality, values are cut to maxLabelValueLen bytes
+// and at most METRIC_LABEL_MAX_SERIES label sets are tracked; connections
+// beyond that are counted under every key's value "other".
+
+const (
+    maxLabelValueLen      = 64
+    defaultMaxLabelSeries = 100
+    otherLabelValue       = "other"
+)
+
+// labelSeries are the counters of one label set.
+type labelSeries struct {
+    values      []string // one per allowlisted key, in key order
+    connections atomic.Int64
+    connects    atomic.Int64
+    msgsIn      atomic.Int64
+    bytesIn     atomic.Int64
+}
+
+type labelMetrics struct {
+    keys      []string // allowlisted label keys, sorted
+    maxSeries int
+
+    mu     sync.Mutex
+    series map[string]*labelSeries
+}
+
+// newLabelMetrics returns nil when METRIC_LABELS is empty.
+func newLabelMetrics(spec string, maxSeries int) *labelMetrics {
+    var keys []string
+    for _, k := range strings.Split(spec, ",") {
+        if k = strings.TrimSpace(k); k != "" {
+            keys = append(keys, k)
+        }
+    }
+    if len(keys) == 0 {
+        return nil
+    }
+    sort.Strings(keys)
+    if maxSeries <= 0 {
+        maxSeries = defaultMaxLabelSeries
+    }
+    return &labelMetrics{keys: keys, maxSeries: maxSeries, series: make(map[string]*labelSeries)}
+}
+
+// LoadMetricLabels sets the label allowlist from METRIC_LABELS; keys must
+// be valid Prometheus label names.
+func (h *Hub) LoadMetricLabels(spec string, maxSeries int) error {
+    for _, k := range strings.Split(spec, ",") {
+        k = strings.TrimSpace(k)
+        if k == "" {
+            continue
+        }
+        for i, r := range k {
+            if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') || strings.HasPrefix(k, "__") {
+                return fmt.Errorf("invalid metric label %q", k)
+            }
+        }
+    }
+    h.labels = newLabelMetrics(spec, maxSeries)
+    return nil
+}
+
+// parseConnLabels reads the ?labels value: comma-separated key:value pairs.
+func parseConnLabels(s string) map[string]string {
+    if s == "" {
+        return nil
+    }
+    labels := make(map[string]string)
+    for _, pair := range strings.Split(s, ",") {
+        k, v, ok := strings.Cut(pair, ":")
+        if !ok || k == "" {
+            continue
+        }
+        if len(v) > maxLabelValueLen {
+            v = v[:maxLabelValueLen]
+        }
+        labels[k] = v
+    }
+    return labels
+}
+
+// seriesFor returns the series a connection with labels is counted in.
+func (lm *labelMetrics) seriesFor(labels map[string]string) *labelSeries {
+    if lm == nil {
+        return nil
+    }
+    values := make([]string, len(lm.keys))
+    for i, k := range lm.keys {
+        values[i] = labels[k]
+    }
+    lm.mu.Lock()
+    defer lm.mu.Unlock()
+    if s := lm.series[seriesKey(values)]; s != nil {
+        return s
+    }
+    if len(lm.series) >= lm.maxSeries {
+        for i := range values {
+            values[i] = otherLabelValue
+        }
+        if s := lm.series[seriesKey(values)]; s != nil {
+            return s
+        }
+    }
+    s := &labelSeries{values: values}
+    lm.series[seriesKey(values)] = s
+    return s
+}
+
+// seriesKey identifies a label set.
+func seriesKey(values []string) string {
+    return fmt.Sprintf("%q", values)
+}
+
+// snapshot returns the tracked series.
+func (lm *labelMetrics) snapshot() []*labelSeries {
+    lm.mu.Lock()
+    defer lm.mu.Unlock()
+    out := make([]*labelSeries, 0, len(lm.series))
+    for _, s := range lm.series {
+        out = append(out, s)
+    }
+    return out
+}
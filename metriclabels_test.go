@@ -0,0 +1,113 @@
+package main
+
+import (
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestMetricsWithTenantLabel(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    if err := hub.LoadMetricLabels("tenant", 0); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    a := dialWS(t, base+"/ws/prom/alice?labels=tenant:acme,user:alice")
+    dialWS(t, base+"/ws/prom/bob?labels=tenant:acme")
+    dialWS(t, base+"/ws/prom/carol?labels=tenant:globex")
+    dialWS(t, base+"/ws/prom/dave")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "prom") == 4 }) {
+        t.Fatal("clients did not join")
+    }
+    if err := a.WriteMessage(1, []byte("hello")); err != nil {
+        t.Fatal(err)
+    }
+    if !waitFor(time.Second, func() bool { return hub.metrics.msgsIn.Load() == 1 }) {
+        t.Fatal("message not read")
+    }
+
+    s := scrapeMetrics(t, base)
+    want := map[string]int64{
+        `relay_labeled_active_connections{tenant="acme"}`:        2,
+        `relay_labeled_active_connections{tenant="globex"}`:      1,
+        `relay_labeled_active_connections{tenant=""}`:            1,
+        `relay_labeled_connections_total{tenant="acme"}`:         2,
+        `relay_labeled_messages_received_total{tenant="acme"}`:   1,
+        `relay_labeled_bytes_received_total{tenant="acme"}`:      5,
+        `relay_labeled_messages_received_total{tenant="globex"}`: 0,
+    }
+    for name, v := range want {
+        if got, ok := s[name]; !ok || got != v {
+            t.Errorf("%s = %d (present %v), want %d", name, got, ok, v)
+        }
+    }
+    for name := range s {
+        if name == `relay_labeled_active_connections{tenant="acme",user="alice"}` || name == `relay_labeled_active_connections{user="alice"}` {
+            t.Errorf("label outside the allowlist exported: %s", name)
+        }
+    }
+}
+
+func TestMetricLabelValuesEscaped(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    if err := hub.LoadMetricLabels("tenant", 0); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/prom/alice?labels=tenant:"+url.QueryEscape("zürich \"q\" \\"))
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "prom") == 1 }) {
+        t.Fatal("client did not join")
+    }
+    resp, err := http.Get("http" + strings.TrimPrefix(base, "ws") + "/metrics")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(resp.Body)
+    // UTF-8 as is; only backslash, quote and newline are escaped
+    want := `relay_labeled_active_connections{tenant="zürich \"q\" \\"} 1`
+    if !strings.Contains(string(body), want) {
+        t.Fatalf("scrape lacks %s:\n%s", want, body)
+    }
+}
+
+func TestMetricsWithoutLabels(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/prom/alice?labels=tenant:acme")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "prom") == 1 }) {
+        t.Fatal("client did not join")
+    }
+    for name := range scrapeMetrics(t, base) {
+        if strings.HasPrefix(name, "relay_labeled") {
+            t.Fatalf("labelled series %s without METRIC_LABELS", name)
+        }
+    }
+}
+
+func TestMetricLabelCardinalityCap(t *testing.T) {
+    lm := newLabelMetrics("tenant", 2)
+    a := lm.seriesFor(map[string]string{"tenant": "a"})
+    if lm.seriesFor(map[string]string{"tenant": "a"}) != a {
+        t.Fatal("same labels got a new series")
+    }
+    lm.seriesFor(map[string]string{"tenant": "b"})
+    c := lm.seriesFor(map[string]string{"tenant": "c"})
+    d := lm.seriesFor(map[string]string{"tenant": "d"})
+    if c != d || c.values[0] != otherLabelValue {
+        t.Fatalf("over the cap: %q and %q", c.values, d.values)
+    }
+    if got := lm.seriesFor(map[string]string{"tenant": "x\"y"}).values; got[0] != otherLabelValue {
+        t.Fatalf("got %q", got)
+    }
+
+    hub := NewHubWithConfig(Config{})
+    for _, bad := range []string{"1tenant", "ten-ant", "__name"} {
+        if err := hub.LoadMetricLabels(bad, 0); err == nil {
+            t.Errorf("label %q accepted", bad)
+        }
+    }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "log"
+    "sync/atomic"
+    "time"
+)
+
+// hubMetrics are process-wide counters shared by the metric exporters.
+// Per-connection detail lives in clientCounters; these survive disconnects.
+type hubMetrics struct {
+    connections    atomic.Int64 // currently open
+    connectsTotal  atomic.Int64
+    msgsIn         atomic.Int64
+    bytesIn        atomic.Int64
+    msgsOut        atomic.Int64
+    bytesOut       atomic.Int64
+    drops          atomic.Int64
+    broadcasts     atomic.Int64 // messages admitted to a room fanout
+    broadcastBytes atomic.Int64
+    egressCutoffs  atomic.Int64 // times a room hit ROOM_EGRESS_CAP
+    writes         atomic.Int64 // frames timed, for mean write latency
+    writeNanos     atomic.Int64
+    udpRejected    [numUDPFrameErrors]atomic.Int64 // datagrams refused, by udpFrameError
+}
+
+// countIn records a frame read from c.
+func (c *Client) countIn(n int) {
+    c.counters.msgsIn.Add(1)
+    c.counters.bytesIn.Add(int64(n))
+    m := &c.room.hub.metrics
+    m.msgsIn.Add(1)
+    m.bytesIn.Add(int64(n))
+    if ls := c.labelStats; ls != nil {
+        ls.msgsIn.Add(1)
+        ls.bytesIn.Add(int64(n))
+    }
+}
+
+// countWrite records a frame of n bytes written to c in d.
+func (c *Client) countWrite(n int, d time.Duration) {
+    c.counters.observeWrite(n, d)
+    m := &c.room.hub.metrics
+    m.msgsOut.Add(1)
+    m.bytesOut.Add(int64(n))
+    m.writes.Add(1)
+    m.writeNanos.Add(int64(d))
+}
+
+// countDrop records a message for c that the relay dropped, and warns
+// each time c's total reaches another multiple of DROP_WARN_THRESHOLD.
+func (c *Client) countDrop() {
+    n := c.counters.drops.Add(1)
+    c.room.hub.metrics.drops.Add(1)
+    if t := c.room.hub.cfg.DropWarnThreshold; t > 0 && n%t == 0 {
+        log.Printf("slow consumer: room=%s user=%s has had %d messages dropped", c.room.name, c.username, n)
+    }
+}
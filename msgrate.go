@@ -0,0 +1,45 @@
+package main
+
+import (
+    "fmt"
+    "math"
+
+    "github.com/gorilla/websocket"
+)
+
+// Per-client message rate limit (MAX_MSGS_PER_SEC). Each connection's data
+// frames draw from a token bucket with one second of burst; a frame that
+// finds it empty is dropped as a rate_limited dead letter and counted in
+// the connection's stats. A client that keeps flooding, with
+// maxThrottleStreak drops in a row, is closed with 1008.
+
+const (
+    dropRateLimited   = "rate_limited"
+    maxThrottleStreak = 100
+)
+
+func newMessageLimiter(perSec float64) *tokenBucket {
+    if perSec <= 0 {
+        return nil
+    }
+    return newTokenBucket(perSec, math.Max(perSec, 1))
+}
+
+// throttle reports whether c's next data frame must be dropped, and
+// whether c has now been throttled often enough in a row to be closed.
+// Only c's reader loop calls it.
+func (c *Client) throttle() (drop, closeConn bool) {
+    if c.msgLimit == nil || c.msgLimit.allow(1) {
+        c.floodStreak = 0
+        return false, false
+    }
+    c.counters.throttled.Add(1)
+    c.floodStreak++
+    return true, c.floodStreak >= maxThrottleStreak
+}
+
+// closeFlooding ends the connection of a client that would not slow down.
+func (c *Client) closeFlooding() {
+    writeClose(c.conn, websocket.ClosePolicyViolation, fmt.Sprintf("message rate above %g/s", c.msgLimit.rate))
+    c.conn.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestMessageRateLimitThrottles(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxMsgsPerSec: 10})
+    dead := make(chan DeadLetter, 64)
+    hub.dead = newDeadLetterSink(64, func(dl DeadLetter) { dead <- dl })
+    base := startTestServer(t, hub)
+    recv := dialWS(t, base+"/ws/flood/recv")
+    sender := dialWS(t, base+"/ws/flood/sender")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "flood") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+
+    for i := 0; i < 40; i++ {
+        if err := sender.WriteMessage(websocket.TextMessage, []byte("spam")); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := readEnvelopes(t, recv, 300*time.Millisecond)
+    if len(got) < 10 || len(got) > 15 {
+        t.Fatalf("received %d of 40 messages, want about the 10-message burst", len(got))
+    }
+
+    var throttled int64
+    room := hub.existingRoomFor("flood", "")
+    room.mu.RLock()
+    for c := range room.clients {
+        if c.username == "sender" {
+            throttled = c.counters.throttled.Load()
+        }
+    }
+    room.mu.RUnlock()
+    if throttled != int64(40-len(got)) {
+        t.Fatalf("throttled counter %d, want %d", throttled, 40-len(got))
+    }
+    select {
+    case dl := <-dead:
+        if dl.Reason != dropRateLimited || dl.From != "sender" {
+            t.Fatalf("dead letter %+v", dl)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("no rate_limited dead letter")
+    }
+}
+
+func TestPersistentFloodingCloses(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxMsgsPerSec: 1})
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/flood/sender")
+    for i := 0; i < maxThrottleStreak+1; i++ {
+        if err := c.WriteMessage(websocket.TextMessage, []byte("spam")); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if ce := expectClose(t, c); ce.Code != websocket.ClosePolicyViolation {
+        t.Fatalf("close code %d, want %d", ce.Code, websocket.ClosePolicyViolation)
+    }
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// Inbound frame size cap (MAX_MESSAGE_BYTES, default 1 MiB). Without it a
+// client could announce a huge frame and make the reader allocate for it.
+// The reader never buffers more than the limit plus one byte of a message;
+// a longer one gets a message_too_large error frame and the connection is
+// closed with 1009 once that frame is written.
+
+var errMessageTooLarge = errors.New("message too large")
+
+// setReadLimit caps c's inbound frames at requested bytes, or at the
+// server's MAX_MESSAGE_BYTES when requested is 0 or larger: a client may
+// lower its own limit but never raise it. It returns the limit applied,
+// 0 meaning unlimited.
+func (c *Client) setReadLimit(requested int64) int64 {
+    limit := c.room.hub.cfg.MaxMessageBytes
+    if requested > 0 && (limit <= 0 || requested < limit) {
+        limit = requested
+    }
+    c.readLimit = max(limit, 0)
+    return c.readLimit
+}
+
+// readMessage reads c's next message, failing with errMessageTooLarge
+// when it is over c's read limit. Only c's reader loop calls it.
+func (c *Client) readMessage() (int, []byte, error) {
+    if c.readLimit <= 0 {
+        return c.conn.ReadMessage()
+    }
+    msgType, r, err := c.conn.NextReader()
+    if err != nil {
+        return msgType, nil, err
+    }
+    msg, err := io.ReadAll(io.LimitReader(r, c.readLimit+1))
+    if err == nil && int64(len(msg)) > c.readLimit {
+        err = errMessageTooLarge
+    }
+    return msgType, msg, err
+}
+
+// rejectOversized tells c its message was over the limit and closes it.
+func (c *Client) rejectOversized() {
+    log.Printf("closing connection over message size limit: room=%s user=%s", c.room.name, c.username)
+    c.sendError(errCodeMessageTooLarge, fmt.Sprintf("message over the %d byte limit", c.readLimit))
+    c.drainAndClose(websocket.CloseMessageTooBig, "message too large", time.Now().Add(c.writeWait))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestOversizedMessageCloses(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxMessageBytes: 1024})
+    base := startTestServer(t, hub)
+    recv := dialWS(t, base+"/ws/big/recv")
+    c := dialWS(t, base+"/ws/big/sender")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "big") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+    if err := c.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 1024))); err != nil {
+        t.Fatal(err)
+    }
+    if got := readEnvelopes(t, recv, 300*time.Millisecond); len(got) != 1 {
+        t.Fatalf("message at the limit: received %d envelopes", len(got))
+    }
+    if err := c.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 64<<10))); err != nil {
+        t.Fatal(err)
+    }
+    if ce := expectClose(t, c); ce.Code != websocket.CloseMessageTooBig {
+        t.Fatalf("close code %d, want %d", ce.Code, websocket.CloseMessageTooBig)
+    }
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "big") == 1 }) {
+        t.Fatal("oversized sender was not cleaned up")
+    }
+}
+
+func TestHandshakeCannotRaiseReadLimit(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxMessageBytes: 1024})
+    c := fakeClient(hub.getRoom("big"), "a", 1)
+    c.conn = &websocket.Conn{}
+    if got := c.setReadLimit(1 << 20); got != 1024 {
+        t.Fatalf("limit %d, want the server's 1024", got)
+    }
+    if got := c.setReadLimit(512); got != 512 {
+        t.Fatalf("limit %d, want the requested 512", got)
+    }
+}
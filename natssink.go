@@ -0,0 +1,89 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/nats-io/nats.go"
+)
+
+// natsSink publishes lifecycle events to a NATS server (EVENT_SINK=nats)
+// on <EVENT_SUBJECT>.<type>, e.g. relay.events.join, as JSON, through the
+// nats.go client. The sink does not wait for the server at startup: it
+// connects in the background and, like after any disconnect, keeps
+// retrying every natsRetryDelay. Events published meanwhile are buffered
+// up to natsBufferSize and fail beyond that, so an unreachable server costs
+// events, never memory. TLS is used for tls:// URLs, or when the server
+// requires it, against the system roots or the CA in EVENT_SINK_CA; a user
+// and password, or a token, go in the URL. Delivery is core NATS publish,
+// at most once; there is no JetStream acknowledgement.
+type natsSink struct {
+    conn    *nats.Conn
+    subject string
+}
+
+const (
+    natsRetryDelay = time.Second
+    natsBufferSize = 1 << 20
+    natsTimeout    = 5 * time.Second
+)
+
+// newNATSSink connects to a nats:// or tls://[user:pass@]host[:port] URL;
+// caFile, if set, is the PEM CA bundle the server certificate is checked
+// against.
+func newNATSSink(rawURL, subject, caFile string) (*natsSink, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" {
+        return nil, fmt.Errorf("invalid NATS URL %q", rawURL)
+    }
+    if subject == "" || strings.ContainsAny(subject, " \t\r\n*>") {
+        return nil, fmt.Errorf("invalid NATS subject %q", subject)
+    }
+    opts := []nats.Option{
+        nats.Name("websocket-relay"),
+        nats.Timeout(natsTimeout),
+        nats.RetryOnFailedConnect(true),
+        nats.MaxReconnects(-1),
+        nats.ReconnectWait(natsRetryDelay),
+        nats.ReconnectBufSize(natsBufferSize),
+        nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+            if err != nil {
+                log.Printf("nats %s: disconnected: %v", u.Host, err)
+            }
+        }),
+        nats.ReconnectHandler(func(*nats.Conn) { log.Printf("nats %s: reconnected", u.Host) }),
+        nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+            log.Printf("nats %s: %v", u.Host, err)
+        }),
+    }
+    if caFile != "" {
+        opts = append(opts, nats.RootCAs(caFile))
+    }
+    conn, err := nats.Connect(rawURL, opts...)
+    if err != nil {
+        return nil, fmt.Errorf("nats %s: %w", u.Host, err)
+    }
+    return &natsSink{conn: conn, subject: subject}, nil
+}
+
+func (s *natsSink) Emit(ev LifecycleEvent) error {
+    b, err := json.Marshal(ev)
+    if err != nil {
+        return err
+    }
+    return s.conn.Publish(s.subject+"."+ev.Type, b)
+}
+
+// Close sends what is buffered, waiting up to natsTimeout, and disconnects.
+func (s *natsSink) Close() error {
+    var err error
+    if s.conn.IsConnected() {
+        err = s.conn.FlushTimeout(natsTimeout)
+    }
+    s.conn.Close()
+    return err
+}
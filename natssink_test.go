@@ -0,0 +1,234 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// natsMsg is a PUB seen by fakeNATS.
+type natsMsg struct {
+    subject string
+    payload []byte
+}
+
+// fakeNATS accepts connections on a local port, greets each with INFO,
+// answers PINGs and reports the CONNECT options and every PUB it receives.
+// Once a client is connected it is sent a PING too; pongs reports the
+// replies.
+type fakeNATS struct {
+    ln       net.Listener
+    connects chan string
+    msgs     chan natsMsg
+    pongs    chan struct{}
+
+    mu    sync.Mutex
+    conns []net.Conn
+}
+
+func startFakeNATS(t *testing.T, addr string) *fakeNATS {
+    t.Helper()
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f := &fakeNATS{ln: ln, connects: make(chan string, 4), msgs: make(chan natsMsg, 16), pongs: make(chan struct{}, 4)}
+    t.Cleanup(func() {
+        ln.Close()
+        f.dropAll()
+    })
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            f.mu.Lock()
+            f.conns = append(f.conns, conn)
+            f.mu.Unlock()
+            go f.serve(conn)
+        }
+    }()
+    return f
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+    defer conn.Close()
+    fmt.Fprint(conn, `INFO {"server_id":"fake","version":"2.10.0","proto":1,"max_payload":1048576}`+"\r\n")
+    r := bufio.NewReader(conn)
+    pinged := false
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return
+        }
+        line = strings.TrimSpace(line)
+        switch {
+        case strings.HasPrefix(line, "CONNECT "):
+            f.connects <- strings.TrimPrefix(line, "CONNECT ")
+        case line == "PING":
+            fmt.Fprint(conn, "PONG\r\n")
+            if !pinged {
+                pinged = true
+                fmt.Fprint(conn, "PING\r\n")
+            }
+        case line == "PONG":
+            f.pongs <- struct{}{}
+        case strings.HasPrefix(line, "PUB "):
+            var subject string
+            var n int
+            if _, err := fmt.Sscanf(line, "PUB %s %d", &subject, &n); err != nil {
+                return
+            }
+            payload := make([]byte, n+2)
+            if _, err := io.ReadFull(r, payload); err != nil {
+                return
+            }
+            f.msgs <- natsMsg{subject, payload[:n]}
+        }
+    }
+}
+
+// dropAll closes every client connection, as a restarting server would.
+func (f *fakeNATS) dropAll() {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    for _, c := range f.conns {
+        c.Close()
+    }
+    f.conns = nil
+}
+
+func (f *fakeNATS) url() string {
+    return "nats://relay:pw@" + f.ln.Addr().String()
+}
+
+func nextNATSMsg(t *testing.T, f *fakeNATS) (string, LifecycleEvent) {
+    t.Helper()
+    select {
+    case m := <-f.msgs:
+        var ev LifecycleEvent
+        if err := json.Unmarshal(m.payload, &ev); err != nil {
+            t.Fatalf("payload %q: %v", m.payload, err)
+        }
+        return m.subject, ev
+    case <-time.After(3 * time.Second):
+        t.Fatal("no PUB received")
+    }
+    return "", LifecycleEvent{}
+}
+
+func TestNATSSinkPublishes(t *testing.T) {
+    srv := startFakeNATS(t, "127.0.0.1:0")
+    sink, err := newNATSSink(srv.url(), "relay.events", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer sink.Close()
+
+    join := LifecycleEvent{Type: eventJoin, Room: "lobby", Username: "alice", Members: 1, Ts: 42}
+    if err := sink.Emit(join); err != nil {
+        t.Fatal(err)
+    }
+    var opts struct {
+        Verbose bool   `json:"verbose"`
+        Name    string `json:"name"`
+        User    string `json:"user"`
+        Pass    string `json:"pass"`
+    }
+    if err := json.Unmarshal([]byte(<-srv.connects), &opts); err != nil {
+        t.Fatal(err)
+    }
+    if opts.User != "relay" || opts.Pass != "pw" || opts.Verbose || opts.Name != "websocket-relay" {
+        t.Fatalf("CONNECT %+v", opts)
+    }
+    subject, ev := nextNATSMsg(t, srv)
+    if subject != "relay.events.join" || ev != join {
+        t.Fatalf("got %s %+v", subject, ev)
+    }
+
+    select {
+    case <-srv.pongs:
+    case <-time.After(2 * time.Second):
+        t.Fatal("PING not answered")
+    }
+
+    summary := LifecycleEvent{Type: eventMessageSummary, Room: "lobby", Messages: 3, Bytes: 30, WindowMs: 1000, Ts: 43}
+    if err := sink.Emit(summary); err != nil {
+        t.Fatal(err)
+    }
+    if subject, ev := nextNATSMsg(t, srv); subject != "relay.events.message_summary" || ev != summary {
+        t.Fatalf("got %s %+v", subject, ev)
+    }
+}
+
+func TestNATSSinkReconnects(t *testing.T) {
+    srv := startFakeNATS(t, "127.0.0.1:0")
+    sink, err := newNATSSink(srv.url(), "relay.events", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer sink.Close()
+    if err := sink.Emit(LifecycleEvent{Type: eventJoin, Room: "a"}); err != nil {
+        t.Fatal(err)
+    }
+    nextNATSMsg(t, srv)
+    <-srv.connects
+
+    // the server drops the connection; the client dials again by itself
+    srv.dropAll()
+    if !waitFor(3*time.Second, func() bool { return len(srv.connects) == 1 }) {
+        t.Fatal("no reconnect")
+    }
+    if err := sink.Emit(LifecycleEvent{Type: eventLeave, Room: "a"}); err != nil {
+        t.Fatal(err)
+    }
+    if subject, _ := nextNATSMsg(t, srv); subject != "relay.events.leave" {
+        t.Fatalf("subject %s", subject)
+    }
+}
+
+func TestNATSSinkStartsBeforeServer(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := ln.Addr().String()
+    ln.Close()
+    sink, err := newNATSSink("nats://"+addr, "relay.events", "")
+    if err != nil {
+        t.Fatalf("an unreachable server failed the sink: %v", err)
+    }
+    defer sink.Close()
+    // buffered until the server comes up
+    if err := sink.Emit(LifecycleEvent{Type: eventJoin, Room: "early"}); err != nil {
+        t.Fatal(err)
+    }
+    srv := startFakeNATS(t, addr)
+    if _, ev := nextNATSMsg(t, srv); ev.Room != "early" {
+        t.Fatalf("got %+v", ev)
+    }
+}
+
+func TestNATSSinkConfig(t *testing.T) {
+    for _, tc := range []struct{ url, subject string }{
+        {"http://nats.internal:4222", "relay.events"},
+        {"nats://", "relay.events"},
+        {"nats://nats.internal", ""},
+        {"nats://nats.internal", "relay.*"},
+        {"nats://nats.internal", "relay events"},
+    } {
+        if _, err := newNATSSink(tc.url, tc.subject, ""); err == nil {
+            t.Errorf("accepted %q %q", tc.url, tc.subject)
+        }
+    }
+    if _, err := newNATSSink("tls://127.0.0.1:1", "relay.events", "/nonexistent/ca.pem"); err == nil {
+        t.Error("accepted a missing CA file")
+    }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "sync"
+)
+
+// Room ordering modes. best_effort (default) fans out straight from the
+// sender's goroutine, so concurrent senders may interleave differently for
+// different recipients, and splits very large rooms across goroutines.
+// strict funnels every message through one broadcaster per room so all
+// recipients observe the same global order.
+const (
+    OrderBestEffort = "best_effort"
+    OrderStrict     = "strict"
+)
+
+// parallelFanoutChunk is the number of recipients handled per goroutine
+// when a best-effort room is large enough to fan out in parallel.
+var parallelFanoutChunk = 512
+
+type pendingBroadcast struct {
+    sender *Client
+    env    Envelope
+}
+
+// strictQueue serializes a room's broadcasts. The broadcaster goroutine runs
+// only while messages are queued, so idle rooms hold no goroutine.
+type strictQueue struct {
+    mu      sync.Mutex
+    queue   []pendingBroadcast
+    running bool
+}
+
+// publish delivers env to the room, and to its sibling shards when the room
+// is a shard of the default room.
+func (r *Room) publish(sender *Client, env Envelope) {
+    if r.shards == nil {
+        r.publishLocal(sender, env)
+        return
+    }
+    env.shared = new(sync.Once)
+    for _, shard := range r.shards {
+        shard.publishLocal(sender, env)
+    }
+}
+
+// publishLocal delivers env to this room using its ordering mode.
+func (r *Room) publishLocal(sender *Client, env Envelope) {
+    if r.strict == nil {
+        r.broadcast(sender, env)
+        return
+    }
+    q := r.strict
+    q.mu.Lock()
+    q.queue = append(q.queue, pendingBroadcast{sender: sender, env: env})
+    if q.running {
+        q.mu.Unlock()
+        return
+    }
+    q.running = true
+    q.mu.Unlock()
+    go r.drainStrict()
+}
+
+func (r *Room) drainStrict() {
+    q := r.strict
+    for {
+        q.mu.Lock()
+        if len(q.queue) == 0 {
+            q.running = false
+            q.mu.Unlock()
+            return
+        }
+        batch := q.queue
+        q.queue = nil
+        q.mu.Unlock()
+        for _, m := range batch {
+            r.broadcast(m.sender, m.env)
+        }
+    }
+}
+
+// fanout hands msg to every recipient, in parallel chunks for large
+// best-effort rooms. The caller holds r.mu for reading.
+func (r *Room) fanout(recipients []*Client, send func(*Client)) {
+    if r.strict != nil || len(recipients) <= parallelFanoutChunk {
+        for _, c := range recipients {
+            send(c)
+        }
+        return
+    }
+    var wg sync.WaitGroup
+    for start := 0; start < len(recipients); start += parallelFanoutChunk {
+        end := min(start+parallelFanoutChunk, len(recipients))
+        wg.Add(1)
+        go func(chunk []*Client) {
+            defer wg.Done()
+            for _, c := range chunk {
+                send(c)
+            }
+        }(recipients[start:end])
+    }
+    wg.Wait()
+}
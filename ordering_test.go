@@ -0,0 +1,110 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func fakeClient(room *Room, name string, buf int) *Client {
+    c := &Client{username: name, room: room, sendCh: make(chan outbound, buf)}
+    room.join(c)
+    return c
+}
+
+func drain(c *Client, n int, timeout time.Duration) []string {
+    var out []string
+    deadline := time.After(timeout)
+    for len(out) < n {
+        select {
+        case o := <-c.sendCh:
+            out = append(out, string(o.msg))
+        case <-deadline:
+            return out
+        }
+    }
+    return out
+}
+
+func TestStrictRoomPreservesGlobalOrder(t *testing.T) {
+    hub := NewHubWithConfig(Config{StrictOrderRooms: "s"})
+    room := hub.getRoom("s")
+    if room.strict == nil {
+        t.Fatal("room s should be strict")
+    }
+    a := fakeClient(room, "a", 4096)
+    b := fakeClient(room, "b", 4096)
+
+    const senders, perSender = 4, 200
+    var wg sync.WaitGroup
+    for s := 0; s < senders; s++ {
+        wg.Add(1)
+        go func(s int) {
+            defer wg.Done()
+            for i := 0; i < perSender; i++ {
+                room.publish(nil, NewEnvelope("s", fmt.Sprintf("sender%d", s), []byte(fmt.Sprint(i))))
+            }
+        }(s)
+    }
+    wg.Wait()
+
+    gotA := drain(a, senders*perSender, 2*time.Second)
+    gotB := drain(b, senders*perSender, 2*time.Second)
+    if len(gotA) != senders*perSender || len(gotB) != senders*perSender {
+        t.Fatalf("delivered %d/%d messages, want %d each", len(gotA), len(gotB), senders*perSender)
+    }
+    for i := range gotA {
+        if gotA[i] != gotB[i] {
+            t.Fatalf("recipients disagree on order at %d: %s vs %s", i, gotA[i], gotB[i])
+        }
+    }
+}
+
+func TestBestEffortParallelFanout(t *testing.T) {
+    defer func(prev int) { parallelFanoutChunk = prev }(parallelFanoutChunk)
+    parallelFanoutChunk = 4
+
+    hub := NewHub()
+    room := hub.getRoom("big")
+    if room.strict != nil {
+        t.Fatal("rooms default to best-effort")
+    }
+    var clients []*Client
+    for i := 0; i < 50; i++ {
+        clients = append(clients, fakeClient(room, fmt.Sprintf("c%d", i), 4))
+    }
+    room.publish(clients[0], NewEnvelope("big", "c0", []byte("hi")))
+    if n := len(clients[0].sendCh); n != 0 {
+        t.Fatalf("sender received its own message")
+    }
+    for _, c := range clients[1:] {
+        if len(c.sendCh) != 1 {
+            t.Fatalf("%s received %d messages, want 1", c.username, len(c.sendCh))
+        }
+    }
+}
+
+func TestStrictRoomRelaysOverWebSocket(t *testing.T) {
+    hub := NewHubWithConfig(Config{StrictOrderRooms: "s"})
+    base := startTestServer(t, hub)
+    sender := dialWS(t, base+"/ws/s/alice")
+    receiver := dialWS(t, base+"/ws/s/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "s") == 2 })
+    for i := 0; i < 5; i++ {
+        if err := sender.WriteMessage(websocket.BinaryMessage, []byte(fmt.Sprint(i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := readEnvelopes(t, receiver, 300*time.Millisecond)
+    if len(got) != 5 {
+        t.Fatalf("got %d messages, want 5", len(got))
+    }
+    for i, env := range got {
+        if string(env.Payload) != fmt.Sprint(i) {
+            t.Fatalf("message %d = %s", i, env.Payload)
+        }
+    }
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+    "strings"
+
+    "github.com/gorilla/websocket"
+)
+
+// Origin policy. ALLOWED_ORIGIN is enforced on the upgrade itself, not only
+// advertised in CORS headers: "*" admits every origin, anything else must
+// match the request's Origin header on scheme and host (case-insensitive).
+// Requests without an Origin header come from non-browser clients, which
+// could send any Origin they liked, and are admitted.
+
+// originAllowed reports whether origin may open a socket under allowed.
+func originAllowed(origin, allowed string) bool {
+    if allowed == "" || allowed == "*" || origin == "" {
+        return true
+    }
+    o, err := url.Parse(origin)
+    if err != nil {
+        return false
+    }
+    a, err := url.Parse(allowed)
+    if err != nil {
+        return false
+    }
+    return strings.EqualFold(o.Scheme, a.Scheme) && strings.EqualFold(o.Host, a.Host)
+}
+
+// newUpgrader builds the upgrader for a server with the given origin policy.
+func newUpgrader(allowedOrigin string) *websocket.Upgrader {
+    return &websocket.Upgrader{
+        ReadBufferSize:    8192,
+        WriteBufferSize:   8192,
+        EnableCompression: true, // writes stay uncompressed until the client asks, see compress.go
+        Subprotocols:      []string{binaryControlProtocol},
+        CheckOrigin: func(r *http.Request) bool {
+            return originAllowed(r.Header.Get("Origin"), allowedOrigin)
+        },
+    }
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestOriginAllowed(t *testing.T) {
+    for _, tc := range []struct {
+        origin, allowed string
+        want            bool
+    }{
+        {"https://evil.example", "*", true},
+        {"https://app.example.com", "https://app.example.com", true},
+        {"HTTPS://App.Example.com", "https://app.example.com", true},
+        {"http://app.example.com", "https://app.example.com", false},
+        {"https://app.example.com:8443", "https://app.example.com", false},
+        {"https://evil.example", "https://app.example.com", false},
+        {"", "https://app.example.com", true},
+    } {
+        if got := originAllowed(tc.origin, tc.allowed); got != tc.want {
+            t.Errorf("originAllowed(%q, %q) = %v, want %v", tc.origin, tc.allowed, got, tc.want)
+        }
+    }
+}
+
+func TestUpgradeEnforcesAllowedOrigin(t *testing.T) {
+    dial := func(allowed, origin string) (*http.Response, error) {
+        mux := http.NewServeMux()
+        mux.HandleFunc("/ws/", HandleWebSocket(NewHubWithConfig(Config{}), allowed))
+        ts := httptest.NewServer(mux)
+        defer ts.Close()
+        c, resp, err := websocket.DefaultDialer.Dial("ws"+ts.URL[len("http"):]+"/ws/lobby/alice", http.Header{"Origin": {origin}})
+        if err == nil {
+            c.Close()
+        }
+        return resp, err
+    }
+
+    if _, err := dial("*", "https://anywhere.example"); err != nil {
+        t.Fatalf("wildcard refused: %v", err)
+    }
+    if _, err := dial("https://app.example.com", "https://app.example.com"); err != nil {
+        t.Fatalf("exact match refused: %v", err)
+    }
+    resp, err := dial("https://app.example.com", "https://evil.example")
+    if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+        t.Fatalf("cross-origin upgrade: err=%v resp=%v, want 403", err, resp)
+    }
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// Send queue overflow policies for OVERFLOW_POLICY: what a broadcast does
+// when a recipient's queue (SEND_BUFFER_SIZE messages) is full. drop_new
+// refuses the new message; drop_old discards the oldest queued one to make
+// room, which suits chat where the latest matters most; close disconnects
+// the slow consumer, for channels that must not lose messages silently.
+// Whatever is dropped is counted and dead-lettered as queue_full. A
+// connection can choose its own policy with ?overflow=.
+const (
+    OverflowDropNew = "drop_new"
+    OverflowDropOld = "drop_old"
+    OverflowClose   = "close"
+)
+
+func (cfg Config) sendBufferSize() int {
+    if cfg.SendBufferSize > 0 {
+        return cfg.SendBufferSize
+    }
+    return 256
+}
+
+// validOverflowPolicy checks OVERFLOW_POLICY; an unknown value is an error
+// rather than a silent drop_new.
+func validOverflowPolicy(p string) error {
+    switch p {
+    case OverflowDropNew, OverflowDropOld, OverflowClose:
+        return nil
+    }
+    return fmt.Errorf("unknown overflow policy %q (want %s, %s or %s)", p, OverflowDropNew, OverflowDropOld, OverflowClose)
+}
+
+// parseOverflowPolicy validates the ?overflow query value, with which a
+// connection picks its own policy; empty means the server's.
+func parseOverflowPolicy(s string) (string, error) {
+    if s == "" {
+        return "", nil
+    }
+    if err := validOverflowPolicy(s); err != nil {
+        return "", err
+    }
+    return s, nil
+}
+
+// offer queues a broadcast message for c, applying c's overflow policy
+// when c's queue is full. High-priority clients spill instead, see
+// priority.go.
+func (c *Client) offer(o outbound) {
+    if c.priority == priorityHigh {
+        c.spill(o)
+        return
+    }
+    if c.enqueue(o) {
+        return
+    }
+    policy := c.overflow
+    if policy == "" {
+        policy = c.room.hub.cfg.OverflowPolicy
+    }
+    switch policy {
+    case OverflowDropOld:
+        if old, ok := c.popOldest(o); ok {
+            c.dropQueueFull(old)
+            if c.enqueue(o) {
+                return
+            }
+        }
+    case OverflowClose:
+        if !c.dead.Load() {
+            log.Printf("closing slow consumer: room=%s user=%s", c.room.name, c.username)
+            c.drainAndClose(websocket.ClosePolicyViolation, "send queue overflow", time.Now())
+        }
+    }
+    c.dropQueueFull(o)
+}
+
+// popOldest takes the oldest message off the queue o would go to.
+func (c *Client) popOldest(o outbound) (outbound, bool) {
+    ch := c.sendCh
+    if c.bulkCh != nil && len(o.msg) > c.bulkOver {
+        ch = c.bulkCh
+    }
+    select {
+    case old := <-ch:
+        c.queued.Add(-int64(len(old.msg)))
+        return old, true
+    default:
+        return outbound{}, false
+    }
+}
+
+func (c *Client) dropQueueFull(o outbound) {
+    c.countDrop()
+    if o.env != nil {
+        c.room.hub.dead.add(dropQueueFull, c.room.name, o.env.Username, c.username, o.env.Payload)
+    }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "fmt"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// overflowRoom broadcasts four messages to a stalled consumer whose queue
+// holds two, returning the payloads it ends up with and those dead-lettered.
+func overflowRoom(t *testing.T, policy string) (kept, dropped []string) {
+    t.Helper()
+    hub := NewHubWithConfig(Config{OverflowPolicy: policy})
+    dead := make(chan DeadLetter, 8)
+    hub.dead = newDeadLetterSink(8, func(dl DeadLetter) { dead <- dl })
+    room := hub.getRoom("overflow")
+    sender := fakeClient(room, "sender", 8)
+    stalled := fakeClient(room, "stalled", 2)
+    for i := 1; i <= 4; i++ {
+        room.broadcast(sender, NewEnvelope("overflow", "sender", []byte(fmt.Sprintf("m%d", i))))
+    }
+    for len(stalled.sendCh) > 0 {
+        kept = append(kept, string((<-stalled.sendCh).env.Payload))
+    }
+    for len(dropped) < 2 {
+        select {
+        case dl := <-dead:
+            if dl.Reason != dropQueueFull || dl.To != "stalled" {
+                t.Fatalf("dead letter %+v", dl)
+            }
+            dropped = append(dropped, string(dl.Payload))
+        case <-time.After(time.Second):
+            t.Fatalf("dead letters %v, want 2", dropped)
+        }
+    }
+    if n := stalled.counters.drops.Load(); n != 2 {
+        t.Fatalf("drops = %d, want 2", n)
+    }
+    return kept, dropped
+}
+
+func TestOverflowDropNew(t *testing.T) {
+    kept, dropped := overflowRoom(t, OverflowDropNew)
+    if !reflect.DeepEqual(kept, []string{"m1", "m2"}) || !reflect.DeepEqual(dropped, []string{"m3", "m4"}) {
+        t.Fatalf("kept %v, dropped %v", kept, dropped)
+    }
+}
+
+func TestOverflowDropOld(t *testing.T) {
+    kept, dropped := overflowRoom(t, OverflowDropOld)
+    if !reflect.DeepEqual(kept, []string{"m3", "m4"}) || !reflect.DeepEqual(dropped, []string{"m1", "m2"}) {
+        t.Fatalf("kept %v, dropped %v", kept, dropped)
+    }
+}
+
+func TestOverflowCloseDisconnectsSlowConsumer(t *testing.T) {
+    // pacing stalls the writer, so the two-message queue fills at once
+    hub := NewHubWithConfig(Config{OverflowPolicy: OverflowClose, SendBufferSize: 2, SendPaceBytes: 50})
+    base := startTestServer(t, hub)
+    stalled := dialWS(t, base+"/ws/overflow/stalled")
+    sender := dialWS(t, base+"/ws/overflow/sender")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "overflow") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+    for i := 0; i < 6; i++ {
+        if err := sender.WriteMessage(websocket.TextMessage, []byte("flood")); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if ce := expectClose(t, stalled); ce.Code != websocket.ClosePolicyViolation {
+        t.Fatalf("close code %d, want %d", ce.Code, websocket.ClosePolicyViolation)
+    }
+    if !waitFor(2*time.Second, func() bool { return roomSize(hub, "overflow") == 1 }) {
+        t.Fatal("slow consumer still in the room")
+    }
+}
+
+func TestOverflowPolicyPerConnection(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    room := hub.getRoom("mixed")
+    sender := fakeClient(room, "sender", 8)
+    fresh := fakeClient(room, "fresh", 2)
+    fresh.overflow = OverflowDropOld
+    steady := fakeClient(room, "steady", 2) // server default, drop_new
+    for i := 1; i <= 5; i++ {
+        room.broadcast(sender, NewEnvelope("mixed", "sender", []byte(fmt.Sprintf("m%d", i))))
+    }
+    queued := func(c *Client) []string {
+        var out []string
+        for len(c.sendCh) > 0 {
+            out = append(out, string((<-c.sendCh).env.Payload))
+        }
+        return out
+    }
+    if got := queued(fresh); !reflect.DeepEqual(got, []string{"m4", "m5"}) {
+        t.Fatalf("drop_old connection kept %v", got)
+    }
+    if got := queued(steady); !reflect.DeepEqual(got, []string{"m1", "m2"}) {
+        t.Fatalf("drop_new connection kept %v", got)
+    }
+    if fresh.counters.drops.Load() != 3 || steady.counters.drops.Load() != 3 {
+        t.Fatalf("drops %d and %d, want 3 each", fresh.counters.drops.Load(), steady.counters.drops.Load())
+    }
+}
+
+func TestOverflowQueryValidated(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/q/a?overflow=drop_old")
+    _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/q/b?overflow=drop-oldest", nil)
+    if err == nil || resp == nil || resp.StatusCode != 400 {
+        t.Fatalf("unknown policy accepted: %v", err)
+    }
+}
+
+func TestValidOverflowPolicy(t *testing.T) {
+    for _, p := range []string{OverflowDropNew, OverflowDropOld, OverflowClose} {
+        if err := validOverflowPolicy(p); err != nil {
+            t.Errorf("%s: %v", p, err)
+        }
+        if got, err := parseOverflowPolicy(p); err != nil || got != p {
+            t.Errorf("?overflow=%s: %q, %v", p, got, err)
+        }
+    }
+    for _, p := range []string{"", "drop-new", "DROP_OLD", "disconnect"} {
+        if err := validOverflowPolicy(p); err == nil {
+            t.Errorf("%q accepted", p)
+        }
+    }
+    if got, err := parseOverflowPolicy(""); err != nil || got != "" {
+        t.Errorf("empty ?overflow: %q, %v, want the server default", got, err)
+    }
+    if _, err := parseOverflowPolicy("drop-new"); err == nil {
+        t.Error("unknown ?overflow accepted")
+    }
+}
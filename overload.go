@@ -0,0 +1,51 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// Overload rejections. When a limit turns a connection away, the client
+// is told when to come back: upgrades are refused with a Retry-After header
+// (whole seconds, at least 1) and the same hint in the body, so well-behaved
+// clients back off instead of retrying in a tight loop. Limits only checked
+// after the upgrade (room capacity, rooms per identity) close the socket
+// instead, with a JSON close reason carrying the same hint in milliseconds.
+
+// limitRetryAfter is the hint for limits that free up when someone leaves,
+// which has no schedule to point at.
+const limitRetryAfter = time.Second
+
+// retryAfterSeconds rounds d up to the whole seconds of a Retry-After header.
+func retryAfterSeconds(d time.Duration) int {
+    return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// rejectOverload refuses an upgrade because a limit was hit.
+func rejectOverload(w http.ResponseWriter, status int, msg string, retry time.Duration) {
+    secs := retryAfterSeconds(retry)
+    w.Header().Set("Retry-After", strconv.Itoa(secs))
+    http.Error(w, fmt.Sprintf("%s; retry after %ds", msg, secs), status)
+}
+
+// OverloadReason is the close reason sent to a connection refused by a
+// limit after the upgrade. It leaves out the room name to stay within the
+// 123 bytes a close reason may hold.
+type OverloadReason struct {
+    Code         string `json:"code"`
+    MaxClients   int    `json:"max_clients,omitempty"` // room_full
+    MaxRooms     int    `json:"max_rooms,omitempty"`   // room_limit
+    RetryAfterMs int    `json:"retry_after_ms"`
+}
+
+// overloadCloseReason renders reason with the retry hint rejectOverload
+// would send for retry.
+func overloadCloseReason(reason OverloadReason, retry time.Duration) string {
+    reason.RetryAfterMs = retryAfterSeconds(retry) * 1000
+    b, _ := json.Marshal(reason)
+    return string(b)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestRetryAfterSeconds(t *testing.T) {
+    cases := map[time.Duration]int{
+        0:                       1,
+        200 * time.Millisecond:  1,
+        time.Second:             1,
+        1500 * time.Millisecond: 2,
+        10 * time.Second:        10,
+    }
+    for d, want := range cases {
+        if got := retryAfterSeconds(d); got != want {
+            t.Errorf("retryAfterSeconds(%v) = %d, want %d", d, got, want)
+        }
+    }
+}
+
+func TestRejectOverload(t *testing.T) {
+    rec := httptest.NewRecorder()
+    rejectOverload(rec, http.StatusTooManyRequests, "slow down", 2500*time.Millisecond)
+    if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3" {
+        t.Fatalf("got %d with Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+    }
+    if body := rec.Body.String(); !strings.Contains(body, "slow down; retry after 3s") {
+        t.Fatalf("body %q lacks the retry hint", body)
+    }
+}
+
+func TestOverloadCloseReasonMatchesRetryAfter(t *testing.T) {
+    got := overloadCloseReason(OverloadReason{Code: "room_full", MaxClients: 3}, 2500*time.Millisecond)
+    if got != `{"code":"room_full","max_clients":3,"retry_after_ms":3000}` {
+        t.Fatalf("close reason %s, want the 3s Retry-After hint in ms", got)
+    }
+    if len(overloadCloseReason(OverloadReason{Code: "room_limit", MaxRooms: 1 << 30}, time.Hour)) > 123 {
+        t.Fatal("close reason over the 123-byte limit")
+    }
+}
+
+func TestConnectLimiterRetryAfter(t *testing.T) {
+    l := newConnectLimiter(0, 2)
+    l.allow("10.0.0.1")
+    l.allow("10.0.0.1")
+    if d := l.retryAfter("10.0.0.1"); d <= 0 || d > 500*time.Millisecond {
+        t.Fatalf("retryAfter = %v, want about one token at 2/s", d)
+    }
+    if d := l.retryAfter("10.0.0.2"); d != 0 {
+        t.Fatalf("fresh IP retryAfter = %v, want 0", d)
+    }
+}
+
+// Every limit that refuses an upgrade must say when to retry.
+func TestOverloadRejectionsCarryRetryAfter(t *testing.T) {
+    cases := []struct {
+        name   string
+        cfg    Config
+        prime  func(t *testing.T, hub *Hub, base string)
+        status int
+    }{
+        {"connect rate", Config{ConnectRatePerIP: 1}, func(t *testing.T, hub *Hub, base string) {
+            dialWS(t, base+"/ws/r/first")
+        }, http.StatusTooManyRequests},
+        {"memory limit", Config{MemorySoftLimit: 1, MemoryInterval: 3 * time.Second}, func(t *testing.T, hub *Hub, base string) {
+            hub.getRoom("r")
+            hub.updateMemoryEstimate()
+        }, http.StatusServiceUnavailable},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            hub := NewHubWithConfig(tc.cfg)
+            base := startTestServer(t, hub)
+            tc.prime(t, hub, base)
+            _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/r/late", nil)
+            if err == nil || resp == nil || resp.StatusCode != tc.status {
+                t.Fatalf("expected %d, got %v (%v)", tc.status, resp, err)
+            }
+            if ra := resp.Header.Get("Retry-After"); ra == "" || ra == "0" {
+                t.Fatalf("Retry-After = %q", ra)
+            }
+        })
+    }
+}
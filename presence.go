@@ -0,0 +1,51 @@
+package main
+
+import (
+    "encoding/json"
+    "time"
+)
+
+// Presence notifications (PRESENCE_ROOMS). In these rooms every join and
+// leave is announced to the other members as a system envelope with type
+// "presence" and the payload {"event":"join"|"leave","username":...}.
+// Like error and stats frames it is always sent in the v1 JSON format,
+// whatever envelope version the recipient chose.
+
+const (
+    presenceJoin  = "join"
+    presenceLeave = "leave"
+)
+
+// PresenceEvent is the payload of a presence envelope.
+type PresenceEvent struct {
+    Event    string `json:"event"`
+    Username string `json:"username"`
+    ClientID string `json:"client_id,omitempty"` // with CLIENT_ID_SECRET
+    Code     int    `json:"code,omitempty"`   // leave only, with PRESENCE_CLOSE_REASON: the client's close code
+    Reason   string `json:"reason,omitempty"` // and the reason it gave
+}
+
+// MarshalPresence renders the presence envelope for user's event in room.
+func MarshalPresence(room, user, event string) []byte {
+    return marshalPresenceEvent(room, PresenceEvent{Event: event, Username: user})
+}
+
+func marshalPresenceEvent(room string, ev PresenceEvent) []byte {
+    body, _ := json.Marshal(ev)
+    return renderEnvelopeV1(Envelope{Type: envelopePresence, Room: room, Username: ev.Username, Ts: time.Now().UnixNano(), Payload: body})
+}
+
+// announce tells r's members other than c that c joined or left, across
+// all shards of the default room.
+func (r *Room) announce(c *Client, event string) {
+    ev := PresenceEvent{Event: event, Username: c.username, ClientID: c.id}
+    if event == presenceLeave && c.closedBy != nil && r.hub.cfg.PresenceCloseReason {
+        ev.Code, ev.Reason = c.closedBy.code, c.closedBy.reason
+    }
+    msg := marshalPresenceEvent(r.logicalName(), ev)
+    for _, m := range r.groupMembers() {
+        if m != c {
+            m.trySend(msg)
+        }
+    }
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestPresenceJoinAndLeave(t *testing.T) {
+    hub := NewHubWithConfig(Config{PresenceRooms: "lobby"})
+    base := startTestServer(t, hub)
+    bob := dialWS(t, base+"/ws/lobby/bob")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 1 }) {
+        t.Fatal("bob did not join")
+    }
+    alice := dialWS(t, base+"/ws/lobby/alice")
+
+    // one envelope at a time: a read that times out spoils the connection
+    next := func() Envelope {
+        t.Helper()
+        bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+        var env Envelope
+        if err := bob.ReadJSON(&env); err != nil {
+            t.Fatal(err)
+        }
+        return env
+    }
+    expectPresence := func(event string) {
+        t.Helper()
+        got := next()
+        if got.Type != envelopePresence || got.Room != "lobby" || got.Username != "alice" {
+            t.Fatalf("bob got %+v, want alice's %s", got, event)
+        }
+        var ev PresenceEvent
+        if err := json.Unmarshal(got.Payload, &ev); err != nil || ev != (PresenceEvent{Event: event, Username: "alice"}) {
+            t.Fatalf("presence payload %s", got.Payload)
+        }
+    }
+    expectPresence(presenceJoin)
+
+    if err := alice.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+        t.Fatal(err)
+    }
+    if got := next(); got.Type != envelopeMessage || string(got.Payload) != "hi" {
+        t.Fatalf("bob got %+v, want alice's message", got)
+    }
+    // alice is not told of her own join
+    if got := readEnvelopes(t, alice, 200*time.Millisecond); len(got) != 0 {
+        t.Fatalf("alice got %+v", got)
+    }
+
+    alice.Close()
+    expectPresence(presenceLeave)
+}
+
+func TestNoPresenceOutsidePresenceRooms(t *testing.T) {
+    hub := NewHubWithConfig(Config{PresenceRooms: "lobby"})
+    base := startTestServer(t, hub)
+    bob := dialWS(t, base+"/ws/quiet/bob")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "quiet") == 1 }) {
+        t.Fatal("bob did not join")
+    }
+    dialWS(t, base+"/ws/quiet/alice").Close()
+    if got := readEnvelopes(t, bob, 300*time.Millisecond); len(got) != 0 {
+        t.Fatalf("bob got %+v", got)
+    }
+}
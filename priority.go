@@ -0,0 +1,124 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "fmt"
+)
+
+// Connection priorities. A high-priority (VIP) connection is one whose
+// username is in VIP_USERS or whose ?role= is in VIP_ROLES, and which
+// proves it with ?vip=<proof>, the HMAC of its username and role under
+// VIP_SECRET (see vipProof), issued by whoever vouches for the identity;
+// usernames and roles alone are whatever the client says they are. A VIP
+// does not have broadcasts dropped for a full queue: what does not fit its
+// send queue is spilled to an overflow list that the writer drains in order
+// once the queue empties. Broadcasts never block on a VIP, so congestion
+// there costs memory rather than delivery, up to VIP_SPILL_MAX messages;
+// beyond that they are dropped as "spill_full" dead letters. Normal
+// connections keep their overflow policy. A VIP whose socket dies still
+// loses its backlog.
+const (
+    priorityNormal = iota
+    priorityHigh
+)
+
+const (
+    dropSpillFull   = "spill_full"
+    defaultSpillMax = 10000
+)
+
+// validVIPConfig refuses VIP lists without a VIP_SECRET to check claims to
+// them against.
+func validVIPConfig(cfg Config) error {
+    if (cfg.VIPUsers != "" || cfg.VIPRoles != "") && cfg.VIPSecret == "" {
+        return fmt.Errorf("VIP_USERS and VIP_ROLES need VIP_SECRET")
+    }
+    return nil
+}
+
+// vipProof is the ?vip= value that lets username, connecting with role,
+// claim the priority VIP_USERS or VIP_ROLES grant it.
+func vipProof(secret, username, role string) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(username + "\x00" + role))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// priorityFor reports the priority of a connection by its username and
+// role, high only when proof vouches for both.
+func (cfg Config) priorityFor(username, role, proof string) int {
+    if !inList(cfg.VIPUsers, username) && (role == "" || !inList(cfg.VIPRoles, role)) {
+        return priorityNormal
+    }
+    if cfg.VIPSecret == "" || !hmac.Equal([]byte(proof), []byte(vipProof(cfg.VIPSecret, username, role))) {
+        return priorityNormal
+    }
+    return priorityHigh
+}
+
+func (cfg Config) spillMax() int {
+    if cfg.VIPSpillMax > 0 {
+        return cfg.VIPSpillMax
+    }
+    return defaultSpillMax
+}
+
+// spill queues o for a high-priority client, behind anything already
+// spilled so delivery stays in order, and wakes the writer.
+func (c *Client) spill(o outbound) {
+    c.spillMu.Lock()
+    if len(c.spilled) == 0 && c.enqueue(o) {
+        c.spillMu.Unlock()
+        return
+    }
+    var reason string
+    switch {
+    case c.dead.Load():
+        reason = dropDeadSocket
+    case len(c.spilled) >= c.room.hub.cfg.spillMax():
+        reason = dropSpillFull
+    }
+    if reason != "" {
+        c.spillMu.Unlock()
+        if o.env != nil {
+            c.countDrop()
+            c.room.hub.dead.add(reason, o.env.Room, o.env.Username, c.username, o.env.Payload)
+        }
+        return
+    }
+    c.spilled = append(c.spilled, o)
+    c.queued.Add(int64(len(o.msg)))
+    c.spillMu.Unlock()
+    select {
+    case c.spillReady <- struct{}{}:
+    default:
+    }
+}
+
+// takeSpilled empties the spill list, returning what was on it. The
+// messages still count as queued until written or dropped.
+func (c *Client) takeSpilled() []outbound {
+    c.spillMu.Lock()
+    defer c.spillMu.Unlock()
+    out := c.spilled
+    c.spilled = nil
+    return out
+}
+
+// writeSpilled writes the regular queue, which holds only messages older
+// than the spill list, and then the spill list. Only the writer calls it.
+func (c *Client) writeSpilled() error {
+    for len(c.sendCh) > 0 {
+        if err := c.writeQueued(<-c.sendCh); err != nil {
+            return err
+        }
+    }
+    for _, o := range c.takeSpilled() {
+        if err := c.writeQueued(o); err != nil {
+            return err
+        }
+    }
+    return nil
+}
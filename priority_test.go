@@ -0,0 +1,143 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+)
+
+func TestPriorityFor(t *testing.T) {
+    cfg := Config{VIPUsers: "alice,carol", VIPRoles: "ops", VIPSecret: "s3cret"}
+    proof := func(user, role string) string { return vipProof(cfg.VIPSecret, user, role) }
+    cases := []struct {
+        user, role, proof string
+        want              int
+    }{
+        {"alice", "", proof("alice", ""), priorityHigh},
+        {"bob", "ops", proof("bob", "ops"), priorityHigh},
+        {"alice", "", "", priorityNormal},                             // claimed, not proven
+        {"alice", "", proof("carol", ""), priorityNormal},             // another user's proof
+        {"bob", "ops", proof("bob", "viewer"), priorityNormal},        // proof for another role
+        {"alice", "", vipProof("other", "alice", ""), priorityNormal}, // wrong secret
+        {"bob", "", proof("bob", ""), priorityNormal},                 // proven, but not a VIP
+        {"bob", "viewer", proof("bob", "viewer"), priorityNormal},
+    }
+    for _, tc := range cases {
+        if got := cfg.priorityFor(tc.user, tc.role, tc.proof); got != tc.want {
+            t.Errorf("priorityFor(%q, %q, %q) = %d, want %d", tc.user, tc.role, tc.proof, got, tc.want)
+        }
+    }
+    if got := (Config{VIPUsers: "alice"}).priorityFor("alice", "", proof("alice", "")); got != priorityNormal {
+        t.Error("VIP granted without VIP_SECRET")
+    }
+}
+
+func TestValidVIPConfig(t *testing.T) {
+    for _, cfg := range []Config{{VIPUsers: "alice"}, {VIPRoles: "ops"}} {
+        if validVIPConfig(cfg) == nil {
+            t.Errorf("%+v accepted without VIP_SECRET", cfg)
+        }
+    }
+    if err := validVIPConfig(Config{VIPUsers: "alice", VIPSecret: "s"}); err != nil {
+        t.Error(err)
+    }
+    if err := validVIPConfig(Config{}); err != nil {
+        t.Error(err)
+    }
+}
+
+func TestVIPSpillIsCapped(t *testing.T) {
+    hub := NewHubWithConfig(Config{VIPSpillMax: 10})
+    dead := make(chan DeadLetter, 64)
+    hub.dead = newDeadLetterSink(64, func(dl DeadLetter) { dead <- dl })
+    room := hub.getRoom("tiers")
+    sender := fakeClient(room, "sender", 8)
+    vip := &Client{username: "vip", room: room, sendCh: make(chan outbound, 4),
+        priority: priorityHigh, spillReady: make(chan struct{}, 1)}
+    room.join(vip)
+
+    for i := 0; i < 20; i++ {
+        room.broadcast(sender, NewEnvelope("tiers", "sender", []byte(fmt.Sprintf("m%d", i))))
+    }
+    if n := len(vip.takeSpilled()); n != 10 {
+        t.Fatalf("spilled %d, want the cap of 10", n)
+    }
+    if got := vip.counters.drops.Load(); got != 6 {
+        t.Fatalf("vip drops = %d, want the 6 past queue and spill", got)
+    }
+    for i := 0; i < 6; i++ {
+        select {
+        case dl := <-dead:
+            if dl.Reason != dropSpillFull || dl.To != "vip" {
+                t.Fatalf("dead letter %+v", dl)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("missing spill_full dead letter")
+        }
+    }
+}
+
+func TestVIPReceivesEverythingUnderOverload(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    room := hub.getRoom("tiers")
+    sender := fakeClient(room, "sender", 8)
+    normal := fakeClient(room, "normal", 4)
+    vip := &Client{username: "vip", room: room, sendCh: make(chan outbound, 4),
+        priority: priorityHigh, spillReady: make(chan struct{}, 1)}
+    room.join(vip)
+
+    const n = 50
+    for i := 0; i < n; i++ {
+        room.broadcast(sender, NewEnvelope("tiers", "sender", []byte(fmt.Sprintf("m%d", i))))
+    }
+
+    if got := len(normal.sendCh); got != 4 {
+        t.Fatalf("normal client holds %d, want 4", got)
+    }
+    if got := normal.counters.drops.Load(); got != n-4 {
+        t.Fatalf("normal client drops = %d, want %d", got, n-4)
+    }
+    if got := vip.counters.drops.Load(); got != 0 {
+        t.Fatalf("vip drops = %d", got)
+    }
+    var got []string
+    for len(vip.sendCh) > 0 {
+        got = append(got, string((<-vip.sendCh).env.Payload))
+    }
+    for _, o := range vip.takeSpilled() {
+        got = append(got, string(o.env.Payload))
+    }
+    if len(got) != n {
+        t.Fatalf("vip got %d messages, want %d", len(got), n)
+    }
+    for i, p := range got {
+        if want := fmt.Sprintf("m%d", i); p != want {
+            t.Fatalf("vip message %d = %q, want %q", i, p, want)
+        }
+    }
+}
+
+func TestVIPConnectionDrainsSpillInOrder(t *testing.T) {
+    hub := NewHubWithConfig(Config{VIPUsers: "vip", VIPSecret: "s3cret", SendBufferSize: 1})
+    base := startTestServer(t, hub)
+    ws := dialWS(t, base+"/ws/tiers/vip?vip="+vipProof("s3cret", "vip", ""))
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "tiers") == 1 }) {
+        t.Fatal("vip did not join")
+    }
+    room := hub.getRoom("tiers")
+    sender := fakeClient(room, "sender", 8)
+
+    const n = 200
+    for i := 0; i < n; i++ {
+        room.broadcast(sender, NewEnvelope("tiers", "sender", []byte(fmt.Sprintf("m%d", i))))
+    }
+    got := readEnvelopes(t, ws, 500*time.Millisecond)
+    if len(got) != n {
+        t.Fatalf("vip got %d messages, want %d", len(got), n)
+    }
+    for i, env := range got {
+        if want := fmt.Sprintf("m%d", i); string(env.Payload) != want {
+            t.Fatalf("vip message %d = %q, want %q", i, env.Payload, want)
+        }
+    }
+}
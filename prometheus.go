@@ -0,0 +1,112 @@
+package main
+
+import (
+    "log"
+    "net/http"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsHandler serves hub metrics to Prometheus from a registry of the
+// hub's own, so nothing else registered in the process (the default
+// registry's Go runtime collectors included) ends up in the scrape.
+func metricsHandler(hub *Hub) http.HandlerFunc {
+    reg := prometheus.NewRegistry()
+    reg.MustRegister(newHubCollector(hub))
+    return promhttp.HandlerFor(reg, promhttp.HandlerOpts{ErrorLog: log.Default()}).ServeHTTP
+}
+
+// hubCollector reports the hub at scrape time: counters from hubMetrics,
+// the event stream and METRIC_LABELS series, gauges from live hub state.
+// Families whose feature is off are described but not collected.
+type hubCollector struct {
+    hub *Hub
+
+    connectsTotal, activeConns, roomsActive      *prometheus.Desc
+    broadcasts, broadcastBytes, drops, cutoffs   *prometheus.Desc
+    eventsPublished, eventsDropped, eventsFailed *prometheus.Desc
+    udpRejected                                  *prometheus.Desc
+    labeled                                      []labeledFamily
+}
+
+// labeledFamily is a relay_labeled_* family and how to read it off a series.
+type labeledFamily struct {
+    desc  *prometheus.Desc
+    kind  prometheus.ValueType
+    value func(*labelSeries) int64
+}
+
+func newHubCollector(hub *Hub) *hubCollector {
+    desc := func(name, help string, labels ...string) *prometheus.Desc {
+        return prometheus.NewDesc(name, help, labels, nil)
+    }
+    c := &hubCollector{
+        hub:             hub,
+        connectsTotal:   desc("relay_connections_total", "WebSocket connections accepted."),
+        activeConns:     desc("relay_active_connections", "WebSocket connections currently open."),
+        roomsActive:     desc("relay_rooms_active", "Rooms currently held by the hub."),
+        broadcasts:      desc("relay_messages_broadcast_total", "Messages fanned out to a room."),
+        broadcastBytes:  desc("relay_bytes_broadcast_total", "Payload bytes fanned out to a room."),
+        drops:           desc("relay_dropped_messages_total", "Messages dropped for a recipient."),
+        cutoffs:         desc("relay_room_egress_cutoffs_total", "Times a room hit its egress cap."),
+        eventsPublished: desc("relay_events_published_total", "Lifecycle events accepted by the event sink."),
+        eventsDropped:   desc("relay_events_dropped_total", "Lifecycle events dropped with the event buffer full."),
+        eventsFailed:    desc("relay_events_failed_total", "Lifecycle events the event sink failed to publish."),
+        udpRejected:     desc("relay_udp_frames_rejected_total", "UDP datagrams refused as malformed or oversized.", "reason"),
+    }
+    if lm := hub.labels; lm != nil {
+        family := func(name, help string, kind prometheus.ValueType, value func(*labelSeries) int64) {
+            c.labeled = append(c.labeled, labeledFamily{desc(name, help, lm.keys...), kind, value})
+        }
+        family("relay_labeled_connections_total", "WebSocket connections accepted, by connection label.", prometheus.CounterValue, func(s *labelSeries) int64 { return s.connects.Load() })
+        family("relay_labeled_active_connections", "WebSocket connections currently open, by connection label.", prometheus.GaugeValue, func(s *labelSeries) int64 { return s.connections.Load() })
+        family("relay_labeled_messages_received_total", "Frames read from clients, by connection label.", prometheus.CounterValue, func(s *labelSeries) int64 { return s.msgsIn.Load() })
+        family("relay_labeled_bytes_received_total", "Bytes read from clients, by connection label.", prometheus.CounterValue, func(s *labelSeries) int64 { return s.bytesIn.Load() })
+    }
+    return c
+}
+
+func (c *hubCollector) Describe(ch chan<- *prometheus.Desc) {
+    for _, d := range []*prometheus.Desc{
+        c.connectsTotal, c.activeConns, c.roomsActive,
+        c.broadcasts, c.broadcastBytes, c.drops, c.cutoffs,
+        c.eventsPublished, c.eventsDropped, c.eventsFailed,
+        c.udpRejected,
+    } {
+        ch <- d
+    }
+    for _, f := range c.labeled {
+        ch <- f.desc
+    }
+}
+
+func (c *hubCollector) Collect(ch chan<- prometheus.Metric) {
+    m := &c.hub.metrics
+    metric := func(d *prometheus.Desc, kind prometheus.ValueType, v int64, labels ...string) {
+        ch <- prometheus.MustNewConstMetric(d, kind, float64(v), labels...)
+    }
+    metric(c.connectsTotal, prometheus.CounterValue, m.connectsTotal.Load())
+    metric(c.activeConns, prometheus.GaugeValue, m.connections.Load())
+    metric(c.roomsActive, prometheus.GaugeValue, int64(c.hub.roomCount()))
+    metric(c.broadcasts, prometheus.CounterValue, m.broadcasts.Load())
+    metric(c.broadcastBytes, prometheus.CounterValue, m.broadcastBytes.Load())
+    metric(c.drops, prometheus.CounterValue, m.drops.Load())
+    metric(c.cutoffs, prometheus.CounterValue, m.egressCutoffs.Load())
+    if ev := c.hub.events; ev != nil {
+        metric(c.eventsPublished, prometheus.CounterValue, ev.published.Load())
+        metric(c.eventsDropped, prometheus.CounterValue, ev.dropped.Load())
+        metric(c.eventsFailed, prometheus.CounterValue, ev.failed.Load())
+    }
+    for e := udpFrameError(0); e < numUDPFrameErrors; e++ {
+        metric(c.udpRejected, prometheus.CounterValue, m.udpRejected[e].Load(), e.reason())
+    }
+    if len(c.labeled) == 0 {
+        return
+    }
+    for _, s := range c.hub.labels.snapshot() {
+        for _, f := range c.labeled {
+            metric(f.desc, f.kind, f.value(s), s.values...)
+        }
+    }
+}
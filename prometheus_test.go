@@ -0,0 +1,68 @@
+package main
+
+import (
+    "bufio"
+    "net/http"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+// scrapeMetrics returns the samples of a /metrics scrape by name.
+func scrapeMetrics(t *testing.T, base string) map[string]int64 {
+    t.Helper()
+    resp, err := http.Get("http" + strings.TrimPrefix(base, "ws") + "/metrics")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+        t.Fatalf("content type %q", ct)
+    }
+    samples := map[string]int64{}
+    sc := bufio.NewScanner(resp.Body)
+    for sc.Scan() {
+        line := sc.Text()
+        if strings.HasPrefix(line, "#") || line == "" {
+            continue
+        }
+        name, v, ok := strings.Cut(line, " ")
+        n, err := strconv.ParseFloat(v, 64)
+        if !ok || err != nil {
+            t.Fatalf("bad sample %q", line)
+        }
+        samples[name] = int64(n)
+    }
+    return samples
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    a := dialWS(t, base+"/ws/prom/alice")
+    b := dialWS(t, base+"/ws/prom/bob")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "prom") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+    if err := a.WriteMessage(1, []byte("hello")); err != nil {
+        t.Fatal(err)
+    }
+    if got := readEnvelopes(t, b, 200*time.Millisecond); len(got) != 1 {
+        t.Fatalf("bob got %d envelopes", len(got))
+    }
+
+    s := scrapeMetrics(t, base)
+    if s["relay_active_connections"] < 1 {
+        t.Fatalf("relay_active_connections = %d", s["relay_active_connections"])
+    }
+    if s["relay_connections_total"] < 2 || s["relay_rooms_active"] != 1 {
+        t.Fatalf("samples %v", s)
+    }
+    if s["relay_messages_broadcast_total"] != 1 || s["relay_bytes_broadcast_total"] != 5 {
+        t.Fatalf("broadcast counters %v", s)
+    }
+    if _, ok := s["relay_dropped_messages_total"]; !ok {
+        t.Fatal("drop counter missing")
+    }
+}
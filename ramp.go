@@ -0,0 +1,74 @@
+package main
+
+import (
+    "context"
+    "math/rand/v2"
+    "time"
+)
+
+// startupRamp paces accepted upgrades for a window after startup so the
+// reconnect storm that follows a deploy is spread out instead of landing
+// at once. Upgrades are delayed to roughly one per pace interval, plus
+// jitter, and the ramp switches itself off when the window ends. Only an
+// upgrade whose turn is more than maxWait away is refused, so a storm does
+// not pile up handlers holding connection slots for the whole ramp. A nil
+// ramp is disabled.
+type startupRamp struct {
+    until   time.Time
+    pace    time.Duration
+    maxWait time.Duration
+    bucket  *tokenBucket
+}
+
+// newStartupRamp returns nil when window or pace is 0; maxWait defaults to
+// five seconds.
+func newStartupRamp(window, pace, maxWait time.Duration) *startupRamp {
+    if window <= 0 || pace <= 0 {
+        return nil
+    }
+    if maxWait <= 0 {
+        maxWait = 5 * time.Second
+    }
+    return &startupRamp{
+        until:   time.Now().Add(window),
+        pace:    pace,
+        maxWait: maxWait,
+        bucket:  newTokenBucket(float64(time.Second)/float64(pace), 1),
+    }
+}
+
+// delay reserves an upgrade slot and returns how long to wait for it.
+func (s *startupRamp) delay() time.Duration {
+    if s == nil || time.Now().After(s.until) {
+        return 0
+    }
+    wait := s.bucket.reserve(1)
+    if wait == 0 {
+        return 0
+    }
+    return wait + rand.N(s.pace/2+1)
+}
+
+// wait holds an upgrade until its slot in the ramp. It reports false with
+// the wait the upgrade would have needed when that is over maxWait, and
+// false with no wait when ctx ends first, as when the client hangs up; in
+// both cases the slot is handed back to the upgrades behind it.
+func (s *startupRamp) wait(ctx context.Context) (time.Duration, bool) {
+    d := s.delay()
+    if d == 0 {
+        return 0, true
+    }
+    if d > s.maxWait {
+        s.bucket.refund(1)
+        return d, false
+    }
+    t := time.NewTimer(d)
+    defer t.Stop()
+    select {
+    case <-t.C:
+        return 0, true
+    case <-ctx.Done():
+        s.bucket.refund(1)
+        return 0, false
+    }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestStartupRampDelay(t *testing.T) {
+    if d := (*startupRamp)(nil).delay(); d != 0 {
+        t.Fatalf("nil ramp delayed %v", d)
+    }
+    s := newStartupRamp(time.Hour, 100*time.Millisecond, 0)
+    if d := s.delay(); d != 0 {
+        t.Fatalf("first upgrade delayed %v", d)
+    }
+    // each further upgrade in the window waits one more pace interval, plus
+    // up to half an interval of jitter
+    for i := 1; i <= 3; i++ {
+        d := s.delay()
+        lo := time.Duration(i)*100*time.Millisecond - 10*time.Millisecond
+        hi := time.Duration(i)*100*time.Millisecond + 50*time.Millisecond
+        if d < lo || d > hi {
+            t.Fatalf("upgrade %d delayed %v, want within [%v, %v]", i, d, lo, hi)
+        }
+    }
+    s.until = time.Now()
+    if d := s.delay(); d != 0 {
+        t.Fatalf("after the window: delayed %v", d)
+    }
+}
+
+func TestStartupRampPacesConnects(t *testing.T) {
+    const n, pace = 5, 40 * time.Millisecond
+    hub := NewHubWithConfig(Config{StartupRampWindow: time.Second, StartupRampPace: pace})
+    base := startTestServer(t, hub)
+
+    connectAll := func() time.Duration {
+        start := time.Now()
+        var wg sync.WaitGroup
+        for i := 0; i < n; i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                c, _, err := websocket.DefaultDialer.Dial(base+"/ws/r/", nil)
+                if err != nil {
+                    t.Errorf("dial: %v", err)
+                    return
+                }
+                t.Cleanup(func() { c.Close() })
+            }()
+        }
+        wg.Wait()
+        return time.Since(start)
+    }
+    if took := connectAll(); took < (n-1)*pace {
+        t.Fatalf("%d connects inside the window took %v, want at least %v", n, took, (n-1)*pace)
+    }
+    time.Sleep(time.Until(hub.ramp.until))
+    if took := connectAll(); took > 2*pace {
+        t.Fatalf("%d connects after the window took %v, want them unthrottled", n, took)
+    }
+}
+
+func TestStartupRampWaitBoundedAndCancellable(t *testing.T) {
+    s := newStartupRamp(time.Hour, 100*time.Millisecond, 250*time.Millisecond)
+    for i := 0; i < 4; i++ {
+        s.delay() // upgrades already queued, the last one 300ms out
+    }
+    // the next turn is over 250ms away: refused at once, turn handed back
+    start := time.Now()
+    before := s.bucket.wait(1)
+    if retry, ok := s.wait(context.Background()); ok || retry <= 250*time.Millisecond {
+        t.Fatalf("wait = %v, %v; want a refusal with the needed wait", retry, ok)
+    }
+    if took := time.Since(start); took > 50*time.Millisecond {
+        t.Fatalf("refusal took %v", took)
+    }
+    if after := s.bucket.wait(1); after > before {
+        t.Fatalf("refused upgrade kept its turn: %v after, %v before", after, before)
+    }
+
+    // a client that hangs up stops waiting and gives its turn back
+    s = newStartupRamp(time.Hour, 100*time.Millisecond, time.Second)
+    s.delay()
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(20*time.Millisecond, cancel)
+    start = time.Now()
+    if _, ok := s.wait(ctx); ok {
+        t.Fatal("cancelled wait reported its turn")
+    }
+    if took := time.Since(start); took > 80*time.Millisecond {
+        t.Fatalf("cancelled wait returned after %v", took)
+    }
+    if d := s.bucket.wait(1); d > 80*time.Millisecond {
+        t.Fatalf("cancelled wait kept its turn: next upgrade waits %v", d)
+    }
+}
+
+func TestStartupRampRefusalReleasesSlots(t *testing.T) {
+    hub := NewHubWithConfig(Config{StartupRampWindow: time.Hour, StartupRampPace: time.Second, StartupRampMaxWait: 100 * time.Millisecond, MaxConnections: 1, MaxConnsPerIP: 1})
+    base := startTestServer(t, hub)
+    first := dialWS(t, base+"/ws/r/a")
+    first.Close()
+    waitFor(time.Second, func() bool { return hub.ipConns.held("127.0.0.1") == 0 })
+    _, resp, err := websocket.DefaultDialer.Dial(base+"/ws/r/b", nil)
+    if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+        t.Fatalf("upgrade past the max wait: %v %v", resp, err)
+    }
+    if n := hub.ipConns.held("127.0.0.1"); n != 0 {
+        t.Fatalf("refused upgrade holds %d per-IP slots", n)
+    }
+    if !waitFor(time.Second, func() bool { return len(hub.slots.sem) == 0 }) {
+        t.Fatal("refused upgrade still holds a connection slot")
+    }
+}
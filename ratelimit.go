@@ -0,0 +1,88 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// tokenBucket refills at rate tokens per second up to burst.
+type tokenBucket struct {
+    mu     sync.Mutex
+    rate   float64
+    burst  float64
+    tokens float64
+    last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+    return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refillLocked(now time.Time) {
+    b.tokens += now.Sub(b.last).Seconds() * b.rate
+    if b.tokens > b.burst {
+        b.tokens = b.burst
+    }
+    b.last = now
+}
+
+// allow takes n tokens if they are available.
+func (b *tokenBucket) allow(n float64) bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.refillLocked(time.Now())
+    if b.tokens < n {
+        return false
+    }
+    b.tokens -= n
+    return true
+}
+
+// refund returns n tokens taken by allow that ended up unused.
+func (b *tokenBucket) refund(n float64) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.tokens = min(b.tokens+n, b.burst)
+}
+
+// wait returns how long until n tokens are available, without taking any.
+func (b *tokenBucket) wait(n float64) time.Duration {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.refillLocked(time.Now())
+    if b.tokens >= n {
+        return 0
+    }
+    return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// reserve takes n tokens unconditionally, going into debt if needed, and
+// returns how long the caller should wait before acting on them.
+func (b *tokenBucket) reserve(n float64) time.Duration {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.refillLocked(time.Now())
+    b.tokens -= n
+    if b.tokens >= 0 {
+        return 0
+    }
+    return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// sendPace resolves a connection's write pace from the global limit and an
+// optional ?pace= request; a client may only ask for a slower pace.
+func sendPace(global int64, requested string) (int64, error) {
+    if requested == "" {
+        return global, nil
+    }
+    pace, err := strconv.ParseInt(requested, 10, 64)
+    if err != nil || pace <= 0 {
+        return 0, fmt.Errorf("invalid pace %q", requested)
+    }
+    if global > 0 && pace > global {
+        return global, nil
+    }
+    return pace, nil
+}
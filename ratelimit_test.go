@@ -0,0 +1,97 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestTokenBucketAllow(t *testing.T) {
+    b := newTokenBucket(10, 3)
+    for i := 0; i < 3; i++ {
+        if !b.allow(1) {
+            t.Fatalf("token %d should be available from burst", i)
+        }
+    }
+    if b.allow(1) {
+        t.Fatal("bucket should be empty")
+    }
+    time.Sleep(150 * time.Millisecond)
+    if !b.allow(1) {
+        t.Fatal("bucket should have refilled")
+    }
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+    b := newTokenBucket(1000, 1000)
+    if d := b.reserve(1000); d != 0 {
+        t.Fatalf("burst reservation delayed %v", d)
+    }
+    d := b.reserve(500)
+    if d < 450*time.Millisecond || d > 550*time.Millisecond {
+        t.Fatalf("reserve in debt waited %v, want ~500ms", d)
+    }
+}
+
+func TestSendPace(t *testing.T) {
+    cases := []struct {
+        global    int64
+        requested string
+        want      int64
+        err       bool
+    }{
+        {0, "", 0, false},
+        {1000, "", 1000, false},
+        {0, "500", 500, false},
+        {1000, "500", 500, false},
+        {1000, "5000", 1000, false},
+        {0, "-1", 0, true},
+        {0, "fast", 0, true},
+    }
+    for _, tc := range cases {
+        got, err := sendPace(tc.global, tc.requested)
+        if (err != nil) != tc.err || got != tc.want {
+            t.Errorf("sendPace(%d, %q) = %d, %v; want %d, err=%v", tc.global, tc.requested, got, err, tc.want, tc.err)
+        }
+    }
+}
+
+func TestPacedWriterCapsRate(t *testing.T) {
+    const pace = 4000
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    sender := dialWS(t, base+"/ws/r/sender")
+    paced := dialWS(t, base+"/ws/r/paced?pace=4000")
+    unpaced := dialWS(t, base+"/ws/r/unpaced")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 3 })
+
+    payload := []byte(strings.Repeat("x", 400))
+    start := time.Now()
+    for i := 0; i < 10; i++ {
+        if err := sender.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if got := readEnvelopes(t, unpaced, 200*time.Millisecond); len(got) != 10 {
+        t.Fatalf("unpaced client got %d messages, want 10", len(got))
+    }
+
+    var bytes int
+    for i := 0; i < 10; i++ {
+        paced.SetReadDeadline(time.Now().Add(3 * time.Second))
+        _, raw, err := paced.ReadMessage()
+        if err != nil {
+            t.Fatalf("paced read %d: %v", i, err)
+        }
+        bytes += len(raw)
+    }
+    elapsed := time.Since(start)
+    // everything beyond the one-second burst must be spread at the pace
+    minElapsed := time.Duration(float64(bytes-pace) / pace * float64(time.Second))
+    if elapsed < minElapsed*9/10 {
+        t.Fatalf("paced client received %d bytes in %v, faster than %d B/s allows (min %v)", bytes, elapsed, pace, minElapsed)
+    }
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+    "bytes"
+    "cmp"
+    "fmt"
+    "slices"
+    "strconv"
+)
+
+// Recency-capped delivery. A connection opened with ?recent=1 may start a
+// message with "recent:<N>|" to deliver it only to the N room members that
+// were most recently active, bounding the fan-out of notifications in very
+// large rooms. A member's activity is the last frame it sent, or its
+// connect. The header comes after any "ttl:" header and before any "room:"
+// routing prefix.
+
+const (
+    recentPrefix = "recent:"
+    maxRecent    = 1 << 20
+)
+
+// parseRecentPrefix splits "recent:<N>|<payload>". ok is false when msg has
+// no recency header; err is set when it has a malformed one.
+func parseRecentPrefix(msg []byte) (n int, payload []byte, ok bool, err error) {
+    rest, found := bytes.CutPrefix(msg, []byte(recentPrefix))
+    if !found {
+        return 0, msg, false, nil
+    }
+    spec, payload, found := bytes.Cut(rest, []byte("|"))
+    if found {
+        n, err = strconv.Atoi(string(spec))
+    }
+    if !found || err != nil || n <= 0 || n > maxRecent {
+        return 0, nil, true, fmt.Errorf("malformed recent header; want %s<N>|<payload> with N from 1 to %d", recentPrefix, maxRecent)
+    }
+    return n, payload, true, nil
+}
+
+// mostRecent keeps the n most recently active of clients, reordering it.
+func mostRecent(clients []*Client, n int) []*Client {
+    if len(clients) <= n {
+        return clients
+    }
+    slices.SortFunc(clients, func(a, b *Client) int {
+        return cmp.Compare(b.lastActive.Load(), a.lastActive.Load())
+    })
+    return clients[:n]
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+)
+
+func TestParseRecentPrefix(t *testing.T) {
+    n, payload, ok, err := parseRecentPrefix([]byte("recent:100|hello"))
+    if err != nil || !ok || n != 100 || string(payload) != "hello" {
+        t.Fatalf("got n=%d payload=%q ok=%v err=%v", n, payload, ok, err)
+    }
+    if _, payload, ok, err := parseRecentPrefix([]byte("hello")); ok || err != nil || string(payload) != "hello" {
+        t.Fatalf("plain message: payload=%q ok=%v err=%v", payload, ok, err)
+    }
+    for _, bad := range []string{"recent:0|x", "recent:-1|x", "recent:ten|x", "recent:5"} {
+        if _, _, ok, err := parseRecentPrefix([]byte(bad)); !ok || err == nil {
+            t.Errorf("%q: ok=%v err=%v, want an error", bad, ok, err)
+        }
+    }
+}
+
+func TestRecentCapDeliversToMostActive(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    room := hub.getRoom("feed")
+    base := time.Now()
+    clients := make([]*Client, 5)
+    for i := range clients {
+        clients[i] = fakeClient(room, fmt.Sprintf("c%d", i), 4)
+        clients[i].lastActive.Store(base.Add(time.Duration(i) * time.Second).UnixNano())
+    }
+    sender := &Client{username: "sender", room: room}
+
+    env := NewEnvelope("feed", "sender", []byte("note"))
+    env.recent = 2
+    room.broadcast(sender, env)
+    for i, c := range clients {
+        want := 0
+        if i >= 3 { // c3 and c4 were active last
+            want = 1
+        }
+        if got := len(drain(c, want, 100*time.Millisecond)); got != want || len(c.sendCh) != 0 {
+            t.Fatalf("%s received %d messages, want %d", c.username, got, want)
+        }
+    }
+
+    // uncapped broadcasts still reach everyone
+    room.broadcast(sender, NewEnvelope("feed", "sender", []byte("all")))
+    for _, c := range clients {
+        if got := drain(c, 1, time.Second); len(got) != 1 {
+            t.Fatalf("%s missed an uncapped broadcast", c.username)
+        }
+    }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// PII redaction (REDACT_PATTERNS). Text payloads are scanned for the
+// configured regular expressions, e.g. emails or card numbers, and every
+// match is replaced with REDACT_MASK before the broadcast fans out. Binary
+// frames are never touched, and neither is anything in E2EE_ROOMS, whose
+// payloads are ciphertext the relay cannot meaningfully inspect.
+type redactor struct {
+    patterns []*regexp.Regexp
+    mask     []byte
+}
+
+// apply masks every pattern match in payload. It copies only when
+// something matched.
+func (rd *redactor) apply(payload []byte) []byte {
+    for _, re := range rd.patterns {
+        payload = re.ReplaceAllLiteral(payload, rd.mask)
+    }
+    return payload
+}
+
+// LoadRedactPatterns parses REDACT_PATTERNS: a JSON array of regular
+// expressions, e.g. ["[\\w.+-]+@[\\w-]+\\.[\\w.]+"], or "file:<path>"
+// naming a file holding one. Matches are replaced with mask.
+func (h *Hub) LoadRedactPatterns(spec, mask string) error {
+    if spec == "" {
+        return nil
+    }
+    raw := []byte(spec)
+    if path, ok := strings.CutPrefix(spec, "file:"); ok {
+        b, err := os.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        raw = b
+    }
+    var exprs []string
+    if err := json.Unmarshal(raw, &exprs); err != nil {
+        return fmt.Errorf("invalid REDACT_PATTERNS: %w", err)
+    }
+    rd := &redactor{mask: []byte(mask)}
+    for _, expr := range exprs {
+        re, err := regexp.Compile(expr)
+        if err != nil {
+            return fmt.Errorf("invalid REDACT_PATTERNS: %w", err)
+        }
+        rd.patterns = append(rd.patterns, re)
+    }
+    h.mu.Lock()
+    h.redact = rd
+    h.mu.Unlock()
+    return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+const testRedactPatterns = `["[\\w.+-]+@[\\w-]+\\.[\\w.]+", "\\b(?:\\d[ -]?){13,16}\\b"]`
+
+func TestRedactPatternsMaskTextPayloads(t *testing.T) {
+    hub := NewHubWithConfig(Config{E2EERooms: "secret"})
+    if err := hub.LoadRedactPatterns(testRedactPatterns, "***"); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    chatRecv := dialWS(t, base+"/ws/chat/recv")
+    chatSend := dialWS(t, base+"/ws/chat/send")
+    secretRecv := dialWS(t, base+"/ws/secret/recv")
+    secretSend := dialWS(t, base+"/ws/secret/send")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "chat") == 2 && roomSize(hub, "secret") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+
+    pii := "mail bob@example.com, card 4111 1111 1111 1111."
+    if err := chatSend.WriteMessage(websocket.TextMessage, []byte(pii)); err != nil {
+        t.Fatal(err)
+    }
+    if err := chatSend.WriteMessage(websocket.BinaryMessage, []byte(pii)); err != nil {
+        t.Fatal(err)
+    }
+    if err := secretSend.WriteMessage(websocket.TextMessage, []byte(pii)); err != nil {
+        t.Fatal(err)
+    }
+
+    got := readEnvelopes(t, chatRecv, 300*time.Millisecond)
+    if len(got) != 2 {
+        t.Fatalf("chat received %d messages, want 2", len(got))
+    }
+    if p := string(got[0].Payload); p != "mail ***, card ***." {
+        t.Fatalf("text payload %q was not redacted", p)
+    }
+    if p := string(got[1].Payload); p != pii {
+        t.Fatalf("binary payload changed to %q", p)
+    }
+    got = readEnvelopes(t, secretRecv, 300*time.Millisecond)
+    if len(got) != 1 || string(got[0].Payload) != pii {
+        t.Fatalf("e2ee room received %v, want the payload untouched", got)
+    }
+}
+
+func TestLoadRedactPatternsRejectsBadSpecs(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    for _, bad := range []string{`not json`, `["("]`, "file:/nonexistent/patterns.json"} {
+        if err := hub.LoadRedactPatterns(bad, "x"); err == nil {
+            t.Errorf("LoadRedactPatterns(%q) accepted", bad)
+        }
+    }
+    if hub.redact != nil {
+        t.Fatal("a rejected spec was installed")
+    }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+)
+
+// Replay protection for REPLAY_PROTECT_ROOMS. Every message in such a room
+// must be a JSON object with a numeric "seq" field strictly greater than
+// the last one accepted on the connection and at most REPLAY_WINDOW ahead
+// of it, so a captured (e.g. signed) message cannot be sent again and a
+// forged far-future sequence cannot burn the connection's sequence space.
+// The sequence is tracked per connection and destination room, so a
+// message routed in with ?route=1 is held to the window of the room it
+// lands in, and starts at zero.
+
+// Replay rejection codes, used both as error frame codes and dead-letter
+// reasons.
+const (
+    dropMissingSeq  = "missing_seq"
+    dropReplay      = "replay"
+    dropOutOfWindow = "out_of_window"
+)
+
+// messageSeq extracts the "seq" field from a JSON object payload.
+func messageSeq(msg []byte) (uint64, bool) {
+    if len(msg) == 0 || msg[0] != '{' {
+        return 0, false
+    }
+    var tagged struct {
+        Seq *uint64 `json:"seq"`
+    }
+    if err := json.Unmarshal(msg, &tagged); err != nil || tagged.Seq == nil {
+        return 0, false
+    }
+    return *tagged.Seq, true
+}
+
+// acceptSequence checks msg against the last sequence accepted for room
+// and records it when valid. On rejection it returns the reason code. Only
+// c's reader loop may call it.
+func (c *Client) acceptSequence(room string, msg []byte, window uint64) (string, error) {
+    seq, ok := messageSeq(msg)
+    last := c.lastSeq[room]
+    switch {
+    case !ok:
+        return dropMissingSeq, fmt.Errorf("message must carry a numeric seq")
+    case seq <= last:
+        return dropReplay, fmt.Errorf("seq %d already used; last accepted is %d", seq, last)
+    case seq-last > window:
+        return dropOutOfWindow, fmt.Errorf("seq %d is more than %d ahead of last accepted %d", seq, window, last)
+    }
+    if c.lastSeq == nil {
+        c.lastSeq = make(map[string]uint64)
+    }
+    c.lastSeq[room] = seq
+    return "", nil
+}
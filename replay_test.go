@@ -0,0 +1,118 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestAcceptSequence(t *testing.T) {
+    c := &Client{}
+    steps := []struct {
+        msg  string
+        code string
+    }{
+        {`{"seq":1}`, ""},
+        {`{"seq":2}`, ""},
+        {`{"seq":5}`, ""},         // gaps within the window are fine
+        {`{"seq":5}`, dropReplay}, // exact replay
+        {`{"seq":3}`, dropReplay}, // older message
+        {`{"seq":16}`, dropOutOfWindow},
+        {`{"seq":15}`, ""},
+        {`{"text":"no seq"}`, dropMissingSeq},
+        {`not json`, dropMissingSeq},
+    }
+    for _, st := range steps {
+        code, err := c.acceptSequence("secure", []byte(st.msg), 10)
+        if code != st.code || (err == nil) != (st.code == "") {
+            t.Fatalf("acceptSequence(%s) = %q, %v; want %q", st.msg, code, err, st.code)
+        }
+    }
+    if c.lastSeq["secure"] != 15 {
+        t.Fatalf("lastSeq = %d, want 15", c.lastSeq["secure"])
+    }
+    if code, err := c.acceptSequence("other", []byte(`{"seq":1}`), 10); err != nil {
+        t.Fatalf("sequences are per room, got %q, %v", code, err)
+    }
+}
+
+func TestReplayProtectedRoom(t *testing.T) {
+    hub := NewHubWithConfig(Config{ReplayRooms: "secure", ReplayWindow: 100})
+    base := startTestServer(t, hub)
+    sender := dialWS(t, base+"/ws/secure/alice")
+    receiver := dialWS(t, base+"/ws/secure/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "secure") == 2 })
+
+    send := func(msg string) {
+        t.Helper()
+        if err := sender.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    expectError := func(code string) {
+        t.Helper()
+        sender.SetReadDeadline(time.Now().Add(2 * time.Second))
+        _, raw, err := sender.ReadMessage()
+        if err != nil {
+            t.Fatalf("sender read: %v", err)
+        }
+        var ef ErrorFrame
+        if err := json.Unmarshal(raw, &ef); err != nil || ef.Code != code {
+            t.Fatalf("got %s, want error %q", raw, code)
+        }
+    }
+
+    send(`{"seq":1,"sig":"a"}`)
+    send(`{"seq":2,"sig":"b"}`)
+    send(`{"seq":1,"sig":"a"}`)
+    expectError(dropReplay)
+    send(`{"seq":500,"sig":"c"}`)
+    expectError(dropOutOfWindow)
+    send(`{"seq":3,"sig":"d"}`)
+
+    got := readEnvelopes(t, receiver, 300*time.Millisecond)
+    want := []string{`{"seq":1,"sig":"a"}`, `{"seq":2,"sig":"b"}`, `{"seq":3,"sig":"d"}`}
+    if len(got) != len(want) {
+        t.Fatalf("receiver got %d messages, want %d: %+v", len(got), len(want), got)
+    }
+    for i := range want {
+        if string(got[i].Payload) != want[i] {
+            t.Errorf("message %d = %s, want %s", i, got[i].Payload, want[i])
+        }
+    }
+}
+
+func TestReplayProtectionAppliesToRoutedMessages(t *testing.T) {
+    hub := NewHubWithConfig(Config{ReplayRooms: "secure", ReplayWindow: 100})
+    dead := make(chan DeadLetter, 16)
+    hub.dead = newDeadLetterSink(16, func(dl DeadLetter) { dead <- dl })
+    base := startTestServer(t, hub)
+    router := dialWS(t, base+"/ws/open/mallory?route=1")
+    receiver := dialWS(t, base+"/ws/secure/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "open") == 1 && roomSize(hub, "secure") == 1 })
+
+    for range 3 {
+        if err := router.WriteMessage(websocket.TextMessage, []byte(`room:secure|{"seq":1,"sig":"a"}`)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    expectErrorFrame(t, router, dropReplay)
+    expectErrorFrame(t, router, dropReplay)
+
+    got := readEnvelopes(t, receiver, 300*time.Millisecond)
+    if len(got) != 1 || string(got[0].Payload) != `{"seq":1,"sig":"a"}` {
+        t.Fatalf("receiver got %+v, want the message once", got)
+    }
+    for range 2 {
+        select {
+        case dl := <-dead:
+            if dl.Reason != dropReplay || dl.Room != "secure" {
+                t.Fatalf("dead letter %+v, want a replay in secure", dl)
+            }
+        case <-time.After(time.Second):
+            t.Fatal("replay not dead-lettered")
+        }
+    }
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Role-filtered broadcast. A connection may carry a role (?role=); with
+// ROLE_TARGETS="broadcaster=viewer|moderator" a broadcaster's messages reach
+// only viewer and moderator connections in the room. Roles without an
+// entry, and messages without a sending client (UDP), reach everyone.
+//
+// The observer role is read-only: such a connection receives the room but
+// its data frames are dropped server-side as "read_only" dead letters.
+// Control frames still work. ?role= is self-declared, so on its own it can
+// only take write access away; a connection admitted with OBSERVER_TOKEN
+// (see auth.go) is an observer whatever it asks for.
+
+const (
+    observerRole = "observer"
+    dropReadOnly = "read_only"
+)
+
+// LoadRoleTargets parses ROLE_TARGETS: comma-separated
+// sender_role=target_role|target_role entries.
+func (h *Hub) LoadRoleTargets(spec string) error {
+    targets := make(map[string][]string)
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        from, to, ok := strings.Cut(entry, "=")
+        if !ok || from == "" || to == "" {
+            return fmt.Errorf("invalid ROLE_TARGETS entry %q (want sender=role|role)", entry)
+        }
+        targets[from] = strings.Split(to, "|")
+    }
+    h.mu.Lock()
+    h.roleTargets = targets
+    h.mu.Unlock()
+    return nil
+}
+
+// targetsFor returns the roles a sender with role may reach; nil means all.
+func (h *Hub) targetsFor(role string) []string {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    return h.roleTargets[role]
+}
+
+// receives reports whether c gets a message from sender under role filtering.
+func (c *Client) receives(sender *Client) bool {
+    if sender == nil {
+        return true
+    }
+    return c.receivesTargets(sender.targets)
+}
+
+// receivesTargets is receives for a sender whose role targets are targets.
+func (c *Client) receivesTargets(targets []string) bool {
+    if targets == nil {
+        return true
+    }
+    for _, role := range targets {
+        if c.role == role {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "slices"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestLoadRoleTargets(t *testing.T) {
+    hub := NewHub()
+    if err := hub.LoadRoleTargets("broadcaster=viewer|moderator, moderator=viewer"); err != nil {
+        t.Fatal(err)
+    }
+    if got := hub.targetsFor("broadcaster"); len(got) != 2 || got[0] != "viewer" || got[1] != "moderator" {
+        t.Fatalf("broadcaster targets = %v", got)
+    }
+    if got := hub.targetsFor("viewer"); got != nil {
+        t.Fatalf("viewer should reach everyone, got %v", got)
+    }
+    for _, bad := range []string{"broadcaster", "=viewer", "broadcaster="} {
+        if err := hub.LoadRoleTargets(bad); err == nil {
+            t.Errorf("%q: expected error", bad)
+        }
+    }
+}
+
+func TestRoleFilteredBroadcast(t *testing.T) {
+    hub := NewHub()
+    if err := hub.LoadRoleTargets("broadcaster=viewer"); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    host := dialWS(t, base+"/ws/stage/host?role=broadcaster")
+    cohost := dialWS(t, base+"/ws/stage/cohost?role=broadcaster")
+    v1 := dialWS(t, base+"/ws/stage/v1?role=viewer")
+    v2 := dialWS(t, base+"/ws/stage/v2?role=viewer")
+    plain := dialWS(t, base+"/ws/stage/plain")
+    waitFor(time.Second, func() bool { return roomSize(hub, "stage") == 5 })
+
+    // viewers have no targets configured and reach the whole room
+    if err := host.WriteMessage(websocket.BinaryMessage, []byte("on air")); err != nil {
+        t.Fatal(err)
+    }
+    if err := v1.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+        t.Fatal(err)
+    }
+    cases := []struct {
+        name string
+        c    *websocket.Conn
+        want []string
+    }{
+        {"host", host, []string{"hello"}},
+        {"cohost", cohost, []string{"hello"}},
+        {"v1", v1, []string{"on air"}},
+        {"v2", v2, []string{"on air", "hello"}},
+        {"plain", plain, []string{"hello"}},
+    }
+    for _, tc := range cases {
+        got := readEnvelopes(t, tc.c, 200*time.Millisecond)
+        var seen []string
+        for _, env := range got {
+            seen = append(seen, string(env.Payload))
+        }
+        slices.Sort(seen)
+        slices.Sort(tc.want)
+        if !slices.Equal(seen, tc.want) {
+            t.Errorf("%s got %q, want %q", tc.name, seen, tc.want)
+        }
+    }
+}
+
+func TestObserverReceivesButCannotBroadcast(t *testing.T) {
+    hub := NewHub()
+    dead := make(chan DeadLetter, 4)
+    hub.dead = newDeadLetterSink(4, func(dl DeadLetter) { dead <- dl })
+    base := startTestServer(t, hub)
+    observer := dialWS(t, base+"/ws/match/spectator?role=observer")
+    player := dialWS(t, base+"/ws/match/player")
+    waitFor(time.Second, func() bool { return roomSize(hub, "match") == 2 })
+
+    if err := observer.WriteMessage(websocket.BinaryMessage, []byte("let me play")); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case dl := <-dead:
+        if dl.Reason != dropReadOnly || dl.From != "spectator" {
+            t.Fatalf("dead letter %+v, want read_only from spectator", dl)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("observer frame was not dropped")
+    }
+    if err := player.WriteMessage(websocket.BinaryMessage, []byte("move")); err != nil {
+        t.Fatal(err)
+    }
+
+    if got := readEnvelopes(t, observer, 200*time.Millisecond); len(got) != 1 || string(got[0].Payload) != "move" {
+        t.Fatalf("observer got %v, want the player's move", got)
+    }
+    if got := readEnvelopes(t, player, 200*time.Millisecond); len(got) != 0 {
+        t.Fatalf("player got %v from an observer", got)
+    }
+}
@@ -0,0 +1,15 @@
+package main
+
+import "errors"
+
+// Per-room capacity (MAX_CLIENTS_PER_ROOM). Every member holds its own send
+// queue, so one popular room could otherwise exhaust memory. Room.join
+// enforces the cap under the room mutex; a connection that does not fit is
+// closed with 1013 (try again later) and an OverloadReason.
+
+var errRoomFull = errors.New("room full")
+
+// roomFullReason is the close reason of a connection refused by a full room.
+func roomFullReason(limit int) string {
+    return overloadCloseReason(OverloadReason{Code: errCodeRoomFull, MaxClients: limit}, limitRetryAfter)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestRoomCapacityRejectsNextDial(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxClientsPerRoom: 2})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/full/a")
+    dialWS(t, base+"/ws/full/b")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "full") == 2 }) {
+        t.Fatal("room did not fill")
+    }
+
+    ce := expectClose(t, dialWS(t, base+"/ws/full/c"))
+    if ce.Code != websocket.CloseTryAgainLater {
+        t.Fatalf("close code %d, want %d", ce.Code, websocket.CloseTryAgainLater)
+    }
+    var reason OverloadReason
+    if err := json.Unmarshal([]byte(ce.Text), &reason); err != nil || reason != (OverloadReason{Code: "room_full", MaxClients: 2, RetryAfterMs: 1000}) {
+        t.Fatalf("close reason %q (%v)", ce.Text, err)
+    }
+    if roomSize(hub, "full") != 2 {
+        t.Fatalf("room holds %d clients", roomSize(hub, "full"))
+    }
+    // other rooms are unaffected
+    dialWS(t, base+"/ws/other/c")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "other") == 1 }) {
+        t.Fatal("client was refused by an empty room")
+    }
+}
+
+func TestRoomCapacityConcurrentJoins(t *testing.T) {
+    hub := NewHubWithConfig(Config{MaxClientsPerRoom: 3})
+    room := hub.roomFor("race", "")
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    joined := 0
+    for i := 0; i < 50; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            if room.join(&Client{username: fmt.Sprint(i), room: room}) == nil {
+                mu.Lock()
+                joined++
+                mu.Unlock()
+            }
+        }(i)
+    }
+    wg.Wait()
+    if joined != 3 || roomSize(hub, "race") != 3 {
+        t.Fatalf("%d joins succeeded, room holds %d; want 3", joined, roomSize(hub, "race"))
+    }
+}
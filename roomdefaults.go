@@ -0,0 +1,69 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+)
+
+// Room delivery defaults (ROOM_DEFAULTS). An operator can give a room a
+// delivery profile once instead of every client asking for it: connections
+// to the room that do not choose for themselves get the room's envelope
+// format (when the query has neither ver nor max_overhead, and a hello does
+// not name one) and its compression setting (when a hello does not name
+// one; the compression op still toggles it later). The spec is a JSON
+// object, e.g. {"ticks":{"envelope":3,"compression":true,"codec":"raw"}}.
+
+// RoomDefaults is one room's delivery profile.
+type RoomDefaults struct {
+    Envelope    int    `json:"envelope,omitempty"`
+    Compression bool   `json:"compression,omitempty"` // on deflate connections only
+    Codec       string `json:"codec,omitempty"`       // "raw", the only codec, or empty
+}
+
+// LoadRoomDefaults parses ROOM_DEFAULTS. It applies to connections made
+// after the call.
+func (h *Hub) LoadRoomDefaults(spec string) error {
+    if spec == "" {
+        return nil
+    }
+    var defaults map[string]RoomDefaults
+    if err := json.Unmarshal([]byte(spec), &defaults); err != nil {
+        return fmt.Errorf("invalid ROOM_DEFAULTS: %w", err)
+    }
+    for room, d := range defaults {
+        if d.Envelope != 0 && envelopeRenderers[d.Envelope] == nil {
+            return fmt.Errorf("invalid ROOM_DEFAULTS for %q: unsupported envelope version %d", room, d.Envelope)
+        }
+        if d.Envelope > 2 && inList(h.cfg.AckRooms, room) {
+            return fmt.Errorf("invalid ROOM_DEFAULTS for %q: ack rooms need a JSON envelope", room)
+        }
+        if d.Codec != "" && d.Codec != payloadCodec {
+            return fmt.Errorf("invalid ROOM_DEFAULTS for %q: unsupported codec %q", room, d.Codec)
+        }
+    }
+    h.mu.Lock()
+    h.roomDefaults = defaults
+    h.mu.Unlock()
+    return nil
+}
+
+// defaultsFor returns the delivery profile of the named room; the zero
+// value when it has none.
+func (h *Hub) defaultsFor(room string) RoomDefaults {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    return h.roomDefaults[room]
+}
+
+// helloNames reports whether a hello frame names capability key, telling
+// "compression":false apart from no word on compression.
+func helloNames(msg []byte, key string) bool {
+    var hello struct {
+        Capabilities map[string]json.RawMessage `json:"capabilities"`
+    }
+    if json.Unmarshal(msg, &hello) != nil {
+        return false
+    }
+    _, ok := hello.Capabilities[key]
+    return ok
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "encoding/json"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestRoomDefaultsInheritedUnlessOverridden(t *testing.T) {
+    hub := NewHubWithConfig(Config{HandshakeTimeout: 100 * time.Millisecond})
+    if err := hub.LoadRoomDefaults(`{"ticks":{"envelope":2,"compression":true,"codec":"raw"}}`); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    var inheritWire, ownWire atomic.Int64
+    inherit := dialCounting(t, base+"/ws/ticks/inherit", &inheritWire)
+    defer inherit.Close()
+    own := dialCounting(t, base+"/ws/ticks/own", &ownWire)
+    defer own.Close()
+
+    // a hello without overrides keeps the room's profile; one with them does not
+    hello := func(c *websocket.Conn, caps string) Capabilities {
+        t.Helper()
+        if err := c.WriteMessage(websocket.TextMessage, []byte(`{"op":"hello","capabilities":`+caps+`}`)); err != nil {
+            t.Fatal(err)
+        }
+        c.SetReadDeadline(time.Now().Add(2 * time.Second))
+        var wf WelcomeFrame
+        if err := c.ReadJSON(&wf); err != nil {
+            t.Fatal(err)
+        }
+        return wf.Capabilities
+    }
+    if got := hello(inherit, `{"echo":false}`); got.Envelope != 2 || !got.Compression {
+        t.Fatalf("inheriting client negotiated %+v, want the room's envelope 2 with compression", got)
+    }
+    if got := hello(own, `{"envelope":1,"compression":false}`); got.Envelope != 1 || got.Compression {
+        t.Fatalf("overriding client negotiated %+v, want its own envelope 1 without compression", got)
+    }
+    waitFor(time.Second, func() bool { return roomSize(hub, "ticks") == 2 })
+
+    sender := dialWS(t, base+"/ws/ticks/sender?ver=1")
+    waitFor(time.Second, func() bool { return roomSize(hub, "ticks") == 3 })
+    inheritBefore, ownBefore := inheritWire.Load(), ownWire.Load()
+    payload := make([]byte, 16<<10)
+    if err := sender.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+        t.Fatal(err)
+    }
+
+    inherit.SetReadDeadline(time.Now().Add(2 * time.Second))
+    var slim envelopeV2
+    if err := inherit.ReadJSON(&slim); err != nil || slim.V != 2 {
+        t.Fatalf("inheriting client: %+v (%v), want a v2 envelope", slim, err)
+    }
+    own.SetReadDeadline(time.Now().Add(2 * time.Second))
+    var env Envelope
+    if err := own.ReadJSON(&env); err != nil || env.Type != envelopeMessage {
+        t.Fatalf("overriding client: %+v (%v), want a v1 envelope", env, err)
+    }
+    if got := inheritWire.Load() - inheritBefore; got > 2<<10 {
+        t.Fatalf("inheriting client got %d wire bytes, want it compressed", got)
+    }
+    if got := ownWire.Load() - ownBefore; got < int64(len(payload)) {
+        t.Fatalf("overriding client got %d wire bytes, want it uncompressed", got)
+    }
+}
+
+func TestRoomDefaultsEnvelopeFromQuery(t *testing.T) {
+    hub := NewHub()
+    if err := hub.LoadRoomDefaults(`{"ticks":{"envelope":2}}`); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    plain := dialWS(t, base+"/ws/ticks/plain")
+    explicit := dialWS(t, base+"/ws/ticks/explicit?ver=1")
+    waitFor(time.Second, func() bool { return roomSize(hub, "ticks") == 2 })
+    sender := dialWS(t, base+"/ws/ticks/sender")
+    waitFor(time.Second, func() bool { return roomSize(hub, "ticks") == 3 })
+    sender.WriteMessage(websocket.TextMessage, []byte("hi"))
+
+    read := func(c *websocket.Conn) map[string]json.RawMessage {
+        t.Helper()
+        c.SetReadDeadline(time.Now().Add(2 * time.Second))
+        var m map[string]json.RawMessage
+        if err := c.ReadJSON(&m); err != nil {
+            t.Fatal(err)
+        }
+        return m
+    }
+    if m := read(plain); string(m["v"]) != "2" {
+        t.Fatalf("plain client got %v, want the room's v2", m)
+    }
+    if m := read(explicit); m["room"] == nil {
+        t.Fatalf("?ver=1 client got %v, want v1", m)
+    }
+}
+
+func TestLoadRoomDefaultsValidates(t *testing.T) {
+    hub := NewHubWithConfig(Config{AckRooms: "orders"})
+    for _, spec := range []string{
+        `not json`,
+        `{"a":{"envelope":9}}`,
+        `{"a":{"codec":"msgpack"}}`,
+        `{"orders":{"envelope":3}}`,
+    } {
+        if err := hub.LoadRoomDefaults(spec); err == nil {
+            t.Errorf("%s accepted", spec)
+        }
+    }
+}
@@ -0,0 +1,54 @@
+package main
+
+// Empty room collection. Rooms are created on demand, so without cleanup
+// every transient room name would stay in the hub forever. When the last
+// connection bound to a room leaves, the room is removed. A connection pins
+// its room from lookup until cleanup, since it may join only after a
+// handshake; a room is removed only when it has neither members nor pins,
+// checked under the room's bucket lock that new lookups take. The shards
+// of the default room are kept, so a shard is never seen without its
+// siblings. A removed room's history may outlive it, see history.go.
+
+// enterRoom is roomFor for a connection: the returned room stays
+// registered until the connection calls leaveRoom.
+func (h *Hub) enterRoom(name, username string) *Room {
+    for {
+        r := h.roomFor(name, username)
+        s := h.shardOf(r.name)
+        s.mu.Lock()
+        if s.rooms[r.name] == r {
+            r.pins.Add(1)
+            s.mu.Unlock()
+            return r
+        }
+        // collected between lookup and pin; look it up again
+        s.mu.Unlock()
+    }
+}
+
+// leaveRoom removes c from r, unpins r and collects it if it is empty.
+func (h *Hub) leaveRoom(r *Room, c *Client) {
+    r.leave(c)
+    r.pins.Add(-1)
+    h.removeRoomIfEmpty(r.name)
+}
+
+// removeRoomIfEmpty deletes the named room when no client is in it or about
+// to join it.
+func (h *Hub) removeRoomIfEmpty(name string) {
+    s := h.shardOf(name)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    r := s.rooms[name]
+    if r == nil || r.shards != nil || r.pins.Load() > 0 {
+        return
+    }
+    r.mu.RLock()
+    empty := len(r.clients) == 0
+    r.mu.RUnlock()
+    if empty {
+        h.keepHistoryLocked(s, r)
+        delete(s.rooms, name)
+        h.hooks.destroyed(name)
+    }
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func roomCount(hub *Hub) int {
+    hub.mu.RLock()
+    defer hub.mu.RUnlock()
+    return hub.roomCount()
+}
+
+func TestEmptyRoomRemovedOnLastLeave(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    a := dialWS(t, base+"/ws/transient/a")
+    b := dialWS(t, base+"/ws/transient/b")
+    if !waitFor(time.Second, func() bool { return roomCount(hub) == 1 && hub.existingRoomFor("transient", "") != nil }) {
+        t.Fatal("room was not created")
+    }
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "transient") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+
+    a.Close()
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "transient") == 1 }) {
+        t.Fatal("first client did not leave")
+    }
+    if roomCount(hub) != 1 {
+        t.Fatal("room removed while a member remained")
+    }
+    b.Close()
+    if !waitFor(time.Second, func() bool { return roomCount(hub) == 0 }) {
+        t.Fatalf("room map still holds %d rooms", roomCount(hub))
+    }
+}
+
+func TestPinnedRoomNotRemoved(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    // a connection that looked the room up but has not joined yet
+    r := hub.enterRoom("pending", "alice")
+    hub.removeRoomIfEmpty("pending")
+    if hub.existingRoomFor("pending", "alice") != r {
+        t.Fatal("room removed before its connection joined")
+    }
+    c := &Client{username: "alice", room: r}
+    if err := r.join(c); err != nil {
+        t.Fatal(err)
+    }
+    hub.leaveRoom(r, c)
+    if hub.existingRoomFor("pending", "alice") != nil {
+        t.Fatal("room kept after its last connection left")
+    }
+    // a later lookup gets a fresh room
+    if again := hub.enterRoom("pending", "bob"); again == r {
+        t.Fatal("removed room was handed out again")
+    }
+}
+
+func TestDefaultRoomShardsKept(t *testing.T) {
+    hub := NewHubWithConfig(Config{DefaultRoomShards: 2})
+    r := hub.enterRoom(defaultRoom, "alice")
+    hub.leaveRoom(r, &Client{username: "alice", room: r})
+    if roomCount(hub) != 2 {
+        t.Fatalf("hub holds %d rooms, want both shards", roomCount(hub))
+    }
+}
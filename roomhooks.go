@@ -0,0 +1,88 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "log"
+    "net/http"
+    "time"
+)
+
+// Room lifecycle webhooks. With ROOM_CREATE_WEBHOOK set, every room the hub
+// creates is POSTed there as {"event":"room_created","room","creator","ts"}
+// (creator is the username whose connection or routed message created
+// it); with ROOM_DESTROY_WEBHOOK set, rooms removed once empty are POSTed
+// as room_destroyed. Delivery is fire-and-forget: at most
+// ROOM_WEBHOOK_CONCURRENCY requests are in flight, and events beyond that
+// are logged and dropped rather than holding up room creation. The same
+// machinery POSTs room_egress_cap to ROOM_EGRESS_ALERT_WEBHOOK, with the
+// cap in "limit", when a room is cut off by ROOM_EGRESS_CAP.
+
+type RoomEvent struct {
+    Event   string `json:"event"`
+    Room    string `json:"room"`
+    Creator string `json:"creator,omitempty"`
+    Limit   int64  `json:"limit,omitempty"`
+    Ts      int64  `json:"ts"`
+}
+
+type roomHooks struct {
+    createURL  string
+    destroyURL string
+    alertURL   string
+    client     *http.Client
+    sem        chan struct{}
+}
+
+// newRoomHooks returns nil when no webhook is configured.
+func newRoomHooks(createURL, destroyURL, alertURL string, concurrency int) *roomHooks {
+    if createURL == "" && destroyURL == "" && alertURL == "" {
+        return nil
+    }
+    return &roomHooks{
+        createURL:  createURL,
+        destroyURL: destroyURL,
+        alertURL:   alertURL,
+        client:     &http.Client{Timeout: 5 * time.Second},
+        sem:        make(chan struct{}, max(concurrency, 1)),
+    }
+}
+
+func (rh *roomHooks) created(room, creator string) {
+    if rh != nil && rh.createURL != "" {
+        rh.post(rh.createURL, RoomEvent{Event: "room_created", Room: room, Creator: creator, Ts: time.Now().UnixNano()})
+    }
+}
+
+func (rh *roomHooks) destroyed(room string) {
+    if rh != nil && rh.destroyURL != "" {
+        rh.post(rh.destroyURL, RoomEvent{Event: "room_destroyed", Room: room, Ts: time.Now().UnixNano()})
+    }
+}
+
+func (rh *roomHooks) egressCapped(room string, limit int64) {
+    if rh != nil && rh.alertURL != "" {
+        rh.post(rh.alertURL, RoomEvent{Event: "room_egress_cap", Room: room, Limit: limit, Ts: time.Now().UnixNano()})
+    }
+}
+
+// post sends ev in the background without blocking the caller, which may
+// hold the hub lock.
+func (rh *roomHooks) post(url string, ev RoomEvent) {
+    select {
+    case rh.sem <- struct{}{}:
+    default:
+        log.Printf("room webhook busy, dropping %s for room=%s", ev.Event, ev.Room)
+        return
+    }
+    go func() {
+        defer func() { <-rh.sem }()
+        b, _ := json.Marshal(ev)
+        resp, err := rh.client.Post(url, "application/json", bytes.NewReader(b))
+        if err != nil {
+            log.Printf("room webhook: %v", err)
+            return
+        }
+        resp.Body.Close()
+    }()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestRoomWebhooks(t *testing.T) {
+    events := make(chan RoomEvent, 8)
+    fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var ev RoomEvent
+        if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+            t.Errorf("bad webhook body: %v", err)
+        }
+        events <- ev
+    }))
+    defer fake.Close()
+
+    hub := NewHubWithConfig(Config{RoomCreateWebhook: fake.URL + "/created", RoomDestroyWebhook: fake.URL + "/destroyed"})
+    base := startTestServer(t, hub)
+    expect := func(event, creator string) {
+        t.Helper()
+        select {
+        case ev := <-events:
+            if ev.Event != event || ev.Room != "hooks" || ev.Creator != creator || ev.Ts == 0 {
+                t.Fatalf("got %+v, want %s for room hooks by %q", ev, event, creator)
+            }
+        case <-time.After(2 * time.Second):
+            t.Fatalf("no %s webhook", event)
+        }
+    }
+
+    a := dialWS(t, base+"/ws/hooks/alice")
+    expect("room_created", "alice")
+    b := dialWS(t, base+"/ws/hooks/bob")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "hooks") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+    a.Close()
+    b.Close()
+    expect("room_destroyed", "")
+    select {
+    case ev := <-events:
+        t.Fatalf("unexpected webhook %+v", ev)
+    case <-time.After(100 * time.Millisecond):
+    }
+}
+
+func TestRoomWebhookSaturatedDrops(t *testing.T) {
+    release := make(chan struct{})
+    hits := make(chan struct{}, 8)
+    fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hits <- struct{}{}
+        <-release
+    }))
+    defer fake.Close()
+    defer close(release)
+
+    rh := newRoomHooks(fake.URL, "", "", 1)
+    rh.created("a", "x")
+    <-hits
+    // the only slot is busy: this one is dropped, not queued
+    rh.created("b", "x")
+    select {
+    case <-hits:
+        t.Fatal("webhook exceeded its concurrency bound")
+    case <-time.After(100 * time.Millisecond):
+    }
+    if newRoomHooks("", "", "", 4) != nil {
+        t.Fatal("hooks built with no URLs")
+    }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+)
+
+// RoomSummary is one entry of the /rooms listing.
+type RoomSummary struct {
+    Room    string `json:"room"`
+    Clients int    `json:"clients"`
+    // messages dropped for the current members, mostly slow consumers
+    Drops   int64           `json:"drops"`
+    Members []MemberSummary `json:"members,omitempty"` // ?detail=1 only
+}
+
+// MemberSummary is one connection in a /rooms?detail=1 entry.
+type MemberSummary struct {
+    Username string `json:"username"`
+    Drops    int64  `json:"drops"`
+}
+
+// roomsHandler serves /rooms: every room with its client count and drop
+// total, busiest first. ?min=N leaves out rooms with fewer than N clients;
+// ?detail=1 lists each member's drops, most dropped first.
+func roomsHandler(hub *Hub) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        applyCORSHeaders(w, hub.cfg.AllowedOrigin)
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        minClients := 0
+        if s := r.URL.Query().Get("min"); s != "" {
+            n, err := strconv.Atoi(s)
+            if err != nil || n < 0 {
+                http.Error(w, "invalid min", http.StatusBadRequest)
+                return
+            }
+            minClients = n
+        }
+        var rooms []*Room
+        hub.forEachRoom(func(room *Room) { rooms = append(rooms, room) })
+        detail := r.URL.Query().Get("detail") == "1"
+        list := make([]RoomSummary, 0, len(rooms))
+        for _, room := range rooms {
+            s := RoomSummary{Room: room.name}
+            room.mu.RLock()
+            s.Clients = len(room.clients)
+            for c := range room.clients {
+                d := c.counters.drops.Load()
+                s.Drops += d
+                if detail {
+                    s.Members = append(s.Members, MemberSummary{Username: c.username, Drops: d})
+                }
+            }
+            room.mu.RUnlock()
+            if s.Clients >= minClients {
+                sort.Slice(s.Members, func(i, j int) bool {
+                    if s.Members[i].Drops != s.Members[j].Drops {
+                        return s.Members[i].Drops > s.Members[j].Drops
+                    }
+                    return s.Members[i].Username < s.Members[j].Username
+                })
+                list = append(list, s)
+            }
+        }
+        sort.Slice(list, func(i, j int) bool {
+            if list[i].Clients != list[j].Clients {
+                return list[i].Clients > list[j].Clients
+            }
+            return list[i].Room < list[j].Room
+        })
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(list)
+    }
+}
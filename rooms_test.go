@@ -0,0 +1,58 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestRoomsListing(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/quiet/a")
+    dialWS(t, base+"/ws/busy/a")
+    dialWS(t, base+"/ws/busy/b")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "busy") == 2 && roomSize(hub, "quiet") == 1 }) {
+        t.Fatal("clients did not join")
+    }
+
+    var got []RoomSummary
+    getJSON(t, base, "/rooms", &got)
+    want := []RoomSummary{{Room: "busy", Clients: 2}, {Room: "quiet", Clients: 1}}
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("/rooms = %+v, want %+v", got, want)
+    }
+
+    got = nil
+    getJSON(t, base, "/rooms?min=2", &got)
+    if !reflect.DeepEqual(got, want[:1]) {
+        t.Fatalf("/rooms?min=2 = %+v, want %+v", got, want[:1])
+    }
+}
+
+func TestRoomsReportSlowConsumerDrops(t *testing.T) {
+    hub := NewHubWithConfig(Config{DropWarnThreshold: 5})
+    base := startTestServer(t, hub)
+    room := hub.getRoom("slow")
+    sender := fakeClient(room, "sender", 16)
+    slow := fakeClient(room, "slow", 1) // never read
+    fast := fakeClient(room, "fast", 64)
+    for i := 0; i < 20; i++ {
+        room.broadcast(sender, NewEnvelope("slow", "sender", []byte("flood")))
+    }
+    if drops := slow.counters.drops.Load(); drops != 19 {
+        t.Fatalf("slow reader drops = %d, want 19", drops)
+    }
+    if drops := fast.counters.drops.Load(); drops != 0 {
+        t.Fatalf("fast reader drops = %d", drops)
+    }
+
+    var got []RoomSummary
+    getJSON(t, base, "/rooms?detail=1", &got)
+    want := []RoomSummary{{Room: "slow", Clients: 3, Drops: 19, Members: []MemberSummary{
+        {Username: "slow", Drops: 19}, {Username: "fast"}, {Username: "sender"},
+    }}}
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("/rooms?detail=1 = %+v, want %+v", got, want)
+    }
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+)
+
+// Prefix routing. A connection opened with ?route=1 may address a single
+// message to another room by starting it with "room:<name>|"; the prefix is
+// stripped and the rest is published to that room as if sent there. Only
+// rooms that already exist can be addressed; a message for any other room
+// is refused with an unknown_room error. Other messages go to the
+// connection's own room.
+
+const (
+    routePrefix  = "room:"
+    maxRouteName = 128
+)
+
+// parseRoutePrefix splits "room:<name>|<payload>". ok is false when msg has
+// no routing prefix; err is set when it has a malformed one.
+func parseRoutePrefix(msg []byte) (room string, payload []byte, ok bool, err error) {
+    rest, found := bytes.CutPrefix(msg, []byte(routePrefix))
+    if !found {
+        return "", msg, false, nil
+    }
+    name, payload, found := bytes.Cut(rest, []byte("|"))
+    if !found || len(name) == 0 || len(name) > maxRouteName || bytes.ContainsAny(name, "/ ") {
+        return "", nil, true, fmt.Errorf("malformed route prefix; want %s<room>|<payload>", routePrefix)
+    }
+    return string(name), payload, true, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestParseRoutePrefix(t *testing.T) {
+    cases := []struct {
+        msg     string
+        room    string
+        payload string
+        ok      bool
+        bad     bool
+    }{
+        {"room:foo|hello", "foo", "hello", true, false},
+        {"room:foo|", "foo", "", true, false},
+        {"room:foo|a|b", "foo", "a|b", true, false},
+        {"hello", "", "hello", false, false},
+        {"room:foo", "", "", true, true},
+        {"room:|x", "", "", true, true},
+        {"room:a/b|x", "", "", true, true},
+    }
+    for _, tc := range cases {
+        room, payload, ok, err := parseRoutePrefix([]byte(tc.msg))
+        if room != tc.room || string(payload) != tc.payload || ok != tc.ok || (err != nil) != tc.bad {
+            t.Errorf("parseRoutePrefix(%q) = %q, %q, %v, %v", tc.msg, room, payload, ok, err)
+        }
+    }
+}
+
+func TestPrefixRoutingPublishesToManyRooms(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    router := dialWS(t, base+"/ws/global/router?route=1")
+    plain := dialWS(t, base+"/ws/global/plain")
+    foo := dialWS(t, base+"/ws/foo/alice")
+    bar := dialWS(t, base+"/ws/bar/bob")
+    waitFor(time.Second, func() bool {
+        return roomSize(hub, "global") == 2 && roomSize(hub, "foo") == 1 && roomSize(hub, "bar") == 1
+    })
+
+    for _, msg := range []string{"room:foo|to foo", "room:bar|to bar", "to global", "room:global|also global"} {
+        if err := router.WriteMessage(websocket.BinaryMessage, []byte(msg)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // without ?route=1 the prefix is just payload
+    if err := plain.WriteMessage(websocket.BinaryMessage, []byte("room:foo|literal")); err != nil {
+        t.Fatal(err)
+    }
+
+    expect := func(name string, c *websocket.Conn, want ...string) {
+        t.Helper()
+        got := readEnvelopes(t, c, 200*time.Millisecond)
+        if len(got) != len(want) {
+            t.Fatalf("%s got %+v, want %q", name, got, want)
+        }
+        for i := range want {
+            if string(got[i].Payload) != want[i] {
+                t.Errorf("%s message %d = %q, want %q", name, i, got[i].Payload, want[i])
+            }
+        }
+    }
+    expect("foo", foo, "to foo")
+    expect("bar", bar, "to bar")
+    expect("plain", plain, "to global", "also global")
+    expect("router", router, "room:foo|literal")
+}
+
+func TestPrefixRoutingMalformed(t *testing.T) {
+    base := startTestServer(t, NewHub())
+    c := dialWS(t, base+"/ws/global/router?route=1")
+    if err := c.WriteMessage(websocket.BinaryMessage, []byte("room:foo")); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := c.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ef ErrorFrame
+    if err := json.Unmarshal(raw, &ef); err != nil || ef.Code != "bad_route" {
+        t.Fatalf("expected bad_route error, got %s", raw)
+    }
+}
+
+func TestPrefixRoutingUnknownRoom(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    c := dialWS(t, base+"/ws/global/router?route=1")
+    if err := c.WriteMessage(websocket.BinaryMessage, []byte("room:nowhere|hi")); err != nil {
+        t.Fatal(err)
+    }
+    expectErrorFrame(t, c, "unknown_room")
+    if hub.lookupRoom("nowhere") != nil {
+        t.Fatal("routing created the room")
+    }
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Per-room JSON Schemas. Messages sent to a room with a schema must be JSON
+// documents that validate against it; anything else is dropped and the
+// sender gets an error frame. Schemas are loaded from files at startup
+// (ROOM_SCHEMAS) and can be replaced or removed at runtime through the
+// admin endpoint /schemas.
+
+// maxSchemaBytes bounds a schema uploaded through /schemas.
+const maxSchemaBytes = 1 << 20
+
+type roomSchemas struct {
+    mu      sync.RWMutex
+    schemas map[string]*jsonschema.Schema
+}
+
+// LoadRoomSchemas registers schema files from a ROOM_SCHEMAS spec:
+// "room=path/to/schema.json,other=other.json".
+func (h *Hub) LoadRoomSchemas(spec string) error {
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        room, path, ok := strings.Cut(entry, "=")
+        if !ok {
+            return fmt.Errorf("invalid ROOM_SCHEMAS entry %q", entry)
+        }
+        room, path = strings.TrimSpace(room), strings.TrimSpace(path)
+        b, err := os.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        if err := h.SetRoomSchema(room, b); err != nil {
+            return fmt.Errorf("schema for room %s: %w", room, err)
+        }
+    }
+    return nil
+}
+
+// SetRoomSchema compiles schema and enforces it on room; a nil schema
+// removes validation from the room.
+func (h *Hub) SetRoomSchema(room string, schema []byte) error {
+    var s *jsonschema.Schema
+    if schema != nil {
+        c := jsonschema.NewCompiler()
+        url := "mem://rooms/" + room + ".json"
+        if err := c.AddResource(url, bytes.NewReader(schema)); err != nil {
+            return err
+        }
+        var err error
+        if s, err = c.Compile(url); err != nil {
+            return err
+        }
+    }
+    h.schemas.mu.Lock()
+    defer h.schemas.mu.Unlock()
+    if s == nil {
+        delete(h.schemas.schemas, room)
+        return nil
+    }
+    if h.schemas.schemas == nil {
+        h.schemas.schemas = make(map[string]*jsonschema.Schema)
+    }
+    h.schemas.schemas[room] = s
+    return nil
+}
+
+// validateMessage checks msg against room's schema, if it has one.
+func (h *Hub) validateMessage(room string, msg []byte) error {
+    h.schemas.mu.RLock()
+    s := h.schemas.schemas[room]
+    h.schemas.mu.RUnlock()
+    if s == nil {
+        return nil
+    }
+    var v any
+    dec := json.NewDecoder(bytes.NewReader(msg))
+    dec.UseNumber()
+    if err := dec.Decode(&v); err != nil {
+        return fmt.Errorf("invalid JSON: %v", err)
+    }
+    if _, err := dec.Token(); err != io.EOF {
+        return fmt.Errorf("invalid JSON: data after the document")
+    }
+    return s.Validate(v)
+}
+
+// schemaRooms returns the rooms with a schema, sorted.
+func (h *Hub) schemaRooms() []string {
+    h.schemas.mu.RLock()
+    rooms := make([]string, 0, len(h.schemas.schemas))
+    for room := range h.schemas.schemas {
+        rooms = append(rooms, room)
+    }
+    h.schemas.mu.RUnlock()
+    sort.Strings(rooms)
+    return rooms
+}
+
+// schemasHandler serves /schemas to requests bearing ADMIN_TOKEN: GET
+// lists the rooms with a schema, PUT /schemas?room=<name> installs the
+// request body as the room's schema and DELETE /schemas?room=<name>
+// removes it.
+func schemasHandler(hub *Hub) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if hub.cfg.AdminToken == "" {
+            http.NotFound(w, r)
+            return
+        }
+        room := r.URL.Query().Get("room")
+        action := map[string]string{http.MethodGet: auditSchemasList, http.MethodPut: auditSchemaPut, http.MethodDelete: auditSchemaDelete}[r.Method]
+        if !authorizedAdmin(r, hub.cfg.AdminToken) {
+            if action != "" {
+                hub.audit(r, action, room, auditDenied)
+            }
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        switch r.Method {
+        case http.MethodGet:
+            hub.audit(r, action, "", auditOK)
+            w.Header().Set("Content-Type", "application/json")
+            _ = json.NewEncoder(w).Encode(map[string][]string{"rooms": hub.schemaRooms()})
+        case http.MethodPut:
+            if room == "" {
+                hub.audit(r, action, room, "room required")
+                http.Error(w, "room required", http.StatusBadRequest)
+                return
+            }
+            b, err := io.ReadAll(io.LimitReader(r.Body, maxSchemaBytes+1))
+            if err != nil {
+                hub.audit(r, action, room, err.Error())
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            if len(b) > maxSchemaBytes {
+                hub.audit(r, action, room, "schema too large")
+                http.Error(w, "schema too large", http.StatusRequestEntityTooLarge)
+                return
+            }
+            if err := hub.SetRoomSchema(room, b); err != nil {
+                hub.audit(r, action, room, "invalid schema: "+err.Error())
+                http.Error(w, "invalid schema: "+err.Error(), http.StatusBadRequest)
+                return
+            }
+            hub.audit(r, action, room, auditOK)
+            w.WriteHeader(http.StatusNoContent)
+        case http.MethodDelete:
+            if room == "" {
+                hub.audit(r, action, room, "room required")
+                http.Error(w, "room required", http.StatusBadRequest)
+                return
+            }
+            hub.SetRoomSchema(room, nil)
+            hub.audit(r, action, room, auditOK)
+            w.WriteHeader(http.StatusNoContent)
+        default:
+            w.Header().Set("Allow", "GET, PUT, DELETE")
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        }
+    }
+}
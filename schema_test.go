@@ -0,0 +1,157 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+const chatSchema = `{
+  "type": "object",
+  "required": ["text"],
+  "properties": {
+    "text": {"type": "string", "maxLength": 20}
+  }
+}`
+
+func TestLoadRoomSchemas(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "chat.json")
+    if err := os.WriteFile(path, []byte(chatSchema), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    hub := NewHub()
+    if err := hub.LoadRoomSchemas("chat=" + path); err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    cases := []struct {
+        msg   string
+        valid bool
+    }{
+        {`{"text":"hello"}`, true},
+        {`{"text":42}`, false},
+        {`{"other":"x"}`, false},
+        {`{"text":"this is far too long to pass"}`, false},
+        {`not json`, false},
+        {`{"text":"hello"}xyz`, false},
+        {`{"text":"hello"} {"text":"again"}`, false},
+        {"{\"text\":\"hello\"}\n", true},
+    }
+    for _, tc := range cases {
+        err := hub.validateMessage("chat", []byte(tc.msg))
+        if (err == nil) != tc.valid {
+            t.Errorf("validate %s: err=%v, want valid=%v", tc.msg, err, tc.valid)
+        }
+    }
+    if err := hub.validateMessage("other", []byte("not json")); err != nil {
+        t.Errorf("room without schema should accept anything, got %v", err)
+    }
+    if err := hub.LoadRoomSchemas("chat"); err == nil {
+        t.Error("expected error for entry without path")
+    }
+}
+
+func TestSchemaRoomRelay(t *testing.T) {
+    hub := NewHub()
+    if err := hub.SetRoomSchema("chat", []byte(chatSchema)); err != nil {
+        t.Fatal(err)
+    }
+    url := startTestServer(t, hub)
+    sender := dialWS(t, url+"/ws/chat/alice")
+    receiver := dialWS(t, url+"/ws/chat/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "chat") == 2 })
+
+    // invalid message: dropped, sender gets an error frame
+    if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"text":1}`)); err != nil {
+        t.Fatal(err)
+    }
+    sender.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := sender.ReadMessage()
+    if err != nil {
+        t.Fatalf("sender read: %v", err)
+    }
+    var ef ErrorFrame
+    if err := json.Unmarshal(raw, &ef); err != nil || ef.Type != "error" || ef.Code != "schema_violation" {
+        t.Fatalf("unexpected error frame %s (%v)", raw, err)
+    }
+
+    // valid message: relayed; it must be the first thing the receiver sees
+    if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"text":"hi"}`)); err != nil {
+        t.Fatal(err)
+    }
+    receiver.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err = receiver.ReadMessage()
+    if err != nil {
+        t.Fatalf("receiver read: %v", err)
+    }
+    var env Envelope
+    if err := json.Unmarshal(raw, &env); err != nil || string(env.Payload) != `{"text":"hi"}` {
+        t.Fatalf("unexpected envelope %s (%v)", raw, err)
+    }
+}
+
+func schemaRequest(t *testing.T, wsBase, method, query, body, token string) *http.Response {
+    t.Helper()
+    req, _ := http.NewRequest(method, "http"+wsBase[len("ws"):]+"/schemas"+query, strings.NewReader(body))
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    return resp
+}
+
+func TestSchemasAdminEndpoint(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "admin-token"})
+    base := startTestServer(t, hub)
+
+    if resp := schemaRequest(t, base, http.MethodPut, "?room=chat", chatSchema, ""); resp.StatusCode != http.StatusUnauthorized {
+        t.Fatalf("unauthenticated PUT: %d", resp.StatusCode)
+    }
+    if resp := schemaRequest(t, base, http.MethodPut, "?room=chat", `{"type": 12}`, "admin-token"); resp.StatusCode != http.StatusBadRequest {
+        t.Fatalf("invalid schema: %d", resp.StatusCode)
+    }
+    if resp := schemaRequest(t, base, http.MethodPut, "", chatSchema, "admin-token"); resp.StatusCode != http.StatusBadRequest {
+        t.Fatalf("PUT without room: %d", resp.StatusCode)
+    }
+    if resp := schemaRequest(t, base, http.MethodPut, "?room=chat", chatSchema, "admin-token"); resp.StatusCode != http.StatusNoContent {
+        t.Fatalf("PUT: %d", resp.StatusCode)
+    }
+    if err := hub.validateMessage("chat", []byte(`{"text":1}`)); err == nil {
+        t.Fatal("uploaded schema not enforced")
+    }
+
+    req, _ := http.NewRequest(http.MethodGet, "http"+base[len("ws"):]+"/schemas", nil)
+    req.Header.Set("Authorization", "Bearer admin-token")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var list struct{ Rooms []string }
+    if err := json.NewDecoder(resp.Body).Decode(&list); err != nil || len(list.Rooms) != 1 || list.Rooms[0] != "chat" {
+        t.Fatalf("list %v (%v)", list.Rooms, err)
+    }
+    resp.Body.Close()
+
+    if resp := schemaRequest(t, base, http.MethodDelete, "?room=chat", "", "admin-token"); resp.StatusCode != http.StatusNoContent {
+        t.Fatalf("DELETE: %d", resp.StatusCode)
+    }
+    if err := hub.validateMessage("chat", []byte(`{"text":1}`)); err != nil {
+        t.Fatalf("schema still enforced after DELETE: %v", err)
+    }
+}
+
+func TestSchemasEndpointDisabledWithoutAdminToken(t *testing.T) {
+    base := startTestServer(t, NewHub())
+    if resp := schemaRequest(t, base, http.MethodPut, "?room=chat", chatSchema, "anything"); resp.StatusCode != http.StatusNotFound {
+        t.Fatalf("status %d, want 404", resp.StatusCode)
+    }
+}
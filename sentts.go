@@ -0,0 +1,95 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "math/rand/v2"
+    "slices"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Client send timestamps. A connection opened with ?sent=1 may start a
+// message with "sent:<unix-ms>|", the time the client sent it, before any
+// other header. The relay strips the header and profiles the one-way
+// latency per room for /stats. A timestamp more than CLOCK_SKEW_TOLERANCE
+// away from the relay's clock, in either direction, comes from a skewed
+// clock: it is counted as skewed and left out of the profile, and the
+// message is relayed all the same.
+
+const sentPrefix = "sent:"
+
+// parseSentPrefix splits "sent:<unix-ms>|<payload>". ok is false when msg
+// has no timestamp header; err is set when it has a malformed one.
+func parseSentPrefix(msg []byte) (sent time.Time, payload []byte, ok bool, err error) {
+    rest, found := bytes.CutPrefix(msg, []byte(sentPrefix))
+    if !found {
+        return time.Time{}, msg, false, nil
+    }
+    spec, payload, found := bytes.Cut(rest, []byte("|"))
+    var ms int64
+    if found {
+        ms, err = strconv.ParseInt(string(spec), 10, 64)
+    }
+    if !found || err != nil || ms <= 0 {
+        return time.Time{}, nil, true, fmt.Errorf("malformed sent header; want %s<unix-ms>|<payload>", sentPrefix)
+    }
+    return time.UnixMilli(ms), payload, true, nil
+}
+
+// latencyStats profiles a room's client-to-relay latencies like sizeStats
+// profiles payload sizes, with a reservoir sample for percentiles.
+type latencyStats struct {
+    mu      sync.Mutex
+    count   int64
+    skewed  int64
+    samples []time.Duration
+}
+
+// LatencyStats is the /stats view of a room's one-way latencies.
+type LatencyStats struct {
+    Count  int64   `json:"count"`
+    Skewed int64   `json:"skewed"` // timestamps outside CLOCK_SKEW_TOLERANCE, not profiled
+    P50Ms  float64 `json:"p50_ms"`
+    P95Ms  float64 `json:"p95_ms"`
+    P99Ms  float64 `json:"p99_ms"`
+}
+
+// observe records a message sent at sent and received at now. A latency
+// below zero but within tolerance is small clock drift and counts as 0.
+func (s *latencyStats) observe(sent, now time.Time, tolerance time.Duration) {
+    d := now.Sub(sent)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if d > tolerance || d < -tolerance {
+        s.skewed++
+        return
+    }
+    d = max(d, 0)
+    s.count++
+    if len(s.samples) < sizeSampleCap {
+        s.samples = append(s.samples, d)
+    } else if i := rand.Int64N(s.count); i < sizeSampleCap {
+        s.samples[i] = d
+    }
+}
+
+// snapshot reports the current statistics and, with reset, starts over.
+func (s *latencyStats) snapshot(reset bool) LatencyStats {
+    s.mu.Lock()
+    st := LatencyStats{Count: s.count, Skewed: s.skewed}
+    samples := slices.Clone(s.samples)
+    if reset {
+        s.count, s.skewed, s.samples = 0, 0, s.samples[:0]
+    }
+    s.mu.Unlock()
+    if len(samples) > 0 {
+        slices.Sort(samples)
+        at := func(p int) float64 {
+            return float64(samples[(len(samples)*p+99)/100-1]) / float64(time.Millisecond)
+        }
+        st.P50Ms, st.P95Ms, st.P99Ms = at(50), at(95), at(99)
+    }
+    return st
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestParseSentPrefix(t *testing.T) {
+    sent, payload, ok, err := parseSentPrefix([]byte("sent:1700000000000|hi"))
+    if err != nil || !ok || !sent.Equal(time.UnixMilli(1700000000000)) || string(payload) != "hi" {
+        t.Fatalf("got %v %q %v %v", sent, payload, ok, err)
+    }
+    if _, payload, ok, err := parseSentPrefix([]byte("hello")); ok || err != nil || string(payload) != "hello" {
+        t.Fatal("plain message taken for a timestamp")
+    }
+    for _, bad := range []string{"sent:|x", "sent:abc|x", "sent:-5|x", "sent:123"} {
+        if _, _, ok, err := parseSentPrefix([]byte(bad)); !ok || err == nil {
+            t.Fatalf("%q accepted", bad)
+        }
+    }
+}
+
+func TestSkewedTimestampsExcludedButDelivered(t *testing.T) {
+    hub := NewHubWithConfig(Config{ClockSkewTolerance: time.Minute})
+    base := startTestServer(t, hub)
+    recv := dialWS(t, base+"/ws/clocks/recv")
+    sender := dialWS(t, base+"/ws/clocks/sender?sent=1")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "clocks") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+    now := time.Now()
+    stamps := []time.Time{now.Add(-20 * time.Millisecond), now.Add(-time.Hour), now.Add(time.Hour), now.Add(-10 * time.Millisecond)}
+    for i, ts := range stamps {
+        msg := fmt.Sprintf("sent:%d|m%d", ts.UnixMilli(), i)
+        if err := sender.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := readEnvelopes(t, recv, 300*time.Millisecond)
+    if len(got) != len(stamps) {
+        t.Fatalf("delivered %d of %d messages", len(got), len(stamps))
+    }
+    for i, env := range got {
+        if want := fmt.Sprintf("m%d", i); string(env.Payload) != want {
+            t.Fatalf("message %d = %q, want %q with the header stripped", i, env.Payload, want)
+        }
+    }
+
+    var rs RoomStats
+    getJSON(t, base, "/stats?room=clocks", &rs)
+    lat := rs.IngressLatency
+    if lat.Count != 2 || lat.Skewed != 2 {
+        t.Fatalf("latency profile %+v, want 2 profiled and 2 skewed", lat)
+    }
+    if lat.P99Ms < 10 || lat.P99Ms > 1000 {
+        t.Fatalf("p99 %.1fms polluted by a skewed timestamp", lat.P99Ms)
+    }
+}
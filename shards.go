@@ -0,0 +1,126 @@
+package main
+
+import (
+    "fmt"
+    "hash/fnv"
+    "strconv"
+    "strings"
+)
+
+// defaultRoom is where connections without a room land.
+const defaultRoom = "global"
+
+// With DEFAULT_ROOM_SHARDS > 1 the default room is split into sub-rooms
+// (global-0..global-N-1). Clients are hashed to a shard by username and
+// every message is published to all shards, so the room still behaves as
+// one while no single room's lock and fan-out carry every member. Frames
+// about the room as a whole (presence, digests, rosters) likewise go to
+// the members of every shard, and DIGEST_ROOMS and PRESENCE_ROOMS name the
+// default room rather than its shards.
+
+// roomFor returns the room a client joins for the requested room name.
+func (h *Hub) roomFor(name, username string) *Room {
+    n := h.cfg.DefaultRoomShards
+    if name != defaultRoom || n <= 1 {
+        return h.getRoomAs(name, username)
+    }
+    return h.getRoomAs(shardName(shardIndex(username, n)), username)
+}
+
+// existingRoomFor is roomFor without creating the room; it returns nil when
+// the room does not exist yet.
+func (h *Hub) existingRoomFor(name, username string) *Room {
+    if n := h.cfg.DefaultRoomShards; name == defaultRoom && n > 1 {
+        name = shardName(shardIndex(username, n))
+    }
+    return h.lookupRoom(name)
+}
+
+// hasClients reports whether anyone would receive a publish to r, counting
+// sibling shards of the default room.
+func (r *Room) hasClients() bool {
+    for _, room := range r.group() {
+        room.mu.RLock()
+        n := len(room.clients)
+        room.mu.RUnlock()
+        if n > 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// group is r with its sibling shards; just r for any other room.
+func (r *Room) group() []*Room {
+    if r.shards == nil {
+        return []*Room{r}
+    }
+    return r.shards
+}
+
+// groupMembers returns the members of every room in r's group.
+func (r *Room) groupMembers() []*Client {
+    var members []*Client
+    for _, room := range r.group() {
+        if room.cow {
+            members = append(members, *room.members.Load()...)
+            continue
+        }
+        room.mu.RLock()
+        for c := range room.clients {
+            members = append(members, c)
+        }
+        room.mu.RUnlock()
+    }
+    return members
+}
+
+// primary is the shard that keeps the default room's room-wide counters;
+// any other room is its own.
+func (r *Room) primary() *Room {
+    if r.shards == nil {
+        return r
+    }
+    return r.shards[0]
+}
+
+// logicalName is the room clients asked for: the default room for any of
+// its shards.
+func (r *Room) logicalName() string {
+    if r.shards == nil {
+        return r.name
+    }
+    return defaultRoom
+}
+
+func shardName(i int) string {
+    return fmt.Sprintf("%s-%d", defaultRoom, i)
+}
+
+func (h *Hub) isDefaultShard(name string) bool {
+    suffix, ok := strings.CutPrefix(name, defaultRoom+"-")
+    if !ok || h.cfg.DefaultRoomShards <= 1 {
+        return false
+    }
+    i, err := strconv.Atoi(suffix)
+    return err == nil && i >= 0 && i < h.cfg.DefaultRoomShards && shardName(i) == name
+}
+
+// createShardsLocked creates every shard of the default room at once, so a
+// shard is never visible without its siblings. Caller holds h.mu for
+// reading and every bucket lock.
+func (h *Hub) createShardsLocked(creator string) {
+    shards := make([]*Room, h.cfg.DefaultRoomShards)
+    for i := range shards {
+        shards[i] = h.newRoomLocked(shardName(i), creator)
+    }
+    for _, r := range shards {
+        r.shards = shards
+    }
+}
+
+func shardIndex(key string, n int) int {
+    f := fnv.New32a()
+    f.Write([]byte(key))
+    return int(f.Sum32() % uint32(n))
+}
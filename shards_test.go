@@ -0,0 +1,120 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestShardIndexIsBalanced(t *testing.T) {
+    const n, users = 4, 1000
+    counts := make([]int, n)
+    for i := 0; i < users; i++ {
+        counts[shardIndex(fmt.Sprintf("user-%d", i), n)]++
+    }
+    for i, c := range counts {
+        if c < users/n/2 || c > users/n*3/2 {
+            t.Fatalf("shard %d has %d of %d users: %v", i, c, users, counts)
+        }
+    }
+}
+
+func TestDefaultRoomShards(t *testing.T) {
+    hub := NewHubWithConfig(Config{DefaultRoomShards: 4})
+    r := hub.roomFor(defaultRoom, "alice")
+    if len(r.shards) != 4 {
+        t.Fatalf("shard group has %d rooms, want 4", len(r.shards))
+    }
+    for i, s := range r.shards {
+        if s.name != shardName(i) || hub.getRoom(shardName(i)) != s {
+            t.Fatalf("shard %d is %q, not registered as %q", i, s.name, shardName(i))
+        }
+    }
+    if other := hub.roomFor("lobby", "alice"); other.name != "lobby" || other.shards != nil {
+        t.Fatal("non-default rooms must not be sharded")
+    }
+    if plain := NewHub().roomFor(defaultRoom, "alice"); plain.name != defaultRoom {
+        t.Fatalf("sharding disabled should use %q, got %q", defaultRoom, plain.name)
+    }
+}
+
+func TestCrossShardDelivery(t *testing.T) {
+    const n = 4
+    hub := NewHubWithConfig(Config{DefaultRoomShards: n})
+    base := startTestServer(t, hub)
+
+    // pick two users that hash to different shards
+    alice, bob := "alice", ""
+    for i := 0; bob == ""; i++ {
+        if name := fmt.Sprintf("bob%d", i); shardIndex(name, n) != shardIndex(alice, n) {
+            bob = name
+        }
+    }
+    sender := dialWS(t, base+"/ws/global/"+alice)
+    receiver := dialWS(t, base+"/ws/global/"+bob)
+    waitFor(time.Second, func() bool {
+        return roomSize(hub, shardName(shardIndex(alice, n))) == 1 && roomSize(hub, shardName(shardIndex(bob, n))) == 1
+    })
+
+    if err := sender.WriteMessage(websocket.BinaryMessage, []byte("across")); err != nil {
+        t.Fatal(err)
+    }
+    got := readEnvelopes(t, receiver, 300*time.Millisecond)
+    if len(got) != 1 || string(got[0].Payload) != "across" || got[0].Room != defaultRoom {
+        t.Fatalf("unexpected delivery %+v", got)
+    }
+    if echoed := readEnvelopes(t, sender, 100*time.Millisecond); len(echoed) != 0 {
+        t.Fatalf("sender received its own message from a sibling shard")
+    }
+}
+
+func TestRoomWideFramesCrossShards(t *testing.T) {
+    const n = 2
+    hub := NewHubWithConfig(Config{DefaultRoomShards: n, PresenceRooms: defaultRoom})
+    if err := hub.LoadDigestRooms(defaultRoom + "=50ms"); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    alice, bob := "alice", ""
+    for i := 0; bob == ""; i++ {
+        if name := fmt.Sprintf("bob%d", i); shardIndex(name, n) != shardIndex(alice, n) {
+            bob = name
+        }
+    }
+    a := dialWS(t, base+"/ws/global/"+alice)
+    waitFor(time.Second, func() bool { return roomSize(hub, shardName(shardIndex(alice, n))) == 1 })
+    b := dialWS(t, base+"/ws/global/"+bob)
+    next := func(c *websocket.Conn) []byte {
+        t.Helper()
+        c.SetReadDeadline(time.Now().Add(2 * time.Second))
+        _, raw, err := c.ReadMessage()
+        if err != nil {
+            t.Fatal(err)
+        }
+        return raw
+    }
+
+    var env Envelope
+    if err := json.Unmarshal(next(a), &env); err != nil || env.Type != envelopePresence || env.Username != bob || env.Room != defaultRoom {
+        t.Fatalf("alice got %+v (%v), want bob's join from the other shard", env, err)
+    }
+
+    if err := a.WriteMessage(websocket.TextMessage, []byte("batched")); err != nil {
+        t.Fatal(err)
+    }
+    var digest DigestFrame
+    if err := json.Unmarshal(next(b), &digest); err != nil || digest.Type != "digest" || digest.Room != defaultRoom || len(digest.Messages) != 1 {
+        t.Fatalf("bob got %+v (%v), want alice's digest from the other shard", digest, err)
+    }
+
+    if err := b.WriteMessage(websocket.TextMessage, []byte(`{"op":"roster"}`)); err != nil {
+        t.Fatal(err)
+    }
+    var roster RosterFrame
+    if err := json.Unmarshal(next(b), &roster); err != nil || fmt.Sprint(roster.Users) != fmt.Sprint([]string{alice, bob}) {
+        t.Fatalf("roster %+v (%v), want both shards' members", roster, err)
+    }
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "context"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// Shutdown closes every client with 1001 "going away", after letting each
+// flush its queue for up to CLOSE_DRAIN_TIMEOUT (never past ctx's
+// deadline), and waits until all of them are cleaned up or ctx is done.
+// Clients that connect while it waits are closed the same way.
+func (h *Hub) Shutdown(ctx context.Context) error {
+    deadline := time.Now().Add(h.cfg.CloseDrainTimeout)
+    if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+        deadline = d
+    }
+    tick := time.NewTicker(10 * time.Millisecond)
+    defer tick.Stop()
+    for {
+        for _, c := range h.liveClients() {
+            c.drainAndClose(websocket.CloseGoingAway, "going away", deadline)
+        }
+        if h.metrics.connections.Load() == 0 {
+            return nil
+        }
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-tick.C:
+        }
+    }
+}
+
+// liveClients returns every tracked connection, joined or not.
+func (h *Hub) liveClients() []*Client {
+    h.idMu.Lock()
+    defer h.idMu.Unlock()
+    var clients []*Client
+    for _, conns := range h.identities {
+        for c := range conns {
+            clients = append(clients, c)
+        }
+    }
+    return clients
+}
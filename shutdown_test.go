@@ -0,0 +1,64 @@
+package main
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestShutdownClosesClientsWithGoingAway(t *testing.T) {
+    hub := NewHubWithConfig(Config{CloseDrainTimeout: time.Second})
+    base := startTestServer(t, hub)
+    a := dialWS(t, base+"/ws/r1/alice")
+    b := dialWS(t, base+"/ws/r2/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r1") == 1 && roomSize(hub, "r2") == 1 })
+    if err := b.WriteMessage(websocket.TextMessage, []byte("last words")); err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    done := make(chan error, 1)
+    go func() { done <- hub.Shutdown(ctx) }()
+    for _, c := range []*websocket.Conn{a, b} {
+        if ce := expectClose(t, c); ce.Code != websocket.CloseGoingAway {
+            t.Fatalf("close code = %d, want %d", ce.Code, websocket.CloseGoingAway)
+        }
+    }
+    if err := <-done; err != nil {
+        t.Fatalf("Shutdown: %v", err)
+    }
+    if n := hub.metrics.connections.Load(); n != 0 {
+        t.Fatalf("%d connections left after shutdown", n)
+    }
+}
+
+func TestShutdownGivesUpAtContextDeadline(t *testing.T) {
+    hub := NewHub()
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/r/alice")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 })
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    hub.metrics.connections.Add(1) // a connection that never finishes cleanup
+    if err := hub.Shutdown(ctx); err == nil {
+        t.Fatal("Shutdown returned nil with connections left")
+    }
+}
+
+func TestUDPRelayStopsWithContext(t *testing.T) {
+    hub := NewHub()
+    ctx, cancel := context.WithCancel(context.Background())
+    if _, err := StartUDPRelay(ctx, "0", hub); err != nil {
+        t.Fatal(err)
+    }
+    if !hub.udpUp.Load() {
+        t.Fatal("relay not running")
+    }
+    cancel()
+    if !waitFor(time.Second, func() bool { return !hub.udpUp.Load() }) {
+        t.Fatal("UDP read loop still running after cancel")
+    }
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "math/rand/v2"
+    "slices"
+    "sync"
+)
+
+// sizeSampleCap bounds the per-room reservoir; percentiles are exact until
+// a room has broadcast this many messages and sampled afterwards.
+const sizeSampleCap = 1024
+
+// sizeStats profiles a room's payload sizes: exact count/min/max/mean and a
+// reservoir sample (Algorithm R) for percentiles.
+type sizeStats struct {
+    mu       sync.Mutex
+    count    int64
+    sum      int64
+    min, max int
+    samples  []int32
+}
+
+// SizeStats is the /stats view of a room's payload sizes in bytes.
+type SizeStats struct {
+    Count int64   `json:"count"`
+    Min   int     `json:"min"`
+    Max   int     `json:"max"`
+    Avg   float64 `json:"avg"`
+    P95   int     `json:"p95"`
+}
+
+func (s *sizeStats) observe(n int) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.count == 0 || n < s.min {
+        s.min = n
+    }
+    if n > s.max {
+        s.max = n
+    }
+    s.count++
+    s.sum += int64(n)
+    if len(s.samples) < sizeSampleCap {
+        s.samples = append(s.samples, int32(n))
+    } else if i := rand.Int64N(s.count); i < sizeSampleCap {
+        s.samples[i] = int32(n)
+    }
+}
+
+// snapshot reports the current statistics and, with reset, starts over.
+func (s *sizeStats) snapshot(reset bool) SizeStats {
+    s.mu.Lock()
+    st := SizeStats{Count: s.count, Min: s.min, Max: s.max}
+    samples := slices.Clone(s.samples)
+    if s.count > 0 {
+        st.Avg = float64(s.sum) / float64(s.count)
+    }
+    if reset {
+        s.count, s.sum, s.min, s.max, s.samples = 0, 0, 0, 0, s.samples[:0]
+    }
+    s.mu.Unlock()
+    if len(samples) > 0 {
+        slices.Sort(samples)
+        st.P95 = int(samples[(len(samples)*95+99)/100-1])
+    }
+    return st
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestSizeStats(t *testing.T) {
+    var s sizeStats
+    if got := s.snapshot(false); got != (SizeStats{}) {
+        t.Fatalf("empty: %+v", got)
+    }
+    for n := 100; n >= 1; n-- {
+        s.observe(n)
+    }
+    want := SizeStats{Count: 100, Min: 1, Max: 100, Avg: 50.5, P95: 95}
+    if got := s.snapshot(true); got != want {
+        t.Fatalf("got %+v, want %+v", got, want)
+    }
+    if got := s.snapshot(false); got != (SizeStats{}) {
+        t.Fatalf("after reset: %+v", got)
+    }
+    for i := 0; i < 5*sizeSampleCap; i++ {
+        s.observe(10)
+    }
+    if len(s.samples) != sizeSampleCap {
+        t.Fatalf("reservoir grew to %d", len(s.samples))
+    }
+    if got := s.snapshot(false); got.Count != 5*sizeSampleCap || got.P95 != 10 {
+        t.Fatalf("got %+v", got)
+    }
+}
+
+func TestRoomSizeStatsEndpoint(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "admin-token"})
+    base := startTestServer(t, hub)
+    r := hub.getRoom("media")
+    fakeClient(r, "viewer", 64)
+    for _, n := range []int{10, 20, 30, 40} {
+        r.publish(nil, NewEnvelope("media", "udp", make([]byte, n)))
+    }
+
+    var rs RoomStats
+    if resp := statsRequest(t, base, http.MethodGet, "/stats?room=media&reset=1", "admin-token", &rs); resp.StatusCode != http.StatusOK {
+        t.Fatalf("reset: %d", resp.StatusCode)
+    }
+    want := SizeStats{Count: 4, Min: 10, Max: 40, Avg: 25, P95: 40}
+    if rs.Room != "media" || rs.PayloadSizes != want {
+        t.Fatalf("got %s %+v, want %+v", rs.Room, rs.PayloadSizes, want)
+    }
+    getJSON(t, base, "/stats?room=media", &rs)
+    if rs.PayloadSizes.Count != 0 {
+        t.Fatalf("reset=1 did not reset: %+v", rs.PayloadSizes)
+    }
+
+    resp, err := http.Get("http" + base[len("ws"):] + "/stats?room=nope")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotFound {
+        t.Fatalf("unknown room: got %d, want 404", resp.StatusCode)
+    }
+}
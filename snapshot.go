@@ -0,0 +1,23 @@
+package main
+
+// Copy-on-write member snapshots (SNAPSHOT_ROOMS). In a large room whose
+// membership rarely changes, every broadcast taking the room lock to walk
+// the clients map is wasted work and contends with other broadcasts. A
+// snapshot room keeps an immutable slice of its members, rebuilt under the
+// room lock on every join and leave and swapped in atomically; broadcasts
+// iterate whichever slice is current without locking. A broadcast may thus
+// still reach a client that left a moment ago, which is harmless since a
+// client's queue outlives its connection. Joins and leaves cost O(members),
+// so churny rooms are better left out.
+
+// snapshotLocked republishes the member snapshot; the caller holds r.mu.
+func (r *Room) snapshotLocked() {
+    if !r.cow {
+        return
+    }
+    members := make([]*Client, 0, len(r.clients))
+    for c := range r.clients {
+        members = append(members, c)
+    }
+    r.members.Store(&members)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestSnapshotRoomUnderChurn(t *testing.T) {
+    hub := NewHubWithConfig(Config{SnapshotRooms: "stable"})
+    room := hub.getRoom("stable")
+    if !room.cow {
+        t.Fatal("room should keep a member snapshot")
+    }
+    const messages = 200
+    steady := []*Client{fakeClient(room, "a", messages), fakeClient(room, "b", messages)}
+    sender := &Client{username: "sender", room: room}
+
+    stop := make(chan struct{})
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        for i := 0; ; i++ {
+            select {
+            case <-stop:
+                return
+            default:
+            }
+            c := &Client{username: fmt.Sprintf("churn-%d", i), room: room, sendCh: make(chan outbound, 1)}
+            room.join(c)
+            room.leave(c)
+        }
+    }()
+    for i := 0; i < messages; i++ {
+        room.broadcast(sender, NewEnvelope("stable", "sender", []byte(fmt.Sprint(i))))
+    }
+    close(stop)
+    wg.Wait()
+
+    for _, c := range steady {
+        if got := drain(c, messages, time.Second); len(got) != messages {
+            t.Fatalf("%s received %d of %d messages", c.username, len(got), messages)
+        }
+    }
+    members := *room.members.Load()
+    if len(members) != len(steady) || roomSize(hub, "stable") != len(steady) {
+        t.Fatalf("snapshot holds %d members, room %d; want %d", len(members), roomSize(hub, "stable"), len(steady))
+    }
+}
+
+func benchmarkBroadcast(b *testing.B, cfg Config) {
+    hub := NewHubWithConfig(cfg)
+    room := hub.getRoom("bench")
+    for i := 0; i < 1000; i++ {
+        // unbuffered and never read: each send takes the full-queue path
+        // without a reader goroutine to schedule
+        fakeClient(room, fmt.Sprintf("c%d", i), 0)
+    }
+    // occasional membership changes, as in a large but stable room
+    stop := make(chan struct{})
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        churn := &Client{username: "churn", room: room, sendCh: make(chan outbound)}
+        for {
+            select {
+            case <-stop:
+                return
+            case <-time.After(time.Millisecond):
+            }
+            room.join(churn)
+            room.leave(churn)
+        }
+    }()
+    sender := &Client{username: "sender", room: room}
+    env := NewEnvelope("bench", "sender", []byte("tick"))
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        for pb.Next() {
+            room.broadcast(sender, env)
+        }
+    })
+    b.StopTimer()
+    close(stop)
+    wg.Wait()
+}
+
+func BenchmarkBroadcastLocked(b *testing.B) { benchmarkBroadcast(b, Config{}) }
+
+func BenchmarkBroadcastSnapshot(b *testing.B) {
+    benchmarkBroadcast(b, Config{SnapshotRooms: "bench"})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// HubStats is the /stats payload.
+type HubStats struct {
+    MemoryEstimate  int64       `json:"memory_estimate_bytes"`
+    MemorySoftLimit int64       `json:"memory_soft_limit_bytes,omitempty"`
+    Broadcasts      int64       `json:"concurrent_broadcasts"`
+    PeakBroadcasts  int64       `json:"peak_concurrent_broadcasts"`
+    MaxBroadcasts   int         `json:"max_concurrent_broadcasts"`
+    Rooms           []RoomStats `json:"rooms"`
+}
+
+type RoomStats struct {
+    Room             string        `json:"room"`
+    Clients          int           `json:"clients"`
+    BytesInPerSec    int64         `json:"bytes_in_per_sec"`
+    BytesOutPerSec   int64         `json:"bytes_out_per_sec"`
+    Amplification    float64       `json:"amplification"`
+    ShedBroadcasts   int64         `json:"shed_broadcasts"`
+    EgressAllocated  int64         `json:"egress_allocated_bytes_per_sec,omitempty"` // weighted share of GLOBAL_EGRESS_BUDGET
+    EgressUsed       int64         `json:"egress_used_bytes_per_sec,omitempty"`
+    CompressionRatio float64       `json:"compression_ratio"` // wire/uncompressed bytes of compressed writes; 0 when none
+    PayloadSizes     SizeStats     `json:"payload_sizes"`
+    IngressLatency   LatencyStats  `json:"ingress_latency"`               // from ?sent=1 client timestamps
+    Fairness         float64       `json:"fairness"`                      // Jain's index over member delivery ratios, see fairness.go
+    Members          []ClientStats `json:"members,omitempty"`             // ADMIN_TOKEN only, a page at a time
+    MembersNext      int           `json:"members_next_offset,omitempty"` // members_offset of the next page
+}
+
+// statsMemberLimit caps the members listed per room in one /stats response.
+const statsMemberLimit = 100
+
+type ClientStats struct {
+    Username         string  `json:"username"`
+    JitterMs         float64 `json:"jitter_ms"`
+    CompressedBytes  int64   `json:"compressed_bytes"` // uncompressed size of frames sent compressed
+    CompressedWire   int64   `json:"compressed_wire_bytes"`
+    CompressionRatio float64 `json:"compression_ratio"`
+    Offered          int64   `json:"messages_offered"` // broadcasts addressed to the member
+    Dropped          int64   `json:"messages_dropped"`
+    DeliveryRatio    float64 `json:"delivery_ratio"`
+}
+
+// stats reports the room's counters; resetSizes restarts its size and
+// latency profiles.
+func (r *Room) stats(resetSizes bool) RoomStats {
+    r.mu.RLock()
+    members := make([]ClientStats, 0, len(r.clients))
+    var raw, wire int64
+    var ratios []float64
+    for c := range r.clients {
+        cs := ClientStats{
+            Username:        c.username,
+            JitterMs:        float64(c.jitter.value()) / float64(time.Millisecond),
+            CompressedBytes: c.counters.rawCompressed.Load(),
+            CompressedWire:  c.counters.wireCompressed.Load(),
+            Offered:         c.counters.offered.Load(),
+            Dropped:         c.counters.drops.Load(),
+        }
+        cs.CompressionRatio = compressionRatio(cs.CompressedBytes, cs.CompressedWire)
+        cs.DeliveryRatio = deliveryRatio(cs.Offered, cs.Dropped)
+        if cs.Offered > 0 {
+            ratios = append(ratios, cs.DeliveryRatio)
+        }
+        raw += cs.CompressedBytes
+        wire += cs.CompressedWire
+        members = append(members, cs)
+    }
+    r.mu.RUnlock()
+    sort.Slice(members, func(i, j int) bool { return members[i].Username < members[j].Username })
+    in, out, shed := r.egress.snapshot()
+    allocated, used := r.hub.fair.snapshot(r)
+    rs := RoomStats{Room: r.name, Clients: len(members), BytesInPerSec: in, BytesOutPerSec: out, ShedBroadcasts: shed, PayloadSizes: r.sizes.snapshot(resetSizes), IngressLatency: r.latency.snapshot(resetSizes), CompressionRatio: compressionRatio(raw, wire), EgressAllocated: allocated, EgressUsed: used, Fairness: jainIndex(ratios), Members: members}
+    if in > 0 {
+        rs.Amplification = float64(out) / float64(in)
+    }
+    return rs
+}
+
+// pageMembers trims rs to what a /stats response lists: no members unless
+// admin, else up to statsMemberLimit of them from offset on.
+func pageMembers(rs *RoomStats, admin bool, offset int) {
+    if !admin {
+        rs.Members = nil
+        return
+    }
+    rs.Members = rs.Members[min(max(offset, 0), len(rs.Members)):]
+    if len(rs.Members) > statsMemberLimit {
+        rs.Members = rs.Members[:statsMemberLimit]
+        rs.MembersNext = max(offset, 0) + statsMemberLimit
+    }
+}
+
+func (h *Hub) stats() HubStats {
+    var rooms []*Room
+    h.forEachRoom(func(r *Room) { rooms = append(rooms, r) })
+    st := HubStats{
+        MemoryEstimate:  h.mem.estimate.Load(),
+        MemorySoftLimit: h.cfg.MemorySoftLimit,
+        Broadcasts:      h.broadcasts.active.Load(),
+        PeakBroadcasts:  h.broadcasts.peak.Load(),
+        MaxBroadcasts:   cap(h.broadcasts.sem),
+        Rooms:           make([]RoomStats, 0, len(rooms)),
+    }
+    for _, r := range rooms {
+        st.Rooms = append(st.Rooms, r.stats(false))
+    }
+    sort.Slice(st.Rooms, func(i, j int) bool { return st.Rooms[i].Room < st.Rooms[j].Room })
+    return st
+}
+
+// statsHandler serves /stats with live per-room counters. ?room=x returns
+// just that room, and &reset=1 restarts its size and latency profiles;
+// being a change, a reset needs ADMIN_TOKEN. The endpoint is read-only
+// otherwise. Per-member counters are only listed to ADMIN_TOKEN, a page of
+// statsMemberLimit per room; ?room=x&members_offset=N pages through one.
+func statsHandler(hub *Hub) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        applyCORSHeaders(w, hub.cfg.AllowedOrigin)
+        if r.Method == http.MethodOptions {
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        admin := authorizedAdmin(r, hub.cfg.AdminToken)
+        reset := r.URL.Query().Get("reset") == "1"
+        if reset && !admin {
+            hub.audit(r, auditStatsReset, r.URL.Query().Get("room"), auditDenied)
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            http.Error(w, "reset needs ADMIN_TOKEN", http.StatusUnauthorized)
+            return
+        }
+        if name := r.URL.Query().Get("room"); name != "" {
+            room := hub.lookupRoom(name)
+            if room == nil {
+                if reset {
+                    hub.audit(r, auditStatsReset, name, "unknown room")
+                }
+                http.Error(w, "unknown room", http.StatusNotFound)
+                return
+            }
+            offset, _ := strconv.Atoi(r.URL.Query().Get("members_offset"))
+            rs := room.stats(reset)
+            if reset {
+                hub.audit(r, auditStatsReset, name, auditOK)
+            }
+            pageMembers(&rs, admin, offset)
+            w.Header().Set("Content-Type", "application/json")
+            _ = json.NewEncoder(w).Encode(rs)
+            return
+        }
+        st := hub.stats()
+        for i := range st.Rooms {
+            pageMembers(&st.Rooms[i], admin, 0)
+        }
+        w.Header().Set("Content-Type", "application/json")
+        _ = json.NewEncoder(w).Encode(st)
+    }
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "testing"
+)
+
+// statsRequest sends a /stats request with token as the bearer, decoding
+// a 200 response into v.
+func statsRequest(t *testing.T, wsBase, method, path, token string, v any) *http.Response {
+    t.Helper()
+    req, _ := http.NewRequest(method, "http"+wsBase[len("ws"):]+path, nil)
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusOK && v != nil {
+        if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return resp
+}
+
+func TestStatsResetNeedsAdminToken(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "admin-token"})
+    base := startTestServer(t, hub)
+    r := hub.getRoom("media")
+    fakeClient(r, "viewer", 64)
+    r.publish(nil, NewEnvelope("media", "udp", make([]byte, 10)))
+
+    for _, token := range []string{"", "wrong"} {
+        resp := statsRequest(t, base, http.MethodGet, "/stats?room=media&reset=1", token, nil)
+        if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") != "Bearer" {
+            t.Fatalf("reset with token %q: %d", token, resp.StatusCode)
+        }
+    }
+    var rs RoomStats
+    statsRequest(t, base, http.MethodGet, "/stats?room=media", "", &rs)
+    if rs.PayloadSizes.Count != 1 {
+        t.Fatalf("unauthorized reset took effect: %+v", rs.PayloadSizes)
+    }
+
+    open := NewHub() // without ADMIN_TOKEN nobody may reset
+    openBase := startTestServer(t, open)
+    open.getRoom("media")
+    if resp := statsRequest(t, openBase, http.MethodGet, "/stats?room=media&reset=1", "", nil); resp.StatusCode != http.StatusUnauthorized {
+        t.Fatalf("reset without ADMIN_TOKEN: %d", resp.StatusCode)
+    }
+}
+
+func TestStatsIsReadOnly(t *testing.T) {
+    base := startTestServer(t, NewHubWithConfig(Config{AdminToken: "admin-token"}))
+    for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+        if resp := statsRequest(t, base, method, "/stats", "admin-token", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+            t.Fatalf("%s /stats: %d", method, resp.StatusCode)
+        }
+    }
+}
+
+func TestStatsMembersNeedAdminAndArePaged(t *testing.T) {
+    hub := NewHubWithConfig(Config{AdminToken: "admin-token"})
+    base := startTestServer(t, hub)
+    r := hub.getRoom("big")
+    for i := 0; i < statsMemberLimit+5; i++ {
+        fakeClient(r, fmt.Sprintf("user-%03d", i), 1)
+    }
+
+    var rs RoomStats
+    statsRequest(t, base, http.MethodGet, "/stats?room=big", "", &rs)
+    if rs.Clients != statsMemberLimit+5 || len(rs.Members) != 0 {
+        t.Fatalf("anonymous view: %d clients, %d members listed", rs.Clients, len(rs.Members))
+    }
+    var st HubStats
+    statsRequest(t, base, http.MethodGet, "/stats", "admin-token", &st)
+    if len(st.Rooms) != 1 || len(st.Rooms[0].Members) != statsMemberLimit || st.Rooms[0].MembersNext != statsMemberLimit {
+        t.Fatalf("admin view lists %d members, next %d", len(st.Rooms[0].Members), st.Rooms[0].MembersNext)
+    }
+    rs = RoomStats{}
+    statsRequest(t, base, http.MethodGet, fmt.Sprintf("/stats?room=big&members_offset=%d", statsMemberLimit), "admin-token", &rs)
+    if len(rs.Members) != 5 || rs.MembersNext != 0 || rs.Members[0].Username != fmt.Sprintf("user-%03d", statsMemberLimit) {
+        t.Fatalf("second page: %d members from %+v, next %d", len(rs.Members), rs.Members, rs.MembersNext)
+    }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "strings"
+    "time"
+)
+
+// statsdEmitter pushes hub metrics to a StatsD server over UDP (STATSD_ADDR)
+// every interval: counters as deltas since the previous push, connections
+// and rooms as gauges, and the mean socket write time as a timer.
+type statsdEmitter struct {
+    hub    *Hub
+    conn   net.Conn
+    prefix string
+    last   statsdTotals
+}
+
+type statsdTotals struct {
+    connects, msgsIn, bytesIn, msgsOut, bytesOut, drops, writes, writeNanos int64
+}
+
+func newStatsdEmitter(hub *Hub, addr, prefix string) (*statsdEmitter, error) {
+    conn, err := net.Dial("udp", addr)
+    if err != nil {
+        return nil, err
+    }
+    if prefix != "" && !strings.HasSuffix(prefix, ".") {
+        prefix += "."
+    }
+    return &statsdEmitter{hub: hub, conn: conn, prefix: prefix}, nil
+}
+
+func (e *statsdEmitter) run(interval time.Duration) {
+    if interval <= 0 {
+        interval = 10 * time.Second
+    }
+    for range time.Tick(interval) {
+        if err := e.flush(); err != nil {
+            log.Printf("statsd: %v", err)
+        }
+    }
+}
+
+func (e *statsdEmitter) totals() statsdTotals {
+    m := &e.hub.metrics
+    return statsdTotals{
+        connects:   m.connectsTotal.Load(),
+        msgsIn:     m.msgsIn.Load(),
+        bytesIn:    m.bytesIn.Load(),
+        msgsOut:    m.msgsOut.Load(),
+        bytesOut:   m.bytesOut.Load(),
+        drops:      m.drops.Load(),
+        writes:     m.writes.Load(),
+        writeNanos: m.writeNanos.Load(),
+    }
+}
+
+// flush sends one datagram with a line per metric.
+func (e *statsdEmitter) flush() error {
+    now, prev := e.totals(), e.last
+    e.last = now
+    rooms := e.hub.roomCount()
+
+    var b strings.Builder
+    line := func(name string, v any, kind string) {
+        fmt.Fprintf(&b, "%s%s:%v|%s\n", e.prefix, name, v, kind)
+    }
+    line("connections", e.hub.metrics.connections.Load(), "g")
+    line("rooms", rooms, "g")
+    line("connects", now.connects-prev.connects, "c")
+    line("messages_in", now.msgsIn-prev.msgsIn, "c")
+    line("bytes_in", now.bytesIn-prev.bytesIn, "c")
+    line("messages_out", now.msgsOut-prev.msgsOut, "c")
+    line("bytes_out", now.bytesOut-prev.bytesOut, "c")
+    line("drops", now.drops-prev.drops, "c")
+    if n := now.writes - prev.writes; n > 0 {
+        mean := time.Duration((now.writeNanos - prev.writeNanos) / n)
+        line("write_latency", fmt.Sprintf("%.3f", float64(mean)/float64(time.Millisecond)), "ms")
+    }
+    _, err := e.conn.Write([]byte(strings.TrimSuffix(b.String(), "\n")))
+    return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestStatsdEmitterSendsMetricLines(t *testing.T) {
+    pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer pc.Close()
+
+    hub := NewHubWithConfig(Config{})
+    base := startTestServer(t, hub)
+    e, err := newStatsdEmitter(hub, pc.LocalAddr().String(), "relay")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    a := dialWS(t, base+"/ws/lobby/alice")
+    dialWS(t, base+"/ws/lobby/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "lobby") == 2 })
+    if err := a.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+        t.Fatal(err)
+    }
+    if !waitFor(time.Second, func() bool { return hub.metrics.msgsOut.Load() == 1 }) {
+        t.Fatal("message was not delivered")
+    }
+
+    if err := e.flush(); err != nil {
+        t.Fatal(err)
+    }
+    lines := readStatsdLines(t, pc)
+    for _, want := range []string{
+        "relay.connections:2|g",
+        "relay.rooms:1|g",
+        "relay.connects:2|c",
+        "relay.messages_in:1|c",
+        "relay.bytes_in:5|c",
+        "relay.messages_out:1|c",
+        "relay.drops:0|c",
+    } {
+        if !lines[want] {
+            t.Errorf("missing %q in %v", want, lines)
+        }
+    }
+    if !hasPrefixLine(lines, "relay.write_latency:", "|ms") {
+        t.Errorf("missing write_latency timer in %v", lines)
+    }
+
+    // counters are deltas: with no traffic since the last push they read zero
+    if err := e.flush(); err != nil {
+        t.Fatal(err)
+    }
+    lines = readStatsdLines(t, pc)
+    for _, want := range []string{"relay.connections:2|g", "relay.connects:0|c", "relay.messages_in:0|c"} {
+        if !lines[want] {
+            t.Errorf("second push: missing %q in %v", want, lines)
+        }
+    }
+    if hasPrefixLine(lines, "relay.write_latency:", "|ms") {
+        t.Errorf("second push: unexpected timer without writes in %v", lines)
+    }
+}
+
+func readStatsdLines(t *testing.T, pc net.PacketConn) map[string]bool {
+    t.Helper()
+    buf := make([]byte, 4096)
+    pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+    n, _, err := pc.ReadFrom(buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    lines := map[string]bool{}
+    for _, l := range strings.Split(string(buf[:n]), "\n") {
+        lines[l] = true
+    }
+    return lines
+}
+
+func hasPrefixLine(lines map[string]bool, prefix, suffix string) bool {
+    for l := range lines {
+        if strings.HasPrefix(l, prefix) && strings.HasSuffix(l, suffix) {
+            return true
+        }
+    }
+    return false
+}
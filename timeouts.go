@@ -0,0 +1,42 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+// Socket timeouts. WRITE_TIMEOUT bounds each frame write: short for voice
+// relays that would rather drop a stalled peer at once, long for large
+// media frames on slow links. READ_TIMEOUT is how long a connection may
+// stay silent while pings are off (with pings on it is twice
+// PING_INTERVAL, see keepalive.go). Both must be positive.
+
+// defaultWriteTimeout applies when Config.WriteTimeout is unset.
+const defaultWriteTimeout = 10 * time.Second
+
+// writeTimeout is the write deadline for each frame.
+func (cfg Config) writeTimeout() time.Duration {
+    if cfg.WriteTimeout > 0 {
+        return cfg.WriteTimeout
+    }
+    return defaultWriteTimeout
+}
+
+// readWait is how long the reader waits for any frame, pongs included.
+func (cfg Config) readWait() time.Duration {
+    if cfg.PingInterval <= 0 && cfg.ReadTimeout > 0 {
+        return cfg.ReadTimeout
+    }
+    return readTimeout(cfg.PingInterval)
+}
+
+// validateTimeouts rejects non-positive WRITE_TIMEOUT and READ_TIMEOUT.
+func (cfg Config) validateTimeouts() error {
+    if cfg.WriteTimeout <= 0 {
+        return fmt.Errorf("WRITE_TIMEOUT must be positive, got %s", cfg.WriteTimeout)
+    }
+    if cfg.ReadTimeout <= 0 {
+        return fmt.Errorf("READ_TIMEOUT must be positive, got %s", cfg.ReadTimeout)
+    }
+    return nil
+}
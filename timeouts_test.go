@@ -0,0 +1,69 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestTimeoutDefaultsAndValidation(t *testing.T) {
+    var cfg Config
+    if cfg.writeTimeout() != defaultWriteTimeout || cfg.readWait() != defaultReadTimeout {
+        t.Fatalf("unset timeouts: write %v, read %v", cfg.writeTimeout(), cfg.readWait())
+    }
+    cfg = Config{WriteTimeout: time.Second, ReadTimeout: 5 * time.Second}
+    if cfg.writeTimeout() != time.Second || cfg.readWait() != 5*time.Second {
+        t.Fatalf("configured timeouts: write %v, read %v", cfg.writeTimeout(), cfg.readWait())
+    }
+    cfg.PingInterval = time.Second // pongs govern the read deadline
+    if cfg.readWait() != 2*time.Second {
+        t.Fatalf("read wait with pings: %v", cfg.readWait())
+    }
+    if err := cfg.validateTimeouts(); err != nil {
+        t.Fatal(err)
+    }
+    for _, bad := range []Config{
+        {WriteTimeout: 0, ReadTimeout: time.Second},
+        {WriteTimeout: time.Second, ReadTimeout: -time.Second},
+    } {
+        if bad.validateTimeouts() == nil {
+            t.Errorf("%v / %v accepted", bad.WriteTimeout, bad.ReadTimeout)
+        }
+    }
+}
+
+func TestShortWriteTimeoutEndsWriterPromptly(t *testing.T) {
+    hub := NewHubWithConfig(Config{WriteTimeout: 50 * time.Millisecond})
+    base := startTestServer(t, hub)
+    // never reads, so once the socket buffers fill every write stalls
+    stalled := dialWS(t, base+"/ws/voice/stalled")
+    defer stalled.Close()
+    sender := dialWS(t, base+"/ws/voice/sender")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "voice") == 2 }) {
+        t.Fatal("clients did not join")
+    }
+    var client *Client
+    for _, c := range hub.liveClients() {
+        if c.username == "stalled" {
+            client = c
+        }
+    }
+
+    // keep the socket buffers full; with the default of 10s the first
+    // stalled write would outlast this
+    frame := bytes.Repeat([]byte("x"), 256<<10)
+    deadline := time.Now().Add(5 * time.Second)
+    for !client.dead.Load() && time.Now().Before(deadline) {
+        if err := sender.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if !client.dead.Load() {
+        t.Fatal("writer still blocked on the stalled peer")
+    }
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "voice") == 1 }) {
+        t.Fatal("stalled client not cleaned up")
+    }
+}
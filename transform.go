@@ -0,0 +1,44 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+)
+
+// PayloadTransform rewrites every payload broadcast in a room, e.g. to tag
+// it for a downstream gateway. A known prefix is stripped first, then the
+// server-controlled prefix and suffix are added. Only the payload changes;
+// the envelope around it is rendered as usual.
+type PayloadTransform struct {
+    StripPrefix string `json:"strip_prefix,omitempty"`
+    Prefix      string `json:"prefix,omitempty"`
+    Suffix      string `json:"suffix,omitempty"`
+}
+
+func (t *PayloadTransform) apply(payload []byte) []byte {
+    payload = bytes.TrimPrefix(payload, []byte(t.StripPrefix))
+    out := make([]byte, 0, len(t.Prefix)+len(payload)+len(t.Suffix))
+    out = append(out, t.Prefix...)
+    out = append(out, payload...)
+    return append(out, t.Suffix...)
+}
+
+// LoadRoomTransforms parses ROOM_TRANSFORMS, a JSON object keyed by room:
+// {"orders":{"prefix":"route=a|"},"ticks":{"strip_prefix":"v1:"}}.
+func (h *Hub) LoadRoomTransforms(spec string) error {
+    if spec == "" {
+        return nil
+    }
+    var transforms map[string]*PayloadTransform
+    if err := json.Unmarshal([]byte(spec), &transforms); err != nil {
+        return fmt.Errorf("invalid ROOM_TRANSFORMS: %w", err)
+    }
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.transforms = transforms
+    h.forEachRoom(func(r *Room) {
+        r.transform = transforms[r.name]
+    })
+    return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestPayloadTransformApply(t *testing.T) {
+    cases := []struct {
+        tr   PayloadTransform
+        in   string
+        want string
+    }{
+        {PayloadTransform{Prefix: "route=a|"}, "hello", "route=a|hello"},
+        {PayloadTransform{Suffix: "|end"}, "hello", "hello|end"},
+        {PayloadTransform{StripPrefix: "v1:"}, "v1:hello", "hello"},
+        {PayloadTransform{StripPrefix: "v1:"}, "hello", "hello"},
+        {PayloadTransform{StripPrefix: "v1:", Prefix: "v2:"}, "v1:hello", "v2:hello"},
+    }
+    for _, tc := range cases {
+        in := []byte(tc.in)
+        if got := string(tc.tr.apply(in)); got != tc.want {
+            t.Errorf("%+v.apply(%q) = %q, want %q", tc.tr, tc.in, got, tc.want)
+        }
+        if string(in) != tc.in {
+            t.Errorf("apply modified its input: %q", in)
+        }
+    }
+}
+
+func TestLoadRoomTransforms(t *testing.T) {
+    hub := NewHub()
+    existing := hub.getRoom("orders")
+    if err := hub.LoadRoomTransforms(`{"orders":{"prefix":"route=a|"},"ticks":{"strip_prefix":"v1:"}}`); err != nil {
+        t.Fatal(err)
+    }
+    if existing.transform == nil || existing.transform.Prefix != "route=a|" {
+        t.Fatal("transform not applied to existing room")
+    }
+    if hub.getRoom("ticks").transform == nil || hub.getRoom("plain").transform != nil {
+        t.Fatal("transforms assigned to the wrong rooms")
+    }
+    if err := hub.LoadRoomTransforms(`not json`); err == nil {
+        t.Fatal("expected error for invalid spec")
+    }
+}
+
+func TestRoomTransformRoundTrip(t *testing.T) {
+    hub := NewHub()
+    if err := hub.LoadRoomTransforms(`{"gw":{"strip_prefix":"v1:","prefix":"route=a|"}}`); err != nil {
+        t.Fatal(err)
+    }
+    base := startTestServer(t, hub)
+    sender := dialWS(t, base+"/ws/gw/alice")
+    receiver := dialWS(t, base+"/ws/gw/bob")
+    plainSender := dialWS(t, base+"/ws/plain/alice")
+    plainReceiver := dialWS(t, base+"/ws/plain/bob")
+    waitFor(time.Second, func() bool { return roomSize(hub, "gw") == 2 && roomSize(hub, "plain") == 2 })
+
+    for _, msg := range []string{"v1:hello", "world"} {
+        if err := sender.WriteMessage(websocket.BinaryMessage, []byte(msg)); err != nil {
+            t.Fatal(err)
+        }
+        if err := plainSender.WriteMessage(websocket.BinaryMessage, []byte(msg)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := readEnvelopes(t, receiver, 300*time.Millisecond)
+    if len(got) != 2 || string(got[0].Payload) != "route=a|hello" || string(got[1].Payload) != "route=a|world" {
+        t.Fatalf("transformed room delivered %+v", got)
+    }
+    plain := readEnvelopes(t, plainReceiver, 300*time.Millisecond)
+    if len(plain) != 2 || string(plain[0].Payload) != "v1:hello" || string(plain[1].Payload) != "world" {
+        t.Fatalf("untransformed room delivered %+v", plain)
+    }
+}
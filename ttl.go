@@ -0,0 +1,49 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "time"
+)
+
+// Per-message expiry. A connection opened with ?ttl=1 may start a message
+// with "ttl:<duration>|" (e.g. "ttl:500ms|..."); the header is stripped and
+// each recipient's writer drops the message, as an "expired" dead letter,
+// if it is still queued once that long has passed. Messages without the
+// header never expire. The header comes before any "room:" routing prefix.
+
+const (
+    dropExpired = "expired"
+    ttlPrefix   = "ttl:"
+    maxTTL      = time.Hour
+)
+
+// outbound is a frame waiting in a client queue.
+type outbound struct {
+    msg     []byte
+    expires time.Time // zero: never expires
+    env     *Envelope // source of an expiring broadcast, for its dead letter
+
+    noCompress bool // content type not worth deflating, see contenttype.go
+}
+
+func (o outbound) expired(now time.Time) bool {
+    return !o.expires.IsZero() && now.After(o.expires)
+}
+
+// parseTTLPrefix splits "ttl:<duration>|<payload>". ok is false when msg has
+// no TTL header; err is set when it has a malformed one.
+func parseTTLPrefix(msg []byte) (ttl time.Duration, payload []byte, ok bool, err error) {
+    rest, found := bytes.CutPrefix(msg, []byte(ttlPrefix))
+    if !found {
+        return 0, msg, false, nil
+    }
+    spec, payload, found := bytes.Cut(rest, []byte("|"))
+    if found {
+        ttl, err = time.ParseDuration(string(spec))
+    }
+    if !found || err != nil || ttl <= 0 || ttl > maxTTL {
+        return 0, nil, true, fmt.Errorf("malformed ttl header; want %s<duration>|<payload> with a duration up to %s", ttlPrefix, maxTTL)
+    }
+    return ttl, payload, true, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestParseTTLPrefix(t *testing.T) {
+    ttl, payload, ok, err := parseTTLPrefix([]byte("ttl:250ms|hello"))
+    if err != nil || !ok || ttl != 250*time.Millisecond || string(payload) != "hello" {
+        t.Fatalf("got %v %q %v %v", ttl, payload, ok, err)
+    }
+    if _, payload, ok, err := parseTTLPrefix([]byte("hello")); err != nil || ok || string(payload) != "hello" {
+        t.Fatalf("plain message: %q %v %v", payload, ok, err)
+    }
+    for _, bad := range []string{"ttl:250ms", "ttl:soon|x", "ttl:0s|x", "ttl:-1s|x", "ttl:2h|x"} {
+        if _, _, ok, err := parseTTLPrefix([]byte(bad)); !ok || err == nil {
+            t.Errorf("%q: expected a malformed header error", bad)
+        }
+    }
+}
+
+func TestExpiredMessagesAreDroppedUnderCongestion(t *testing.T) {
+    hub := NewHubWithConfig(Config{})
+    dead := make(chan DeadLetter, 16)
+    hub.dead = newDeadLetterSink(16, func(dl DeadLetter) { dead <- dl })
+    base := startTestServer(t, hub)
+
+    // ~80 byte envelopes at 300 B/s: the reader falls behind after a few
+    slow := dialWS(t, base+"/ws/r/slow?pace=300")
+    sender := dialWS(t, base+"/ws/r/sender?ttl=1")
+    waitFor(time.Second, func() bool { return roomSize(hub, "r") == 2 })
+
+    for _, m := range []string{
+        "fill-0", "fill-1", "fill-2", "fill-3",
+        "ttl:50ms|stale-0", "fill-4", "ttl:50ms|stale-1",
+        "ttl:10s|fresh", "tail",
+    } {
+        if err := sender.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    var got []string
+    for _, env := range readEnvelopes(t, slow, 1500*time.Millisecond) {
+        got = append(got, string(env.Payload))
+    }
+    want := []string{"fill-0", "fill-1", "fill-2", "fill-3", "fill-4", "fresh", "tail"}
+    if len(got) != len(want) {
+        t.Fatalf("got %v, want %v", got, want)
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Fatalf("got %v, want %v", got, want)
+        }
+    }
+    for _, stale := range []string{"stale-0", "stale-1"} {
+        select {
+        case dl := <-dead:
+            if dl.Reason != dropExpired || string(dl.Payload) != stale || dl.To != "slow" {
+                t.Fatalf("dead letter %+v, want %s expired for slow", dl, stale)
+            }
+        case <-time.After(time.Second):
+            t.Fatalf("no dead letter for %s", stale)
+        }
+    }
+}
+
+func TestMalformedTTLHeaderIsRefused(t *testing.T) {
+    base := startTestServer(t, NewHub())
+    c := dialWS(t, base+"/ws/r/alice?ttl=1")
+    if err := c.WriteMessage(websocket.TextMessage, []byte("ttl:later|x")); err != nil {
+        t.Fatal(err)
+    }
+    c.SetReadDeadline(time.Now().Add(2 * time.Second))
+    _, raw, err := c.ReadMessage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ef ErrorFrame
+    if err := json.Unmarshal(raw, &ef); err != nil || ef.Code != "bad_ttl" {
+        t.Fatalf("expected bad_ttl error, got %s", raw)
+    }
+}
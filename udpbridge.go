@@ -0,0 +1,25 @@
+package main
+
+// WebSocket to UDP bridging. UDP datagrams always reach the room's
+// WebSocket clients; with UDP_BRIDGE_BIDIRECTIONAL, messages broadcast
+// from WebSocket clients also go, as raw payloads, to the UDP peers
+// registered in the same room. Envelopes that came in over UDP are marked
+// and never bridged back, since the read loop has already relayed them to
+// the room's peers. Messages a sender limited to some roles, and payloads
+// over udpMaxPayload, stay on the WebSocket side.
+
+// bridgeToUDP sends a broadcast to the room's UDP peers, if the bridge is on.
+func (r *Room) bridgeToUDP(sender *Client, env Envelope) {
+    reg := r.hub.udp.Load()
+    if reg == nil || env.viaUDP || len(env.Payload) > udpMaxPayload {
+        return
+    }
+    if sender != nil && sender.targets != nil {
+        return
+    }
+    reg.mu.Lock()
+    defer reg.mu.Unlock()
+    for _, p := range reg.rooms[r.logicalName()] {
+        _, _ = p.conn.WriteToUDP(env.Payload, p.addr)
+    }
+}
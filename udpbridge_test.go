@@ -0,0 +1,112 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// bridgeFixture starts the HTTP and UDP relays and registers a UDP peer
+// and a WebSocket client in room "r".
+func bridgeFixture(t *testing.T, cfg Config) (*websocket.Conn, *net.UDPConn) {
+    t.Helper()
+    hub := NewHubWithConfig(cfg)
+    base := startTestServer(t, hub)
+    ctx, cancel := context.WithCancel(context.Background())
+    t.Cleanup(cancel)
+    udp, err := StartUDPRelay(ctx, "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    peer, err := net.DialUDP("udp", nil, udp.LocalAddr().(*net.UDPAddr))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { peer.Close() })
+    ws := dialWS(t, base+"/ws/r/alice")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "r") == 1 }) {
+        t.Fatal("alice did not join")
+    }
+    peer.Write([]byte("ROOM:r;USER:sensor\nhello"))
+    if got := readEnvelopes(t, ws, 300*time.Millisecond); len(got) != 1 || string(got[0].Payload) != "hello" {
+        t.Fatalf("alice got %+v, want the peer's datagram", got)
+    }
+    return ws, peer
+}
+
+func TestBidirectionalBridgeReachesUDPPeers(t *testing.T) {
+    ws, peer := bridgeFixture(t, Config{UDPBridgeBidirectional: true})
+    // the datagram relayed to alice must not have come back to the peer
+    buf := make([]byte, 2048)
+    peer.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+    if n, err := peer.Read(buf); err == nil {
+        t.Fatalf("peer got its own datagram back: %q", buf[:n])
+    }
+
+    if err := ws.WriteMessage(websocket.TextMessage, []byte("from ws")); err != nil {
+        t.Fatal(err)
+    }
+    peer.SetReadDeadline(time.Now().Add(time.Second))
+    n, err := peer.Read(buf)
+    if err != nil || string(buf[:n]) != "from ws" {
+        t.Fatalf("peer got %q, %v", buf[:n], err)
+    }
+}
+
+func TestBridgeIsOneWayByDefault(t *testing.T) {
+    ws, peer := bridgeFixture(t, Config{})
+    if err := ws.WriteMessage(websocket.TextMessage, []byte("from ws")); err != nil {
+        t.Fatal(err)
+    }
+    buf := make([]byte, 2048)
+    peer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+    if n, err := peer.Read(buf); err == nil {
+        t.Fatalf("peer got %q with the bridge off", buf[:n])
+    }
+}
+
+func TestBridgeReachesDefaultRoomShardsOnce(t *testing.T) {
+    hub := NewHubWithConfig(Config{DefaultRoomShards: 4, UDPBridgeBidirectional: true})
+    base := startTestServer(t, hub)
+    ctx, cancel := context.WithCancel(context.Background())
+    t.Cleanup(cancel)
+    udp, err := StartUDPRelay(ctx, "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    peer, err := net.DialUDP("udp", nil, udp.LocalAddr().(*net.UDPAddr))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer peer.Close()
+    ws := dialWS(t, base+"/ws/global/alice")
+    // one member per shard, so every shard delivers the message
+    for i := 0; i < 20; i++ {
+        dialWS(t, base+fmt.Sprintf("/ws/global/user-%d", i))
+    }
+    peer.Write([]byte("ROOM:global;USER:sensor\nhello"))
+    if got := readEnvelopes(t, ws, 300*time.Millisecond); len(got) != 1 || string(got[0].Payload) != "hello" {
+        t.Fatalf("alice got %+v, want the peer's datagram", got)
+    }
+    before := hub.metrics.broadcasts.Load()
+
+    if err := ws.WriteMessage(websocket.TextMessage, []byte("from ws")); err != nil {
+        t.Fatal(err)
+    }
+    buf := make([]byte, 2048)
+    peer.SetReadDeadline(time.Now().Add(time.Second))
+    if n, err := peer.Read(buf); err != nil || string(buf[:n]) != "from ws" {
+        t.Fatalf("peer in %q got %q, %v", defaultRoom, buf[:n], err)
+    }
+    peer.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+    if n, err := peer.Read(buf); err == nil {
+        t.Fatalf("peer got the message again: %q", buf[:n])
+    }
+    if n := hub.metrics.broadcasts.Load() - before; n != 1 {
+        t.Fatalf("%d broadcasts counted across the shards, want 1", n)
+    }
+}
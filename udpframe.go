@@ -0,0 +1,75 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+)
+
+// UDP frame validation. A datagram's header line may be at most
+// udpMaxHeader bytes and its payload at most udpMaxPayload, which fits a
+// 1500-byte Ethernet MTU after the IPv4 and UDP headers, so anything
+// relayed goes out unfragmented. With UDP_STRICT a frame must also carry a
+// header naming both ROOM and USER; otherwise a datagram without a newline
+// is relayed whole to the default room as before. Rejected frames are
+// counted by reason in relay_udp_frames_rejected_total.
+const (
+    udpMaxHeader  = 256
+    udpMaxPayload = 1472
+)
+
+// udpFrameError is why a datagram was rejected.
+type udpFrameError int
+
+const (
+    udpErrHeaderTooLong udpFrameError = iota
+    udpErrNoHeader
+    udpErrMissingRoom
+    udpErrMissingUser
+    udpErrOversized
+    numUDPFrameErrors
+)
+
+var udpFrameErrorReasons = [numUDPFrameErrors]string{
+    udpErrHeaderTooLong: "header_too_long",
+    udpErrNoHeader:      "no_header",
+    udpErrMissingRoom:   "missing_room",
+    udpErrMissingUser:   "missing_user",
+    udpErrOversized:     "oversized",
+}
+
+func (e udpFrameError) reason() string { return udpFrameErrorReasons[e] }
+
+func (e udpFrameError) Error() string { return "udp frame rejected: " + e.reason() }
+
+// readUDPFrame validates a datagram and splits it into header and payload.
+// Status queries need no ROOM or USER even when strict.
+func readUDPFrame(b []byte, strict bool) (udpHeader, []byte, error) {
+    i := bytes.IndexByte(b, '\n')
+    if i > udpMaxHeader {
+        return udpHeader{}, nil, udpErrHeaderTooLong
+    }
+    if i < 0 && strict {
+        return udpHeader{}, nil, udpErrNoHeader
+    }
+    h, payload := parseUDPFrame(b)
+    if len(payload) > udpMaxPayload {
+        return udpHeader{}, nil, udpErrOversized
+    }
+    if strict && h.op == "" {
+        if h.room == "" {
+            return udpHeader{}, nil, udpErrMissingRoom
+        }
+        if h.user == "" {
+            return udpHeader{}, nil, udpErrMissingUser
+        }
+    }
+    return h, payload, nil
+}
+
+// countUDPReject records a datagram refused by readUDPFrame.
+func (m *hubMetrics) countUDPReject(err error) {
+    var fe udpFrameError
+    if errors.As(err, &fe) {
+        m.udpRejected[fe].Add(1)
+    }
+}
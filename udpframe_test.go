@@ -0,0 +1,80 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "net"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestReadUDPFrame(t *testing.T) {
+    long := "ROOM:r;USER:u;PAD:" + strings.Repeat("x", udpMaxHeader)
+    cases := []struct {
+        name   string
+        frame  string
+        strict bool
+        want   error
+    }{
+        {"valid", "ROOM:r;USER:u\nhi", true, nil},
+        {"raw lenient", "raw", false, nil},
+        {"missing newline", "ROOM:r;USER:u", true, udpErrNoHeader},
+        {"header too long", long + "\nhi", false, udpErrHeaderTooLong},
+        {"missing room", "USER:u\nhi", true, udpErrMissingRoom},
+        {"missing user", "ROOM:r\nhi", true, udpErrMissingUser},
+        {"malformed header", "garbage;;:\nhi", true, udpErrMissingRoom},
+        {"missing fields lenient", "garbage\nhi", false, nil},
+        {"status query strict", "OP:STATUS\n", true, nil},
+        {"oversized", "ROOM:r;USER:u\n" + strings.Repeat("x", udpMaxPayload+1), false, udpErrOversized},
+        {"largest payload", "ROOM:r;USER:u\n" + strings.Repeat("x", udpMaxPayload), true, nil},
+        {"oversized raw", strings.Repeat("x", udpMaxPayload+1), false, udpErrOversized},
+    }
+    for _, tc := range cases {
+        _, _, err := readUDPFrame([]byte(tc.frame), tc.strict)
+        if !errors.Is(err, tc.want) {
+            t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+        }
+    }
+}
+
+func TestUDPStrictRejectsAndCounts(t *testing.T) {
+    hub := NewHubWithConfig(Config{UDPStrict: true})
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    udp, err := StartUDPRelay(ctx, "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := udp.LocalAddr().(*net.UDPAddr)
+    dial := func() *net.UDPConn {
+        c, err := net.DialUDP("udp", nil, addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() { c.Close() })
+        return c
+    }
+    listener, bad := dial(), dial()
+    listener.Write([]byte("ROOM:r;USER:listener\nhello"))
+    time.Sleep(20 * time.Millisecond)
+
+    bad.Write([]byte("no header at all"))
+    bad.Write([]byte("ROOM:r\nno user"))
+    bad.Write(append([]byte("ROOM:r;USER:bad\n"), bytes.Repeat([]byte("x"), udpMaxPayload+1)...))
+    bad.Write([]byte("ROOM:r;USER:bad\nok"))
+
+    buf := make([]byte, 2048)
+    listener.SetReadDeadline(time.Now().Add(time.Second))
+    n, err := listener.Read(buf)
+    if err != nil || string(buf[:n]) != "ok" {
+        t.Fatalf("listener got %q, %v; want only the valid frame", buf[:n], err)
+    }
+    m := &hub.metrics
+    for e, want := range map[udpFrameError]int64{udpErrNoHeader: 1, udpErrMissingUser: 1, udpErrOversized: 1} {
+        if got := m.udpRejected[e].Load(); got != want {
+            t.Errorf("%s rejected %d, want %d", e.reason(), got, want)
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "strconv"
+)
+
+// listenUDP binds n sockets to port. A single socket is a plain listener;
+// more share the port with SO_REUSEPORT. Port "0" picks a free port for the
+// first socket and binds the rest to the same one.
+func listenUDP(port string, n int) ([]*net.UDPConn, error) {
+    if n == 1 {
+        addr, err := net.ResolveUDPAddr("udp", ":"+port)
+        if err != nil {
+            return nil, err
+        }
+        conn, err := net.ListenUDP("udp", addr)
+        if err != nil {
+            return nil, err
+        }
+        return []*net.UDPConn{conn}, nil
+    }
+    if !reusePortSupported {
+        return nil, fmt.Errorf("%d UDP listeners need SO_REUSEPORT, which this platform lacks", n)
+    }
+    lc := net.ListenConfig{Control: setReusePort}
+    conns := make([]*net.UDPConn, 0, n)
+    for i := 0; i < n; i++ {
+        pc, err := lc.ListenPacket(context.Background(), "udp", ":"+port)
+        if err != nil {
+            for _, c := range conns {
+                c.Close()
+            }
+            return nil, err
+        }
+        conn := pc.(*net.UDPConn)
+        conns = append(conns, conn)
+        port = strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+    }
+    return conns, nil
+}
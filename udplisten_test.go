@@ -0,0 +1,112 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestReusePortListenersAllReceive(t *testing.T) {
+    if !reusePortSupported {
+        t.Skip("no SO_REUSEPORT on this platform")
+    }
+    conns, err := listenUDP("0", 4)
+    if err != nil {
+        t.Fatal(err)
+    }
+    port := conns[0].LocalAddr().(*net.UDPAddr).Port
+    var wg sync.WaitGroup
+    received := make([]int, len(conns))
+    for i, c := range conns {
+        defer c.Close()
+        if p := c.LocalAddr().(*net.UDPAddr).Port; p != port {
+            t.Fatalf("listener %d bound port %d, want %d", i, p, port)
+        }
+        wg.Add(1)
+        go func(i int, c *net.UDPConn) {
+            defer wg.Done()
+            buf := make([]byte, 64)
+            for {
+                c.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+                if _, _, err := c.ReadFromUDP(buf); err != nil {
+                    return
+                }
+                received[i]++
+            }
+        }(i, c)
+    }
+    // the kernel spreads senders across the sockets by source address
+    for i := 0; i < 64; i++ {
+        out, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+        if err != nil {
+            t.Fatal(err)
+        }
+        out.Write([]byte("ping"))
+        out.Close()
+    }
+    wg.Wait()
+    total := 0
+    for i, n := range received {
+        if n == 0 {
+            t.Errorf("listener %d received nothing (%v)", i, received)
+        }
+        total += n
+    }
+    if total != 64 {
+        t.Errorf("received %d datagrams, want 64", total)
+    }
+}
+
+func TestUDPRelaysShareRegistry(t *testing.T) {
+    if !reusePortSupported {
+        t.Skip("no SO_REUSEPORT on this platform")
+    }
+    hub := NewHub()
+    conns, err := StartUDPRelays(context.Background(), "0", hub, 4)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, c := range conns {
+        defer c.Close()
+    }
+    addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: conns[0].LocalAddr().(*net.UDPAddr).Port}
+    // peers likely land on different listeners; all must hear each other
+    var peers []*net.UDPConn
+    for i := 0; i < 8; i++ {
+        p, err := net.DialUDP("udp", nil, addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer p.Close()
+        if _, err := p.Write([]byte(fmt.Sprintf("ROOM:r;USER:peer%d\nhello", i))); err != nil {
+            t.Fatal(err)
+        }
+        peers = append(peers, p)
+    }
+    time.Sleep(100 * time.Millisecond)
+    for _, p := range peers { // skip the join chatter
+        for {
+            p.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+            if _, err := p.Read(make([]byte, 64)); err != nil {
+                break
+            }
+        }
+    }
+    speaker, err := net.DialUDP("udp", nil, addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer speaker.Close()
+    speaker.Write([]byte("ROOM:r;USER:speaker\nnews"))
+    for i, p := range peers {
+        buf := make([]byte, 64)
+        p.SetReadDeadline(time.Now().Add(time.Second))
+        n, err := p.Read(buf)
+        if err != nil || string(buf[:n]) != "news" {
+            t.Errorf("peer%d got %q, %v", i, buf[:n], err)
+        }
+    }
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "container/list"
+    "context"
+    "net"
+    "time"
+)
+
+// UDP peer bounds. Peers are registered by the first datagram they send
+// and, since source addresses are trivially spoofed, an unbounded registry
+// grows with every forged sender. Two independent limits apply per room:
+// UDP_PEER_TTL forgets a peer not heard from for that long, and
+// UDP_PEER_MAX keeps at most that many peers, evicting the least recently
+// seen when a new one arrives. Either may be 0 to disable it; with both set
+// a peer goes on whichever limit it hits first. Expiry is checked on every
+// datagram to the room and by a background sweep, so peers of a room that
+// fell silent altogether are forgotten too, along with the room itself.
+
+// udpRoomPeers is one room's peers, ordered from most to least recently seen.
+type udpRoomPeers struct {
+    byName map[string]*list.Element // username -> element holding *udpPeer
+    order  *list.List
+}
+
+// touchLocked records a datagram from username, registering it if new, and
+// returns the peers of the room after applying the TTL and LRU bounds.
+// reg.mu must be held.
+func (reg *udpRegistry) touchLocked(roomName, username string, remote *net.UDPAddr, conn *net.UDPConn, now time.Time) map[string]*udpPeer {
+    rp := reg.lru[roomName]
+    if rp == nil {
+        rp = &udpRoomPeers{byName: map[string]*list.Element{}, order: list.New()}
+        reg.lru[roomName] = rp
+        reg.rooms[roomName] = map[string]*udpPeer{}
+    }
+    peers := reg.rooms[roomName]
+    p := &udpPeer{name: username, addr: remote, last: now, conn: conn}
+    if e, ok := rp.byName[username]; ok {
+        e.Value = p
+        rp.order.MoveToFront(e)
+    } else {
+        rp.byName[username] = rp.order.PushFront(p)
+    }
+    peers[username] = p
+    reg.expireLocked(roomName, now)
+    if reg.maxPeers > 0 {
+        for rp.order.Len() > reg.maxPeers {
+            reg.evictLocked(roomName, rp.order.Back())
+        }
+    }
+    return peers
+}
+
+func (reg *udpRegistry) evictLocked(roomName string, e *list.Element) {
+    rp := reg.lru[roomName]
+    name := e.Value.(*udpPeer).name
+    rp.order.Remove(e)
+    delete(rp.byName, name)
+    delete(reg.rooms[roomName], name)
+    if rp.order.Len() == 0 {
+        delete(reg.lru, roomName)
+        delete(reg.rooms, roomName)
+    }
+}
+
+// expireLocked evicts the room's peers silent for longer than the TTL.
+// reg.mu must be held.
+func (reg *udpRegistry) expireLocked(roomName string, now time.Time) {
+    rp := reg.lru[roomName]
+    if reg.ttl <= 0 || rp == nil {
+        return
+    }
+    for e := rp.order.Back(); e != nil && now.Sub(e.Value.(*udpPeer).last) > reg.ttl; e = rp.order.Back() {
+        reg.evictLocked(roomName, e)
+    }
+}
+
+// sweep expires silent peers in every room.
+func (reg *udpRegistry) sweep(now time.Time) {
+    reg.mu.Lock()
+    defer reg.mu.Unlock()
+    for roomName := range reg.lru {
+        reg.expireLocked(roomName, now)
+    }
+}
+
+// sweepLoop sweeps every half TTL until ctx ends; it returns at once when
+// peers never expire.
+func (reg *udpRegistry) sweepLoop(ctx context.Context) {
+    if reg.ttl <= 0 {
+        return
+    }
+    t := time.NewTicker(max(reg.ttl/2, time.Millisecond))
+    defer t.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-t.C:
+            reg.sweep(now)
+        }
+    }
+}
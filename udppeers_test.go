@@ -0,0 +1,135 @@
+package main
+
+import (
+    "context"
+    "net"
+    "sort"
+    "testing"
+    "time"
+)
+
+func udpPeerNames(reg *udpRegistry, room string) []string {
+    reg.mu.Lock()
+    defer reg.mu.Unlock()
+    var names []string
+    for name := range reg.rooms[room] {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func TestUDPPeerLRUEvictsAtCap(t *testing.T) {
+    reg := newUDPRegistry(0, 2)
+    now := time.Now()
+    touch := func(user string) {
+        reg.mu.Lock()
+        reg.touchLocked("r", user, &net.UDPAddr{}, nil, now)
+        reg.mu.Unlock()
+        now = now.Add(time.Millisecond)
+    }
+    touch("a")
+    touch("b")
+    if got := udpPeerNames(reg, "r"); len(got) != 2 {
+        t.Fatalf("below the cap: %v", got)
+    }
+    touch("a") // b is now the least recently seen
+    touch("c")
+    if got := udpPeerNames(reg, "r"); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+        t.Fatalf("after eviction: %v, want [a c]", got)
+    }
+    for i := 0; i < 100; i++ { // churn from spoofed senders stays bounded
+        touch(string(rune('d' + i%20)))
+    }
+    if got := udpPeerNames(reg, "r"); len(got) != 2 {
+        t.Fatalf("registry grew to %d under churn", len(got))
+    }
+}
+
+func TestUDPPeerTTLExpiresSilentPeers(t *testing.T) {
+    reg := newUDPRegistry(time.Second, 0)
+    now := time.Now()
+    reg.mu.Lock()
+    reg.touchLocked("r", "old", &net.UDPAddr{}, nil, now)
+    reg.touchLocked("r", "fresh", &net.UDPAddr{}, nil, now.Add(1500*time.Millisecond))
+    reg.touchLocked("r", "new", &net.UDPAddr{}, nil, now.Add(2*time.Second))
+    reg.mu.Unlock()
+    if got := udpPeerNames(reg, "r"); len(got) != 2 || got[0] != "fresh" || got[1] != "new" {
+        t.Fatalf("peers %v, want [fresh new]", got)
+    }
+}
+
+func TestUDPPeerMaxOverRelay(t *testing.T) {
+    hub := NewHubWithConfig(Config{UDPStatus: true, UDPPeerMax: 2})
+    udp, err := StartUDPRelay(context.Background(), "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer udp.Close()
+    addr := udp.LocalAddr().(*net.UDPAddr)
+    for _, user := range []string{"a", "b", "c"} {
+        peer, err := net.DialUDP("udp", nil, addr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer peer.Close()
+        peer.Write([]byte("ROOM:r;USER:" + user + "\nx"))
+        time.Sleep(20 * time.Millisecond)
+    }
+    st, err := queryUDPStatus(t, addr)
+    if err != nil {
+        t.Fatalf("no status reply: %v", err)
+    }
+    if st.UDPPeers != 2 {
+        t.Fatalf("tracked %d peers, want the cap of 2", st.UDPPeers)
+    }
+}
+
+func TestUDPPeerSweepEvictsSilentPeers(t *testing.T) {
+    reg := newUDPRegistry(time.Second, 0)
+    now := time.Now()
+    reg.mu.Lock()
+    reg.touchLocked("quiet", "a", &net.UDPAddr{}, nil, now)
+    reg.touchLocked("busy", "b", &net.UDPAddr{}, nil, now.Add(1500*time.Millisecond))
+    reg.mu.Unlock()
+    reg.sweep(now.Add(2 * time.Second))
+    reg.mu.Lock()
+    _, rooms := reg.rooms["quiet"]
+    _, lru := reg.lru["quiet"]
+    reg.mu.Unlock()
+    if rooms || lru {
+        t.Fatal("empty room was kept")
+    }
+    if got := udpPeerNames(reg, "busy"); len(got) != 1 {
+        t.Fatalf("busy room peers %v", got)
+    }
+}
+
+func TestUDPPeerExpiresOverRelay(t *testing.T) {
+    hub := NewHubWithConfig(Config{UDPStatus: true, UDPPeerTTL: 100 * time.Millisecond})
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    udp, err := StartUDPRelay(ctx, "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := udp.LocalAddr().(*net.UDPAddr)
+    peer, err := net.DialUDP("udp", nil, addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer peer.Close()
+    peer.Write([]byte("ROOM:r;USER:once\nx"))
+    time.Sleep(20 * time.Millisecond)
+    if st, err := queryUDPStatus(t, addr); err != nil || st.UDPPeers != 1 || st.UDPRooms != 1 {
+        t.Fatalf("after send: %+v, %v", st, err)
+    }
+    time.Sleep(300 * time.Millisecond)
+    st, err := queryUDPStatus(t, addr)
+    if err != nil {
+        t.Fatalf("no status reply: %v", err)
+    }
+    if st.UDPPeers != 0 || st.UDPRooms != 0 {
+        t.Fatalf("after the TTL: %d peers in %d rooms, want none", st.UDPPeers, st.UDPRooms)
+    }
+}
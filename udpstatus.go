@@ -0,0 +1,40 @@
+package main
+
+import (
+    "encoding/json"
+    "time"
+)
+
+// UDP status query (UDP_STATUS). For deployments that only expose the UDP
+// relay, a datagram whose header is "OP:STATUS" is answered, to its sender
+// only, with a compact JSON status instead of being relayed.
+const udpOpStatus = "STATUS"
+
+// UDPStatus is the reply to a status query.
+type UDPStatus struct {
+    Type      string `json:"type"`
+    UptimeSec int64  `json:"uptime_s"`
+    UDPPeers  int    `json:"udp_peers"`
+    UDPRooms  int    `json:"udp_rooms"`
+    WSClients int64  `json:"ws_clients"`
+    WSRooms   int    `json:"ws_rooms"`
+    Commit    string `json:"commit"`
+}
+
+func (reg *udpRegistry) status(hub *Hub) []byte {
+    st := UDPStatus{
+        Type:      "status",
+        UptimeSec: int64(time.Since(hub.started) / time.Second),
+        WSClients: hub.metrics.connections.Load(),
+        Commit:    CommitHash,
+    }
+    reg.mu.Lock()
+    st.UDPRooms = len(reg.rooms)
+    for _, peers := range reg.rooms {
+        st.UDPPeers += len(peers)
+    }
+    reg.mu.Unlock()
+    st.WSRooms = hub.roomCount()
+    b, _ := json.Marshal(st)
+    return b
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net"
+    "testing"
+    "time"
+)
+
+func queryUDPStatus(t *testing.T, addr *net.UDPAddr) (UDPStatus, error) {
+    t.Helper()
+    c, err := net.DialUDP("udp", nil, addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    if _, err := c.Write([]byte("OP:STATUS\n")); err != nil {
+        t.Fatal(err)
+    }
+    buf := make([]byte, 1024)
+    c.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+    n, err := c.Read(buf)
+    if err != nil {
+        return UDPStatus{}, err
+    }
+    var st UDPStatus
+    if err := json.Unmarshal(buf[:n], &st); err != nil {
+        t.Fatalf("decode %s: %v", buf[:n], err)
+    }
+    return st, nil
+}
+
+func TestUDPStatusQuery(t *testing.T) {
+    hub := NewHubWithConfig(Config{UDPStatus: true})
+    udp, err := StartUDPRelay(context.Background(), "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer udp.Close()
+    addr := udp.LocalAddr().(*net.UDPAddr)
+    peer, err := net.DialUDP("udp", nil, addr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer peer.Close()
+    peer.Write([]byte("ROOM:r;USER:sensor\nreading"))
+    time.Sleep(50 * time.Millisecond)
+
+    for i := 0; i < 2; i++ { // a query does not register its sender
+        st, err := queryUDPStatus(t, addr)
+        if err != nil {
+            t.Fatalf("no status reply: %v", err)
+        }
+        if st.Type != "status" || st.UDPPeers != 1 || st.UDPRooms != 1 || st.UptimeSec < 0 {
+            t.Fatalf("status %+v", st)
+        }
+    }
+    // nor is it relayed to the room
+    peer.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+    if n, err := peer.Read(make([]byte, 64)); err == nil {
+        t.Fatalf("peer received a %d byte datagram", n)
+    }
+}
+
+func TestUDPStatusQueryDisabled(t *testing.T) {
+    hub := NewHub()
+    udp, err := StartUDPRelay(context.Background(), "0", hub)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer udp.Close()
+    if st, err := queryUDPStatus(t, udp.LocalAddr().(*net.UDPAddr)); err == nil {
+        t.Fatalf("status answered while disabled: %+v", st)
+    }
+}
@@ -0,0 +1,24 @@
+package main
+
+import "errors"
+
+// Unique usernames per room (UNIQUE_USERNAMES). Presence and roster name
+// members by username, so two members with one name are ambiguous. With
+// the option on, Room.join refuses a connection whose username is already
+// in the room, checking and adding under the same write lock so two racing
+// joins cannot both get in; the refused connection is closed with 1008
+// "username taken". DUPLICATE_POLICY governs the same name across the whole
+// hub instead.
+
+var errUsernameTaken = errors.New("username taken")
+
+// usernameTakenLocked reports whether another member of r is called
+// c.username. Caller holds r.mu.
+func (r *Room) usernameTakenLocked(c *Client) bool {
+    for m := range r.clients {
+        if m != c && m.username == c.username {
+            return true
+        }
+    }
+    return false
+}
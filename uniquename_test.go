@@ -0,0 +1,50 @@
+package main
+
+import (
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestUniqueUsernamesRejectsSecondAlice(t *testing.T) {
+    hub := NewHubWithConfig(Config{UniqueUsernames: true})
+    base := startTestServer(t, hub)
+    dialWS(t, base+"/ws/names/alice")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "names") == 1 }) {
+        t.Fatal("alice did not join")
+    }
+    ce := expectClose(t, dialWS(t, base+"/ws/names/alice"))
+    if ce.Code != websocket.ClosePolicyViolation || ce.Text != "username taken" {
+        t.Fatalf("close %d %q, want 1008 username taken", ce.Code, ce.Text)
+    }
+    dialWS(t, base+"/ws/names/bob")
+    dialWS(t, base+"/ws/elsewhere/alice")
+    if !waitFor(time.Second, func() bool { return roomSize(hub, "names") == 2 && roomSize(hub, "elsewhere") == 1 }) {
+        t.Fatalf("rooms hold %d and %d", roomSize(hub, "names"), roomSize(hub, "elsewhere"))
+    }
+}
+
+func TestUniqueUsernamesConcurrentJoins(t *testing.T) {
+    hub := NewHubWithConfig(Config{UniqueUsernames: true})
+    room := hub.getRoom("race")
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    joined := 0
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if room.join(&Client{username: "alice", room: room}) == nil {
+                mu.Lock()
+                joined++
+                mu.Unlock()
+            }
+        }()
+    }
+    wg.Wait()
+    if joined != 1 || roomSize(hub, "race") != 1 {
+        t.Fatalf("%d joins succeeded, room holds %d", joined, roomSize(hub, "race"))
+    }
+}